@@ -43,7 +43,10 @@ func main() {
 	}
 
 	// Initialize OpenAI client
-	openaiClient, err := openai.NewClient(config.OpenAI)
+	openaiClient, err := openai.NewClient(&openai.ClientConfig{
+		APIKey:         config.OpenAI.APIKey,
+		FallbackModels: config.OpenAI.FallbackModels,
+	})
 	if err != nil {
 		logger.Fatal("Failed to initialize OpenAI client:", err)
 	}
@@ -1,11 +1,13 @@
 package core
 
 import (
+	"sort"
 	"sync"
 	"time"
 	"encoding/json"
 
 	"github.com/labs-alone/alone-main/internal/utils"
+	"github.com/labs-alone/alone-main/pkg/logger"
 )
 
 // State manages the application's runtime state
@@ -16,7 +18,7 @@ type State struct {
 	connections   map[string]*Connection
 	transactions  map[string]*Transaction
 	cache         *Cache
-	logger        *utils.Logger
+	logger        logger.Logger
 }
 
 // Status represents the current state status
@@ -51,6 +53,14 @@ type Transaction struct {
 // Metadata stores additional information
 type Metadata map[string]interface{}
 
+// normalized returns m, or an empty map if m is nil
+func (m Metadata) normalized() Metadata {
+	if m == nil {
+		return Metadata{}
+	}
+	return m
+}
+
 // Cache provides in-memory caching
 type Cache struct {
 	data map[string][]byte
@@ -97,8 +107,13 @@ func (s *State) UpdateStatus(status Status) {
 
 // AddConnection adds a new connection
 func (s *State) AddConnection(conn *Connection) {
+	if conn == nil {
+		return
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	conn.Metadata = conn.Metadata.normalized()
 	s.connections[conn.ID] = conn
 	s.status.ActiveUsers++
 	s.lastUpdated = time.Now()
@@ -115,10 +130,52 @@ func (s *State) RemoveConnection(id string) {
 	}
 }
 
+// ConnectionFilter selects connections in ListConnections. Zero values
+// match every connection.
+type ConnectionFilter struct {
+	Type    string        // only connections of this type
+	MinIdle time.Duration // only connections with no ping for at least this long
+}
+
+// ListConnections returns copies of the connections matching filter, oldest
+// first
+func (s *State) ListConnections(filter ConnectionFilter) []*Connection {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	conns := make([]*Connection, 0, len(s.connections))
+	for _, conn := range s.connections {
+		if filter.Type != "" && conn.Type != filter.Type {
+			continue
+		}
+		if filter.MinIdle > 0 && now.Sub(conn.LastPing) < filter.MinIdle {
+			continue
+		}
+
+		c := *conn
+		c.Metadata = make(Metadata, len(conn.Metadata))
+		for k, v := range conn.Metadata {
+			c.Metadata[k] = v
+		}
+		conns = append(conns, &c)
+	}
+
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].StartTime.Before(conns[j].StartTime)
+	})
+	return conns
+}
+
 // TrackTransaction adds a new transaction
 func (s *State) TrackTransaction(tx *Transaction) {
+	if tx == nil {
+		return
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	tx.Data = tx.Data.normalized()
 	s.transactions[tx.ID] = tx
 	s.lastUpdated = time.Now()
 }
@@ -130,6 +187,7 @@ func (s *State) UpdateTransaction(id string, status string) {
 	if tx, exists := s.transactions[id]; exists {
 		tx.Status = status
 		tx.EndTime = time.Now()
+		tx.Data = tx.Data.normalized()
 		s.lastUpdated = time.Now()
 	}
 }
@@ -212,6 +270,22 @@ func (s *State) Cleanup() {
 func (s *State) Export() ([]byte, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
+
+	// Callers hold pointers to tracked entries and may have cleared their
+	// metadata since, so export normalized copies
+	connections := make(map[string]*Connection, len(s.connections))
+	for id, conn := range s.connections {
+		c := *conn
+		c.Metadata = c.Metadata.normalized()
+		connections[id] = &c
+	}
+
+	transactions := make(map[string]*Transaction, len(s.transactions))
+	for id, tx := range s.transactions {
+		t := *tx
+		t.Data = t.Data.normalized()
+		transactions[id] = &t
+	}
 	
 	return json.Marshal(struct {
 		Status       Status                  `json:"status"`
@@ -220,8 +294,8 @@ func (s *State) Export() ([]byte, error) {
 		LastUpdated  time.Time              `json:"last_updated"`
 	}{
 		Status:       s.status,
-		Connections:  s.connections,
-		Transactions: s.transactions,
+		Connections:  connections,
+		Transactions: transactions,
 		LastUpdated:  s.lastUpdated,
 	})
 }
\ No newline at end of file
@@ -1,29 +1,85 @@
 package middleware
 
 import (
-	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/labs-alone/alone-main/pkg/auth"
 	"github.com/labs-alone/alone-main/pkg/logger"
 )
 
 var (
 	// JWT signing key - should be loaded from secure config in production
 	signingKey = []byte("your-secret-key")
+
+	// errWrongKey is returned by a key's keyfunc for tokens naming another key
+	errWrongKey = errors.New("token signed with an unknown key")
 )
 
+// JWTKey is an HMAC secret, named in the "kid" header of the tokens it signs
+type JWTKey struct {
+	ID     string
+	Secret []byte
+}
+
+// AuthConfig holds JWT key configuration
+type AuthConfig struct {
+	// Keys verify tokens, picked by the token's "kid" header. The first is
+	// the primary key that signs new tokens. To rotate, put the new key
+	// first and keep the old ones until the tokens they signed expire.
+	Keys []JWTKey
+}
+
+// DefaultAuthConfig returns the built-in development key
+func DefaultAuthConfig() *AuthConfig {
+	return &AuthConfig{
+		Keys: []JWTKey{{ID: "default", Secret: signingKey}},
+	}
+}
+
 // AuthMiddleware handles JWT authentication
 type AuthMiddleware struct {
-	log *logger.Logger
+	keys []JWTKey
+	log  logger.Logger
 }
 
-// NewAuthMiddleware creates a new auth middleware instance
-func NewAuthMiddleware(log *logger.Logger) *AuthMiddleware {
-	return &AuthMiddleware{log: log}
+// NewAuthMiddleware creates a new auth middleware instance using the
+// default key
+func NewAuthMiddleware(log logger.Logger) *AuthMiddleware {
+	return &AuthMiddleware{keys: DefaultAuthConfig().Keys, log: log}
+}
+
+// NewAuthMiddlewareWithConfig creates an auth middleware using the keys in
+// config. Every key needs a secret and an ID no other key uses.
+func NewAuthMiddlewareWithConfig(config *AuthConfig, log logger.Logger) (*AuthMiddleware, error) {
+	if config == nil {
+		config = DefaultAuthConfig()
+	}
+	if len(config.Keys) == 0 {
+		return nil, errors.New("at least one JWT key is required")
+	}
+
+	seen := make(map[string]bool, len(config.Keys))
+	for i, key := range config.Keys {
+		if key.ID == "" {
+			return nil, fmt.Errorf("JWT key %d has no ID", i)
+		}
+		if len(key.Secret) == 0 {
+			return nil, fmt.Errorf("JWT key %q has no secret", key.ID)
+		}
+		if seen[key.ID] {
+			return nil, fmt.Errorf("duplicate JWT key ID %q", key.ID)
+		}
+		seen[key.ID] = true
+	}
+
+	keys := make([]JWTKey, len(config.Keys))
+	copy(keys, config.Keys)
+	return &AuthMiddleware{keys: keys, log: log}, nil
 }
 
 // Authenticate verifies JWT tokens and adds claims to context
@@ -44,13 +100,7 @@ func (m *AuthMiddleware) Authenticate(next http.Handler) http.Handler {
 		}
 
 		// Parse and validate token
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return signingKey, nil
-		})
-
+		token, err := m.parse(tokenString)
 		if err != nil {
 			m.log.Error("Failed to parse token", "error", err)
 			http.Error(w, "Invalid token", http.StatusUnauthorized)
@@ -69,25 +119,46 @@ func (m *AuthMiddleware) Authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		// Add claims to request context
-		ctx := context.WithValue(r.Context(), "user_id", claims["user_id"])
-		ctx = context.WithValue(ctx, "role", claims["role"])
-
-		// Call next handler with updated context
-		next.ServeHTTP(w, r.WithContext(ctx))
+		// Add claims to request context for auth.ClaimsFromContext
+		next.ServeHTTP(w, r.WithContext(auth.WithClaims(r.Context(), claims)))
 	})
 }
 
 // GenerateToken creates a new JWT token
 func (m *AuthMiddleware) GenerateToken(userID string, role string) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+	return m.GenerateTokenWithClaims(map[string]interface{}{
 		"user_id": userID,
 		"role":    role,
-		"exp":     time.Now().Add(time.Hour * 24).Unix(),
-		"iat":     time.Now().Unix(),
-	})
+	}, time.Hour*24)
+}
+
+// GenerateTokenWithClaims creates a JWT token carrying custom claims, such
+// as tenant IDs or scopes, that expires after ttl. "aud" may be a string or
+// a list of strings and "sub" must be a string. The time claims exp, iat and
+// nbf are always set from ttl and can't be supplied.
+func (m *AuthMiddleware) GenerateTokenWithClaims(claims map[string]interface{}, ttl time.Duration) (string, error) {
+	if ttl <= 0 {
+		return "", fmt.Errorf("token ttl must be positive, got %s", ttl)
+	}
+	if err := validateCustomClaims(claims); err != nil {
+		return "", err
+	}
 
-	tokenString, err := token.SignedString(signingKey)
+	now := time.Now()
+	mapClaims := jwt.MapClaims{
+		"exp": now.Add(ttl).Unix(),
+		"iat": now.Unix(),
+		"nbf": now.Unix(),
+	}
+	for k, v := range claims {
+		mapClaims[k] = v
+	}
+
+	primary := m.keys[0]
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, mapClaims)
+	token.Header["kid"] = primary.ID
+
+	tokenString, err := token.SignedString(primary.Secret)
 	if err != nil {
 		m.log.Error("Failed to generate token", "error", err)
 		return "", fmt.Errorf("failed to generate token: %w", err)
@@ -96,12 +167,65 @@ func (m *AuthMiddleware) GenerateToken(userID string, role string) (string, erro
 	return tokenString, nil
 }
 
+// parse verifies tokenString with the key its "kid" header names. Tokens
+// without one, issued before keys had IDs, are tried against every key.
+func (m *AuthMiddleware) parse(tokenString string) (*jwt.Token, error) {
+	lastErr := errWrongKey
+	for _, key := range m.keys {
+		token, err := jwt.Parse(tokenString, key.keyFunc)
+		if errors.Is(err, errWrongKey) {
+			continue
+		}
+		if !errors.Is(err, jwt.ErrTokenSignatureInvalid) {
+			return token, err
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// keyFunc returns k's secret for HMAC tokens naming k or no key at all
+func (k JWTKey) keyFunc(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	if kid, ok := token.Header["kid"]; ok && kid != k.ID {
+		return nil, errWrongKey
+	}
+	return k.Secret, nil
+}
+
+// validateCustomClaims rejects time claims and registered claims of the
+// wrong type, which would otherwise produce tokens that fail validation
+func validateCustomClaims(claims map[string]interface{}) error {
+	for _, reserved := range []string{"exp", "iat", "nbf"} {
+		if _, ok := claims[reserved]; ok {
+			return fmt.Errorf("claim %q is reserved and set from the token ttl", reserved)
+		}
+	}
+
+	if sub, ok := claims["sub"]; ok {
+		if _, isString := sub.(string); !isString {
+			return fmt.Errorf("claim \"sub\" must be a string, got %T", sub)
+		}
+	}
+
+	if aud, ok := claims["aud"]; ok {
+		switch aud.(type) {
+		case string, []string:
+		default:
+			return fmt.Errorf("claim \"aud\" must be a string or []string, got %T", aud)
+		}
+	}
+
+	return nil
+}
+
 // RequireRole middleware checks if user has required role
 func (m *AuthMiddleware) RequireRole(role string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			userRole, ok := r.Context().Value("role").(string)
-			if !ok || userRole != role {
+			if auth.Role(r.Context()) != role {
 				http.Error(w, "Unauthorized", http.StatusForbidden)
 				return
 			}
@@ -112,13 +236,7 @@ func (m *AuthMiddleware) RequireRole(role string) func(http.Handler) http.Handle
 
 // ValidateToken checks if a token is valid without full middleware processing
 func (m *AuthMiddleware) ValidateToken(tokenString string) (jwt.MapClaims, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return signingKey, nil
-	})
-
+	token, err := m.parse(tokenString)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse token: %w", err)
 	}
@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/labs-alone/alone-main/pkg/logger"
@@ -42,11 +43,11 @@ func DefaultCORSConfig() *CORSConfig {
 // CORSMiddleware handles Cross-Origin Resource Sharing
 type CORSMiddleware struct {
 	config *CORSConfig
-	log    *logger.Logger
+	log    logger.Logger
 }
 
 // NewCORSMiddleware creates a new CORS middleware instance
-func NewCORSMiddleware(config *CORSConfig, log *logger.Logger) *CORSMiddleware {
+func NewCORSMiddleware(config *CORSConfig, log logger.Logger) *CORSMiddleware {
 	if config == nil {
 		config = DefaultCORSConfig()
 	}
@@ -116,7 +117,7 @@ func (m *CORSMiddleware) handlePreflight(w http.ResponseWriter, r *http.Request)
 	m.setCORSHeaders(w, origin)
 	w.Header().Set("Access-Control-Allow-Methods", strings.Join(m.config.AllowedMethods, ","))
 	w.Header().Set("Access-Control-Allow-Headers", strings.Join(m.config.AllowedHeaders, ","))
-	w.Header().Set("Access-Control-Max-Age", string(m.config.MaxAge))
+	w.Header().Set("Access-Control-Max-Age", strconv.Itoa(m.config.MaxAge))
 	w.WriteHeader(http.StatusNoContent)
 }
 
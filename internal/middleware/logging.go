@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -10,11 +11,11 @@ import (
 
 // LoggingMiddleware handles request logging
 type LoggingMiddleware struct {
-	log *logger.Logger
+	log logger.Logger
 }
 
 // NewLoggingMiddleware creates a new logging middleware instance
-func NewLoggingMiddleware(log *logger.Logger) *LoggingMiddleware {
+func NewLoggingMiddleware(log logger.Logger) *LoggingMiddleware {
 	return &LoggingMiddleware{log: log}
 }
 
@@ -25,11 +26,12 @@ type responseWriter struct {
 	wroteHeader bool
 }
 
-func wrapResponseWriter(w http.ResponseWriter) *responseWriter {
-	return &responseWriter{ResponseWriter: w}
-}
-
+// Status returns the status written, which is 200 if the handler wrote a
+// body without setting one or wrote nothing at all
 func (rw *responseWriter) Status() int {
+	if !rw.wroteHeader {
+		return http.StatusOK
+	}
 	return rw.status
 }
 
@@ -41,69 +43,127 @@ func (rw *responseWriter) WriteHeader(code int) {
 	}
 }
 
-// Handle implements the logging middleware
+// Flush sends buffered data to the client, so streaming handlers such as
+// server-sent events work behind the logging middleware
+func (rw *responseWriter) Flush() {
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		if !rw.wroteHeader {
+			rw.WriteHeader(http.StatusOK)
+		}
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying writer for http.ResponseController
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// maxRequestLogFields is the most keys and values logged for one request
+const maxRequestLogFields = 14
+
+// slowRequest is how long a request takes before it's logged as slow
+const slowRequest = time.Second
+
+// requestLog is the per-request state of the logging middleware. It's
+// pooled along with the field slice handed to the logger, so a request
+// that succeeds quickly only allocates its ID and the boxed field values.
+type requestLog struct {
+	rw     responseWriter
+	fields []interface{}
+}
+
+var requestLogPool = sync.Pool{
+	New: func() interface{} {
+		return &requestLog{fields: make([]interface{}, 0, maxRequestLogFields)}
+	},
+}
+
+func getRequestLog(w http.ResponseWriter) *requestLog {
+	l := requestLogPool.Get().(*requestLog)
+	l.rw = responseWriter{ResponseWriter: w}
+	return l
+}
+
+// putRequestLog returns l to the pool, dropping what it references so
+// pooled entries don't keep requests alive
+func putRequestLog(l *requestLog) {
+	l.rw = responseWriter{}
+	l.fields = l.fields[:cap(l.fields)]
+	for i := range l.fields {
+		l.fields[i] = nil
+	}
+	l.fields = l.fields[:0]
+	requestLogPool.Put(l)
+}
+
+// Handle implements the logging middleware. The wrapped writer is reused
+// once the handler returns, so handlers must not keep it past that, as
+// net/http already requires.
 func (m *LoggingMiddleware) Handle(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		requestID := uuid.New().String()
-
-		// Add request ID to context
-		ctx := r.Context()
-		r = r.WithContext(ctx)
+		requestID := uuid.NewString()
 
 		// Wrap response writer to capture status code
-		wrapped := wrapResponseWriter(w)
+		l := getRequestLog(w)
+		wrapped := &l.rw
 
-		// Add request ID to response headers
-		wrapped.Header().Set("X-Request-ID", requestID)
+		// Add request ID to response headers. The key is given in canonical
+		// form so setting it doesn't allocate.
+		wrapped.Header().Set("X-Request-Id", requestID)
 
 		// Log request details
-		m.log.Info("Request started",
+		l.fields = append(l.fields[:0],
 			"request_id", requestID,
 			"method", r.Method,
 			"path", r.URL.Path,
 			"remote_addr", r.RemoteAddr,
 			"user_agent", r.UserAgent(),
 		)
+		m.log.Info("Request started", l.fields...)
 
-		// Process request
+		// Process request. A handler that panics leaves its writer out of
+		// the pool, since whoever recovers may still write to it.
 		next.ServeHTTP(wrapped, r)
 
 		// Calculate duration
 		duration := time.Since(start)
+		status := wrapped.Status()
 
-		// Log response details
-		m.log.Info("Request completed",
-			"request_id", requestID,
-			"method", r.Method,
-			"path", r.URL.Path,
-			"status", wrapped.Status(),
-			"duration", duration.String(),
+		// Log response details, reusing the request ID, method and path
+		// already boxed for the first entry
+		l.fields = append(l.fields[:6],
+			"status", status,
+			"duration", duration,
 			"duration_ms", duration.Milliseconds(),
 		)
+		m.log.Info("Request completed", l.fields...)
 
 		// Log detailed error information for non-2xx responses
-		if wrapped.Status() >= 400 {
+		if status >= 400 {
 			m.log.Error("Request error",
 				"request_id", requestID,
 				"method", r.Method,
 				"path", r.URL.Path,
-				"status", wrapped.Status(),
-				"duration", duration.String(),
+				"status", status,
+				"duration", duration,
 				"remote_addr", r.RemoteAddr,
 				"user_agent", r.UserAgent(),
 			)
 		}
 
 		// Log performance warning for slow requests
-		if duration > 1*time.Second {
+		if duration > slowRequest {
 			m.log.Warn("Slow request",
 				"request_id", requestID,
 				"method", r.Method,
 				"path", r.URL.Path,
-				"duration", duration.String(),
+				"duration", duration,
 			)
 		}
+
+		putRequestLog(l)
 	})
 }
 
@@ -1,83 +1,193 @@
-package api
+package middleware
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"sort"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/labs-alone/alone-main/internal/api/handlers"
-	"github.com/labs-alone/alone-main/internal/api/middleware"
+	"github.com/labs-alone/alone-main/internal/openai"
+	"github.com/labs-alone/alone-main/internal/utils"
 	"github.com/labs-alone/alone-main/pkg/logger"
+	"github.com/labs-alone/alone-main/pkg/maintenance"
+
+	lilith "github.com/labs-alone/alone-main/lilith-on-vae"
 )
 
-// Router handles all API routing
+// Router serves the admin API. The application API itself is served by
+// pkg/api.
 type Router struct {
-	router *mux.Router
-	log    *logger.Logger
+	router      *mux.Router
+	log         logger.Logger
+	prompts     *openai.PromptManager
+	tasks       *lilith.Processor
+	agents      *lilith.Registry
+	config      *utils.Config
+	maintenance *maintenance.Mode
+	middleware  map[string][]string // middleware names by path prefix
+}
+
+// maxPromptImportSize caps the body of a prompt template import
+const maxPromptImportSize = 1 << 20
+
+// Agent event stream settings: results sent on connect unless the client
+// asks for a different backlog, results buffered per client, and how often
+// an idle stream is kept alive
+const (
+	defaultEventBacklog = 20
+	eventStreamBuffer   = 64
+	eventKeepAlive      = 15 * time.Second
+)
+
+// Agent memory export page sizes
+const (
+	defaultMemoryPageSize = 100
+	maxMemoryPageSize     = 1000
+)
+
+// agentEventsPath serves a stream that stays open for as long as the client
+// listens, so it's exempt from the request timeout
+const agentEventsPath = "/v1/admin/agent/events"
+
+// promptSet is the JSON form of the prompt templates for export and import
+type promptSet struct {
+	Templates []openai.PromptTemplate `json:"templates"`
+}
+
+// RouteInfo describes a registered route
+type RouteInfo struct {
+	Methods      []string `json:"methods"`
+	Path         string   `json:"path"`
+	AuthRequired bool     `json:"auth_required"`
+	Middleware   []string `json:"middleware"`
 }
 
 // NewRouter creates a new router instance
-func NewRouter(log *logger.Logger) *Router {
+func NewRouter(log logger.Logger) *Router {
 	return &Router{
-		router: mux.NewRouter(),
-		log:    log,
+		router:      mux.NewRouter(),
+		log:         log,
+		maintenance: maintenance.New(false, nil, log),
+		middleware:  make(map[string][]string),
 	}
 }
 
+// SetPromptManager configures the prompt templates served by the admin
+// prompt endpoints
+func (r *Router) SetPromptManager(prompts *openai.PromptManager) {
+	r.prompts = prompts
+}
+
+// SetTaskProcessor configures the task processor served by the admin
+// dead-letter endpoints
+func (r *Router) SetTaskProcessor(tasks *lilith.Processor) {
+	r.tasks = tasks
+}
+
+// SetAgentRegistry configures the agents served by the admin agent state
+// endpoints
+func (r *Router) SetAgentRegistry(agents *lilith.Registry) {
+	r.agents = agents
+}
+
+// SetConfig configures the runtime config patched by the admin config
+// endpoint
+func (r *Router) SetConfig(config *utils.Config) {
+	r.config = config
+}
+
+// SetMaintenance replaces the maintenance mode, e.g. with the API router's
+// so the admin toggle applies to both. It must be called before Setup.
+func (r *Router) SetMaintenance(mode *maintenance.Mode) {
+	r.maintenance = mode
+}
+
+// Maintenance returns the maintenance mode so it can be toggled at runtime
+func (r *Router) Maintenance() *maintenance.Mode {
+	return r.maintenance
+}
+
+// use applies middleware to router and records its name against the
+// router's path prefix so Routes can report it
+func (r *Router) use(router *mux.Router, prefix, name string, mw mux.MiddlewareFunc) {
+	router.Use(mw)
+	r.middleware[prefix] = append(r.middleware[prefix], name)
+}
+
 // Setup configures all routes and middleware
 func (r *Router) Setup() {
 	// Create middleware instances
-	loggingMiddleware := middleware.NewLoggingMiddleware(r.log)
-	authMiddleware := middleware.NewAuthMiddleware(r.log)
-	corsMiddleware := middleware.NewCORSMiddleware(nil, r.log)
-
-	// Create handlers
-	healthHandler := handlers.NewHealthHandler(r.log)
-	aiHandler := handlers.NewAIHandler(r.log)
-	solanaHandler := handlers.NewSolanaHandler(r.log)
+	loggingMiddleware := NewLoggingMiddleware(r.log)
+	authMiddleware := NewAuthMiddleware(r.log)
+	corsMiddleware := NewCORSMiddleware(nil, r.log)
 
 	// Apply global middleware
-	r.router.Use(loggingMiddleware.Handle)
-	r.router.Use(loggingMiddleware.LogPanic)
-	r.router.Use(corsMiddleware.Handle)
-	r.router.Use(mux.CORSMethodMiddleware(r.router))
+	r.use(r.router, "", "logging", loggingMiddleware.Handle)
+	r.use(r.router, "", "panic_logging", loggingMiddleware.LogPanic)
+	r.use(r.router, "", "cors", corsMiddleware.Handle)
+	r.use(r.router, "", "cors_methods", mux.CORSMethodMiddleware(r.router))
 
 	// Set timeouts
-	r.router.Use(middleware.TimeoutMiddleware(30 * time.Second))
+	r.use(r.router, "", "timeout", TimeoutMiddleware(30*time.Second, agentEventsPath))
 
 	// Public routes
-	r.router.HandleFunc("/health", healthHandler.Check).Methods(http.MethodGet)
-	r.router.HandleFunc("/v1/auth/token", authMiddleware.GenerateTokenHandler).Methods(http.MethodPost)
+	r.router.HandleFunc("/health", r.handleHealth).Methods(http.MethodGet)
 
 	// API routes (protected)
 	api := r.router.PathPrefix("/v1").Subrouter()
-	api.Use(authMiddleware.Authenticate)
-
-	// AI routes
-	ai := api.PathPrefix("/ai").Subrouter()
-	ai.HandleFunc("/complete", aiHandler.Complete).Methods(http.MethodPost)
-	ai.HandleFunc("/stream", aiHandler.Stream).Methods(http.MethodPost)
-
-	// Solana routes
-	solana := api.PathPrefix("/solana").Subrouter()
-	solana.HandleFunc("/balance", solanaHandler.GetBalance).Methods(http.MethodGet)
-	solana.HandleFunc("/transfer", solanaHandler.Transfer).Methods(http.MethodPost)
-	solana.HandleFunc("/swap", solanaHandler.Swap).Methods(http.MethodPost)
+	r.use(api, "/v1", "authenticate", authMiddleware.Authenticate)
+	r.use(api, "/v1", "maintenance", r.maintenance.Handle)
 
 	// Admin routes (protected + admin role)
 	admin := api.PathPrefix("/admin").Subrouter()
-	admin.Use(authMiddleware.RequireRole("admin"))
-	admin.HandleFunc("/metrics", handlers.GetMetrics).Methods(http.MethodGet)
-	admin.HandleFunc("/users", handlers.ManageUsers).Methods(http.MethodGet, http.MethodPost)
+	r.use(admin, "/v1/admin", "require_role:admin", authMiddleware.RequireRole("admin"))
+	admin.HandleFunc("/routes", r.handleRoutes).Methods(http.MethodGet)
+	admin.HandleFunc("/prompts", r.handleExportPrompts).Methods(http.MethodGet)
+	admin.HandleFunc("/prompts", r.handleImportPrompts).Methods(http.MethodPut)
+	admin.HandleFunc("/maintenance", r.handleGetMaintenance).Methods(http.MethodGet)
+	admin.HandleFunc("/maintenance", r.handleSetMaintenance).Methods(http.MethodPut)
+	r.maintenance.Exempt("/v1/admin/maintenance")
+	admin.HandleFunc("/config", r.handlePatchConfig).Methods(http.MethodPatch)
+	admin.HandleFunc("/tasks/dead-letters", r.handleDeadLetters).Methods(http.MethodGet)
+	admin.HandleFunc("/tasks/dead-letters/replay", r.handleReplayDeadLetters).Methods(http.MethodPost)
+	admin.HandleFunc("/agent/events", r.handleAgentEvents).Methods(http.MethodGet)
+	admin.HandleFunc("/agent/memory", r.handleAgentMemory).Methods(http.MethodGet)
+	admin.HandleFunc("/agent/{id}/state", r.handleAgentState).Methods(http.MethodGet)
+	admin.HandleFunc("/agent/{id}/state/export", r.handleExportAgentState).Methods(http.MethodPost)
 
 	// Not found handler
-	r.router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	r.router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		r.log.Warn("Not found",
-			"path", r.URL.Path,
-			"method", r.Method,
+			"path", req.URL.Path,
+			"method", req.Method,
 		)
 		http.Error(w, "Not found", http.StatusNotFound)
 	})
+
+	r.warnDuplicateRoutes()
+}
+
+// warnDuplicateRoutes logs each method and path registered more than once.
+// mux only ever serves the first, so the others are wiring mistakes.
+func (r *Router) warnDuplicateRoutes() {
+	seen := make(map[string]bool)
+	for _, route := range r.Routes() {
+		for _, method := range route.Methods {
+			key := method + " " + route.Path
+			if seen[key] {
+				r.log.Warn("Duplicate route registered", "method", method, "path", route.Path)
+			}
+			seen[key] = true
+		}
+	}
 }
 
 // ServeHTTP implements the http.Handler interface
@@ -90,27 +200,519 @@ func (r *Router) GetRouter() *mux.Router {
 	return r.router
 }
 
-// TimeoutMiddleware adds a timeout to the request context
-func TimeoutMiddleware(timeout time.Duration) mux.MiddlewareFunc {
+// Routes lists the registered routes sorted by path, with the middleware
+// applied to each in the order they run
+func (r *Router) Routes() []RouteInfo {
+	var routes []RouteInfo
+
+	r.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+
+		// Subrouter mounts have no methods of their own
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+
+		info := RouteInfo{
+			Methods:    methods,
+			Path:       path,
+			Middleware: append([]string{}, r.middleware[""]...),
+		}
+		for _, ancestor := range ancestors {
+			prefix, err := ancestor.GetPathTemplate()
+			if err != nil {
+				continue
+			}
+			info.Middleware = append(info.Middleware, r.middleware[prefix]...)
+		}
+
+		for _, name := range info.Middleware {
+			if name == "authenticate" {
+				info.AuthRequired = true
+				break
+			}
+		}
+
+		routes = append(routes, info)
+		return nil
+	})
+
+	sort.SliceStable(routes, func(i, j int) bool {
+		return routes[i].Path < routes[j].Path
+	})
+	return routes
+}
+
+// handleHealth reports that the admin API is up
+func (r *Router) handleHealth(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleRoutes serves the route listing
+func (r *Router) handleRoutes(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"routes": r.Routes(),
+	})
+}
+
+// handleExportPrompts serves the full prompt template set
+func (r *Router) handleExportPrompts(w http.ResponseWriter, req *http.Request) {
+	if r.prompts == nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "prompt templates not configured"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, promptSet{Templates: r.prompts.Templates()})
+}
+
+// handleImportPrompts replaces the prompt template set. The import is
+// rejected as a whole if any template is invalid.
+func (r *Router) handleImportPrompts(w http.ResponseWriter, req *http.Request) {
+	if r.prompts == nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "prompt templates not configured"})
+		return
+	}
+
+	var set promptSet
+	dec := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxPromptImportSize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&set); err != nil {
+		r.log.Warn("Invalid prompt import body", "error", err)
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	if err := r.prompts.ReplaceTemplates(set.Templates); err != nil {
+		var invalid openai.TemplateErrors
+		if errors.As(err, &invalid) {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+				"error":   "invalid templates",
+				"details": invalid,
+			})
+			return
+		}
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	r.log.Info("Prompt templates imported", "count", len(set.Templates))
+	writeJSON(w, http.StatusOK, promptSet{Templates: r.prompts.Templates()})
+}
+
+// handleGetMaintenance serves the maintenance mode settings
+func (r *Router) handleGetMaintenance(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, http.StatusOK, r.maintenance.Status())
+}
+
+// handleSetMaintenance turns maintenance mode on or off
+func (r *Router) handleSetMaintenance(w http.ResponseWriter, req *http.Request) {
+	var body struct {
+		Enabled *bool `json:"enabled"`
+	}
+	dec := json.NewDecoder(http.MaxBytesReader(w, req.Body, 1024))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil {
+		r.log.Warn("Invalid maintenance body", "error", err)
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+	if body.Enabled == nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "enabled is required"})
+		return
+	}
+
+	r.maintenance.SetEnabled(*body.Enabled)
+	writeJSON(w, http.StatusOK, r.maintenance.Status())
+}
+
+// handlePatchConfig applies a JSON merge patch to the runtime config. The
+// patch is rejected as a whole if it touches a setting that can't change at
+// runtime or leaves the config invalid.
+func (r *Router) handlePatchConfig(w http.ResponseWriter, req *http.Request) {
+	if r.config == nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "config not configured"})
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, 64*1024))
+	if err != nil {
+		r.log.Warn("Invalid config patch body", "error", err)
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	updated, err := r.config.Patch(body)
+	if err != nil {
+		r.log.Warn("Config patch rejected", "error", err)
+		switch {
+		case errors.Is(err, utils.ErrSecretConfigKey), errors.Is(err, utils.ErrReadOnlyConfigKey):
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "config key can't be patched"})
+		case errors.Is(err, utils.ErrUnknownConfigKey):
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unknown config key"})
+		default:
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid config patch"})
+		}
+		return
+	}
+
+	r.log.Info("Config patched", "keys", updated)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"updated": updated,
+	})
+}
+
+// handleDeadLetters serves the failed tasks awaiting replay
+func (r *Router) handleDeadLetters(w http.ResponseWriter, req *http.Request) {
+	if r.tasks == nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "task processor not configured"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"dead_letters": r.tasks.DeadLetters(),
+	})
+}
+
+// handleReplayDeadLetters requeues dead-lettered tasks selected by ID or
+// type. An empty body replays every dead-lettered task.
+func (r *Router) handleReplayDeadLetters(w http.ResponseWriter, req *http.Request) {
+	if r.tasks == nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "task processor not configured"})
+		return
+	}
+
+	var filter lilith.DeadLetterFilter
+	dec := json.NewDecoder(http.MaxBytesReader(w, req.Body, 64*1024))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&filter); err != nil && !errors.Is(err, io.EOF) {
+		r.log.Warn("Invalid dead-letter replay body", "error", err)
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	replayed := r.tasks.ReplayDeadLetters(filter)
+	ids := make([]string, len(replayed))
+	for i, task := range replayed {
+		ids[i] = task.ID
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"replayed": ids,
+	})
+}
+
+// handleAgentEvents streams task results as server-sent events, starting
+// with a backlog of the most recent ones. The backlog query parameter sets
+// how many, up to the processor's history size.
+func (r *Router) handleAgentEvents(w http.ResponseWriter, req *http.Request) {
+	if r.tasks == nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "task processor not configured"})
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "streaming not supported"})
+		return
+	}
+
+	backlog := defaultEventBacklog
+	if value := req.URL.Query().Get("backlog"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "backlog must be a non-negative integer"})
+			return
+		}
+		backlog = n
+	}
+
+	recent, results, unsubscribe := r.tasks.SubscribeResults(backlog, eventStreamBuffer)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	for _, result := range recent {
+		if err := writeEvent(w, "task_result", result); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case result, ok := <-results:
+			if !ok {
+				return
+			}
+			if err := writeEvent(w, "task_result", result); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes v as a server-sent event of the given type
+func writeEvent(w io.Writer, event string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
+
+// agent looks up the agent named in the request path, answering 503 if no
+// registry is configured and 404 if the agent is unknown
+func (r *Router) agent(w http.ResponseWriter, req *http.Request) (*lilith.Agent, bool) {
+	if r.agents == nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "agent registry not configured"})
+		return nil, false
+	}
+
+	agent, err := r.agents.Get(mux.Vars(req)["id"])
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return nil, false
+	}
+	return agent, true
+}
+
+// handleAgentState serves a snapshot of an agent's state
+func (r *Router) handleAgentState(w http.ResponseWriter, req *http.Request) {
+	agent, ok := r.agent(w, req)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, agent.State().Snapshot(false))
+}
+
+// handleExportAgentState persists a full snapshot of an agent's state to
+// disk
+func (r *Router) handleExportAgentState(w http.ResponseWriter, req *http.Request) {
+	agent, ok := r.agent(w, req)
+	if !ok {
+		return
+	}
+
+	path, err := agent.ExportState()
+	if err != nil {
+		r.log.Error("Agent state export failed", "agent", agent.ID, "error", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"agent_id": agent.ID,
+		"path":     path,
+	})
+}
+
+// handleAgentMemory exports the unexpired items of one of an agent's memory
+// stores, chosen by the type query parameter, with sensitive values
+// redacted. The agent query parameter may be left out when only one agent
+// is registered. Pages of up to limit items are fetched by passing the
+// previous page's next_cursor as cursor.
+func (r *Router) handleAgentMemory(w http.ResponseWriter, req *http.Request) {
+	if r.agents == nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "agent registry not configured"})
+		return
+	}
+
+	query := req.URL.Query()
+	id := query.Get("agent")
+	if id == "" {
+		ids := r.agents.IDs()
+		if len(ids) != 1 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "agent is required when more than one agent is registered"})
+			return
+		}
+		id = ids[0]
+	}
+	agent, err := r.agents.Get(id)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+
+	memoryType, err := lilith.ParseMemoryType(query.Get("type"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "type must be short, long or volatile"})
+		return
+	}
+
+	limit := defaultMemoryPageSize
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxMemoryPageSize {
+			writeJSON(w, http.StatusBadRequest, map[string]string{
+				"error": fmt.Sprintf("limit must be between 1 and %d", maxMemoryPageSize),
+			})
+			return
+		}
+		limit = n
+	}
+
+	page, err := agent.State().ExportMemory(memoryType, query.Get("cursor"), limit)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"agent_id":    agent.ID,
+		"type":        query.Get("type"),
+		"entries":     page.Entries,
+		"total":       page.Total,
+		"next_cursor": page.NextCursor,
+	})
+}
+
+// writeJSON writes v as a JSON response with the given status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// TimeoutMiddleware adds a timeout to the request context. Handlers that do
+// not finish in time are answered with a JSON 504 body; anything they write
+// after the deadline is discarded. Requests for the exempt paths, such as
+// event streams, are passed through untouched.
+func TimeoutMiddleware(timeout time.Duration, exempt ...string) mux.MiddlewareFunc {
+	exempted := make(map[string]bool, len(exempt))
+	for _, path := range exempt {
+		exempted[path] = true
+	}
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			ctx := r.Context()
-			ctx, cancel := context.WithTimeout(ctx, timeout)
+			// Streams stay open for as long as the client listens and
+			// can't be buffered
+			if exempted[r.URL.Path] {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
 			defer cancel()
 
-			done := make(chan bool)
+			tw := &timeoutWriter{
+				w:      w,
+				header: make(http.Header),
+			}
+
+			done := make(chan struct{})
+			panicChan := make(chan interface{}, 1)
 			go func() {
-				next.ServeHTTP(w, r.WithContext(ctx))
-				done <- true
+				defer func() {
+					if p := recover(); p != nil {
+						panicChan <- p
+					}
+				}()
+				next.ServeHTTP(tw, r.WithContext(ctx))
+				close(done)
 			}()
 
 			select {
+			case p := <-panicChan:
+				panic(p)
 			case <-done:
-				return
+				tw.flush()
 			case <-ctx.Done():
-				w.WriteHeader(http.StatusGatewayTimeout)
-				return
+				tw.timeout(timeout)
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+
+// TimeoutResponse is the body returned when a request exceeds its deadline
+type TimeoutResponse struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+	Timeout string `json:"timeout"`
+}
+
+// timeoutWriter buffers a handler's response so it can be dropped in favour
+// of a 504 if the deadline passes first
+type timeoutWriter struct {
+	w        http.ResponseWriter
+	header   http.Header
+	body     bytes.Buffer
+	status   int
+	timedOut bool
+	mu       sync.Mutex
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.status != 0 {
+		return
+	}
+	tw.status = code
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.status == 0 {
+		tw.status = http.StatusOK
+	}
+	return tw.body.Write(b)
+}
+
+// flush copies the buffered response to the underlying writer
+func (tw *timeoutWriter) flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	dst := tw.w.Header()
+	for k, v := range tw.header {
+		dst[k] = v
+	}
+	if tw.status == 0 {
+		tw.status = http.StatusOK
+	}
+	tw.w.WriteHeader(tw.status)
+	tw.w.Write(tw.body.Bytes())
+}
+
+// timeout discards the buffered response and writes the 504 body
+func (tw *timeoutWriter) timeout(timeout time.Duration) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	tw.timedOut = true
+	tw.w.Header().Set("Content-Type", "application/json")
+	tw.w.WriteHeader(http.StatusGatewayTimeout)
+	json.NewEncoder(tw.w).Encode(TimeoutResponse{
+		Error:   "gateway_timeout",
+		Message: "request did not complete in time",
+		Timeout: timeout.String(),
+	})
+}
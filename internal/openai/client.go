@@ -3,14 +3,19 @@ package openai
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/labs-alone/alone-main/internal/utils"
+	"github.com/labs-alone/alone-main/pkg/logger"
 )
 
 const (
@@ -20,22 +25,76 @@ const (
 
 // Client manages OpenAI API interactions
 type Client struct {
-	apiKey     string
+	keys       *keyPool
 	baseURL    string
 	httpClient *http.Client
-	logger     *utils.Logger
+	timeout    time.Duration
+	logger     logger.Logger
 	metrics    *Metrics
+	fallbacks  []string
+	limiter    *utils.ConcurrencyLimiter // bounds batch requests
+	retry      utils.RetryPolicy
 	mu         sync.RWMutex
 }
 
 // ClientConfig holds the configuration for the OpenAI client
 type ClientConfig struct {
-	APIKey     string
-	BaseURL    string
+	APIKey string
+	// APIKeys spreads requests across several keys by weight, in addition
+	// to APIKey if that is set. A key that is rate limited is skipped for
+	// the Retry-After the API sends, or KeyCooldown if it sends none.
+	APIKeys     []KeyConfig
+	KeyCooldown time.Duration
+
+	BaseURL string
+	// Timeout bounds each CreateChatCompletion call, including retries and
+	// fallbacks. A call made with a context deadline gets whatever is left
+	// of it if that is shorter.
 	Timeout    time.Duration
 	MaxRetries int
+
+	// RetryDelay is the wait before the first of MaxRetries retries of a
+	// throttled or failed request, doubled for each one after. It defaults
+	// to DefaultRetryDelay.
+	RetryDelay time.Duration
+	// MaxRetryDelay caps the backoff and any Retry-After the API sends, so a
+	// huge value can't stall the request. It defaults to
+	// DefaultMaxRetryDelay.
+	MaxRetryDelay time.Duration
+	// Clock times retry delays, defaulting to the system clock
+	Clock utils.Clock
+
+	// FallbackModels are tried in order when the requested model is
+	// overloaded (429 or 503). Leave empty to disable fallback.
+	FallbackModels []string
+
+	// ProxyURL routes requests through an outbound proxy (http, https or
+	// socks5)
+	ProxyURL string
+	// TLSConfig customizes TLS, e.g. to trust a corporate CA bundle
+	TLSConfig *tls.Config
+	// Transport replaces the HTTP transport entirely. It can't be combined
+	// with ProxyURL or TLSConfig, which should be set on it instead.
+	Transport http.RoundTripper
+
+	// MaxConcurrency caps the requests CreateChatCompletions sends at once,
+	// defaulting to DefaultMaxConcurrency
+	MaxConcurrency int
+	// Limiter, if set, is used instead of a limiter sized by MaxConcurrency
+	// so the bound can be shared with other clients
+	Limiter *utils.ConcurrencyLimiter
 }
 
+// DefaultMaxConcurrency is used when ClientConfig.MaxConcurrency is unset
+const DefaultMaxConcurrency = 4
+
+const (
+	// DefaultRetryDelay is used when ClientConfig.RetryDelay is unset
+	DefaultRetryDelay = time.Second
+	// DefaultMaxRetryDelay is used when ClientConfig.MaxRetryDelay is unset
+	DefaultMaxRetryDelay = time.Minute
+)
+
 // Metrics tracks API usage and performance
 type Metrics struct {
 	RequestCount   int64
@@ -43,6 +102,11 @@ type Metrics struct {
 	ErrorCount     int64
 	AverageLatency time.Duration
 	LastRequest    time.Time
+	FallbackCount  int64
+	// Keys is the usage of each API key
+	Keys []KeyMetrics
+	// Batch is the usage of the limiter bounding batch requests
+	Batch utils.LimiterStats
 	mu            sync.RWMutex
 }
 
@@ -65,6 +129,7 @@ type ChatCompletionResponse struct {
 	ID      string `json:"id"`
 	Object  string `json:"object"`
 	Created int64  `json:"created"`
+	Model   string `json:"model"`
 	Choices []struct {
 		Message      ChatMessage `json:"message"`
 		FinishReason string      `json:"finish_reason"`
@@ -74,11 +139,77 @@ type ChatCompletionResponse struct {
 		CompletionTokens int `json:"completion_tokens"`
 		TotalTokens      int `json:"total_tokens"`
 	} `json:"usage"`
+
+	// ServedBy is the requested model that produced this response, which
+	// differs from the request when a fallback model was used
+	ServedBy string `json:"served_by,omitempty"`
+}
+
+// APIError is returned when the API responds with a non-200 status
+type APIError struct {
+	StatusCode int
+	Body       string
+
+	retryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API request failed with status %d: %s", e.StatusCode, e.Body)
+}
+
+// RetryAfter is the delay the API asked for in its Retry-After header, zero
+// if it didn't say
+func (e *APIError) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+// retryable reports whether the request may succeed if sent again
+func (e *APIError) retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError
+}
+
+// IsOverloaded reports whether err is a rate limit or service unavailable
+// response from the API
+func IsOverloaded(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.StatusCode == http.StatusTooManyRequests ||
+		apiErr.StatusCode == http.StatusServiceUnavailable
+}
+
+// ValidateAPIKey checks that key looks like an OpenAI API key, catching
+// placeholders and copy-paste mistakes before the first request
+func ValidateAPIKey(key string) error {
+	if key == "" {
+		return fmt.Errorf("API key is required")
+	}
+	if !strings.HasPrefix(key, "sk-") {
+		return fmt.Errorf("API key must start with \"sk-\"")
+	}
+	if len(key) < 20 {
+		return fmt.Errorf("API key is too short")
+	}
+	if strings.ContainsAny(key, " \t\r\n") {
+		return fmt.Errorf("API key contains whitespace")
+	}
+	return nil
 }
 
 // NewClient creates a new OpenAI client
 func NewClient(config *ClientConfig) (*Client, error) {
-	if config.APIKey == "" {
+	var keys []KeyConfig
+	if config.APIKey != "" {
+		keys = append(keys, KeyConfig{Key: config.APIKey})
+	}
+	for _, key := range config.APIKeys {
+		if key.Key == "" {
+			return nil, fmt.Errorf("API keys can't be empty")
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
 		return nil, fmt.Errorf("API key is required")
 	}
 
@@ -92,35 +223,213 @@ func NewClient(config *ClientConfig) (*Client, error) {
 		timeout = defaultTimeout
 	}
 
+	transport, err := newTransport(config)
+	if err != nil {
+		return nil, err
+	}
+
+	limiter := config.Limiter
+	if limiter == nil {
+		maxConcurrency := config.MaxConcurrency
+		if maxConcurrency <= 0 {
+			maxConcurrency = DefaultMaxConcurrency
+		}
+		limiter = utils.NewConcurrencyLimiter(int64(maxConcurrency))
+	}
+
+	retry := utils.RetryPolicy{
+		MaxRetries: config.MaxRetries,
+		Delay:      config.RetryDelay,
+		MaxDelay:   config.MaxRetryDelay,
+		Clock:      config.Clock,
+	}
+	if retry.Delay <= 0 {
+		retry.Delay = DefaultRetryDelay
+	}
+	if retry.MaxDelay <= 0 {
+		retry.MaxDelay = DefaultMaxRetryDelay
+	}
+	if retry.Clock == nil {
+		retry.Clock = utils.SystemClock{}
+	}
+
 	return &Client{
-		apiKey:  config.APIKey,
+		keys:    newKeyPool(keys, config.KeyCooldown, retry.Clock),
 		baseURL: baseURL,
 		httpClient: &http.Client{
-			Timeout: timeout,
+			Transport: transport,
 		},
-		logger:  utils.NewLogger(),
-		metrics: &Metrics{},
+		timeout:   timeout,
+		logger:    utils.NewLogger(),
+		metrics:   &Metrics{},
+		fallbacks: config.FallbackModels,
+		limiter:   limiter,
+		retry:     retry,
 	}, nil
 }
 
-// CreateChatCompletion sends a chat completion request
+// newTransport builds the HTTP transport from the proxy and TLS settings,
+// returning nil to use the default transport when none are set
+func newTransport(config *ClientConfig) (http.RoundTripper, error) {
+	if config.Transport != nil {
+		if config.ProxyURL != "" || config.TLSConfig != nil {
+			return nil, fmt.Errorf("transport can't be combined with proxy URL or TLS config")
+		}
+		return config.Transport, nil
+	}
+	if config.ProxyURL == "" && config.TLSConfig == nil {
+		return nil, nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.ProxyURL != "" {
+		proxy, err := url.Parse(config.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		switch proxy.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme %q", proxy.Scheme)
+		}
+		if proxy.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL: missing host")
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	if config.TLSConfig != nil {
+		transport.TLSClientConfig = config.TLSConfig.Clone()
+	}
+	return transport, nil
+}
+
+// CreateChatCompletions sends a batch of chat completion requests
+// concurrently, bounded by the client's limiter. The responses and errors
+// are indexed like reqs, with exactly one of the two set for each request.
+func (c *Client) CreateChatCompletions(ctx context.Context, reqs []*ChatCompletionRequest) ([]*ChatCompletionResponse, []error) {
+	responses := make([]*ChatCompletionResponse, len(reqs))
+	errs := make([]error, len(reqs))
+
+	var wg sync.WaitGroup
+	for i, req := range reqs {
+		wg.Add(1)
+		go func(i int, req *ChatCompletionRequest) {
+			defer wg.Done()
+
+			if err := c.limiter.Acquire(ctx, 1); err != nil {
+				errs[i] = err
+				return
+			}
+			defer c.limiter.Release(1)
+
+			responses[i], errs[i] = c.CreateChatCompletion(ctx, req)
+		}(i, req)
+	}
+	wg.Wait()
+
+	return responses, errs
+}
+
+// CreateChatCompletion sends a chat completion request. If the requested
+// model is overloaded and fallback models are configured, each is tried in
+// turn and the one that served the request is recorded in ServedBy. The
+// call is bounded by the client timeout or the remaining budget of ctx.
 func (c *Client) CreateChatCompletion(ctx context.Context, req *ChatCompletionRequest) (*ChatCompletionResponse, error) {
 	startTime := time.Now()
 	defer c.updateMetrics(startTime)
 
-	url := fmt.Sprintf("%s/chat/completions", c.baseURL)
+	ctx, cancel := utils.WithCallTimeout(ctx, c.timeout)
+	defer cancel()
+
+	result, err := c.sendChatCompletion(ctx, req)
+	if err == nil || !IsOverloaded(err) {
+		return result, err
+	}
+
+	for _, model := range c.fallbacks {
+		if model == req.Model {
+			continue
+		}
+
+		c.logger.Warn("Model overloaded, trying fallback",
+			"model", req.Model,
+			"fallback", model,
+			"error", err.Error(),
+		)
+		c.incrementFallbackCount()
+
+		fallbackReq := *req
+		fallbackReq.Model = model
+		result, err = c.sendChatCompletion(ctx, &fallbackReq)
+		if err == nil || !IsOverloaded(err) {
+			return result, err
+		}
+	}
+
+	return nil, err
+}
+
+// sendChatCompletion sends a chat completion request for req.Model,
+// retrying rate limits, server errors and failed connections up to
+// MaxRetries times. A Retry-After header on the response is honored when
+// it asks for longer than the backoff, up to MaxRetryDelay.
+func (c *Client) sendChatCompletion(ctx context.Context, req *ChatCompletionRequest) (*ChatCompletionResponse, error) {
 	body, err := json.Marshal(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
+	var result *ChatCompletionResponse
+	attempts := 0
+	err = utils.Retry(ctx, c.retry, func() error {
+		attempts++
+		var err error
+		result, err = c.doChatCompletion(ctx, req.Model, body)
+		if err == nil {
+			return nil
+		}
+
+		var apiErr *APIError
+		switch {
+		case ctx.Err() != nil:
+			return utils.Permanent(err)
+		case errors.As(err, &apiErr) && !apiErr.retryable():
+			return utils.Permanent(err)
+		case errors.Is(err, errDecode):
+			return utils.Permanent(err)
+		}
+
+		if attempts <= c.retry.MaxRetries {
+			c.logger.Warn("Chat completion failed, retrying",
+				"model", req.Model,
+				"attempt", attempts,
+				"error", err.Error(),
+			)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// errDecode marks a response that couldn't be decoded, which resending
+// won't fix
+var errDecode = errors.New("failed to decode response")
+
+// doChatCompletion makes a single attempt at a chat completion request
+func (c *Client) doChatCompletion(ctx context.Context, model string, body []byte) (*ChatCompletionResponse, error) {
+	url := fmt.Sprintf("%s/chat/completions", c.baseURL)
+
 	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.apiKey))
+	key := c.keys.next()
+	httpReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", key.key))
 
 	resp, err := c.httpClient.Do(httpReq)
 	if err != nil {
@@ -132,30 +441,60 @@ func (c *Client) CreateChatCompletion(ctx context.Context, req *ChatCompletionRe
 	if resp.StatusCode != http.StatusOK {
 		c.incrementErrorCount()
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+		apiErr := &APIError{
+			StatusCode: resp.StatusCode,
+			Body:       string(body),
+			retryAfter: utils.ParseRetryAfter(resp.Header.Get("Retry-After"), c.retry.Clock.Now()),
+		}
+
+		// The key sits out its Retry-After, so a retry with another key
+		// needn't wait for it
+		if resp.StatusCode == http.StatusTooManyRequests && c.keys.rateLimited(key, apiErr.retryAfter) {
+			apiErr.retryAfter = 0
+		}
+		return nil, apiErr
 	}
 
 	var result ChatCompletionResponse
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+		return nil, fmt.Errorf("%w: %v", errDecode, err)
 	}
 
+	result.ServedBy = model
 	c.updateTokenUsage(result.Usage.TotalTokens)
 	return &result, nil
 }
 
-// GetMetrics returns the current metrics
+// GetMetrics returns a snapshot of the current metrics
 func (c *Client) GetMetrics() Metrics {
 	c.metrics.mu.RLock()
 	defer c.metrics.mu.RUnlock()
-	return *c.metrics
+
+	return Metrics{
+		RequestCount:   c.metrics.RequestCount,
+		TokensUsed:     c.metrics.TokensUsed,
+		ErrorCount:     c.metrics.ErrorCount,
+		AverageLatency: c.metrics.AverageLatency,
+		LastRequest:    c.metrics.LastRequest,
+		FallbackCount:  c.metrics.FallbackCount,
+		Keys:           c.keys.metrics(),
+		Batch:          c.limiter.Stats(),
+	}
 }
 
-// ResetMetrics resets all metrics to zero
+// ResetMetrics resets all metrics to zero. The fields are cleared in place
+// so the mutex guarding them is never replaced while in use.
 func (c *Client) ResetMetrics() {
 	c.metrics.mu.Lock()
 	defer c.metrics.mu.Unlock()
-	c.metrics = &Metrics{}
+
+	c.metrics.RequestCount = 0
+	c.metrics.TokensUsed = 0
+	c.metrics.ErrorCount = 0
+	c.metrics.AverageLatency = 0
+	c.metrics.LastRequest = time.Time{}
+	c.metrics.FallbackCount = 0
+	c.keys.reset()
 }
 
 func (c *Client) updateMetrics(startTime time.Time) {
@@ -179,6 +518,12 @@ func (c *Client) updateTokenUsage(tokens int) {
 	c.metrics.TokensUsed += int64(tokens)
 }
 
+func (c *Client) incrementFallbackCount() {
+	c.metrics.mu.Lock()
+	defer c.metrics.mu.Unlock()
+	c.metrics.FallbackCount++
+}
+
 func (c *Client) incrementErrorCount() {
 	c.metrics.mu.Lock()
 	defer c.metrics.mu.Unlock()
@@ -0,0 +1,163 @@
+package openai
+
+import (
+	"sync"
+	"time"
+
+	"github.com/labs-alone/alone-main/internal/utils"
+)
+
+// DefaultKeyCooldown is how long a rate-limited key is skipped when the API
+// doesn't send a Retry-After and ClientConfig.KeyCooldown is unset
+const DefaultKeyCooldown = time.Minute
+
+// KeyConfig is one of several API keys requests are spread across
+type KeyConfig struct {
+	Key string
+	// Weight is the key's share of requests relative to the other keys,
+	// defaulting to 1
+	Weight int
+}
+
+// KeyMetrics is the usage of a single API key
+type KeyMetrics struct {
+	// Key is masked to its last four characters
+	Key         string
+	Weight      int
+	Requests    int64
+	RateLimited int64
+	// CooldownUntil is when a rate-limited key is used again, zero if it
+	// isn't cooling down
+	CooldownUntil time.Time
+}
+
+// apiKey is a key in the pool with its usage and scheduling state
+type apiKey struct {
+	key           string
+	weight        int
+	current       int
+	requests      int64
+	rateLimited   int64
+	cooldownUntil time.Time
+}
+
+// keyPool hands out API keys by smooth weighted round-robin, so each key
+// gets its share of requests evenly interleaved. Keys that hit a rate limit
+// are skipped until their cooldown ends.
+type keyPool struct {
+	keys     []*apiKey
+	cooldown time.Duration
+	clock    utils.Clock
+	mu       sync.Mutex
+}
+
+func newKeyPool(configs []KeyConfig, cooldown time.Duration, clock utils.Clock) *keyPool {
+	if cooldown <= 0 {
+		cooldown = DefaultKeyCooldown
+	}
+
+	pool := &keyPool{cooldown: cooldown, clock: clock}
+	for _, config := range configs {
+		weight := config.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		pool.keys = append(pool.keys, &apiKey{key: config.Key, weight: weight})
+	}
+	return pool
+}
+
+// next picks the key for a request. If every key is cooling down, the one
+// available soonest is used rather than failing the request outright.
+func (p *keyPool) next() *apiKey {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.clock.Now()
+	var best *apiKey
+	total := 0
+	for _, k := range p.keys {
+		if now.Before(k.cooldownUntil) {
+			continue
+		}
+		k.current += k.weight
+		total += k.weight
+		if best == nil || k.current > best.current {
+			best = k
+		}
+	}
+
+	if best != nil {
+		best.current -= total
+	} else {
+		for _, k := range p.keys {
+			if best == nil || k.cooldownUntil.Before(best.cooldownUntil) {
+				best = k
+			}
+		}
+	}
+
+	best.requests++
+	return best
+}
+
+// rateLimited cools key down for retryAfter, or the pool's cooldown if the
+// API didn't say, and reports whether another key can be used meanwhile
+func (p *keyPool) rateLimited(key *apiKey, retryAfter time.Duration) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if retryAfter <= 0 {
+		retryAfter = p.cooldown
+	}
+	now := p.clock.Now()
+	key.rateLimited++
+	key.cooldownUntil = now.Add(retryAfter)
+
+	for _, k := range p.keys {
+		if !now.Before(k.cooldownUntil) {
+			return true
+		}
+	}
+	return false
+}
+
+// metrics returns the usage of each key in the order configured
+func (p *keyPool) metrics() []KeyMetrics {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.clock.Now()
+	metrics := make([]KeyMetrics, len(p.keys))
+	for i, k := range p.keys {
+		metrics[i] = KeyMetrics{
+			Key:         maskKey(k.key),
+			Weight:      k.weight,
+			Requests:    k.requests,
+			RateLimited: k.rateLimited,
+		}
+		if now.Before(k.cooldownUntil) {
+			metrics[i].CooldownUntil = k.cooldownUntil
+		}
+	}
+	return metrics
+}
+
+// reset clears the usage counters, leaving cooldowns in place
+func (p *keyPool) reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, k := range p.keys {
+		k.requests = 0
+		k.rateLimited = 0
+	}
+}
+
+// maskKey hides all but the last four characters of key
+func maskKey(key string) string {
+	if len(key) <= 4 {
+		return "****"
+	}
+	return "..." + key[len(key)-4:]
+}
@@ -1,39 +1,96 @@
 package openai
 
 import (
+	"container/list"
+	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/labs-alone/alone-main/internal/utils"
+	"github.com/labs-alone/alone-main/pkg/logger"
 )
 
 // PromptManager handles prompt construction and management
 type PromptManager struct {
-	templates    map[string]string
+	templates    map[string]PromptTemplate
 	cache        *PromptCache
-	logger       *utils.Logger
+	logger       logger.Logger
 	maxTokens    int
 	temperature  float32
+	systemPrompt string
 	mu           sync.RWMutex
+
+	stats   map[string]*TemplateStats
+	statsMu sync.Mutex
+
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
-// PromptCache provides caching for generated prompts
+// TemplateStats counts how a template has been used since the manager was
+// created
+type TemplateStats struct {
+	// Generations counts prompts returned, including those from the cache
+	Generations int64 `json:"generations"`
+	CacheHits   int64 `json:"cache_hits"`
+	CacheMisses int64 `json:"cache_misses"`
+	// AverageVariables is the mean number of variables provided per
+	// generation
+	AverageVariables float64   `json:"average_variables"`
+	LastUsed         time.Time `json:"last_used"`
+
+	totalVariables int64
+}
+
+const (
+	// DefaultPromptCacheSize caps the prompt cache unless SetCacheSize is
+	// called
+	DefaultPromptCacheSize = 1000
+	// promptCacheCleanInterval is how often expired prompts are removed
+	promptCacheCleanInterval = 5 * time.Minute
+
+	// DefaultSystemPrompt is the system prompt used when neither the call,
+	// the template nor the manager sets one
+	DefaultSystemPrompt = "You are a helpful assistant."
+	// SystemPromptMetadataKey is the PromptTemplate.Metadata key holding
+	// the template's system prompt
+	SystemPromptMetadataKey = "system_prompt"
+)
+
+// PromptCache provides caching for generated prompts, evicting the least
+// recently used entry once it holds maxSize prompts
 type PromptCache struct {
-	items map[string]PromptCacheItem
-	mu    sync.RWMutex
+	items     map[string]*list.Element
+	order     *list.List // front is most recently used
+	maxSize   int
+	hits      int64
+	misses    int64
+	evictions int64
+	mu        sync.Mutex
 }
 
 // PromptCacheItem represents a cached prompt
 type PromptCacheItem struct {
+	key       string
 	prompt    string
 	messages  []ChatMessage
 	created   time.Time
 	expiresAt time.Time
 }
 
+// CacheStats describes the prompt cache
+type CacheStats struct {
+	Size      int   `json:"size"`
+	MaxSize   int   `json:"max_size"`
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Evictions int64 `json:"evictions"`
+}
+
 // PromptTemplate represents a structured prompt template
 type PromptTemplate struct {
 	Name        string            `json:"name"`
@@ -51,22 +108,61 @@ type PromptOptions struct {
 	Temperature  float32
 	UseCache     bool
 	CacheTTL     time.Duration
+	// SystemPrompt overrides the template's and the manager's system prompt
+	// when set
 	SystemPrompt string
+	// StrictVariables makes generation fail when the template references a
+	// variable that was not provided, instead of leaving the token untouched.
+	StrictVariables bool
 }
 
-// NewPromptManager creates a new prompt manager
-func NewPromptManager() *PromptManager {
-	return &PromptManager{
-		templates: make(map[string]string),
+// NewPromptManager creates a new prompt manager. It cleans expired prompts
+// from the cache in the background until ctx is done or Close is called.
+func NewPromptManager(ctx context.Context) *PromptManager {
+	pm := &PromptManager{
+		templates: make(map[string]PromptTemplate),
 		cache: &PromptCache{
-			items: make(map[string]PromptCacheItem),
+			items:   make(map[string]*list.Element),
+			order:   list.New(),
+			maxSize: DefaultPromptCacheSize,
 		},
 		logger:      utils.NewLogger(),
 		maxTokens:   2000,
 		temperature: 0.7,
+		stats:       make(map[string]*TemplateStats),
+		done:        make(chan struct{}),
+	}
+
+	go pm.cleanLoop(ctx, promptCacheCleanInterval)
+	return pm
+}
+
+// cleanLoop runs CleanCache every interval until ctx is done or Close
+func (pm *PromptManager) cleanLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			pm.CleanCache()
+		case <-pm.done:
+			return
+		case <-ctx.Done():
+			return
+		}
 	}
 }
 
+// Close stops the background cache cleanup. It is safe to call more than
+// once.
+func (pm *PromptManager) Close() error {
+	pm.closeOnce.Do(func() {
+		close(pm.done)
+	})
+	return nil
+}
+
 // AddTemplate adds a new prompt template
 func (pm *PromptManager) AddTemplate(name, template string) error {
 	pm.mu.Lock()
@@ -76,30 +172,78 @@ func (pm *PromptManager) AddTemplate(name, template string) error {
 		return fmt.Errorf("name and template are required")
 	}
 
-	pm.templates[name] = template
-	pm.logger.Info("Added template:", name)
+	pm.templates[name] = PromptTemplate{Name: name, Template: template}
+	pm.logger.Info("Added template", "name", name)
 	return nil
 }
 
-// LoadTemplates loads templates from JSON
+// LoadTemplates loads templates from JSON, adding them to the current set
+// and replacing any with the same name. The templates are validated first
+// and the set is swapped in one step, so a load that fails leaves the
+// existing templates untouched. Validation failures are returned as
+// TemplateErrors.
 func (pm *PromptManager) LoadTemplates(data []byte) error {
 	var templates []PromptTemplate
 	if err := json.Unmarshal(data, &templates); err != nil {
 		return fmt.Errorf("failed to unmarshal templates: %w", err)
 	}
 
-	pm.mu.Lock()
-	defer pm.mu.Unlock()
+	var errs TemplateErrors
+	for i, tmpl := range templates {
+		if err := ValidateTemplate(tmpl); err != nil {
+			errs = append(errs, TemplateError{Index: i, Name: tmpl.Name, Message: err.Error()})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
 
+	pm.mu.Lock()
+	merged := make(map[string]PromptTemplate, len(pm.templates)+len(templates))
+	for name, tmpl := range pm.templates {
+		merged[name] = tmpl
+	}
 	for _, tmpl := range templates {
-		pm.templates[tmpl.Name] = tmpl.Template
+		merged[tmpl.Name] = tmpl
 	}
+	pm.templates = merged
+	pm.mu.Unlock()
 
-	pm.logger.Info("Loaded templates:", len(templates))
+	// Cached prompts may come from templates that were just replaced
+	pm.ClearCache()
+	pm.logger.Info("Loaded templates", "count", len(templates))
 	return nil
 }
 
-// GeneratePrompt creates a prompt from a template
+// SetSystemPrompt sets the system prompt for templates that don't set their
+// own under SystemPromptMetadataKey. An empty prompt restores
+// DefaultSystemPrompt.
+func (pm *PromptManager) SetSystemPrompt(prompt string) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	pm.systemPrompt = prompt
+}
+
+// systemPromptFor picks the system prompt for tmpl: the template's own,
+// then the manager's, then DefaultSystemPrompt
+func (pm *PromptManager) systemPromptFor(tmpl PromptTemplate) string {
+	if prompt := tmpl.Metadata[SystemPromptMetadataKey]; prompt != "" {
+		return prompt
+	}
+
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	if pm.systemPrompt != "" {
+		return pm.systemPrompt
+	}
+	return DefaultSystemPrompt
+}
+
+// GeneratePrompt creates a prompt from a template. variables may be nil for
+// templates without placeholders. Cached prompts are keyed on the variables
+// and on the options that shape the result. Without opts.SystemPrompt the
+// system prompt comes from the template's metadata, then the manager.
 func (pm *PromptManager) GeneratePrompt(
 	templateName string,
 	variables map[string]string,
@@ -107,28 +251,39 @@ func (pm *PromptManager) GeneratePrompt(
 ) ([]ChatMessage, error) {
 	if opts == nil {
 		opts = &PromptOptions{
-			MaxTokens:    pm.maxTokens,
-			Temperature:  pm.temperature,
-			UseCache:     true,
-			CacheTTL:     time.Hour,
-			SystemPrompt: "You are a helpful assistant.",
+			MaxTokens:   pm.maxTokens,
+			Temperature: pm.temperature,
+			UseCache:    true,
+			CacheTTL:    time.Hour,
 		}
 	}
 
+	template, err := pm.getTemplate(templateName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Resolved before the cache lookup so the key holds the prompt used
+	if opts.SystemPrompt == "" {
+		resolved := *opts
+		resolved.SystemPrompt = pm.systemPromptFor(template)
+		opts = &resolved
+	}
+
 	// Check cache if enabled
+	key := pm.getCacheKey(templateName, variables, opts)
 	if opts.UseCache {
-		if cached, ok := pm.getFromCache(templateName, variables); ok {
+		if cached, ok := pm.getFromCache(key); ok {
+			pm.recordUsage(templateName, len(variables), opts.UseCache, true)
 			return cached, nil
 		}
 	}
 
-	template, err := pm.getTemplate(templateName)
+	prompt, err := pm.interpolateTemplate(template.Template, variables, opts.StrictVariables)
 	if err != nil {
 		return nil, err
 	}
 
-	prompt := pm.interpolateTemplate(template, variables)
-
 	messages := []ChatMessage{
 		{
 			Role:    "system",
@@ -142,12 +297,50 @@ func (pm *PromptManager) GeneratePrompt(
 
 	// Cache the result if enabled
 	if opts.UseCache {
-		pm.cachePrompt(templateName, variables, messages, opts.CacheTTL)
+		pm.cachePrompt(key, messages, opts.CacheTTL)
 	}
 
+	pm.recordUsage(templateName, len(variables), opts.UseCache, false)
 	return messages, nil
 }
 
+// recordUsage counts a successful generation. Failed generations aren't
+// counted, so unknown template names never appear in Stats.
+func (pm *PromptManager) recordUsage(name string, variables int, cached, hit bool) {
+	pm.statsMu.Lock()
+	defer pm.statsMu.Unlock()
+
+	stats, ok := pm.stats[name]
+	if !ok {
+		stats = &TemplateStats{}
+		pm.stats[name] = stats
+	}
+
+	stats.Generations++
+	if cached {
+		if hit {
+			stats.CacheHits++
+		} else {
+			stats.CacheMisses++
+		}
+	}
+	stats.totalVariables += int64(variables)
+	stats.AverageVariables = float64(stats.totalVariables) / float64(stats.Generations)
+	stats.LastUsed = time.Now()
+}
+
+// Stats returns usage counters for each template that has been generated
+func (pm *PromptManager) Stats() map[string]TemplateStats {
+	pm.statsMu.Lock()
+	defer pm.statsMu.Unlock()
+
+	stats := make(map[string]TemplateStats, len(pm.stats))
+	for name, s := range pm.stats {
+		stats[name] = *s
+	}
+	return stats
+}
+
 // GenerateCodePrompt creates a prompt specifically for code-related queries
 func (pm *PromptManager) GenerateCodePrompt(
 	language string,
@@ -184,77 +377,315 @@ func (pm *PromptManager) GenerateCodePrompt(
 }
 
 // GetTemplate retrieves a template
-func (pm *PromptManager) getTemplate(name string) (string, error) {
+func (pm *PromptManager) getTemplate(name string) (PromptTemplate, error) {
 	pm.mu.RLock()
 	defer pm.mu.RUnlock()
 
 	template, ok := pm.templates[name]
 	if !ok {
-		return "", fmt.Errorf("template not found: %s", name)
+		return PromptTemplate{}, fmt.Errorf("template not found: %s", name)
 	}
 
 	return template, nil
 }
 
-// interpolateTemplate replaces variables in template
+// Templates returns all templates sorted by name
+func (pm *PromptManager) Templates() []PromptTemplate {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	templates := make([]PromptTemplate, 0, len(pm.templates))
+	for _, tmpl := range pm.templates {
+		templates = append(templates, tmpl)
+	}
+
+	sort.Slice(templates, func(i, j int) bool {
+		return templates[i].Name < templates[j].Name
+	})
+	return templates
+}
+
+// ReplaceTemplates validates templates and, only if all are valid, replaces
+// the whole template set with them and clears the prompt cache. Validation
+// failures are returned as TemplateErrors.
+func (pm *PromptManager) ReplaceTemplates(templates []PromptTemplate) error {
+	var errs TemplateErrors
+	replacement := make(map[string]PromptTemplate, len(templates))
+
+	for i, tmpl := range templates {
+		if err := ValidateTemplate(tmpl); err != nil {
+			errs = append(errs, TemplateError{Index: i, Name: tmpl.Name, Message: err.Error()})
+			continue
+		}
+		if _, exists := replacement[tmpl.Name]; exists {
+			errs = append(errs, TemplateError{Index: i, Name: tmpl.Name, Message: "duplicate template name"})
+			continue
+		}
+		replacement[tmpl.Name] = tmpl
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	pm.mu.Lock()
+	pm.templates = replacement
+	pm.mu.Unlock()
+
+	pm.ClearCache()
+	pm.logger.Info("Replaced templates", "count", len(replacement))
+	return nil
+}
+
+// TemplateError describes why a template was rejected
+type TemplateError struct {
+	Index   int    `json:"index"`
+	Name    string `json:"name"`
+	Message string `json:"message"`
+}
+
+// TemplateErrors lists every rejected template in an import
+type TemplateErrors []TemplateError
+
+func (e TemplateErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = fmt.Sprintf("template %d (%s): %s", err.Index, err.Name, err.Message)
+	}
+	return "invalid templates: " + strings.Join(msgs, "; ")
+}
+
+// ValidateTemplate checks that a template has a name and body, that its
+// placeholders are well formed, and that they match Variables when declared
+func ValidateTemplate(tmpl PromptTemplate) error {
+	if tmpl.Name == "" || tmpl.Template == "" {
+		return fmt.Errorf("name and template are required")
+	}
+	if tmpl.MaxTokens < 0 {
+		return fmt.Errorf("max_tokens cannot be negative")
+	}
+	if tmpl.Temperature < 0 || tmpl.Temperature > 2 {
+		return fmt.Errorf("temperature must be between 0 and 2")
+	}
+
+	used, err := templateVariables(tmpl.Template)
+	if err != nil {
+		return err
+	}
+
+	if len(tmpl.Variables) > 0 {
+		declared := make(map[string]bool, len(tmpl.Variables))
+		for _, v := range tmpl.Variables {
+			declared[v] = true
+		}
+		for _, v := range used {
+			if !declared[v] {
+				return fmt.Errorf("undeclared variable: %s", v)
+			}
+		}
+	}
+
+	return nil
+}
+
+// templateVariables returns the variable names referenced by {{name}}
+// tokens, in order of first use
+func templateVariables(template string) ([]string, error) {
+	var names []string
+	seen := make(map[string]bool)
+
+	rest := template
+	for {
+		start := strings.Index(rest, "{{")
+		if start < 0 {
+			return names, nil
+		}
+
+		end := strings.Index(rest[start+2:], "}}")
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed placeholder at %q", rest[start:])
+		}
+		end += start + 2
+
+		key := rest[start+2 : end]
+		if strings.Contains(key, "{") {
+			// Skip a literal brace, as interpolateTemplate does
+			rest = rest[start+1:]
+			continue
+		}
+		if key == "" || strings.ContainsAny(key, " \t\n") {
+			return nil, fmt.Errorf("invalid placeholder {{%s}}", key)
+		}
+
+		if !seen[key] {
+			seen[key] = true
+			names = append(names, key)
+		}
+		rest = rest[end+2:]
+	}
+}
+
+// interpolateTemplate replaces {{name}} tokens in a single pass over the
+// template. Tokens without a matching variable are left untouched, or
+// reported as an error when strict is set.
 func (pm *PromptManager) interpolateTemplate(
 	template string,
 	variables map[string]string,
-) string {
-	result := template
-	for key, value := range variables {
-		placeholder := fmt.Sprintf("{{%s}}", key)
-		result = strings.ReplaceAll(result, placeholder, value)
+	strict bool,
+) (string, error) {
+	var result strings.Builder
+	result.Grow(len(template))
+
+	rest := template
+	for {
+		start := strings.Index(rest, "{{")
+		if start < 0 {
+			break
+		}
+
+		end := strings.Index(rest[start+2:], "}}")
+		if end < 0 {
+			break
+		}
+		end += start + 2
+
+		key := rest[start+2 : end]
+		if strings.Contains(key, "{") {
+			// Not a token on its own, e.g. "{{{name}}}"; move past one brace
+			// so the inner token is picked up on the next iteration.
+			result.WriteString(rest[:start+1])
+			rest = rest[start+1:]
+			continue
+		}
+
+		result.WriteString(rest[:start])
+		if value, ok := variables[key]; ok {
+			result.WriteString(value)
+		} else if strict {
+			return "", fmt.Errorf("missing template variable: %s", key)
+		} else {
+			result.WriteString(rest[start : end+2])
+		}
+		rest = rest[end+2:]
 	}
-	return result
+
+	result.WriteString(rest)
+	return result.String(), nil
 }
 
 // Cache operations
-func (pm *PromptManager) getFromCache(
-	templateName string,
-	variables map[string]string,
-) ([]ChatMessage, bool) {
-	key := pm.getCacheKey(templateName, variables)
-
-	pm.cache.mu.RLock()
-	defer pm.cache.mu.RUnlock()
+func (pm *PromptManager) getFromCache(key string) ([]ChatMessage, bool) {
+	pm.cache.mu.Lock()
+	defer pm.cache.mu.Unlock()
 
-	if item, ok := pm.cache.items[key]; ok {
+	if elem, ok := pm.cache.items[key]; ok {
+		item := elem.Value.(*PromptCacheItem)
 		if time.Now().Before(item.expiresAt) {
+			pm.cache.order.MoveToFront(elem)
+			pm.cache.hits++
 			return item.messages, true
 		}
+		pm.cache.remove(elem)
 	}
 
+	pm.cache.misses++
 	return nil, false
 }
 
 func (pm *PromptManager) cachePrompt(
-	templateName string,
-	variables map[string]string,
+	key string,
 	messages []ChatMessage,
 	ttl time.Duration,
 ) {
-	key := pm.getCacheKey(templateName, variables)
-
 	pm.cache.mu.Lock()
 	defer pm.cache.mu.Unlock()
 
-	pm.cache.items[key] = PromptCacheItem{
+	now := time.Now()
+	item := &PromptCacheItem{
+		key:       key,
 		messages:  messages,
-		created:   time.Now(),
-		expiresAt: time.Now().Add(ttl),
+		created:   now,
+		expiresAt: now.Add(ttl),
+	}
+
+	if elem, ok := pm.cache.items[key]; ok {
+		elem.Value = item
+		pm.cache.order.MoveToFront(elem)
+		return
+	}
+
+	pm.cache.items[key] = pm.cache.order.PushFront(item)
+	pm.cache.evict()
+}
+
+// evict removes least recently used entries until the cache fits maxSize.
+// The caller must hold mu.
+func (c *PromptCache) evict() {
+	for c.maxSize > 0 && c.order.Len() > c.maxSize {
+		c.remove(c.order.Back())
+		c.evictions++
+	}
+}
+
+// remove deletes elem from the cache. The caller must hold mu.
+func (c *PromptCache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.items, elem.Value.(*PromptCacheItem).key)
+}
+
+// SetCacheSize caps the number of cached prompts, evicting the least
+// recently used ones if the cache is already larger. Zero or less removes
+// the cap.
+func (pm *PromptManager) SetCacheSize(maxSize int) {
+	pm.cache.mu.Lock()
+	defer pm.cache.mu.Unlock()
+
+	pm.cache.maxSize = maxSize
+	pm.cache.evict()
+}
+
+// CacheStats returns the prompt cache size and counters
+func (pm *PromptManager) CacheStats() CacheStats {
+	pm.cache.mu.Lock()
+	defer pm.cache.mu.Unlock()
+
+	return CacheStats{
+		Size:      pm.cache.order.Len(),
+		MaxSize:   pm.cache.maxSize,
+		Hits:      pm.cache.hits,
+		Misses:    pm.cache.misses,
+		Evictions: pm.cache.evictions,
 	}
 }
 
+// getCacheKey identifies a generation by its template, variables and the
+// options that affect the result. Variables are encoded in sorted order and
+// nil and empty variable maps share a key.
 func (pm *PromptManager) getCacheKey(
 	templateName string,
 	variables map[string]string,
+	opts *PromptOptions,
 ) string {
-	parts := []string{templateName}
-	for k, v := range variables {
-		parts = append(parts, fmt.Sprintf("%s=%s", k, v))
+	if len(variables) == 0 {
+		variables = nil
 	}
-	return strings.Join(parts, "|")
+
+	key, _ := json.Marshal(struct {
+		Template        string            `json:"t"`
+		Variables       map[string]string `json:"v"`
+		SystemPrompt    string            `json:"s"`
+		Temperature     float32           `json:"temp"`
+		MaxTokens       int               `json:"max"`
+		StrictVariables bool              `json:"strict"`
+	}{
+		Template:        templateName,
+		Variables:       variables,
+		SystemPrompt:    opts.SystemPrompt,
+		Temperature:     opts.Temperature,
+		MaxTokens:       opts.MaxTokens,
+		StrictVariables: opts.StrictVariables,
+	})
+	return string(key)
 }
 
 // CleanCache removes expired cache entries
@@ -263,10 +694,12 @@ func (pm *PromptManager) CleanCache() {
 	defer pm.cache.mu.Unlock()
 
 	now := time.Now()
-	for key, item := range pm.cache.items {
-		if now.After(item.expiresAt) {
-			delete(pm.cache.items, key)
+	for elem := pm.cache.order.Front(); elem != nil; {
+		next := elem.Next()
+		if now.After(elem.Value.(*PromptCacheItem).expiresAt) {
+			pm.cache.remove(elem)
 		}
+		elem = next
 	}
 }
 
@@ -275,5 +708,6 @@ func (pm *PromptManager) ClearCache() {
 	pm.cache.mu.Lock()
 	defer pm.cache.mu.Unlock()
 
-	pm.cache.items = make(map[string]PromptCacheItem)
+	pm.cache.items = make(map[string]*list.Element)
+	pm.cache.order.Init()
 }
\ No newline at end of file
@@ -0,0 +1,99 @@
+package solana
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/gagliardetto/solana-go"
+)
+
+// ErrInvalidTransaction is returned for transfers that can't be built from
+// the given parameters and for submitted transactions that can't be decoded
+// or aren't fully signed
+var ErrInvalidTransaction = errors.New("invalid transaction")
+
+// UnsignedTransfer is a transfer built for a client to sign in its own wallet
+type UnsignedTransfer struct {
+	// IdempotencyKey is set for transfers built with PrepareTransfer
+	IdempotencyKey       string `json:"idempotency_key,omitempty"`
+	Transaction          string `json:"transaction"`
+	Blockhash            string `json:"blockhash"`
+	LastValidBlockHeight uint64 `json:"last_valid_block_height"`
+}
+
+// BuildUnsignedTransfer builds a SOL transfer with a fresh blockhash and
+// returns it base64 encoded without signing it
+func (c *Client) BuildUnsignedTransfer(ctx context.Context, from, to string, amount uint64) (*UnsignedTransfer, error) {
+	built, _, err := c.buildUnsignedTransfer(ctx, from, to, amount)
+	return built, err
+}
+
+// buildUnsignedTransfer is BuildUnsignedTransfer, also returning the
+// serialized message the client signs
+func (c *Client) buildUnsignedTransfer(ctx context.Context, from, to string, amount uint64) (*UnsignedTransfer, []byte, error) {
+	if amount == 0 {
+		return nil, nil, fmt.Errorf("%w: amount must be greater than zero", ErrInvalidTransaction)
+	}
+
+	tx, lastValid, err := c.BuildTransfer(ctx, from, to, amount)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	encoded, err := encodeTransaction(tx)
+	if err != nil {
+		return nil, nil, err
+	}
+	message, err := tx.Message.MarshalBinary()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to serialize message: %w", err)
+	}
+
+	return &UnsignedTransfer{
+		Transaction:          encoded,
+		Blockhash:            tx.Message.RecentBlockhash.String(),
+		LastValidBlockHeight: lastValid,
+	}, message, nil
+}
+
+// SubmitSignedTransaction decodes a base64 transaction signed by the client,
+// checks every required signature is present and valid, and sends it
+func (c *Client) SubmitSignedTransaction(ctx context.Context, encoded string) (string, error) {
+	tx, err := decodeSignedTransaction(encoded)
+	if err != nil {
+		return "", err
+	}
+
+	sig, err := c.sendTransaction(ctx, tx)
+	if err != nil {
+		return "", err
+	}
+
+	return sig.String(), nil
+}
+
+// decodeSignedTransaction decodes a base64 transaction and checks every
+// required signature is present and valid
+func decodeSignedTransaction(encoded string) (*solana.Transaction, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: transaction is not valid base64", ErrInvalidTransaction)
+	}
+
+	tx, err := solana.TransactionFromDecoder(solana.NewBinDecoder(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTransaction, err)
+	}
+
+	if len(tx.Signatures) != int(tx.Message.Header.NumRequiredSignatures) {
+		return nil, fmt.Errorf("%w: expected %d signatures, got %d",
+			ErrInvalidTransaction, tx.Message.Header.NumRequiredSignatures, len(tx.Signatures))
+	}
+	if err := tx.VerifySignatures(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTransaction, err)
+	}
+
+	return tx, nil
+}
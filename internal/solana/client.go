@@ -3,41 +3,119 @@ package solana
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gagliardetto/solana-go"
 	"github.com/gagliardetto/solana-go/rpc"
+	"github.com/gagliardetto/solana-go/rpc/jsonrpc"
 	"github.com/labs-alone/alone-main/internal/utils"
+	"github.com/labs-alone/alone-main/pkg/logger"
 )
 
 // ClientConfig holds the Solana client configuration
 type ClientConfig struct {
 	Endpoint    string        `json:"endpoint"`
+	// WsEndpoint is used for subscriptions, derived from Endpoint if empty
+	WsEndpoint  string        `json:"ws_endpoint"`
 	Commitment  string        `json:"commitment"`
+	// Timeout bounds each RPC call, retries included. Calls made with a
+	// context deadline get whatever is left of it if that is shorter.
 	Timeout     time.Duration `json:"timeout"`
 	MaxRetries  int          `json:"max_retries"`
 	Environment string        `json:"environment"`
+	// MaxResubmitAttempts caps how often an expired transfer is rebuilt
+	MaxResubmitAttempts int `json:"max_resubmit_attempts"`
+	// MaxSubscriptions caps concurrent websocket subscriptions, defaulting
+	// to DefaultMaxSubscriptions
+	MaxSubscriptions int `json:"max_subscriptions"`
+	// RetryDelay is the wait before the first of MaxRetries retries of a
+	// throttled or failed RPC call, doubled for each retry after
+	RetryDelay time.Duration `json:"retry_delay"`
+	// MaxRetryDelay caps the backoff and Retry-After delays; zero means no cap
+	MaxRetryDelay time.Duration `json:"max_retry_delay"`
+	// Clock times retry delays, defaulting to the system clock
+	Clock utils.Clock `json:"-"`
+	// MaxConcurrency caps the RPC calls batch methods run at once,
+	// defaulting to DefaultMaxConcurrency
+	MaxConcurrency int `json:"max_concurrency"`
+	// Limiter, if set, is used instead of a limiter sized by MaxConcurrency
+	// so the bound can be shared with other clients
+	Limiter *utils.ConcurrencyLimiter `json:"-"`
+	// Logger is used by the client and its wallets, defaulting to a console
+	// logger
+	Logger logger.Logger `json:"-"`
+	// ConfirmTimeout bounds how long SendAndConfirm waits for a sent
+	// transaction, defaulting to DefaultConfirmTimeout
+	ConfirmTimeout time.Duration `json:"confirm_timeout"`
+	// Programs restricts the programs the client's wallets sign for
+	Programs ProgramPolicy `json:"programs"`
+	// AuditLogger records refused signing requests, defaulting to Logger
+	// with an audit=true field
+	AuditLogger logger.Logger `json:"-"`
 }
 
+const (
+	// DefaultMaxSubscriptions is used when ClientConfig.MaxSubscriptions is
+	// unset
+	DefaultMaxSubscriptions = 100
+	// DefaultMaxConcurrency is used when ClientConfig.MaxConcurrency is unset
+	DefaultMaxConcurrency = 8
+)
+
+// Client errors
+var (
+	// ErrTooManySubscriptions is returned when the subscription limit is reached
+	ErrTooManySubscriptions = errors.New("too many subscriptions")
+	// ErrClientClosed is returned when subscribing on a closed client
+	ErrClientClosed = errors.New("client is closed")
+)
+
 // Client manages Solana blockchain interactions
 type Client struct {
 	config     *ClientConfig
 	rpcClient  *rpc.Client
-	wsClient   *rpc.WsClient
-	logger     *utils.Logger
+	wsClient   *rpc.WsClient // connected on first subscription
+	logger     logger.Logger
+	audit      logger.Logger
+	programs   *programFilter
 	cache      *sync.Map
 	subscriptions map[string]*Subscription
+	pendingSubs   int
+	transfers  *transferTracker
+	limiter    *utils.ConcurrencyLimiter // bounds batch RPC calls
+	closed     bool
+	closing    chan struct{} // closed by Close to abort in-flight requests
 	mu         sync.RWMutex
 }
 
+// ClientStatus reports the connection state and subscription usage
+type ClientStatus struct {
+	State            string `json:"state"`
+	Subscriptions    int    `json:"subscriptions"`
+	MaxSubscriptions int    `json:"max_subscriptions"`
+	// Batch is the usage of the limiter bounding batch RPC calls
+	Batch utils.LimiterStats `json:"batch"`
+}
+
 // Subscription represents a websocket subscription
 type Subscription struct {
 	ID       string
+	// Type is SubscriptionProgram or SubscriptionAccount, and picks the
+	// websocket calls used to unsubscribe and resubscribe
 	Type     string
-	Callback func(interface{}) error
-	Active   bool
+	// Target is the program or account subscribed to
+	Target    solana.PublicKey
+	// ServerID is the node's ID for the subscription on the current
+	// connection, used to unsubscribe
+	ServerID  uint64
+	Callback  func(interface{}) error
+	Active    bool
+	CreatedAt time.Time
 }
 
 // TransactionInfo holds processed transaction data
@@ -50,38 +128,97 @@ type TransactionInfo struct {
 	Metadata      map[string]interface{} `json:"metadata"`
 }
 
+// SignatureInfo is an entry in an address's transaction history
+type SignatureInfo struct {
+	Signature          string `json:"signature"`
+	Slot               uint64 `json:"slot"`
+	BlockTime          int64  `json:"block_time,omitempty"`
+	ConfirmationStatus string `json:"confirmation_status,omitempty"`
+	Failed             bool   `json:"failed"`
+	Memo               string `json:"memo,omitempty"`
+}
+
 // NewClient creates a new Solana client instance
 func NewClient(config *ClientConfig) (*Client, error) {
 	if config == nil {
 		config = &ClientConfig{
 			Endpoint:    rpc.DevnetRPCEndpoint,
-			Commitment:  rpc.CommitmentFinalized,
+			Commitment:  string(rpc.CommitmentFinalized),
 			Timeout:     time.Second * 30,
 			MaxRetries:  3,
 			Environment: "devnet",
 		}
 	}
 
-	rpcClient := rpc.New(config.Endpoint)
+	// Copy so defaulting doesn't modify the caller's config
+	cfg := *config
+	config = &cfg
+	if config.Commitment == "" {
+		config.Commitment = string(rpc.CommitmentFinalized)
+	}
+	if _, err := ParseCommitment(config.Commitment); err != nil {
+		return nil, err
+	}
+
+	limiter := config.Limiter
+	if limiter == nil {
+		maxConcurrency := config.MaxConcurrency
+		if maxConcurrency <= 0 {
+			maxConcurrency = DefaultMaxConcurrency
+		}
+		limiter = utils.NewConcurrencyLimiter(int64(maxConcurrency))
+	}
 
-	wsEndpoint := fmt.Sprintf("ws%s", config.Endpoint[4:])
-	wsClient, err := rpc.NewWsClient(wsEndpoint)
+	programs, err := newProgramFilter(config.Programs)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create websocket client: %w", err)
+		return nil, err
+	}
+
+	log := config.Logger
+	if log == nil {
+		log = utils.NewLogger()
+	}
+	audit := config.AuditLogger
+	if audit == nil {
+		audit = log.With("audit", true)
 	}
+	closing := make(chan struct{})
+	rpcClient := rpc.NewWithCustomRPCClient(jsonrpc.NewClientWithOpts(config.Endpoint, &jsonrpc.RPCClientOpts{
+		HTTPClient: &http.Client{Transport: newRetryTransport(config, closing, log)},
+	}))
 
 	return &Client{
 		config:        config,
 		rpcClient:     rpcClient,
-		wsClient:      wsClient,
-		logger:        utils.NewLogger(),
+		logger:        log,
+		audit:         audit,
+		programs:      programs,
+		closing:       closing,
 		cache:         &sync.Map{},
 		subscriptions: make(map[string]*Subscription),
+		transfers:     newTransferTracker(config.MaxResubmitAttempts),
+		limiter:       limiter,
 	}, nil
 }
 
+// ParseCommitment validates a commitment level name
+func ParseCommitment(commitment string) (rpc.CommitmentType, error) {
+	switch c := rpc.CommitmentType(commitment); c {
+	case rpc.CommitmentProcessed, rpc.CommitmentConfirmed, rpc.CommitmentFinalized:
+		return c, nil
+	default:
+		return "", fmt.Errorf("invalid commitment %q: must be processed, confirmed or finalized", commitment)
+	}
+}
+
 // GetBalance retrieves the balance for a given address
-func (c *Client) GetBalance(ctx context.Context, address string) (uint64, error) {
+func (c *Client) GetBalance(ctx context.Context, address string) (Lamports, error) {
+	return c.GetBalanceWithCommitment(ctx, address, rpc.CommitmentType(c.config.Commitment))
+}
+
+// GetBalanceWithCommitment retrieves the balance for a given address at the
+// given commitment level
+func (c *Client) GetBalanceWithCommitment(ctx context.Context, address string, commitment rpc.CommitmentType) (Lamports, error) {
 	pubKey, err := solana.PublicKeyFromBase58(address)
 	if err != nil {
 		return 0, fmt.Errorf("invalid address: %w", err)
@@ -90,13 +227,13 @@ func (c *Client) GetBalance(ctx context.Context, address string) (uint64, error)
 	balance, err := c.rpcClient.GetBalance(
 		ctx,
 		pubKey,
-		rpc.CommitmentConfig{Commitment: c.config.Commitment},
+		commitment,
 	)
 	if err != nil {
 		return 0, fmt.Errorf("failed to get balance: %w", err)
 	}
 
-	return balance.Value, nil
+	return Lamports(balance.Value), nil
 }
 
 // GetTransaction retrieves transaction information
@@ -131,67 +268,198 @@ func (c *Client) GetTransaction(ctx context.Context, signature string) (*Transac
 	return info, nil
 }
 
-// SubscribeToProgram subscribes to program account changes
-func (c *Client) SubscribeToProgram(programID string, callback func(interface{}) error) (string, error) {
-	pubKey, err := solana.PublicKeyFromBase58(programID)
+// GetTransactionHistory returns up to limit signatures for address, newest
+// first. Pass the last signature of a page as before to fetch the next one.
+func (c *Client) GetTransactionHistory(ctx context.Context, address string, limit int, before string) ([]SignatureInfo, error) {
+	pubKey, err := solana.PublicKeyFromBase58(address)
 	if err != nil {
-		return "", fmt.Errorf("invalid program ID: %w", err)
+		return nil, fmt.Errorf("invalid address: %w", err)
 	}
 
-	sub := &Subscription{
-		ID:       utils.GenerateID(),
-		Type:     "program",
-		Callback: callback,
-		Active:   true,
+	opts := &rpc.GetSignaturesForAddressOpts{
+		Limit:      &limit,
+		Commitment: rpc.CommitmentType(c.config.Commitment),
+	}
+	if before != "" {
+		sig, err := solana.SignatureFromBase58(before)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cursor: %w", err)
+		}
+		opts.Before = sig
 	}
 
-	err = c.wsClient.ProgramSubscribe(
-		pubKey,
-		rpc.CommitmentConfig{Commitment: c.config.Commitment},
-		func(result interface{}) error {
-			if sub.Active {
-				return callback(result)
-			}
-			return nil
-		},
-	)
+	results, err := c.rpcClient.GetSignaturesForAddressWithOpts(ctx, pubKey, opts)
 	if err != nil {
-		return "", fmt.Errorf("failed to subscribe to program: %w", err)
+		return nil, fmt.Errorf("failed to get transaction history: %w", err)
+	}
+
+	history := make([]SignatureInfo, 0, len(results))
+	for _, result := range results {
+		info := SignatureInfo{
+			Signature:          result.Signature.String(),
+			Slot:               result.Slot,
+			ConfirmationStatus: string(result.ConfirmationStatus),
+			Failed:             result.Err != nil,
+		}
+		if result.BlockTime != nil {
+			info.BlockTime = int64(*result.BlockTime)
+		}
+		if result.Memo != nil {
+			info.Memo = *result.Memo
+		}
+		history = append(history, info)
+	}
+
+	return history, nil
+}
+
+// Status returns the connection state and current subscription count
+func (c *Client) Status() ClientStatus {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	state := "connected"
+	if c.closed {
+		state = "closed"
+	}
+
+	return ClientStatus{
+		State:            state,
+		Subscriptions:    len(c.subscriptions),
+		MaxSubscriptions: c.maxSubscriptions(),
+		Batch:            c.limiter.Stats(),
+	}
+}
+
+func (c *Client) maxSubscriptions() int {
+	if c.config.MaxSubscriptions > 0 {
+		return c.config.MaxSubscriptions
+	}
+	return DefaultMaxSubscriptions
+}
+
+// websocket returns the websocket client, connecting it on first use so
+// clients that never subscribe don't need a websocket endpoint. The dial
+// happens outside c.mu so a slow node doesn't block the client; if another
+// caller connected first, the extra connection is closed.
+func (c *Client) websocket() (*rpc.WsClient, error) {
+	c.mu.RLock()
+	closed, current := c.closed, c.wsClient
+	c.mu.RUnlock()
+	if closed {
+		return nil, ErrClientClosed
+	}
+	if current != nil {
+		return current, nil
+	}
+
+	endpoint, err := wsEndpoint(c.config)
+	if err != nil {
+		return nil, err
+	}
+
+	wsClient, err := rpc.NewWsClient(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create websocket client: %w", err)
 	}
 
 	c.mu.Lock()
-	c.subscriptions[sub.ID] = sub
+	switch {
+	case c.closed:
+		err = ErrClientClosed
+	case c.wsClient != nil:
+		current = c.wsClient
+	default:
+		c.wsClient = wsClient
+	}
 	c.mu.Unlock()
 
-	return sub.ID, nil
+	if err != nil || current != nil {
+		if closeErr := wsClient.Close(); closeErr != nil {
+			c.logger.Warn("Failed to close websocket client", "error", closeErr)
+		}
+		return current, err
+	}
+	return wsClient, nil
 }
 
-// UnsubscribeFromProgram unsubscribes from program updates
-func (c *Client) UnsubscribeFromProgram(subscriptionID string) error {
+// wsEndpoint returns the configured websocket endpoint, or the RPC endpoint
+// with its http(s) scheme swapped for ws(s)
+func wsEndpoint(config *ClientConfig) (string, error) {
+	if config.WsEndpoint != "" {
+		return config.WsEndpoint, nil
+	}
+
+	switch {
+	case strings.HasPrefix(config.Endpoint, "https://"):
+		return "wss://" + strings.TrimPrefix(config.Endpoint, "https://"), nil
+	case strings.HasPrefix(config.Endpoint, "http://"):
+		return "ws://" + strings.TrimPrefix(config.Endpoint, "http://"), nil
+	default:
+		return "", fmt.Errorf("no websocket endpoint configured for %q", config.Endpoint)
+	}
+}
+
+// reserveSubscription claims a slot before subscribing so concurrent callers
+// can't overshoot the limit while the websocket call is in flight
+func (c *Client) reserveSubscription() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	sub, exists := c.subscriptions[subscriptionID]
-	if !exists {
-		return fmt.Errorf("subscription not found")
+	if len(c.subscriptions)+c.pendingSubs >= c.maxSubscriptions() {
+		return ErrTooManySubscriptions
 	}
+	c.pendingSubs++
+	return nil
+}
 
-	sub.Active = false
-	delete(c.subscriptions, subscriptionID)
+// finishSubscription releases a reserved slot, registering sub if the
+// subscription succeeded
+func (c *Client) finishSubscription(sub *Subscription) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	return nil
+	c.pendingSubs--
+	if sub != nil {
+		c.subscriptions[sub.ID] = sub
+	}
+}
+
+// SubscribeToProgram subscribes to program account changes
+func (c *Client) SubscribeToProgram(programID string, callback func(interface{}) error) (string, error) {
+	pubKey, err := solana.PublicKeyFromBase58(programID)
+	if err != nil {
+		return "", fmt.Errorf("invalid program ID: %w", err)
+	}
+	return c.subscribe(SubscriptionProgram, pubKey, callback)
+}
+
+// SubscribeToAccount subscribes to changes of a single account
+func (c *Client) SubscribeToAccount(address string, callback func(interface{}) error) (string, error) {
+	pubKey, err := solana.PublicKeyFromBase58(address)
+	if err != nil {
+		return "", fmt.Errorf("invalid address: %w", err)
+	}
+	return c.subscribe(SubscriptionAccount, pubKey, callback)
+}
+
+// UnsubscribeFromProgram unsubscribes from program updates. It is
+// Unsubscribe, kept for existing callers.
+func (c *Client) UnsubscribeFromProgram(subscriptionID string) error {
+	return c.Unsubscribe(subscriptionID)
 }
 
-// SendTransaction sends a signed transaction
+// SendTransaction sends a signed transaction without waiting for it to be
+// confirmed. See SendAndConfirm to wait.
 func (c *Client) SendTransaction(ctx context.Context, transaction []byte) (string, error) {
 	tx, err := solana.TransactionFromDecoder(solana.NewBinDecoder(transaction))
 	if err != nil {
 		return "", fmt.Errorf("failed to decode transaction: %w", err)
 	}
 
-	sig, err := c.rpcClient.SendTransaction(ctx, tx)
+	sig, err := c.sendTransaction(ctx, tx)
 	if err != nil {
-		return "", fmt.Errorf("failed to send transaction: %w", err)
+		return "", err
 	}
 
 	return sig.String(), nil
@@ -217,18 +485,155 @@ func (c *Client) GetAccountInfo(ctx context.Context, address string) (map[string
 	return result, nil
 }
 
-// Close closes the client connections
+// maxAccountsPerRequest is the getMultipleAccounts limit per call
+const maxAccountsPerRequest = 100
+
+// ErrAccountNotFound is reported for addresses with no account on chain
+var ErrAccountNotFound = errors.New("account not found")
+
+// AccountData is the raw state of an account
+type AccountData struct {
+	Address    string `json:"address"`
+	Lamports   uint64 `json:"lamports"`
+	Owner      string `json:"owner"`
+	Executable bool   `json:"executable"`
+	RentEpoch  uint64 `json:"rent_epoch"`
+	Data       []byte `json:"data"`
+}
+
+// GetMultipleAccounts fetches many accounts with as few RPC calls as
+// possible, 100 addresses at a time. Addresses that are invalid, have no
+// account or whose batch failed are reported in errs instead of accounts.
+func (c *Client) GetMultipleAccounts(ctx context.Context, addresses []string) (accounts map[string]*AccountData, errs map[string]error) {
+	accounts = make(map[string]*AccountData)
+	errs = make(map[string]error)
+
+	var keys []solana.PublicKey
+	seen := make(map[string]bool)
+	for _, address := range addresses {
+		if seen[address] {
+			continue
+		}
+		seen[address] = true
+
+		pubKey, err := solana.PublicKeyFromBase58(address)
+		if err != nil {
+			errs[address] = fmt.Errorf("invalid address: %w", err)
+			continue
+		}
+		keys = append(keys, pubKey)
+	}
+
+	// Chunks are fetched concurrently, bounded by the client's limiter
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for start := 0; start < len(keys); start += maxAccountsPerRequest {
+		end := start + maxAccountsPerRequest
+		if end > len(keys) {
+			end = len(keys)
+		}
+		chunk := keys[start:end]
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			found, failed := c.getAccountsChunk(ctx, chunk)
+
+			mu.Lock()
+			defer mu.Unlock()
+			for address, account := range found {
+				accounts[address] = account
+			}
+			for address, err := range failed {
+				errs[address] = err
+			}
+		}()
+	}
+	wg.Wait()
+
+	return accounts, errs
+}
+
+// getAccountsChunk fetches up to maxAccountsPerRequest accounts in one call
+func (c *Client) getAccountsChunk(ctx context.Context, chunk []solana.PublicKey) (map[string]*AccountData, map[string]error) {
+	accounts := make(map[string]*AccountData, len(chunk))
+	errs := make(map[string]error)
+
+	fail := func(err error) (map[string]*AccountData, map[string]error) {
+		for _, key := range chunk {
+			errs[key.String()] = err
+		}
+		return accounts, errs
+	}
+
+	if err := c.limiter.Acquire(ctx, 1); err != nil {
+		return fail(fmt.Errorf("failed to get accounts: %w", err))
+	}
+	result, err := c.rpcClient.GetMultipleAccountsWithOpts(ctx, chunk, &rpc.GetMultipleAccountsOpts{
+		Encoding:   solana.EncodingBase64,
+		Commitment: rpc.CommitmentType(c.config.Commitment),
+	})
+	c.limiter.Release(1)
+	if err != nil {
+		return fail(fmt.Errorf("failed to get accounts: %w", err))
+	}
+
+	for i, key := range chunk {
+		address := key.String()
+		if i >= len(result.Value) || result.Value[i] == nil {
+			errs[address] = ErrAccountNotFound
+			continue
+		}
+
+		account := result.Value[i]
+		var rentEpoch uint64
+		if account.RentEpoch != nil {
+			rentEpoch = account.RentEpoch.Uint64()
+		}
+		accounts[address] = &AccountData{
+			Address:    address,
+			Lamports:   account.Lamports,
+			Owner:      account.Owner.String(),
+			Executable: account.Executable,
+			RentEpoch:  rentEpoch,
+			Data:       account.Data.GetBinary(),
+		}
+	}
+	return accounts, errs
+}
+
+// GetHealth checks that the RPC node reports itself healthy.
+//
+// Deprecated: use Health, which bounds the call and reports why the node is
+// unhealthy.
+func (c *Client) GetHealth(ctx context.Context) error {
+	return c.Health(ctx)
+}
+
+// Close closes the client connections and aborts in-flight RPC requests,
+// which fail with ErrClientClosed
 func (c *Client) Close() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if !c.closed {
+		close(c.closing)
+	}
+
 	// Close all active subscriptions
 	for _, sub := range c.subscriptions {
 		sub.Active = false
 	}
 	c.subscriptions = make(map[string]*Subscription)
+	c.closed = true
+
+	if c.wsClient == nil {
+		return nil
+	}
 
-	if err := c.wsClient.Close(); err != nil {
+	wsClient := c.wsClient
+	c.wsClient = nil
+	if err := wsClient.Close(); err != nil {
 		return fmt.Errorf("failed to close websocket client: %w", err)
 	}
 
@@ -0,0 +1,193 @@
+package solana
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+)
+
+// DefaultConfirmTimeout is used when ClientConfig.ConfirmTimeout is unset.
+// It is a little over the lifetime of a blockhash, after which an unseen
+// transaction can no longer land.
+const DefaultConfirmTimeout = 90 * time.Second
+
+// confirmPollInterval is how often a signature's status is checked while
+// waiting for it to be confirmed
+const confirmPollInterval = 500 * time.Millisecond
+
+// commitmentRank orders confirmation statuses so a status can be compared
+// with a commitment
+var commitmentRank = map[rpc.ConfirmationStatusType]int{
+	rpc.ConfirmationStatusProcessed: 1,
+	rpc.ConfirmationStatusConfirmed: 2,
+	rpc.ConfirmationStatusFinalized: 3,
+}
+
+// SendResult is the outcome of a transaction sent with SendAndConfirm
+type SendResult struct {
+	Signature string `json:"signature"`
+	// Slot is the slot the transaction was processed in
+	Slot uint64 `json:"slot"`
+	// Confirmations is how many blocks have confirmed the transaction, or
+	// nil once it is finalized
+	Confirmations      *uint64 `json:"confirmations"`
+	ConfirmationStatus string  `json:"confirmation_status"`
+	// Err is set if the transaction landed but failed on chain
+	Err *TransactionError `json:"error,omitempty"`
+}
+
+// SendAndConfirm sends a signed transaction and waits until it reaches
+// commitment, or the client's ConfirmTimeout passes. An empty commitment is
+// the client's. Transactions that land but fail on chain return the result
+// with Err set, along with that error, so the slot they failed in is
+// known. A timeout returns the result holding just the signature, as the
+// transaction may still land. Use SendTransaction to send without waiting.
+func (c *Client) SendAndConfirm(ctx context.Context, transaction []byte, commitment rpc.CommitmentType) (*SendResult, error) {
+	if commitment == "" {
+		commitment = rpc.CommitmentType(c.config.Commitment)
+	}
+	if _, err := ParseCommitment(string(commitment)); err != nil {
+		return nil, err
+	}
+
+	tx, err := solana.TransactionFromDecoder(solana.NewBinDecoder(transaction))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode transaction: %w", err)
+	}
+
+	sig, err := c.sendTransaction(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	result := &SendResult{Signature: sig.String()}
+
+	ctx, cancel := context.WithTimeout(ctx, c.confirmTimeout())
+	defer cancel()
+
+	status, err := c.awaitCommitment(ctx, sig, commitment, 0)
+	if err != nil {
+		return result, fmt.Errorf("transaction %s not confirmed: %w", result.Signature, err)
+	}
+
+	result.Slot = status.Slot
+	result.Confirmations = status.Confirmations
+	result.ConfirmationStatus = string(status.ConfirmationStatus)
+	if status.Err != nil {
+		result.Err = newTransactionError("transaction failed on chain", status.Err, nil)
+		return result, &onChainError{result.Err}
+	}
+	return result, nil
+}
+
+// onChainError reports a confirmed transaction that failed on chain. It
+// matches ErrTransferFailed and unwraps to the *TransactionError.
+type onChainError struct {
+	err *TransactionError
+}
+
+func (e *onChainError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrTransferFailed, e.err)
+}
+
+func (e *onChainError) Is(target error) bool {
+	return target == ErrTransferFailed
+}
+
+func (e *onChainError) Unwrap() error {
+	return e.err
+}
+
+func (c *Client) confirmTimeout() time.Duration {
+	if c.config.ConfirmTimeout > 0 {
+		return c.config.ConfirmTimeout
+	}
+	return DefaultConfirmTimeout
+}
+
+// simulateTransaction runs tx against the node without submitting it,
+// returning an on-chain failure as a *TransactionError carrying the logs
+func (c *Client) simulateTransaction(ctx context.Context, tx *solana.Transaction) error {
+	result, err := c.rpcClient.SimulateTransaction(ctx, tx)
+	if err != nil {
+		return fmt.Errorf("failed to simulate transaction: %w", err)
+	}
+	if result.Value == nil || result.Value.Err == nil {
+		return nil
+	}
+
+	txErr := newTransactionError("transaction simulation failed", result.Value.Err, result.Value.Logs)
+	return fmt.Errorf("simulation failed: %w", txErr)
+}
+
+// newTransactionError converts a transaction error reported in an RPC
+// result, which arrives loosely typed, to a *TransactionError
+func newTransactionError(message string, value interface{}, logs []string) *TransactionError {
+	txErr := &TransactionError{
+		Message:          message,
+		InstructionIndex: -1,
+		Logs:             logs,
+	}
+	raw, err := json.Marshal(value)
+	if err != nil || !parseErrorVariant(raw, txErr) {
+		txErr.Kind = fmt.Sprint(value)
+	}
+	return txErr
+}
+
+// confirmTransfer waits up to the client's ConfirmTimeout for sig to reach
+// the client's commitment. It fails with ErrTransferFailed if the
+// transaction failed on chain, and ErrTransferExpired if it was never seen
+// and the chain has moved past lastValid.
+func (c *Client) confirmTransfer(ctx context.Context, sig solana.Signature, lastValid uint64) error {
+	ctx, cancel := context.WithTimeout(ctx, c.confirmTimeout())
+	defer cancel()
+
+	status, err := c.awaitCommitment(ctx, sig, rpc.CommitmentType(c.config.Commitment), lastValid)
+	if err != nil {
+		return err
+	}
+	if status.Err != nil {
+		return fmt.Errorf("%w: %v", ErrTransferFailed, status.Err)
+	}
+	return nil
+}
+
+// awaitCommitment polls the status of sig until it reaches commitment or
+// fails on chain, and returns that status. A lastValid block height above
+// zero stops polling with ErrTransferExpired once the chain has passed it
+// without seeing sig.
+func (c *Client) awaitCommitment(ctx context.Context, sig solana.Signature, commitment rpc.CommitmentType, lastValid uint64) (*rpc.SignatureStatusesResult, error) {
+	want := commitmentRank[rpc.ConfirmationStatusType(commitment)]
+
+	for {
+		statuses, err := c.rpcClient.GetSignatureStatuses(ctx, false, sig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get signature status: %w", err)
+		}
+
+		if len(statuses.Value) > 0 && statuses.Value[0] != nil {
+			status := statuses.Value[0]
+			if status.Err != nil || commitmentRank[status.ConfirmationStatus] >= want {
+				return status, nil
+			}
+		} else if lastValid > 0 {
+			expired, err := c.blockhashExpired(ctx, lastValid)
+			if err != nil {
+				return nil, err
+			}
+			if expired {
+				return nil, ErrTransferExpired
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(confirmPollInterval):
+		}
+	}
+}
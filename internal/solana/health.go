@@ -0,0 +1,85 @@
+package solana
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gagliardetto/solana-go/rpc"
+	"github.com/gagliardetto/solana-go/rpc/jsonrpc"
+)
+
+// HealthTimeout bounds a Health call so a hung node is reported quickly
+// rather than stalling health and readiness checks
+const HealthTimeout = 3 * time.Second
+
+// nodeUnhealthyCode is the JSON-RPC error code getHealth answers with when
+// the node is behind or otherwise unhealthy
+const nodeUnhealthyCode = -32005
+
+// Health statuses reported in HealthError
+const (
+	HealthBehind    = "behind"
+	HealthUnhealthy = "unhealthy"
+	// HealthUnknown means the node couldn't be asked, e.g. because the
+	// request failed or timed out
+	HealthUnknown = "unknown"
+)
+
+// ErrNodeUnhealthy matches every HealthError
+var ErrNodeUnhealthy = errors.New("node is unhealthy")
+
+// HealthError reports an RPC node that failed a health check
+type HealthError struct {
+	// Status is why the node is unhealthy: HealthBehind, HealthUnhealthy,
+	// HealthUnknown or a status the node reported
+	Status string
+	// Message is the node's explanation, e.g. "Node is behind by 42 slots"
+	Message string
+	// Err is the failed request when the status is HealthUnknown
+	Err error
+}
+
+func (e *HealthError) Error() string {
+	switch {
+	case e.Message != "":
+		return fmt.Sprintf("node health %s: %s", e.Status, e.Message)
+	case e.Err != nil:
+		return fmt.Sprintf("node health %s: %v", e.Status, e.Err)
+	}
+	return fmt.Sprintf("node health %s", e.Status)
+}
+
+func (e *HealthError) Is(target error) bool {
+	return target == ErrNodeUnhealthy
+}
+
+func (e *HealthError) Unwrap() error {
+	return e.Err
+}
+
+// Health calls getHealth on the RPC node, returning nil if the node reports
+// "ok" and a *HealthError otherwise. The call is bounded by HealthTimeout.
+func (c *Client) Health(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, HealthTimeout)
+	defer cancel()
+
+	status, err := c.rpcClient.GetHealth(ctx)
+	if err != nil {
+		var rpcErr *jsonrpc.RPCError
+		if errors.As(err, &rpcErr) && rpcErr.Code == nodeUnhealthyCode {
+			healthErr := &HealthError{Status: HealthUnhealthy, Message: rpcErr.Message}
+			if strings.Contains(strings.ToLower(rpcErr.Message), "behind") {
+				healthErr.Status = HealthBehind
+			}
+			return healthErr
+		}
+		return &HealthError{Status: HealthUnknown, Err: err}
+	}
+	if status != rpc.HealthOk {
+		return &HealthError{Status: status}
+	}
+	return nil
+}
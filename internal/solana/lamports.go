@@ -0,0 +1,46 @@
+package solana
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// LamportsPerSOL is the number of lamports in one SOL
+const LamportsPerSOL = 1_000_000_000
+
+// Lamports is an amount of SOL in its smallest unit. It is a distinct type
+// so lamport amounts can't be mixed up with SOL values or other integers
+// without a conversion. It serializes to JSON as the raw integer.
+type Lamports uint64
+
+// LamportsFromSOL converts a SOL amount to lamports, rounding to the
+// nearest lamport
+func LamportsFromSOL(sol float64) (Lamports, error) {
+	if math.IsNaN(sol) || math.IsInf(sol, 0) || sol < 0 {
+		return 0, fmt.Errorf("invalid SOL amount %v", sol)
+	}
+	lamports := math.Round(sol * LamportsPerSOL)
+	if lamports >= math.MaxUint64 {
+		return 0, fmt.Errorf("SOL amount %v overflows lamports", sol)
+	}
+	return Lamports(lamports), nil
+}
+
+// SOL returns the amount in SOL. Large amounts lose precision; use
+// FormatSOL for display.
+func (l Lamports) SOL() float64 {
+	return float64(l) / LamportsPerSOL
+}
+
+// FormatSOL formats a lamport amount as SOL without losing precision,
+// trimming trailing zeros, e.g. 1500000000 becomes "1.5"
+func FormatSOL(lamports Lamports) string {
+	whole := uint64(lamports) / LamportsPerSOL
+	frac := uint64(lamports) % LamportsPerSOL
+	if frac == 0 {
+		return strconv.FormatUint(whole, 10)
+	}
+	return fmt.Sprintf("%d.%s", whole, strings.TrimRight(fmt.Sprintf("%09d", frac), "0"))
+}
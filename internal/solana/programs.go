@@ -0,0 +1,97 @@
+package solana
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gagliardetto/solana-go"
+)
+
+// ErrProgramNotAllowed is returned when a wallet is asked to sign a
+// transaction calling a program its client's ProgramPolicy rejects
+var ErrProgramNotAllowed = errors.New("program not allowed")
+
+// ProgramPolicy restricts the programs wallets sign transactions for, so a
+// server wallet can't be used to call arbitrary programs through swaps or
+// transfers built elsewhere. The zero policy allows every program.
+type ProgramPolicy struct {
+	// Allowed, if not empty, lists the only program IDs a transaction may
+	// call. Transfers need the system program and swaps the programs of
+	// their route.
+	Allowed []string `json:"allowed"`
+	// Denied lists program IDs that are never signed for, even if allowed
+	Denied []string `json:"denied"`
+}
+
+// programFilter is a parsed ProgramPolicy
+type programFilter struct {
+	allowed map[solana.PublicKey]bool
+	denied  map[solana.PublicKey]bool
+}
+
+func newProgramFilter(policy ProgramPolicy) (*programFilter, error) {
+	allowed, err := programSet("allowed", policy.Allowed)
+	if err != nil {
+		return nil, err
+	}
+	denied, err := programSet("denied", policy.Denied)
+	if err != nil {
+		return nil, err
+	}
+	return &programFilter{allowed: allowed, denied: denied}, nil
+}
+
+func programSet(list string, ids []string) (map[solana.PublicKey]bool, error) {
+	set := make(map[solana.PublicKey]bool, len(ids))
+	for _, id := range ids {
+		key, err := solana.PublicKeyFromBase58(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s program %q: %w", list, id, err)
+		}
+		set[key] = true
+	}
+	return set, nil
+}
+
+// ProgramViolation describes an instruction calling a program the policy
+// rejects
+type ProgramViolation struct {
+	Instruction int
+	Program     solana.PublicKey
+	// Reason is "denied" for a denied program and "not_allowed" for one
+	// missing from the allowlist
+	Reason string
+}
+
+func (v *ProgramViolation) Error() string {
+	if v.Reason == "denied" {
+		return fmt.Sprintf("%s: instruction %d calls denied program %s", ErrProgramNotAllowed, v.Instruction, v.Program)
+	}
+	return fmt.Sprintf("%s: instruction %d calls program %s, which is not in the allowlist", ErrProgramNotAllowed, v.Instruction, v.Program)
+}
+
+func (v *ProgramViolation) Unwrap() error {
+	return ErrProgramNotAllowed
+}
+
+// check returns a *ProgramViolation for the first instruction of tx calling
+// a program the filter rejects
+func (f *programFilter) check(tx *solana.Transaction) error {
+	if f == nil || (len(f.allowed) == 0 && len(f.denied) == 0) {
+		return nil
+	}
+
+	for i, inst := range tx.Message.Instructions {
+		program, err := tx.ResolveProgramIDIndex(inst.ProgramIDIndex)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidTransaction, err)
+		}
+		switch {
+		case f.denied[program]:
+			return &ProgramViolation{Instruction: i, Program: program, Reason: "denied"}
+		case len(f.allowed) > 0 && !f.allowed[program]:
+			return &ProgramViolation{Instruction: i, Program: program, Reason: "not_allowed"}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,433 @@
+package solana
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/programs/system"
+	"github.com/gagliardetto/solana-go/rpc"
+)
+
+// DefaultMaxResubmitAttempts caps how often an expired transfer is rebuilt
+const DefaultMaxResubmitAttempts = 3
+
+// Resubmit errors
+var (
+	ErrTransferNotFound   = errors.New("transfer not found")
+	ErrTransferExists     = errors.New("transfer already tracked for idempotency key")
+	ErrTransferNotExpired = errors.New("transfer has not expired")
+	ErrTransferFailed     = errors.New("transfer failed on chain")
+	ErrResubmitLimit      = errors.New("resubmit attempt limit reached")
+	ErrSignerRequired     = errors.New("server wallet required to re-sign transfer")
+	ErrTransferExpired    = errors.New("transfer expired before it was confirmed")
+	ErrTransferInFlight   = errors.New("transfer is already being resubmitted")
+	ErrTransferMismatch   = errors.New("transaction is not the one built for the transfer")
+)
+
+// PendingTransfer tracks a transfer submitted under an idempotency key
+type PendingTransfer struct {
+	Key                  string    `json:"idempotency_key"`
+	From                 string    `json:"from"`
+	To                   string    `json:"to"`
+	Amount               uint64    `json:"amount"`
+	ClientSigned         bool      `json:"client_signed"`
+	Signature            string    `json:"signature,omitempty"`
+	LastValidBlockHeight uint64    `json:"last_valid_block_height"`
+	Attempts             int       `json:"attempts"`
+	CreatedAt            time.Time `json:"created_at"`
+	UpdatedAt            time.Time `json:"updated_at"`
+
+	// resubmitting is set while a resubmission holds the transfer's claim
+	resubmitting bool
+	// message is the serialized message last built for a client-signed
+	// transfer, which the transaction the client submits must carry
+	message []byte
+}
+
+// ResubmitResult describes the rebuilt transfer. Server-signed transfers
+// carry the new signature; client-signed ones carry a fresh unsigned
+// transaction for the client to sign.
+type ResubmitResult struct {
+	Key                  string `json:"idempotency_key"`
+	Attempt              int    `json:"attempt"`
+	Signature            string `json:"signature,omitempty"`
+	UnsignedTransaction  string `json:"unsigned_transaction,omitempty"`
+	LastValidBlockHeight uint64 `json:"last_valid_block_height"`
+}
+
+// transferTracker stores pending transfers by idempotency key
+type transferTracker struct {
+	transfers   map[string]*PendingTransfer
+	maxAttempts int
+	mu          sync.Mutex
+}
+
+func newTransferTracker(maxAttempts int) *transferTracker {
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxResubmitAttempts
+	}
+	return &transferTracker{
+		transfers:   make(map[string]*PendingTransfer),
+		maxAttempts: maxAttempts,
+	}
+}
+
+func (t *transferTracker) add(transfer *PendingTransfer) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, exists := t.transfers[transfer.Key]; exists {
+		return fmt.Errorf("%w: %s", ErrTransferExists, transfer.Key)
+	}
+	t.transfers[transfer.Key] = transfer
+	return nil
+}
+
+func (t *transferTracker) remove(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.transfers, key)
+}
+
+// recordSignature records the signature of the transaction a client signed
+// for the transfer under key. The transaction's message must be the one last
+// built for it.
+func (t *transferTracker) recordSignature(key string, message []byte, signature string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	transfer, exists := t.transfers[key]
+	switch {
+	case !exists:
+		return fmt.Errorf("%w: %s", ErrTransferNotFound, key)
+	case transfer.resubmitting:
+		return fmt.Errorf("%w: %s", ErrTransferInFlight, key)
+	case !transfer.ClientSigned || !bytes.Equal(transfer.message, message):
+		return fmt.Errorf("%w: %s", ErrTransferMismatch, key)
+	}
+
+	transfer.Signature = signature
+	transfer.UpdatedAt = time.Now()
+	return nil
+}
+
+func (t *transferTracker) get(key string) (PendingTransfer, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	transfer, exists := t.transfers[key]
+	if !exists {
+		return PendingTransfer{}, false
+	}
+	return *transfer, true
+}
+
+func (t *transferTracker) update(key string, fn func(*PendingTransfer)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if transfer, exists := t.transfers[key]; exists {
+		fn(transfer)
+		transfer.UpdatedAt = time.Now()
+	}
+}
+
+// claim reserves the transfer under key for one resubmission, counting the
+// attempt, and returns it as it was before. Concurrent claims fail with
+// ErrTransferInFlight until the claim is released.
+func (t *transferTracker) claim(key string) (PendingTransfer, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	transfer, exists := t.transfers[key]
+	switch {
+	case !exists:
+		return PendingTransfer{}, fmt.Errorf("%w: %s", ErrTransferNotFound, key)
+	case transfer.resubmitting:
+		return PendingTransfer{}, fmt.Errorf("%w: %s", ErrTransferInFlight, key)
+	case transfer.Attempts > t.maxAttempts:
+		return PendingTransfer{}, fmt.Errorf("%w: %d attempts", ErrResubmitLimit, transfer.Attempts)
+	}
+
+	claimed := *transfer
+	transfer.resubmitting = true
+	transfer.Attempts++
+	return claimed, nil
+}
+
+// release drops the claim on the transfer under key. A failed resubmission
+// gives its attempt back, as nothing was sent for it.
+func (t *transferTracker) release(key string, failed bool) {
+	t.update(key, func(transfer *PendingTransfer) {
+		transfer.resubmitting = false
+		if failed {
+			transfer.Attempts--
+		}
+	})
+}
+
+// BuildTransfer creates an unsigned SOL transfer with a fresh blockhash and
+// returns it with the last block height at which it is valid
+func (c *Client) BuildTransfer(ctx context.Context, from, to string, amount uint64) (*solana.Transaction, uint64, error) {
+	fromKey, err := solana.PublicKeyFromBase58(from)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: invalid sender address: %v", ErrInvalidTransaction, err)
+	}
+	toKey, err := solana.PublicKeyFromBase58(to)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: invalid recipient address: %v", ErrInvalidTransaction, err)
+	}
+
+	recent, err := c.rpcClient.GetLatestBlockhash(ctx, rpc.CommitmentType(c.config.Commitment))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get latest blockhash: %w", err)
+	}
+
+	tx, err := solana.NewTransaction(
+		[]solana.Instruction{
+			system.NewTransferInstruction(amount, fromKey, toKey).Build(),
+		},
+		recent.Value.Blockhash,
+		solana.TransactionPayer(fromKey),
+	)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create transaction: %w", err)
+	}
+
+	return tx, recent.Value.LastValidBlockHeight, nil
+}
+
+// PrepareTransfer builds an unsigned transfer for client signing and tracks
+// it under key so it can be rebuilt if it expires. The client should submit
+// it with SubmitPreparedTransfer, so a resubmission can tell if it landed.
+func (c *Client) PrepareTransfer(ctx context.Context, key, from, to string, amount uint64) (*UnsignedTransfer, error) {
+	built, message, err := c.buildUnsignedTransfer(ctx, from, to, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if err := c.transfers.add(&PendingTransfer{
+		Key:                  key,
+		From:                 from,
+		To:                   to,
+		Amount:               amount,
+		ClientSigned:         true,
+		LastValidBlockHeight: built.LastValidBlockHeight,
+		Attempts:             1,
+		CreatedAt:            now,
+		UpdatedAt:            now,
+		message:              message,
+	}); err != nil {
+		return nil, err
+	}
+
+	built.IdempotencyKey = key
+	return built, nil
+}
+
+// SubmitPreparedTransfer submits the client-signed transaction of the
+// transfer tracked under key. It must be the transaction last built for the
+// transfer by PrepareTransfer or ResubmitTransfer, or ErrTransferMismatch is
+// returned. Its signature is recorded before it is sent, so a resubmission
+// finds the transfer if it lands instead of building another.
+func (c *Client) SubmitPreparedTransfer(ctx context.Context, key, encoded string) (string, error) {
+	tx, err := decodeSignedTransaction(encoded)
+	if err != nil {
+		return "", err
+	}
+	message, err := tx.Message.MarshalBinary()
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize message: %w", err)
+	}
+
+	if err := c.transfers.recordSignature(key, message, tx.Signatures[0].String()); err != nil {
+		return "", err
+	}
+
+	sig, err := c.sendTransaction(ctx, tx)
+	if err != nil {
+		return "", err
+	}
+
+	return sig.String(), nil
+}
+
+// GetPendingTransfer returns the transfer tracked under key
+func (c *Client) GetPendingTransfer(key string) (PendingTransfer, bool) {
+	return c.transfers.get(key)
+}
+
+// isExpired reports whether a transfer can no longer land: it was never seen
+// by the cluster and the chain has moved past its last valid block height
+func (c *Client) isExpired(ctx context.Context, transfer PendingTransfer) (bool, error) {
+	if transfer.Signature != "" {
+		sig, err := solana.SignatureFromBase58(transfer.Signature)
+		if err != nil {
+			return false, fmt.Errorf("invalid signature: %w", err)
+		}
+
+		statuses, err := c.rpcClient.GetSignatureStatuses(ctx, true, sig)
+		if err != nil {
+			return false, fmt.Errorf("failed to get signature status: %w", err)
+		}
+		if len(statuses.Value) > 0 && statuses.Value[0] != nil {
+			if statuses.Value[0].Err != nil {
+				return false, fmt.Errorf("%w: %v", ErrTransferFailed, statuses.Value[0].Err)
+			}
+			return false, nil
+		}
+	}
+
+	return c.blockhashExpired(ctx, transfer.LastValidBlockHeight)
+}
+
+// blockhashExpired reports whether the chain has moved past lastValid, the
+// last block height at which a transaction's blockhash is accepted
+func (c *Client) blockhashExpired(ctx context.Context, lastValid uint64) (bool, error) {
+	height, err := c.rpcClient.GetBlockHeight(ctx, rpc.CommitmentType(c.config.Commitment))
+	if err != nil {
+		return false, fmt.Errorf("failed to get block height: %w", err)
+	}
+	return height > lastValid, nil
+}
+
+// ResubmitTransfer rebuilds an expired transfer with a new blockhash. Transfers
+// sent by the server wallet are re-signed by signer and submitted; client
+// signed transfers are returned unsigned for the client to sign again. Only
+// one resubmission of a transfer runs at a time; others fail with
+// ErrTransferInFlight.
+func (c *Client) ResubmitTransfer(ctx context.Context, key string, signer *Wallet) (result *ResubmitResult, err error) {
+	transfer, err := c.transfers.claim(key)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			c.transfers.release(key, true)
+		}
+	}()
+
+	expired, err := c.isExpired(ctx, transfer)
+	if err != nil {
+		return nil, err
+	}
+	if !expired {
+		return nil, ErrTransferNotExpired
+	}
+
+	if !transfer.ClientSigned && (signer == nil || signer.GetAddress() != transfer.From) {
+		return nil, ErrSignerRequired
+	}
+
+	tx, lastValid, err := c.BuildTransfer(ctx, transfer.From, transfer.To, transfer.Amount)
+	if err != nil {
+		return nil, err
+	}
+
+	result = &ResubmitResult{
+		Key:                  key,
+		Attempt:              transfer.Attempts + 1,
+		LastValidBlockHeight: lastValid,
+	}
+
+	var message []byte
+	if transfer.ClientSigned {
+		if result.UnsignedTransaction, err = encodeTransaction(tx); err != nil {
+			return nil, err
+		}
+		if message, err = tx.Message.MarshalBinary(); err != nil {
+			return nil, fmt.Errorf("failed to serialize message: %w", err)
+		}
+	} else {
+		if err := signer.SignTransaction(tx); err != nil {
+			return nil, fmt.Errorf("failed to sign transaction: %w", err)
+		}
+
+		sig, err := c.sendTransaction(ctx, tx)
+		if err != nil {
+			return nil, err
+		}
+		result.Signature = sig.String()
+	}
+
+	c.transfers.update(key, func(t *PendingTransfer) {
+		t.resubmitting = false
+		t.LastValidBlockHeight = lastValid
+		if result.Signature != "" {
+			t.Signature = result.Signature
+		}
+		if message != nil {
+			t.message = message
+		}
+	})
+
+	c.logger.Info("Resubmitted expired transfer",
+		"idempotency_key", key,
+		"attempt", result.Attempt,
+		"client_signed", transfer.ClientSigned,
+	)
+
+	return result, nil
+}
+
+// SendTransfer signs and submits a transfer from the wallet, tracking it
+// under key so it can be resubmitted if it expires before landing. A
+// transfer the node rejects outright is no longer tracked, so the key can be
+// used again.
+func (w *Wallet) SendTransfer(ctx context.Context, key, recipient string, amount uint64) (*ResubmitResult, error) {
+	tx, lastValid, err := w.client.BuildTransfer(ctx, w.GetAddress(), recipient, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := w.SignTransaction(tx); err != nil {
+		return nil, fmt.Errorf("failed to sign transaction: %w", err)
+	}
+
+	now := time.Now()
+	transfer := &PendingTransfer{
+		Key:                  key,
+		From:                 w.GetAddress(),
+		To:                   recipient,
+		Amount:               amount,
+		Signature:            tx.Signatures[0].String(),
+		LastValidBlockHeight: lastValid,
+		Attempts:             1,
+		CreatedAt:            now,
+		UpdatedAt:            now,
+	}
+	if err := w.client.transfers.add(transfer); err != nil {
+		return nil, err
+	}
+
+	if _, err := w.client.sendTransaction(ctx, tx); err != nil {
+		var txErr *TransactionError
+		if errors.As(err, &txErr) {
+			w.client.transfers.remove(key)
+		}
+		return nil, err
+	}
+
+	return &ResubmitResult{
+		Key:                  key,
+		Attempt:              1,
+		Signature:            transfer.Signature,
+		LastValidBlockHeight: lastValid,
+	}, nil
+}
+
+func encodeTransaction(tx *solana.Transaction) (string, error) {
+	data, err := tx.MarshalBinary()
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize transaction: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
@@ -0,0 +1,213 @@
+package solana
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+	"github.com/labs-alone/alone-main/internal/utils"
+)
+
+// Subscription types
+const (
+	SubscriptionProgram = "program"
+	SubscriptionAccount = "account"
+)
+
+// subscriptionKind holds the websocket calls for one Subscription.Type.
+// subscribe returns the node's ID for the subscription, which unsubscribe
+// takes.
+type subscriptionKind struct {
+	subscribe   func(ws *rpc.WsClient, key solana.PublicKey, commitment rpc.CommitmentConfig, handler func(interface{}) error) (uint64, error)
+	unsubscribe func(ws *rpc.WsClient, id uint64) error
+}
+
+var subscriptionKinds = map[string]subscriptionKind{
+	SubscriptionProgram: {
+		subscribe:   (*rpc.WsClient).ProgramSubscribe,
+		unsubscribe: (*rpc.WsClient).ProgramUnsubscribe,
+	},
+	SubscriptionAccount: {
+		subscribe:   (*rpc.WsClient).AccountSubscribe,
+		unsubscribe: (*rpc.WsClient).AccountUnsubscribe,
+	},
+}
+
+// SubscriptionInfo describes a subscription, as listed by ListSubscriptions
+type SubscriptionInfo struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Target    string    `json:"target"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// subscribe registers callback for changes to key using the websocket calls
+// for typ
+func (c *Client) subscribe(typ string, key solana.PublicKey, callback func(interface{}) error) (string, error) {
+	kind, ok := subscriptionKinds[typ]
+	if !ok {
+		return "", fmt.Errorf("unknown subscription type %q", typ)
+	}
+
+	if err := c.reserveSubscription(); err != nil {
+		return "", err
+	}
+
+	ws, err := c.websocket()
+	if err != nil {
+		c.finishSubscription(nil)
+		return "", err
+	}
+
+	sub := &Subscription{
+		ID:        utils.GenerateID(),
+		Type:      typ,
+		Target:    key,
+		Callback:  callback,
+		Active:    true,
+		CreatedAt: time.Now(),
+	}
+
+	serverID, err := kind.subscribe(ws, key, c.commitmentConfig(), sub.handle)
+	if err != nil {
+		c.finishSubscription(nil)
+		return "", fmt.Errorf("failed to subscribe to %s: %w", typ, err)
+	}
+	sub.ServerID = serverID
+
+	c.finishSubscription(sub)
+	return sub.ID, nil
+}
+
+// handle passes a notification to the callback while the subscription is
+// active
+func (s *Subscription) handle(result interface{}) error {
+	if s.Active {
+		return s.Callback(result)
+	}
+	return nil
+}
+
+func (c *Client) commitmentConfig() rpc.CommitmentConfig {
+	return rpc.CommitmentConfig{Commitment: c.config.Commitment}
+}
+
+// Unsubscribe cancels a subscription with the websocket call for its type.
+// The subscription is removed even if the node can't be told, as no more
+// notifications reach its callback either way.
+func (c *Client) Unsubscribe(subscriptionID string) error {
+	c.mu.Lock()
+	sub, exists := c.subscriptions[subscriptionID]
+	if !exists {
+		c.mu.Unlock()
+		return fmt.Errorf("subscription not found")
+	}
+	sub.Active = false
+	delete(c.subscriptions, subscriptionID)
+	serverID := sub.ServerID
+	ws := c.wsClient
+	c.mu.Unlock()
+
+	if ws == nil {
+		return nil
+	}
+	if err := subscriptionKinds[sub.Type].unsubscribe(ws, serverID); err != nil {
+		return fmt.Errorf("failed to unsubscribe from %s: %w", sub.Type, err)
+	}
+	return nil
+}
+
+// Reconnect replaces the websocket connection, for use after the node drops
+// it, and subscribes every subscription again with the calls for its type.
+// Subscriptions that can't be restored are removed and their errors
+// returned; the rest keep their IDs and take the node's new ones.
+func (c *Client) Reconnect() error {
+	c.mu.Lock()
+	old := c.wsClient
+	c.wsClient = nil
+	subs := c.sortedSubscriptions()
+	c.mu.Unlock()
+
+	if old != nil {
+		// The connection is being replaced, so a failed close is only logged
+		if err := old.Close(); err != nil {
+			c.logger.Warn("Failed to close websocket client", "error", err)
+		}
+	}
+	if len(subs) == 0 {
+		return nil
+	}
+
+	ws, err := c.websocket()
+	if err != nil {
+		return fmt.Errorf("failed to reconnect websocket: %w", err)
+	}
+
+	var failed []string
+	for _, sub := range subs {
+		serverID, err := subscriptionKinds[sub.Type].subscribe(ws, sub.Target, c.commitmentConfig(), sub.handle)
+		if err == nil {
+			c.mu.Lock()
+			sub.ServerID = serverID
+			c.mu.Unlock()
+			continue
+		}
+
+		c.mu.Lock()
+		sub.Active = false
+		delete(c.subscriptions, sub.ID)
+		c.mu.Unlock()
+
+		c.logger.Warn("Failed to restore subscription",
+			"id", sub.ID,
+			"type", sub.Type,
+			"target", sub.Target.String(),
+			"error", err,
+		)
+		failed = append(failed, fmt.Sprintf("%s %s: %v", sub.Type, sub.ID, err))
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to resubscribe to %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// ListSubscriptions returns the current subscriptions, oldest first
+func (c *Client) ListSubscriptions() []SubscriptionInfo {
+	c.mu.RLock()
+	subs := c.sortedSubscriptions()
+	c.mu.RUnlock()
+
+	infos := make([]SubscriptionInfo, 0, len(subs))
+	for _, sub := range subs {
+		infos = append(infos, SubscriptionInfo{
+			ID:        sub.ID,
+			Type:      sub.Type,
+			Target:    sub.Target.String(),
+			Active:    sub.Active,
+			CreatedAt: sub.CreatedAt,
+		})
+	}
+	return infos
+}
+
+// sortedSubscriptions returns the subscriptions oldest first. Callers must
+// hold c.mu.
+func (c *Client) sortedSubscriptions() []*Subscription {
+	subs := make([]*Subscription, 0, len(c.subscriptions))
+	for _, sub := range c.subscriptions {
+		subs = append(subs, sub)
+	}
+	sort.Slice(subs, func(i, j int) bool {
+		if !subs[i].CreatedAt.Equal(subs[j].CreatedAt) {
+			return subs[i].CreatedAt.Before(subs[j].CreatedAt)
+		}
+		return subs[i].ID < subs[j].ID
+	})
+	return subs
+}
@@ -0,0 +1,467 @@
+package solana
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+)
+
+const (
+	// DefaultSwapEndpoint is the Jupiter quote API used when
+	// SwapConfig.Endpoint is unset
+	DefaultSwapEndpoint = "https://quote-api.jup.ag/v6"
+	// DefaultSwapProgram is the Jupiter v6 program, used when
+	// SwapConfig.Program is unset
+	DefaultSwapProgram = "JUP6LkbZbjS1jKKwapdHNy74zcZ3tLUZoi5QNyVTaV4"
+	// DefaultSwapTimeout bounds each quote and swap request when
+	// SwapConfig.Timeout is unset
+	DefaultSwapTimeout = 10 * time.Second
+	// DefaultSlippageBps is used when a swap doesn't set its slippage
+	DefaultSlippageBps = 50
+	// MaxSlippageBps rejects swaps that would accept losing more than half
+	// their output, which is almost certainly a mistake
+	MaxSlippageBps = 5000
+)
+
+// Swap errors
+var (
+	// ErrInvalidSwap is returned for swaps with bad mints, amounts or
+	// slippage
+	ErrInvalidSwap = errors.New("invalid swap")
+	// ErrNoSwapRoute is returned when the quote source can't route a swap
+	ErrNoSwapRoute = errors.New("no swap route found")
+	// ErrSwapMismatch is returned when a quote or swap transaction from the
+	// quote API doesn't match what was asked for
+	ErrSwapMismatch = errors.New("swap doesn't match its quote")
+	// ErrSwapPolicyRequired is returned when the server wallet is asked to
+	// sign a swap without a ProgramPolicy allowlist to bound what it signs
+	ErrSwapPolicyRequired = errors.New("server-signed swaps require a program allowlist")
+)
+
+// SwapConfig configures the quote source swaps are routed through
+type SwapConfig struct {
+	// Endpoint is the base URL of a Jupiter-compatible quote API, serving
+	// GET /quote and POST /swap
+	Endpoint string
+	// Program is the swap program a swap transaction must call, checked
+	// before the server wallet signs one
+	Program string
+	Timeout time.Duration
+	// HTTPClient defaults to a client with Timeout
+	HTTPClient *http.Client
+}
+
+// SwapClient fetches swap routes and builds swap transactions from a quote
+// API
+type SwapClient struct {
+	endpoint   string
+	program    string
+	httpClient *http.Client
+}
+
+// SwapParams describes a swap of Amount base units of InputMint into
+// OutputMint, accepting up to SlippageBps basis points less output than
+// quoted
+type SwapParams struct {
+	InputMint   string `json:"input_mint"`
+	OutputMint  string `json:"output_mint"`
+	Amount      uint64 `json:"amount"`
+	SlippageBps int    `json:"slippage_bps,omitempty"`
+}
+
+// SwapQuote is a route for a swap and the output it's expected to give
+type SwapQuote struct {
+	InputMint  string `json:"input_mint"`
+	OutputMint string `json:"output_mint"`
+	InAmount   uint64 `json:"in_amount"`
+	OutAmount  uint64 `json:"out_amount"`
+	// MinOutAmount is the least output accepted after slippage
+	MinOutAmount   uint64 `json:"min_out_amount"`
+	SlippageBps    int    `json:"slippage_bps"`
+	PriceImpactPct string `json:"price_impact_pct,omitempty"`
+
+	// raw is the quote as the API returned it, sent back to build the swap
+	raw json.RawMessage
+}
+
+// swapInstructionTail is the length of the amounts ending a Jupiter route
+// instruction: in amount (u64), quoted out amount (u64), slippage bps (u16)
+// and platform fee bps (u8)
+const swapInstructionTail = 8 + 8 + 2 + 1
+
+// UnsignedSwap is a swap transaction built for a wallet to sign
+type UnsignedSwap struct {
+	Transaction          string     `json:"transaction"`
+	LastValidBlockHeight uint64     `json:"last_valid_block_height"`
+	Quote                *SwapQuote `json:"quote"`
+
+	// program is the swap program the transaction must call
+	program string
+}
+
+// NewSwapClient creates a swap client for the configured quote API
+func NewSwapClient(config SwapConfig) *SwapClient {
+	endpoint := strings.TrimSuffix(config.Endpoint, "/")
+	if endpoint == "" {
+		endpoint = DefaultSwapEndpoint
+	}
+
+	httpClient := config.HTTPClient
+	if httpClient == nil {
+		timeout := config.Timeout
+		if timeout <= 0 {
+			timeout = DefaultSwapTimeout
+		}
+		httpClient = &http.Client{Timeout: timeout}
+	}
+
+	program := config.Program
+	if program == "" {
+		program = DefaultSwapProgram
+	}
+
+	return &SwapClient{endpoint: endpoint, program: program, httpClient: httpClient}
+}
+
+// Validate checks the mints are valid and distinct, the amount is positive
+// and the slippage is within bounds
+func (p SwapParams) Validate() error {
+	input, err := solana.PublicKeyFromBase58(p.InputMint)
+	if err != nil {
+		return fmt.Errorf("%w: invalid input mint: %v", ErrInvalidSwap, err)
+	}
+	output, err := solana.PublicKeyFromBase58(p.OutputMint)
+	if err != nil {
+		return fmt.Errorf("%w: invalid output mint: %v", ErrInvalidSwap, err)
+	}
+	if input.Equals(output) {
+		return fmt.Errorf("%w: input and output mints are the same", ErrInvalidSwap)
+	}
+	if p.Amount == 0 {
+		return fmt.Errorf("%w: amount must be greater than zero", ErrInvalidSwap)
+	}
+	if p.SlippageBps < 0 || p.SlippageBps > MaxSlippageBps {
+		return fmt.Errorf("%w: slippage must be between 0 and %d bps", ErrInvalidSwap, MaxSlippageBps)
+	}
+	return nil
+}
+
+// jupiterQuote is the part of a quote API response the client reads.
+// Amounts are strings since they can exceed what JSON numbers hold exactly.
+type jupiterQuote struct {
+	InputMint            string `json:"inputMint"`
+	OutputMint           string `json:"outputMint"`
+	InAmount             string `json:"inAmount"`
+	OutAmount            string `json:"outAmount"`
+	OtherAmountThreshold string `json:"otherAmountThreshold"`
+	SlippageBps          int    `json:"slippageBps"`
+	PriceImpactPct       string `json:"priceImpactPct"`
+}
+
+// Quote fetches the best route for a swap. A zero slippage uses
+// DefaultSlippageBps.
+func (s *SwapClient) Quote(ctx context.Context, params SwapParams) (*SwapQuote, error) {
+	if params.SlippageBps == 0 {
+		params.SlippageBps = DefaultSlippageBps
+	}
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+	query.Set("inputMint", params.InputMint)
+	query.Set("outputMint", params.OutputMint)
+	query.Set("amount", strconv.FormatUint(params.Amount, 10))
+	query.Set("slippageBps", strconv.Itoa(params.SlippageBps))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.endpoint+"/quote?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create quote request: %w", err)
+	}
+
+	raw, err := s.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get quote: %w", err)
+	}
+
+	var jq jupiterQuote
+	if err := json.Unmarshal(raw, &jq); err != nil {
+		return nil, fmt.Errorf("failed to decode quote: %w", err)
+	}
+
+	quote := &SwapQuote{
+		InputMint:      jq.InputMint,
+		OutputMint:     jq.OutputMint,
+		SlippageBps:    jq.SlippageBps,
+		PriceImpactPct: jq.PriceImpactPct,
+		raw:            raw,
+	}
+	if quote.InAmount, err = parseQuoteAmount(jq.InAmount); err != nil {
+		return nil, err
+	}
+	if quote.OutAmount, err = parseQuoteAmount(jq.OutAmount); err != nil {
+		return nil, err
+	}
+	if quote.MinOutAmount, err = parseQuoteAmount(jq.OtherAmountThreshold); err != nil {
+		return nil, err
+	}
+	if quote.OutAmount == 0 {
+		return nil, ErrNoSwapRoute
+	}
+	if quote.InputMint != params.InputMint || quote.OutputMint != params.OutputMint ||
+		quote.InAmount != params.Amount || quote.SlippageBps != params.SlippageBps {
+		return nil, fmt.Errorf("%w: quote is for %d %s to %s at %d bps", ErrSwapMismatch,
+			quote.InAmount, quote.InputMint, quote.OutputMint, quote.SlippageBps)
+	}
+
+	return quote, nil
+}
+
+func parseQuoteAmount(value string) (uint64, error) {
+	amount, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode quote: invalid amount %q", value)
+	}
+	return amount, nil
+}
+
+// BuildSwap builds the transaction for quote, with userPublicKey as the
+// wallet paying for and signing it. The transaction is returned unsigned.
+func (s *SwapClient) BuildSwap(ctx context.Context, quote *SwapQuote, userPublicKey string) (*UnsignedSwap, error) {
+	if _, err := solana.PublicKeyFromBase58(userPublicKey); err != nil {
+		return nil, fmt.Errorf("%w: invalid user public key: %v", ErrInvalidSwap, err)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"quoteResponse":    quote.raw,
+		"userPublicKey":    userPublicKey,
+		"wrapAndUnwrapSol": true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal swap request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint+"/swap", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create swap request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	raw, err := s.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build swap: %w", err)
+	}
+
+	var result struct {
+		SwapTransaction      string `json:"swapTransaction"`
+		LastValidBlockHeight uint64 `json:"lastValidBlockHeight"`
+	}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, fmt.Errorf("failed to decode swap: %w", err)
+	}
+	if result.SwapTransaction == "" {
+		return nil, fmt.Errorf("failed to build swap: no transaction returned")
+	}
+
+	return &UnsignedSwap{
+		Transaction:          result.SwapTransaction,
+		LastValidBlockHeight: result.LastValidBlockHeight,
+		Quote:                quote,
+		program:              s.program,
+	}, nil
+}
+
+// do sends req and returns the response body, mapping the quote API's
+// no-route error to ErrNoSwapRoute
+func (s *SwapClient) do(req *http.Request) ([]byte, error) {
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		return body, nil
+	}
+
+	var apiErr struct {
+		Error     string `json:"error"`
+		ErrorCode string `json:"errorCode"`
+	}
+	json.Unmarshal(body, &apiErr)
+	if apiErr.ErrorCode == "COULD_NOT_FIND_ANY_ROUTE" || apiErr.ErrorCode == "NO_ROUTES_FOUND" {
+		return nil, fmt.Errorf("%w: %s", ErrNoSwapRoute, apiErr.Error)
+	}
+	if apiErr.Error != "" {
+		return nil, fmt.Errorf("quote API returned %d: %s", resp.StatusCode, apiErr.Error)
+	}
+	return nil, fmt.Errorf("quote API returned %d", resp.StatusCode)
+}
+
+// ExecuteSwap signs a swap built for wallet and sends it, returning the
+// signature. The transaction comes from the quote API, so it is only signed
+// if the client has a ProgramPolicy allowlist and the transaction matches
+// the swap's quote; see verifySwap.
+func (c *Client) ExecuteSwap(ctx context.Context, swap *UnsignedSwap, wallet *Wallet) (string, error) {
+	if wallet == nil {
+		return "", fmt.Errorf("wallet is required to sign a swap")
+	}
+	if c.programs == nil || len(c.programs.allowed) == 0 {
+		return "", ErrSwapPolicyRequired
+	}
+
+	data, err := base64.StdEncoding.DecodeString(swap.Transaction)
+	if err != nil {
+		return "", fmt.Errorf("%w: swap transaction is not valid base64", ErrInvalidTransaction)
+	}
+	tx, err := solana.TransactionFromDecoder(solana.NewBinDecoder(data))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidTransaction, err)
+	}
+
+	payer, err := solana.PublicKeyFromBase58(wallet.GetAddress())
+	if err != nil {
+		return "", fmt.Errorf("invalid wallet address: %w", err)
+	}
+	if err := verifySwap(tx, swap, payer); err != nil {
+		return "", err
+	}
+
+	if err := wallet.SignTransaction(tx); err != nil {
+		return "", fmt.Errorf("failed to sign swap: %w", err)
+	}
+
+	sig, err := c.sendTransaction(ctx, tx)
+	if err != nil {
+		return "", err
+	}
+	return sig.String(), nil
+}
+
+// Instructions a swap transaction may carry besides the swap itself
+const (
+	systemTransfer    = 2
+	tokenCloseAccount = 9
+	tokenSyncNative   = 17
+)
+
+// computeBudgetProgram sets the compute limit and priority fee of a
+// transaction
+var computeBudgetProgram = solana.MustPublicKeyFromBase58("ComputeBudget111111111111111111111111111111")
+
+// verifySwap checks a swap transaction from the quote API does only what
+// its quote says before payer signs it: payer pays the fees and is the only
+// signer, exactly one instruction calls the swap program with the quoted
+// amounts and slippage, and the rest only set the compute budget, create
+// payer's token accounts, wrap SOL into payer's wrapped SOL account and
+// close accounts back to payer.
+func verifySwap(tx *solana.Transaction, swap *UnsignedSwap, payer solana.PublicKey) error {
+	program, err := solana.PublicKeyFromBase58(swap.program)
+	if err != nil {
+		return fmt.Errorf("%w: invalid swap program %q", ErrSwapMismatch, swap.program)
+	}
+	quote := swap.Quote
+	if quote == nil {
+		return fmt.Errorf("%w: swap has no quote", ErrSwapMismatch)
+	}
+
+	keys := tx.Message.AccountKeys
+	if len(keys) == 0 || !keys[0].Equals(payer) {
+		return fmt.Errorf("%w: fee payer is not the wallet", ErrSwapMismatch)
+	}
+	if tx.Message.Header.NumRequiredSignatures != 1 {
+		return fmt.Errorf("%w: transaction needs %d signers", ErrSwapMismatch, tx.Message.Header.NumRequiredSignatures)
+	}
+
+	// account returns the static account key an instruction refers to at i
+	account := func(inst solana.CompiledInstruction, i int) (solana.PublicKey, bool) {
+		if i >= len(inst.Accounts) || int(inst.Accounts[i]) >= len(keys) {
+			return solana.PublicKey{}, false
+		}
+		return keys[inst.Accounts[i]], true
+	}
+
+	wrappedSOL, _, err := solana.FindAssociatedTokenAddress(payer, solana.SolMint)
+	if err != nil {
+		return fmt.Errorf("failed to derive wrapped SOL account: %w", err)
+	}
+
+	swaps := 0
+	var wrapped uint64
+	for i, inst := range tx.Message.Instructions {
+		id, err := tx.ResolveProgramIDIndex(inst.ProgramIDIndex)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidTransaction, err)
+		}
+		data := []byte(inst.Data)
+
+		switch {
+		case id.Equals(program):
+			swaps++
+			if len(data) < swapInstructionTail {
+				return fmt.Errorf("%w: instruction %d is not a swap route", ErrSwapMismatch, i)
+			}
+			tail := data[len(data)-swapInstructionTail:]
+			in := binary.LittleEndian.Uint64(tail[0:8])
+			out := binary.LittleEndian.Uint64(tail[8:16])
+			slippage := int(binary.LittleEndian.Uint16(tail[16:18]))
+			if in != quote.InAmount || out != quote.OutAmount || slippage != quote.SlippageBps {
+				return fmt.Errorf("%w: instruction %d swaps %d for %d at %d bps, quoted %d for %d at %d bps",
+					ErrSwapMismatch, i, in, out, slippage, quote.InAmount, quote.OutAmount, quote.SlippageBps)
+			}
+
+		case id.Equals(solana.SystemProgramID):
+			from, okFrom := account(inst, 0)
+			to, okTo := account(inst, 1)
+			if len(data) != 12 || binary.LittleEndian.Uint32(data) != systemTransfer ||
+				!okFrom || !okTo || !from.Equals(payer) || !to.Equals(wrappedSOL) {
+				return fmt.Errorf("%w: instruction %d is a system instruction other than wrapping SOL", ErrSwapMismatch, i)
+			}
+			wrapped += binary.LittleEndian.Uint64(data[4:])
+
+		case id.Equals(solana.TokenProgramID):
+			if len(data) == 0 {
+				return fmt.Errorf("%w: instruction %d is an empty token instruction", ErrSwapMismatch, i)
+			}
+			switch data[0] {
+			case tokenSyncNative:
+			case tokenCloseAccount:
+				if to, ok := account(inst, 1); !ok || !to.Equals(payer) {
+					return fmt.Errorf("%w: instruction %d closes an account to another wallet", ErrSwapMismatch, i)
+				}
+			default:
+				return fmt.Errorf("%w: instruction %d is an unexpected token instruction", ErrSwapMismatch, i)
+			}
+
+		case id.Equals(solana.SPLAssociatedTokenAccountProgramID), id.Equals(computeBudgetProgram):
+
+		default:
+			return fmt.Errorf("%w: instruction %d calls unexpected program %s", ErrSwapMismatch, i, id)
+		}
+	}
+
+	if swaps != 1 {
+		return fmt.Errorf("%w: transaction has %d swap instructions", ErrSwapMismatch, swaps)
+	}
+	if wrapped > 0 && (quote.InputMint != solana.SolMint.String() || wrapped > quote.InAmount) {
+		return fmt.Errorf("%w: transaction wraps %d lamports for a swap of %d %s",
+			ErrSwapMismatch, wrapped, quote.InAmount, quote.InputMint)
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package solana
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/labs-alone/alone-main/internal/utils"
+	"github.com/labs-alone/alone-main/pkg/logger"
+)
+
+// DefaultRetryDelay is the wait before the first retry of a failed RPC call
+// when ClientConfig.RetryDelay is unset
+const DefaultRetryDelay = 500 * time.Millisecond
+
+// rpcStatusError is a retryable HTTP status returned by the RPC node
+type rpcStatusError struct {
+	status     int
+	retryAfter time.Duration
+}
+
+func (e *rpcStatusError) Error() string {
+	return fmt.Sprintf("rpc returned %d %s", e.status, http.StatusText(e.status))
+}
+
+// RetryAfter is the delay the node asked for, zero if it didn't say
+func (e *rpcStatusError) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+// retryTransport retries RPC requests that fail at the HTTP level (rate
+// limiting, server errors and connection failures) with backoff, honoring
+// Retry-After on 429 responses. Each request, retries included, is bounded
+// by timeout or the remaining budget of its context. Requests are aborted
+// when closing is closed.
+type retryTransport struct {
+	base    http.RoundTripper
+	policy  utils.RetryPolicy
+	clock   utils.Clock
+	timeout time.Duration
+	closing <-chan struct{}
+	logger  logger.Logger
+}
+
+func newRetryTransport(config *ClientConfig, closing <-chan struct{}, logger logger.Logger) *retryTransport {
+	delay := config.RetryDelay
+	if delay <= 0 {
+		delay = DefaultRetryDelay
+	}
+	clock := config.Clock
+	if clock == nil {
+		clock = utils.SystemClock{}
+	}
+
+	return &retryTransport{
+		base: http.DefaultTransport,
+		policy: utils.RetryPolicy{
+			MaxRetries: config.MaxRetries,
+			Delay:      delay,
+			MaxDelay:   config.MaxRetryDelay,
+			Clock:      clock,
+		},
+		clock:   clock,
+		timeout: config.Timeout,
+		closing: closing,
+		logger:  logger,
+	}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	select {
+	case <-t.closing:
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, ErrClientClosed
+	default:
+	}
+
+	// Cancel the request, including any response body still being read,
+	// when either the caller gives up, the timeout passes or the client is
+	// closed
+	ctx, cancel := utils.WithCallTimeout(req.Context(), t.timeout)
+	go func() {
+		select {
+		case <-t.closing:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	resp, err := t.roundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		select {
+		case <-t.closing:
+			return nil, fmt.Errorf("%w: %v", ErrClientClosed, err)
+		default:
+			return nil, err
+		}
+	}
+
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose releases the request context once the body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+func (t *retryTransport) roundTrip(req *http.Request) (*http.Response, error) {
+	// The body is read once so each attempt can resend it
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var resp *http.Response
+	attempts := 0
+	err := utils.Retry(req.Context(), t.policy, func() error {
+		attempts++
+		attempt := req.Clone(req.Context())
+		if body != nil {
+			attempt.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		r, err := t.base.RoundTrip(attempt)
+		if err != nil {
+			if req.Context().Err() != nil {
+				return utils.Permanent(err)
+			}
+			t.logger.Warn("RPC request failed",
+				"attempt", attempts,
+				"error", err.Error(),
+			)
+			return err
+		}
+
+		// The last attempt's response is passed through so the RPC client
+		// reports the node's error
+		if !retryableStatus(r.StatusCode) || attempts > t.policy.MaxRetries {
+			resp = r
+			return nil
+		}
+
+		io.Copy(io.Discard, r.Body)
+		r.Body.Close()
+
+		t.logger.Warn("RPC request throttled",
+			"attempt", attempts,
+			"status", r.StatusCode,
+		)
+		return &rpcStatusError{
+			status:     r.StatusCode,
+			retryAfter: utils.ParseRetryAfter(r.Header.Get("Retry-After"), t.clock.Now()),
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func retryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
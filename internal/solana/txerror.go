@@ -0,0 +1,135 @@
+package solana
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc/jsonrpc"
+)
+
+// TransactionError is an on-chain failure reported by the RPC node when a
+// transaction fails preflight simulation
+type TransactionError struct {
+	// Message is the node's error message
+	Message string `json:"message"`
+	// Kind is the error variant, e.g. "Custom", "InsufficientFunds" or
+	// "BlockhashNotFound"
+	Kind string `json:"kind"`
+	// InstructionIndex is the failing instruction, or -1 if the error isn't
+	// tied to one
+	InstructionIndex int `json:"instruction_index"`
+	// Code is the program's custom error code when Kind is "Custom"
+	Code *uint32 `json:"code,omitempty"`
+	// Logs are the program logs from simulation
+	Logs []string `json:"logs,omitempty"`
+}
+
+func (e *TransactionError) Error() string {
+	detail := e.Kind
+	if e.Code != nil {
+		detail = fmt.Sprintf("custom program error 0x%x", *e.Code)
+	}
+	if e.InstructionIndex >= 0 {
+		return fmt.Sprintf("instruction %d failed: %s", e.InstructionIndex, detail)
+	}
+	return fmt.Sprintf("transaction failed: %s", detail)
+}
+
+// IsCustom reports whether the failure is the given custom program error
+func (e *TransactionError) IsCustom(code uint32) bool {
+	return e.Code != nil && *e.Code == code
+}
+
+// sendTransaction sends tx, returning on-chain failures as a wrapped
+// *TransactionError
+func (c *Client) sendTransaction(ctx context.Context, tx *solana.Transaction) (solana.Signature, error) {
+	sig, err := c.rpcClient.SendTransaction(ctx, tx)
+	if err != nil {
+		if txErr := parseTransactionError(err); txErr != nil {
+			return solana.Signature{}, fmt.Errorf("failed to send transaction: %w", txErr)
+		}
+		return solana.Signature{}, fmt.Errorf("failed to send transaction: %w", err)
+	}
+	return sig, nil
+}
+
+// parseTransactionError extracts a TransactionError from an RPC error whose
+// data carries a transaction error, returning nil for any other error
+func parseTransactionError(err error) *TransactionError {
+	var rpcErr *jsonrpc.RPCError
+	if !errors.As(err, &rpcErr) || rpcErr.Data == nil {
+		return nil
+	}
+
+	// Round trip the loosely typed data into the shape we expect
+	raw, marshalErr := json.Marshal(rpcErr.Data)
+	if marshalErr != nil {
+		return nil
+	}
+	var data struct {
+		Err  json.RawMessage `json:"err"`
+		Logs []string        `json:"logs"`
+	}
+	if json.Unmarshal(raw, &data) != nil || len(data.Err) == 0 || string(data.Err) == "null" {
+		return nil
+	}
+
+	txErr := &TransactionError{
+		Message:          rpcErr.Message,
+		InstructionIndex: -1,
+		Logs:             data.Logs,
+	}
+	if !parseErrorVariant(data.Err, txErr) {
+		return nil
+	}
+	return txErr
+}
+
+// parseErrorVariant decodes a serialized TransactionError enum, which is
+// either a bare variant name or a single-key object holding its payload
+func parseErrorVariant(raw json.RawMessage, txErr *TransactionError) bool {
+	var name string
+	if json.Unmarshal(raw, &name) == nil {
+		txErr.Kind = name
+		return true
+	}
+
+	var variant map[string]json.RawMessage
+	if json.Unmarshal(raw, &variant) != nil || len(variant) == 0 {
+		return false
+	}
+	keys := make([]string, 0, len(variant))
+	for k := range variant {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	kind, payload := keys[0], variant[keys[0]]
+
+	switch kind {
+	case "InstructionError":
+		// [index, InstructionError]
+		var pair []json.RawMessage
+		if json.Unmarshal(payload, &pair) != nil || len(pair) != 2 {
+			return false
+		}
+		if json.Unmarshal(pair[0], &txErr.InstructionIndex) != nil {
+			return false
+		}
+		return parseErrorVariant(pair[1], txErr)
+	case "Custom":
+		var code uint32
+		if json.Unmarshal(payload, &code) != nil {
+			return false
+		}
+		txErr.Kind = kind
+		txErr.Code = &code
+		return true
+	default:
+		txErr.Kind = kind
+		return true
+	}
+}
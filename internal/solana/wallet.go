@@ -2,34 +2,44 @@ package solana
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
+	bin "github.com/gagliardetto/binary"
 	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/programs/token"
 	"github.com/gagliardetto/solana-go/rpc"
 	"github.com/labs-alone/alone-main/internal/utils"
+	"github.com/labs-alone/alone-main/pkg/logger"
 )
 
 // Wallet manages Solana wallet operations
 type Wallet struct {
 	keypair    *solana.Keypair
 	client     *Client
-	logger     *utils.Logger
+	logger     logger.Logger
 	cache      *sync.Map
 	lastUpdate time.Time
-	mu         sync.RWMutex
+	// mu guards lastUpdate only. It is never held across RPC calls, so
+	// wallet methods can call each other without deadlocking.
+	mu sync.RWMutex
 }
 
 // WalletInfo contains wallet information
 type WalletInfo struct {
 	Address     string                 `json:"address"`
-	Balance     uint64                 `json:"balance"`
+	Balance     Lamports               `json:"balance"`
 	Tokens      []TokenBalance         `json:"tokens"`
 	NFTs        []NFTInfo             `json:"nfts"`
 	LastUpdated time.Time             `json:"last_updated"`
 	Metadata    map[string]interface{} `json:"metadata"`
+
+	// Warnings describe enrichment that could not be fetched; the rest of
+	// the info is still valid
+	Warnings []string `json:"warnings,omitempty"`
 }
 
 // TokenBalance represents a token balance
@@ -61,19 +71,28 @@ func NewWallet(client *Client, keypairData []byte) (*Wallet, error) {
 	return &Wallet{
 		keypair:    keypair,
 		client:     client,
-		logger:     utils.NewLogger(),
+		logger:     walletLogger(client),
 		cache:      &sync.Map{},
 		lastUpdate: time.Now(),
 	}, nil
 }
 
+// walletLogger returns the logger of client, which may be nil for wallets
+// used only to sign
+func walletLogger(client *Client) logger.Logger {
+	if client == nil {
+		return utils.NewLogger()
+	}
+	return client.logger
+}
+
 // GetAddress returns the wallet's public address
 func (w *Wallet) GetAddress() string {
 	return w.keypair.PublicKey.String()
 }
 
 // GetBalance returns the wallet's SOL balance
-func (w *Wallet) GetBalance(ctx context.Context) (uint64, error) {
+func (w *Wallet) GetBalance(ctx context.Context) (Lamports, error) {
 	balance, err := w.client.GetBalance(ctx, w.GetAddress())
 	if err != nil {
 		return 0, fmt.Errorf("failed to get balance: %w", err)
@@ -81,41 +100,91 @@ func (w *Wallet) GetBalance(ctx context.Context) (uint64, error) {
 	return balance, nil
 }
 
-// GetInfo returns comprehensive wallet information
+// GetInfo returns comprehensive wallet information. Only the balance is
+// required; token and NFT failures are reported in Warnings. Metadata
+// records how long each section took under "timings". It is safe to call
+// concurrently with itself and the other wallet methods.
 func (w *Wallet) GetInfo(ctx context.Context) (*WalletInfo, error) {
-	w.mu.Lock()
-	defer w.mu.Unlock()
+	timings := make(map[string]string)
+	start := time.Now()
 
 	balance, err := w.GetBalance(ctx)
 	if err != nil {
 		return nil, err
 	}
+	timings["balance"] = time.Since(start).String()
 
-	tokens, err := w.getTokenBalances(ctx)
+	info := &WalletInfo{
+		Address:  w.GetAddress(),
+		Balance:  balance,
+		Tokens:   []TokenBalance{},
+		NFTs:     []NFTInfo{},
+		Metadata: map[string]interface{}{"timings": timings},
+	}
+
+	start = time.Now()
+	tokens, warnings, err := w.getTokenBalances(ctx)
+	timings["tokens"] = time.Since(start).String()
 	if err != nil {
-		return nil, err
+		info.Warnings = append(info.Warnings, fmt.Sprintf("token balances unavailable: %v", err))
+	} else {
+		info.Tokens = tokens
 	}
+	info.Warnings = append(info.Warnings, warnings...)
 
-	nfts, err := w.getNFTs(ctx)
+	start = time.Now()
+	nfts, warnings, err := w.getNFTs(ctx)
+	timings["nfts"] = time.Since(start).String()
 	if err != nil {
-		return nil, err
+		info.Warnings = append(info.Warnings, fmt.Sprintf("NFTs unavailable: %v", err))
+	} else {
+		info.NFTs = nfts
 	}
+	info.Warnings = append(info.Warnings, warnings...)
 
-	info := &WalletInfo{
-		Address:     w.GetAddress(),
-		Balance:     balance,
-		Tokens:      tokens,
-		NFTs:        nfts,
-		LastUpdated: time.Now(),
-		Metadata:    make(map[string]interface{}),
+	if len(info.Warnings) > 0 {
+		w.logger.Warn("Wallet info incomplete",
+			"address", info.Address,
+			"warnings", info.Warnings,
+		)
 	}
 
-	w.lastUpdate = time.Now()
+	info.LastUpdated = time.Now()
+	w.mu.Lock()
+	if info.LastUpdated.After(w.lastUpdate) {
+		w.lastUpdate = info.LastUpdated
+	}
+	w.mu.Unlock()
 	return info, nil
 }
 
-// SignTransaction signs a transaction
+// LastUpdated returns when GetInfo last completed, or when the wallet was
+// created if it hasn't
+func (w *Wallet) LastUpdated() time.Time {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.lastUpdate
+}
+
+// SignTransaction signs a transaction. Transactions calling a program the
+// client's ProgramPolicy rejects are refused with a *ProgramViolation and
+// recorded in the audit log.
 func (w *Wallet) SignTransaction(transaction *solana.Transaction) error {
+	if w.client != nil {
+		if err := w.client.programs.check(transaction); err != nil {
+			var violation *ProgramViolation
+			if errors.As(err, &violation) {
+				w.client.audit.Warn("Refused to sign transaction",
+					"wallet", w.GetAddress(),
+					"program", violation.Program.String(),
+					"instruction", violation.Instruction,
+					"reason", violation.Reason,
+				)
+			}
+			return err
+		}
+	}
+
 	_, err := transaction.Sign(func(key solana.PublicKey) *solana.PrivateKey {
 		if key.Equals(w.keypair.PublicKey) {
 			return &w.keypair.PrivateKey
@@ -125,88 +194,226 @@ func (w *Wallet) SignTransaction(transaction *solana.Transaction) error {
 	return err
 }
 
-// SendSOL sends SOL to a recipient
-func (w *Wallet) SendSOL(ctx context.Context, recipient string, amount uint64) (string, error) {
-	recipientPubKey, err := solana.PublicKeyFromBase58(recipient)
-	if err != nil {
-		return "", fmt.Errorf("invalid recipient address: %w", err)
-	}
-
-	tx, err := solana.NewTransaction(
-		[]solana.Instruction{
-			solana.NewInstruction(
-				solana.SystemProgramID,
-				[]byte{2, 0, 0, 0}, // Transfer instruction
-				[]solana.AccountMeta{
-					{PublicKey: w.keypair.PublicKey, IsSigner: true, IsWritable: true},
-					{PublicKey: recipientPubKey, IsSigner: false, IsWritable: true},
-				},
-				amount,
-			),
-		},
-		w.keypair.PublicKey,
-	)
+// Transfer stages, logged under "stage" as SendSOL reaches them
+const (
+	stageBuild    = "build"
+	stageSign     = "sign"
+	stageSimulate = "simulate"
+	stageSubmit   = "submit"
+	stageConfirm  = "confirm"
+)
+
+// SendSOL sends amount to a recipient and returns once the transfer is
+// submitted. Each stage is logged with how long it took.
+func (w *Wallet) SendSOL(ctx context.Context, recipient string, amount Lamports) (string, error) {
+	return w.sendSOL(ctx, recipient, amount, false)
+}
+
+// SendSOLConfirmed is SendSOL that also simulates the transfer before
+// submitting it, so on-chain failures are returned as a *TransactionError
+// without paying fees, and then waits up to the client's ConfirmTimeout for
+// it to reach the client's commitment. On a timeout the signature is
+// returned with the error, as the transfer may still land.
+func (w *Wallet) SendSOLConfirmed(ctx context.Context, recipient string, amount Lamports) (string, error) {
+	return w.sendSOL(ctx, recipient, amount, true)
+}
+
+func (w *Wallet) sendSOL(ctx context.Context, recipient string, amount Lamports, confirm bool) (string, error) {
+	log := w.logger.With("recipient", shortAddress(recipient), "amount", amount)
+
+	start := time.Now()
+	tx, lastValid, err := w.client.BuildTransfer(ctx, w.GetAddress(), recipient, uint64(amount))
 	if err != nil {
-		return "", fmt.Errorf("failed to create transaction: %w", err)
+		logStage(log, stageBuild, start, err)
+		return "", err
 	}
+	logStage(log, stageBuild, start, nil, "last_valid_block_height", lastValid)
 
+	start = time.Now()
 	if err := w.SignTransaction(tx); err != nil {
+		logStage(log, stageSign, start, err)
 		return "", fmt.Errorf("failed to sign transaction: %w", err)
 	}
+	signature := tx.Signatures[0]
+	log = log.With("signature", signature.String())
+	logStage(log, stageSign, start, nil)
+
+	if confirm {
+		start = time.Now()
+		if err := w.client.simulateTransaction(ctx, tx); err != nil {
+			logStage(log, stageSimulate, start, err)
+			return "", err
+		}
+		logStage(log, stageSimulate, start, nil)
+	}
+
+	start = time.Now()
+	if _, err := w.client.sendTransaction(ctx, tx); err != nil {
+		logStage(log, stageSubmit, start, err)
+		return "", err
+	}
+	logStage(log, stageSubmit, start, nil)
+	if !confirm {
+		return signature.String(), nil
+	}
 
-	serializedTx, err := tx.MarshalBinary()
+	start = time.Now()
+	if err := w.client.confirmTransfer(ctx, signature, lastValid); err != nil {
+		logStage(log, stageConfirm, start, err)
+		return signature.String(), err
+	}
+	logStage(log, stageConfirm, start, nil, "commitment", w.client.config.Commitment)
+
+	return signature.String(), nil
+}
+
+// logStage logs the outcome of one SendSOL stage. Failures are errors;
+// submission and confirmation are info, as the points an operator follows a
+// transfer by, and the stages before them are debug.
+func logStage(log logger.Logger, stage string, start time.Time, err error, keysAndValues ...interface{}) {
+	fields := append([]interface{}{"stage", stage, "elapsed", time.Since(start)}, keysAndValues...)
+	if err != nil {
+		log.Error("Transfer stage failed", append(fields, "outcome", "failed", "error", err)...)
+		return
+	}
+
+	fields = append(fields, "outcome", "ok")
+	if stage == stageSubmit || stage == stageConfirm {
+		log.Info("Transfer stage completed", fields...)
+		return
+	}
+	log.Debug("Transfer stage completed", fields...)
+}
+
+// shortAddress shortens an address to its first and last four characters
+// so logs identify the recipient without repeating it in full
+func shortAddress(address string) string {
+	if len(address) <= 8 {
+		return address
+	}
+	return address[:4] + "..." + address[len(address)-4:]
+}
+
+// GetTokenBalance returns the wallet's balance of mint. found is false when
+// the wallet has no token account for the mint, as opposed to an account
+// holding zero tokens. Balances of multiple accounts for one mint are summed.
+func (w *Wallet) GetTokenBalance(ctx context.Context, mint string) (balance uint64, found bool, err error) {
+	mintKey, err := solana.PublicKeyFromBase58(mint)
 	if err != nil {
-		return "", fmt.Errorf("failed to serialize transaction: %w", err)
+		return 0, false, fmt.Errorf("invalid mint address: %w", err)
 	}
 
-	signature, err := w.client.SendTransaction(ctx, serializedTx)
+	accounts, err := w.getTokenAccounts(ctx, &rpc.GetTokenAccountsConfig{
+		Mint: mintKey.ToPointer(),
+	})
 	if err != nil {
-		return "", fmt.Errorf("failed to send transaction: %w", err)
+		return 0, false, err
+	}
+
+	for _, account := range accounts.Value {
+		var data token.Account
+		if err := bin.NewBinDecoder(account.Account.Data.GetBinary()).Decode(&data); err != nil {
+			return 0, false, fmt.Errorf("failed to decode token account %s: %w", account.Pubkey, err)
+		}
+		balance += data.Amount
+		found = true
 	}
 
-	return signature, nil
+	return balance, found, nil
 }
 
-// getTokenBalances retrieves all token balances
-func (w *Wallet) getTokenBalances(ctx context.Context) ([]TokenBalance, error) {
+// getTokenAccounts lists the wallet's token accounts matching conf
+func (w *Wallet) getTokenAccounts(ctx context.Context, conf *rpc.GetTokenAccountsConfig) (*rpc.GetTokenAccountsResult, error) {
 	accounts, err := w.client.rpcClient.GetTokenAccountsByOwner(
 		ctx,
 		w.keypair.PublicKey,
-		&rpc.GetTokenAccountsConfig{
-			ProgramId: solana.TokenProgramID,
+		conf,
+		&rpc.GetTokenAccountsOpts{
+			Encoding: solana.EncodingBase64,
 		},
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get token accounts: %w", err)
 	}
+	return accounts, nil
+}
+
+// getTokenBalances retrieves all token balances. Accounts that can't be
+// decoded are skipped and reported as warnings.
+func (w *Wallet) getTokenBalances(ctx context.Context) ([]TokenBalance, []string, error) {
+	accounts, err := w.getTokenAccounts(ctx, &rpc.GetTokenAccountsConfig{
+		ProgramId: solana.TokenProgramID.ToPointer(),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
 
-	var balances []TokenBalance
+	balances := []TokenBalance{}
+	var warnings []string
 	for _, account := range accounts.Value {
-		var data solana.TokenAccount
-		if err := data.UnmarshalBinary(account.Account.Data.GetBinary()); err != nil {
+		var data token.Account
+		if err := bin.NewBinDecoder(account.Account.Data.GetBinary()).Decode(&data); err != nil {
+			warnings = append(warnings, fmt.Sprintf("failed to decode token account %s: %v", account.Pubkey, err))
 			continue
 		}
 
 		balance := TokenBalance{
 			Mint:      data.Mint.String(),
 			Balance:   data.Amount,
-			Decimals: data.Decimals,
 			Authority: data.Owner.String(),
 		}
 		balances = append(balances, balance)
 	}
 
-	return balances, nil
+	// Token accounts don't carry their mint's decimals, so they're read from
+	// the mints themselves
+	mints := make([]string, 0, len(balances))
+	for _, balance := range balances {
+		mints = append(mints, balance.Mint)
+	}
+	decimals, errs := w.mintDecimals(ctx, mints)
+	for i := range balances {
+		balances[i].Decimals = decimals[balances[i].Mint]
+	}
+
+	failed := make([]string, 0, len(errs))
+	for mint := range errs {
+		failed = append(failed, mint)
+	}
+	sort.Strings(failed)
+	for _, mint := range failed {
+		warnings = append(warnings, fmt.Sprintf("decimals unavailable for mint %s: %v", mint, errs[mint]))
+	}
+
+	return balances, warnings, nil
+}
+
+// mintDecimals reads the decimals of each mint. Mints that can't be fetched
+// or decoded are reported in errs.
+func (w *Wallet) mintDecimals(ctx context.Context, mints []string) (map[string]uint8, map[string]error) {
+	accounts, errs := w.client.GetMultipleAccounts(ctx, mints)
+
+	decimals := make(map[string]uint8, len(accounts))
+	for address, account := range accounts {
+		var mint token.Mint
+		if err := bin.NewBinDecoder(account.Data).Decode(&mint); err != nil {
+			errs[address] = fmt.Errorf("failed to decode mint: %w", err)
+			continue
+		}
+		decimals[address] = mint.Decimals
+	}
+	return decimals, errs
 }
 
-// getNFTs retrieves all NFTs owned by the wallet
-func (w *Wallet) getNFTs(ctx context.Context) ([]NFTInfo, error) {
+// getNFTs retrieves all NFTs owned by the wallet. Per-NFT metadata failures
+// are returned as warnings.
+func (w *Wallet) getNFTs(ctx context.Context) ([]NFTInfo, []string, error) {
 	// This is a simplified implementation
 	// In a real application, you would need to:
 	// 1. Query Metaplex accounts
 	// 2. Fetch metadata from URIs
 	// 3. Filter for actual NFTs
-	return []NFTInfo{}, nil
+	return []NFTInfo{}, nil, nil
 }
 
 // ExportPrivateKey exports the private key (use with caution)
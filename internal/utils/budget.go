@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"context"
+	"time"
+)
+
+// A request's deadline budget is the deadline on its context. Downstream
+// calls made while serving the request take their timeout from what is left
+// of it, so a Solana call followed by an OpenAI call share one budget instead
+// of each getting a fresh full timeout.
+
+// WithBudget starts a deadline budget of d for a request. If ctx already has
+// an earlier deadline, that deadline is the budget.
+func WithBudget(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, d)
+}
+
+// RemainingBudget returns how much of ctx's deadline budget is left, or
+// false if ctx has no deadline. It is zero once the deadline has passed.
+func RemainingBudget(ctx context.Context) (time.Duration, bool) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+	remaining := time.Until(deadline)
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining, true
+}
+
+// WithCallTimeout bounds a downstream call by timeout, or by the remaining
+// budget of ctx if that is shorter. A timeout of zero or less leaves the
+// call bounded by the budget alone.
+func WithCallTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	if remaining, ok := RemainingBudget(ctx); ok && remaining < timeout {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
@@ -2,9 +2,13 @@ package utils
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 	"sync"
 
 	"gopkg.in/yaml.v3"
@@ -34,10 +38,12 @@ type Config struct {
 
 	// OpenAI settings
 	OpenAI struct {
-		APIKey      string  `json:"api_key" yaml:"api_key"`
+		APIKey      string  `json:"api_key" yaml:"api_key" secret:"true"`
 		Model       string  `json:"model" yaml:"model"`
 		MaxTokens   int     `json:"max_tokens" yaml:"max_tokens"`
 		Temperature float32 `json:"temperature" yaml:"temperature"`
+		// FallbackModels are used in order when Model is overloaded
+		FallbackModels []string `json:"fallback_models" yaml:"fallback_models"`
 	} `json:"openai" yaml:"openai"`
 
 	// Database settings
@@ -46,7 +52,7 @@ type Config struct {
 		Port     int    `json:"port" yaml:"port"`
 		Name     string `json:"name" yaml:"name"`
 		User     string `json:"user" yaml:"user"`
-		Password string `json:"password" yaml:"password"`
+		Password string `json:"password" yaml:"password" secret:"true"`
 		SSLMode  string `json:"ssl_mode" yaml:"ssl_mode"`
 	} `json:"database" yaml:"database"`
 
@@ -55,8 +61,11 @@ type Config struct {
 		Enabled  bool   `json:"enabled" yaml:"enabled"`
 		Type     string `json:"type" yaml:"type"`
 		Address  string `json:"address" yaml:"address"`
-		Password string `json:"password" yaml:"password"`
+		Password string `json:"password" yaml:"password" secret:"true"`
 		TTL      int    `json:"ttl" yaml:"ttl"`
+		// MaxEntries bounds the responses cached; 0 is the default of the
+		// cache using it
+		MaxEntries int `json:"max_entries" yaml:"max_entries"`
 	} `json:"cache" yaml:"cache"`
 
 	// Metrics settings
@@ -65,7 +74,55 @@ type Config struct {
 		Path    string `json:"path" yaml:"path"`
 	} `json:"metrics" yaml:"metrics"`
 
+	// Security settings
+	Security struct {
+		// HSTSMaxAge is the Strict-Transport-Security max-age in seconds.
+		// Zero keeps the default of a year; a negative value omits the
+		// header, e.g. behind a proxy that sets its own.
+		HSTSMaxAge int `json:"hsts_max_age" yaml:"hsts_max_age"`
+		// HSTSExcludeSubdomains leaves includeSubDomains out of the header
+		HSTSExcludeSubdomains bool `json:"hsts_exclude_subdomains" yaml:"hsts_exclude_subdomains"`
+	} `json:"security" yaml:"security"`
+
+	// Maintenance settings
+	Maintenance struct {
+		Enabled bool `json:"enabled" yaml:"enabled"`
+		// AllowedUsers can still write while maintenance mode is on
+		AllowedUsers []string `json:"allowed_users" yaml:"allowed_users"`
+	} `json:"maintenance" yaml:"maintenance"`
+
+	// Startup settings
+	Startup struct {
+		// FailOnSelfCheck aborts startup when a critical self-check fails
+		// instead of logging a warning
+		FailOnSelfCheck bool `json:"fail_on_self_check" yaml:"fail_on_self_check"`
+	} `json:"startup" yaml:"startup"`
+
 	mu sync.RWMutex
+	// patchListeners are notified after a successful Patch
+	patchListeners []func(patched *Config, keys []string)
+}
+
+// Config errors
+var (
+	// ErrUnknownConfigKey is returned for a key that names no setting
+	ErrUnknownConfigKey = errors.New("unknown config key")
+	// ErrSecretConfigKey is returned when a patch touches a secret setting
+	ErrSecretConfigKey = errors.New("secret config key")
+	// ErrReadOnlyConfigKey is returned when a patch touches a setting that
+	// can't change at runtime
+	ErrReadOnlyConfigKey = errors.New("config key can't be changed at runtime")
+	// ErrInvalidConfigPatch is returned for a malformed patch or one that
+	// leaves the configuration invalid
+	ErrInvalidConfigPatch = errors.New("invalid config patch")
+)
+
+// patchableKeys are the settings Patch may change. Anything else, such as
+// endpoints, hosts, credentials and access lists, is only read at startup
+// and would need its consumers rebuilt, so it takes a restart to change.
+var patchableKeys = map[string]bool{
+	"log_level": true,
+	"cache.ttl": true,
 }
 
 // LoadConfig loads configuration from a file
@@ -146,24 +203,208 @@ func (c *Config) Save(path string) error {
 	return nil
 }
 
-// Get retrieves a configuration value
+// Get retrieves a configuration value by its dotted JSON path, e.g.
+// "cache.ttl". It returns nil for unknown keys.
 func (c *Config) Get(key string) interface{} {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	// Add implementation for getting nested config values
-	return nil
+	field, _, err := c.field(key)
+	if err != nil {
+		return nil
+	}
+	return field.Interface()
 }
 
-// Set updates a configuration value
+// Set updates a configuration value by its dotted JSON path, e.g.
+// "cache.ttl". The value is converted as JSON would be, so a float64 from
+// decoded JSON can set an int setting; nil resets the setting to its zero
+// value.
 func (c *Config) Set(key string, value interface{}) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	// Add implementation for setting nested config values
+	return c.set(key, value)
+}
+
+// Patch applies a JSON merge patch (RFC 7396) to the configuration, e.g.
+// {"log_level": "debug", "cache": {"ttl": 600}}, and returns the keys it
+// changed. The patched configuration is validated first and only applied if
+// valid, so a failed patch leaves the configuration untouched. Only the
+// settings in patchableKeys can be patched; listeners registered with
+// OnPatch are then told about the change.
+func (c *Config) Patch(patch []byte) ([]string, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(patch, &doc); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidConfigPatch, err)
+	}
+	if doc == nil {
+		return nil, fmt.Errorf("%w: must be a JSON object", ErrInvalidConfigPatch)
+	}
+
+	changes := make(map[string]interface{})
+	flattenPatch("", doc, changes)
+	keys := make([]string, 0, len(changes))
+	for key := range changes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	c.mu.Lock()
+	candidate := &Config{}
+	copyConfig(candidate, c)
+	if err := candidate.patch(keys, changes); err != nil {
+		c.mu.Unlock()
+		return nil, err
+	}
+	// Only the patched settings are written, so fields read without the
+	// lock at startup never change under their readers
+	for _, key := range keys {
+		field, _, _ := c.field(key)
+		patched, _, _ := candidate.field(key)
+		field.Set(patched)
+	}
+	listeners := c.patchListeners
+	c.mu.Unlock()
+
+	for _, fn := range listeners {
+		fn(candidate, keys)
+	}
+	return keys, nil
+}
+
+// patch sets each changed key on a private candidate configuration and
+// validates the result
+func (c *Config) patch(keys []string, changes map[string]interface{}) error {
+	for _, key := range keys {
+		_, info, err := c.field(key)
+		if err != nil {
+			return err
+		}
+		if info.Type.Kind() == reflect.Struct {
+			return fmt.Errorf("%w: %s is a section, patch its settings instead", ErrUnknownConfigKey, key)
+		}
+		if info.Tag.Get("secret") == "true" {
+			return fmt.Errorf("%w: %s", ErrSecretConfigKey, key)
+		}
+		if !patchableKeys[key] {
+			return fmt.Errorf("%w: %s", ErrReadOnlyConfigKey, key)
+		}
+		if err := c.set(key, changes[key]); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidConfigPatch, err)
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidConfigPatch, err)
+	}
 	return nil
 }
 
+// OnPatch registers fn to be called after each successful Patch with the
+// patched configuration and the keys it changed. fn runs on the patching
+// goroutine after the lock is released.
+func (c *Config) OnPatch(fn func(patched *Config, keys []string)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.patchListeners = append(c.patchListeners, fn)
+}
+
+// flattenPatch collects the settings a merge patch changes by dotted path.
+// Objects are merged into recursively; any other value, including null and
+// arrays, replaces the setting.
+func flattenPatch(prefix string, doc map[string]interface{}, changes map[string]interface{}) {
+	for name, value := range doc {
+		key := name
+		if prefix != "" {
+			key = prefix + "." + name
+		}
+		if nested, ok := value.(map[string]interface{}); ok {
+			flattenPatch(key, nested, changes)
+			continue
+		}
+		changes[key] = value
+	}
+}
+
+// field finds the setting at a dotted JSON path. Callers must hold mu.
+func (c *Config) field(key string) (reflect.Value, reflect.StructField, error) {
+	value := reflect.ValueOf(c).Elem()
+	var info reflect.StructField
+
+	for _, name := range strings.Split(key, ".") {
+		if value.Kind() != reflect.Struct {
+			return reflect.Value{}, info, fmt.Errorf("%w: %s", ErrUnknownConfigKey, key)
+		}
+
+		found := false
+		for i := 0; i < value.NumField(); i++ {
+			f := value.Type().Field(i)
+			if f.IsExported() && jsonName(f) == name {
+				value, info, found = value.Field(i), f, true
+				break
+			}
+		}
+		if !found {
+			return reflect.Value{}, info, fmt.Errorf("%w: %s", ErrUnknownConfigKey, key)
+		}
+	}
+	return value, info, nil
+}
+
+// set updates the setting at a dotted JSON path. Callers must hold mu.
+func (c *Config) set(key string, value interface{}) error {
+	field, _, err := c.field(key)
+	if err != nil {
+		return err
+	}
+
+	if value == nil {
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	}
+
+	// Round trip through JSON to convert e.g. float64 to int
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %w", key, err)
+	}
+	converted := reflect.New(field.Type())
+	if err := json.Unmarshal(data, converted.Interface()); err != nil {
+		return fmt.Errorf("invalid value for %s: %w", key, err)
+	}
+	field.Set(converted.Elem())
+	return nil
+}
+
+// jsonName returns the name a struct field has in JSON
+func jsonName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+// copyConfig copies every setting from src to dst, leaving dst's lock alone
+func copyConfig(dst, src *Config) {
+	d, s := reflect.ValueOf(dst).Elem(), reflect.ValueOf(src).Elem()
+	for i := 0; i < d.NumField(); i++ {
+		if d.Type().Field(i).IsExported() {
+			d.Field(i).Set(s.Field(i))
+		}
+	}
+}
+
+// validLogLevels are the accepted LogLevel values, matched case-insensitively
+var validLogLevels = map[string]bool{
+	"debug": true,
+	"info":  true,
+	"warn":  true,
+	"error": true,
+	"fatal": true,
+}
+
 // Validate checks if the configuration is valid
 func (c *Config) Validate() error {
 	if c.Environment == "" {
@@ -175,6 +416,15 @@ func (c *Config) Validate() error {
 	if c.OpenAI.APIKey == "" {
 		return fmt.Errorf("OpenAI API key is required")
 	}
+	if c.LogLevel != "" && !validLogLevels[strings.ToLower(c.LogLevel)] {
+		return fmt.Errorf("invalid log level %q", c.LogLevel)
+	}
+	if c.Cache.TTL < 0 {
+		return fmt.Errorf("cache TTL cannot be negative, got %d", c.Cache.TTL)
+	}
+	if c.Cache.MaxEntries < 0 {
+		return fmt.Errorf("cache max entries cannot be negative, got %d", c.Cache.MaxEntries)
+	}
 	return nil
 }
 
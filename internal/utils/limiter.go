@@ -0,0 +1,129 @@
+package utils
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+)
+
+// ConcurrencyLimiter is a weighted semaphore bounding how much work runs at
+// once, e.g. the requests a batch method has in flight. Waiters are served
+// in FIFO order so a heavy request isn't starved by lighter ones.
+type ConcurrencyLimiter struct {
+	capacity int64
+	inFlight int64
+	peak     int64
+	waiters  list.List
+	mu       sync.Mutex
+}
+
+// LimiterStats describes a limiter's current and peak usage
+type LimiterStats struct {
+	Capacity int64 `json:"capacity"`
+	InFlight int64 `json:"in_flight"`
+	Peak     int64 `json:"peak"`
+	Waiting  int   `json:"waiting"`
+}
+
+type limiterWaiter struct {
+	weight int64
+	ready  chan struct{}
+}
+
+// NewConcurrencyLimiter creates a limiter allowing capacity units of work in
+// flight at once
+func NewConcurrencyLimiter(capacity int64) *ConcurrencyLimiter {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &ConcurrencyLimiter{capacity: capacity}
+}
+
+// Acquire blocks until weight units are available or ctx is done
+func (l *ConcurrencyLimiter) Acquire(ctx context.Context, weight int64) error {
+	if weight < 1 || weight > l.capacity {
+		return fmt.Errorf("weight %d outside limiter capacity %d", weight, l.capacity)
+	}
+
+	l.mu.Lock()
+	if l.waiters.Len() == 0 && l.inFlight+weight <= l.capacity {
+		l.take(weight)
+		l.mu.Unlock()
+		return nil
+	}
+
+	w := &limiterWaiter{weight: weight, ready: make(chan struct{})}
+	elem := l.waiters.PushBack(w)
+	l.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		select {
+		case <-w.ready:
+			// Acquired just as ctx was cancelled; hand the units back
+			l.inFlight -= weight
+			l.notify()
+		default:
+			l.waiters.Remove(elem)
+			// Removing the head may let the next waiter in
+			l.notify()
+		}
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// Release returns weight units acquired with Acquire
+func (l *ConcurrencyLimiter) Release(weight int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight -= weight
+	if l.inFlight < 0 {
+		panic("utils: ConcurrencyLimiter released more than acquired")
+	}
+	l.notify()
+}
+
+// Stats returns the limiter's current usage
+func (l *ConcurrencyLimiter) Stats() LimiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return LimiterStats{
+		Capacity: l.capacity,
+		InFlight: l.inFlight,
+		Peak:     l.peak,
+		Waiting:  l.waiters.Len(),
+	}
+}
+
+// take records weight units as in flight. The caller must hold mu.
+func (l *ConcurrencyLimiter) take(weight int64) {
+	l.inFlight += weight
+	if l.inFlight > l.peak {
+		l.peak = l.inFlight
+	}
+}
+
+// notify admits waiters from the front of the queue while they fit. The
+// caller must hold mu.
+func (l *ConcurrencyLimiter) notify() {
+	for {
+		front := l.waiters.Front()
+		if front == nil {
+			return
+		}
+		w := front.Value.(*limiterWaiter)
+		if l.inFlight+w.weight > l.capacity {
+			return
+		}
+		l.take(w.weight)
+		l.waiters.Remove(front)
+		close(w.ready)
+	}
+}
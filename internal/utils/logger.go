@@ -9,6 +9,8 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/labs-alone/alone-main/pkg/logger"
 )
 
 // LogLevel represents the severity of a log message
@@ -26,13 +28,23 @@ const (
 // Logger provides structured logging capabilities
 type Logger struct {
 	level     LogLevel
-	outputs   []io.Writer
+	outputs   []logOutput
 	prefix    string
 	timeFormat string
 	mu        sync.Mutex
 	fields    map[string]interface{}
 }
 
+// logOutput is a registered writer with its own minimum level
+type logOutput struct {
+	writer io.Writer
+	level  LogLevel
+	// inherit makes the output follow the logger level instead of its own
+	inherit bool
+}
+
+var _ logger.Logger = (*Logger)(nil)
+
 // LoggerOption configures the logger
 type LoggerOption func(*Logger)
 
@@ -60,7 +72,7 @@ const (
 func NewLogger(opts ...LoggerOption) *Logger {
 	l := &Logger{
 		level:      INFO,
-		outputs:    []io.Writer{os.Stdout},
+		outputs:    []logOutput{{writer: os.Stdout, inherit: true}},
 		timeFormat: "2006-01-02 15:04:05.000",
 		fields:     make(map[string]interface{}),
 	}
@@ -79,10 +91,10 @@ func WithLevel(level LogLevel) LoggerOption {
 	}
 }
 
-// WithOutput adds an output writer
-func WithOutput(w io.Writer) LoggerOption {
+// WithOutput adds an output writer that receives entries at or above level
+func WithOutput(w io.Writer, level LogLevel) LoggerOption {
 	return func(l *Logger) {
-		l.outputs = append(l.outputs, w)
+		l.outputs = append(l.outputs, logOutput{writer: w, level: level})
 	}
 }
 
@@ -107,11 +119,37 @@ func (l *Logger) SetLevel(level LogLevel) {
 	l.level = level
 }
 
-// AddOutput adds an additional output writer
-func (l *Logger) AddOutput(w io.Writer) {
+// Level returns the log level
+func (l *Logger) Level() LogLevel {
 	l.mu.Lock()
 	defer l.mu.Unlock()
-	l.outputs = append(l.outputs, w)
+	return l.level
+}
+
+// FollowLevel sets the log level from config's log_level and keeps it in
+// step with later config patches
+func (l *Logger) FollowLevel(config *Config) {
+	if level, err := ParseLogLevel(fmt.Sprint(config.Get("log_level"))); err == nil {
+		l.SetLevel(level)
+	}
+	config.OnPatch(func(patched *Config, keys []string) {
+		for _, key := range keys {
+			if key != "log_level" {
+				continue
+			}
+			if level, err := ParseLogLevel(patched.LogLevel); err == nil {
+				l.SetLevel(level)
+			}
+		}
+	})
+}
+
+// AddOutput adds an additional output writer that receives entries at or
+// above level
+func (l *Logger) AddOutput(w io.Writer, level LogLevel) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.outputs = append(l.outputs, logOutput{writer: w, level: level})
 }
 
 // WithFields creates a new logger with additional fields
@@ -137,88 +175,115 @@ func (l *Logger) WithFields(fields map[string]interface{}) *Logger {
 	return newLogger
 }
 
-// log handles the actual logging
-func (l *Logger) log(level LogLevel, message string, fields map[string]interface{}) {
-	if level < l.level {
-		return
-	}
+// With creates a new logger with additional fields given as key-value pairs
+func (l *Logger) With(keysAndValues ...interface{}) logger.Logger {
+	return l.WithFields(logger.Fields(keysAndValues...))
+}
 
+// log handles the actual logging. Malformed key-value pairs are logged as
+// well as they can be, followed by a warning describing the mistake.
+func (l *Logger) log(level LogLevel, message string, keysAndValues []interface{}) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	// Create log entry
-	entry := LogEntry{
+	if level < l.minLevel() {
+		return
+	}
+
+	fields, err := logger.ParseFields(keysAndValues...)
+	caller := l.getCaller()
+	l.write(LogEntry{
 		Time:    time.Now(),
 		Level:   level,
 		Message: message,
-		Fields:  make(map[string]interface{}),
-		Caller:  l.getCaller(),
+		Fields:  l.entryFields(fields),
+		Caller:  caller,
+	})
+	if err != nil {
+		l.write(LogEntry{
+			Time:    time.Now(),
+			Level:   WARN,
+			Message: "Malformed log fields",
+			Fields:  l.entryFields(map[string]interface{}{"error": err.Error(), "message": message}),
+			Caller:  caller,
+		})
+	}
+
+	if level == FATAL {
+		os.Exit(1)
 	}
+}
+
+// entryFields merges fields over the logger fields
+func (l *Logger) entryFields(fields map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(l.fields)+len(fields))
 
 	// Add logger fields
 	for k, v := range l.fields {
-		entry.Fields[k] = v
+		merged[k] = v
 	}
 
 	// Add additional fields
 	for k, v := range fields {
-		entry.Fields[k] = v
+		merged[k] = v
 	}
+	return merged
+}
 
-	// Format and write the log entry
+// write formats entry and writes it to the outputs accepting its level.
+// Callers must hold l.mu.
+func (l *Logger) write(entry LogEntry) {
 	formattedLog := l.formatLogEntry(entry)
 	for _, output := range l.outputs {
-		fmt.Fprintln(output, formattedLog)
+		if entry.Level < l.outputLevel(output) {
+			continue
+		}
+		fmt.Fprintln(output.writer, formattedLog)
 	}
+}
 
-	if level == FATAL {
-		os.Exit(1)
+// outputLevel returns the minimum level an output accepts
+func (l *Logger) outputLevel(output logOutput) LogLevel {
+	if output.inherit {
+		return l.level
 	}
+	return output.level
 }
 
-// Debug logs a debug message
-func (l *Logger) Debug(message string, fields ...map[string]interface{}) {
-	var f map[string]interface{}
-	if len(fields) > 0 {
-		f = fields[0]
+// minLevel returns the lowest level accepted by any output
+func (l *Logger) minLevel() LogLevel {
+	min := FATAL
+	for _, output := range l.outputs {
+		if level := l.outputLevel(output); level < min {
+			min = level
+		}
 	}
-	l.log(DEBUG, message, f)
+	return min
+}
+
+// Debug logs a debug message
+func (l *Logger) Debug(message string, keysAndValues ...interface{}) {
+	l.log(DEBUG, message, keysAndValues)
 }
 
 // Info logs an info message
-func (l *Logger) Info(message string, fields ...map[string]interface{}) {
-	var f map[string]interface{}
-	if len(fields) > 0 {
-		f = fields[0]
-	}
-	l.log(INFO, message, f)
+func (l *Logger) Info(message string, keysAndValues ...interface{}) {
+	l.log(INFO, message, keysAndValues)
 }
 
 // Warn logs a warning message
-func (l *Logger) Warn(message string, fields ...map[string]interface{}) {
-	var f map[string]interface{}
-	if len(fields) > 0 {
-		f = fields[0]
-	}
-	l.log(WARN, message, f)
+func (l *Logger) Warn(message string, keysAndValues ...interface{}) {
+	l.log(WARN, message, keysAndValues)
 }
 
 // Error logs an error message
-func (l *Logger) Error(message string, fields ...map[string]interface{}) {
-	var f map[string]interface{}
-	if len(fields) > 0 {
-		f = fields[0]
-	}
-	l.log(ERROR, message, f)
+func (l *Logger) Error(message string, keysAndValues ...interface{}) {
+	l.log(ERROR, message, keysAndValues)
 }
 
 // Fatal logs a fatal message and exits
-func (l *Logger) Fatal(message string, fields ...map[string]interface{}) {
-	var f map[string]interface{}
-	if len(fields) > 0 {
-		f = fields[0]
-	}
-	l.log(FATAL, message, f)
+func (l *Logger) Fatal(message string, keysAndValues ...interface{}) {
+	l.log(FATAL, message, keysAndValues)
 }
 
 // formatLogEntry formats a log entry for output
@@ -314,4 +379,23 @@ func (l LogLevel) String() string {
 	default:
 		return fmt.Sprintf("UNKNOWN(%d)", l)
 	}
-}
\ No newline at end of file
+}
+
+// ParseLogLevel parses a log level name such as "debug", matched
+// case-insensitively
+func ParseLogLevel(s string) (LogLevel, error) {
+	switch strings.ToUpper(s) {
+	case "DEBUG":
+		return DEBUG, nil
+	case "INFO":
+		return INFO, nil
+	case "WARN":
+		return WARN, nil
+	case "ERROR":
+		return ERROR, nil
+	case "FATAL":
+		return FATAL, nil
+	default:
+		return INFO, fmt.Errorf("unknown log level %q", s)
+	}
+}
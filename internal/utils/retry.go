@@ -0,0 +1,134 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Clock abstracts time so retry delays can be tested without sleeping
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// SystemClock is the real wall clock
+type SystemClock struct{}
+
+// Now returns the current time
+func (SystemClock) Now() time.Time { return time.Now() }
+
+// After waits for d on the real clock
+func (SystemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// RetryPolicy controls how often and how quickly an operation is retried
+type RetryPolicy struct {
+	// MaxRetries is the number of retries after the first attempt
+	MaxRetries int
+	// Delay is the wait before the first retry, doubled for each one after
+	Delay time.Duration
+	// MaxDelay caps the backoff and any server-requested delay; zero means
+	// no cap
+	MaxDelay time.Duration
+	// Clock defaults to SystemClock
+	Clock Clock
+}
+
+// RetryAfterError is implemented by errors that carry a server-requested
+// delay, such as a 429 response with a Retry-After header
+type RetryAfterError interface {
+	error
+	RetryAfter() time.Duration
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as not worth retrying
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// Retry calls fn until it succeeds, returns a Permanent error, the retries
+// are used up or ctx is done. Between attempts it waits the backoff delay,
+// or the delay requested by a RetryAfterError when that is longer.
+func Retry(ctx context.Context, policy RetryPolicy, fn func() error) error {
+	clock := policy.Clock
+	if clock == nil {
+		clock = SystemClock{}
+	}
+
+	delay := policy.Delay
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			return permanent.err
+		}
+		if attempt >= policy.MaxRetries {
+			return err
+		}
+
+		wait := delay
+		var retryAfter RetryAfterError
+		if errors.As(err, &retryAfter) && retryAfter.RetryAfter() > wait {
+			wait = retryAfter.RetryAfter()
+		}
+		if policy.MaxDelay > 0 && wait > policy.MaxDelay {
+			wait = policy.MaxDelay
+		}
+
+		if wait > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-clock.After(wait):
+			}
+		} else if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		delay *= 2
+	}
+}
+
+// ParseRetryAfter reads a Retry-After header given in seconds or as an HTTP
+// date, returning zero if it is missing, invalid or in the past. Values too
+// large for a Duration saturate rather than overflow, so callers should cap
+// the result with RetryPolicy.MaxDelay.
+func ParseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds <= 0 {
+			return 0
+		}
+		if seconds > int64(math.MaxInt64/time.Second) {
+			return time.Duration(math.MaxInt64)
+		}
+		return time.Duration(seconds) * time.Second
+	} else if errors.Is(err, strconv.ErrRange) && !strings.HasPrefix(value, "-") {
+		return time.Duration(math.MaxInt64)
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,144 @@
+package validate
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+)
+
+// Field error codes
+const (
+	CodeRequired          = "required"
+	CodeInvalid           = "invalid"
+	CodeInvalidType       = "invalid_type"
+	CodeInvalidAddress    = "invalid_address"
+	CodeInvalidCommitment = "invalid_commitment"
+	CodeNotAllowed        = "not_allowed"
+	CodeOutOfRange        = "out_of_range"
+	CodeTooLong           = "too_long"
+)
+
+// Number is the types the numeric rules accept
+type Number interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 |
+		~float32 | ~float64
+}
+
+// All combines rules into one that applies them in order, failing with the
+// first rule that fails
+func All[T any](rules ...Rule[T]) Rule[T] {
+	return func(value T) *FieldError {
+		for _, rule := range rules {
+			if err := rule(value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Optional applies rules only to values that aren't the zero value, for
+// fields that may be omitted
+func Optional[T comparable](rules ...Rule[T]) Rule[T] {
+	all := All(rules...)
+	return func(value T) *FieldError {
+		var zero T
+		if value == zero {
+			return nil
+		}
+		return all(value)
+	}
+}
+
+// Required rejects the zero value
+func Required[T comparable]() Rule[T] {
+	return func(value T) *FieldError {
+		var zero T
+		if value == zero {
+			return Fail(CodeRequired, "is required")
+		}
+		return nil
+	}
+}
+
+// Address requires a base58 Solana public key
+func Address() Rule[string] {
+	return func(value string) *FieldError {
+		if _, err := solana.PublicKeyFromBase58(value); err != nil {
+			return Fail(CodeInvalidAddress, "must be a base58 Solana address")
+		}
+		return nil
+	}
+}
+
+// Commitment requires a commitment level name
+func Commitment() Rule[string] {
+	return func(value string) *FieldError {
+		switch rpc.CommitmentType(value) {
+		case rpc.CommitmentProcessed, rpc.CommitmentConfirmed, rpc.CommitmentFinalized:
+			return nil
+		default:
+			return Fail(CodeInvalidCommitment, "must be processed, confirmed or finalized")
+		}
+	}
+}
+
+// OneOf requires one of values
+func OneOf(values ...string) Rule[string] {
+	message := "must be " + values[0]
+	if n := len(values); n > 1 {
+		message = "must be " + strings.Join(values[:n-1], ", ") + " or " + values[n-1]
+	}
+	return func(value string) *FieldError {
+		for _, allowed := range values {
+			if value == allowed {
+				return nil
+			}
+		}
+		return Fail(CodeNotAllowed, message)
+	}
+}
+
+// Positive requires a value greater than zero
+func Positive[T Number]() Rule[T] {
+	return func(value T) *FieldError {
+		if value <= 0 {
+			return Fail(CodeOutOfRange, "must be greater than zero")
+		}
+		return nil
+	}
+}
+
+// Min requires a value of at least min
+func Min[T Number](min T) Rule[T] {
+	return func(value T) *FieldError {
+		if value < min {
+			return Fail(CodeOutOfRange, fmt.Sprintf("must be at least %v", min))
+		}
+		return nil
+	}
+}
+
+// Between requires a value from min to max inclusive
+func Between[T Number](min, max T) Rule[T] {
+	return func(value T) *FieldError {
+		if value < min || value > max {
+			return Fail(CodeOutOfRange, fmt.Sprintf("must be between %v and %v", min, max))
+		}
+		return nil
+	}
+}
+
+// MaxLength requires a string of at most n characters
+func MaxLength(n int) Rule[string] {
+	return func(value string) *FieldError {
+		if utf8.RuneCountInString(value) > n {
+			return Fail(CodeTooLong, fmt.Sprintf("must be at most %d characters", n))
+		}
+		return nil
+	}
+}
@@ -0,0 +1,101 @@
+// Package validate checks request inputs against composable rules and
+// reports every invalid field with a machine-readable code, so handlers
+// share one set of rules and one error shape.
+package validate
+
+import (
+	"strconv"
+	"strings"
+)
+
+// FieldError describes why one field is invalid. Message reads after the
+// field name, as in "amount must be greater than zero".
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + " " + e.Message
+}
+
+// Errors is the field errors of a failed validation, in the order the
+// fields were checked
+type Errors []*FieldError
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Rule checks a value, returning a FieldError without its Field if the
+// value is invalid
+type Rule[T any] func(value T) *FieldError
+
+// Fail returns a failed rule's error, for rules written outside the package
+func Fail(code, message string) *FieldError {
+	return &FieldError{Code: code, Message: message}
+}
+
+// Validator collects field errors across the fields of a request. The zero
+// value is ready to use.
+type Validator struct {
+	errs Errors
+}
+
+// Field checks value against rules in order, recording the first failure
+// under name. It reports whether the value is valid.
+func Field[T any](v *Validator, name string, value T, rules ...Rule[T]) bool {
+	for _, rule := range rules {
+		if err := rule(value); err != nil {
+			v.Add(name, err.Code, err.Message)
+			return false
+		}
+	}
+	return true
+}
+
+// Int parses s as a decimal integer and checks it against rules, recording
+// a failure under name. It returns the parsed value and whether it's valid.
+func Int(v *Validator, name, s string, rules ...Rule[int]) (int, bool) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		v.Add(name, CodeInvalidType, "must be an integer")
+		return 0, false
+	}
+	return n, Field(v, name, n, rules...)
+}
+
+// Add records an error for a check that isn't expressed as a rule
+func (v *Validator) Add(name, code, message string) {
+	v.errs = append(v.errs, &FieldError{Field: name, Code: code, Message: message})
+}
+
+// Merge records the field errors in err, as returned by another
+// validation, with prefix prepended to their field names. Errors that
+// aren't field errors are recorded under the prefix alone.
+func (v *Validator) Merge(prefix string, err error) {
+	switch err := err.(type) {
+	case nil:
+	case Errors:
+		for _, e := range err {
+			v.Add(prefix+e.Field, e.Code, e.Message)
+		}
+	case *FieldError:
+		v.Add(prefix+err.Field, err.Code, err.Message)
+	default:
+		v.Add(strings.TrimSuffix(prefix, "."), CodeInvalid, err.Error())
+	}
+}
+
+// Err returns the recorded errors as Errors, or nil if every field is valid
+func (v *Validator) Err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}
@@ -3,10 +3,13 @@ package lilith
 import (
 	"context"
 	"fmt"
+	"os"
+	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/alone-labs/pkg/logger"
+	"github.com/labs-alone/alone-main/pkg/logger"
 )
 
 // Agent represents the Lilith AI agent
@@ -19,14 +22,23 @@ type Agent struct {
 	config    *Config
 	processor *Processor
 	state     *State
-	logger    *logger.Logger
+	logger    logger.Logger
 	mu        sync.RWMutex
 	isRunning bool
 	startTime time.Time
+
+	// draining is set by StopGracefully to stop accepting tasks, and
+	// runDone is closed when the processing loop exits
+	draining bool
+	runDone  chan struct{}
+
+	// Current process interval in nanoseconds, which changes over time in
+	// adaptive mode
+	interval atomic.Int64
 }
 
 // NewAgent creates and initializes a new Lilith agent
-func NewAgent(config *Config, logger *logger.Logger) (*Agent, error) {
+func NewAgent(config *Config, logger logger.Logger) (*Agent, error) {
 	if err := config.Validate(); err != nil {
 		return nil, fmt.Errorf("invalid config: %w", err)
 	}
@@ -40,15 +52,23 @@ func NewAgent(config *Config, logger *logger.Logger) (*Agent, error) {
 		ctx:       ctx,
 		cancel:    cancel,
 		config:    config,
-		processor: NewProcessor(),
-		state:     NewState(),
+		processor: NewProcessor(config, logger),
+		state:     NewState(config, logger),
 		logger:    logger,
 		isRunning: false,
 	}
 
+	agent.interval.Store(int64(config.ProcessInterval))
+
 	// Register default task handlers
 	agent.registerDefaultHandlers()
 
+	// Config handlers override or disable the defaults
+	if err := agent.registerConfiguredHandlers(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
 	return agent, nil
 }
 
@@ -64,11 +84,13 @@ func (a *Agent) Start() error {
 	a.logger.Info("Starting Lilith agent", "id", a.ID, "version", a.Version)
 
 	a.isRunning = true
+	a.draining = false
+	a.runDone = make(chan struct{})
 	a.startTime = time.Now()
 	a.state.UpdateStatus(StatusWorking)
 
 	// Start main processing loop
-	go a.run()
+	go a.run(a.runDone)
 
 	// Start memory cleanup routine
 	go a.memoryCleanup()
@@ -96,52 +118,143 @@ func (a *Agent) Stop() error {
 
 // AddTask adds a new task to the agent's processing queue
 func (a *Agent) AddTask(task Task) error {
-	if !a.isRunning {
+	a.mu.RLock()
+	running, draining := a.isRunning, a.draining
+	a.mu.RUnlock()
+
+	if !running {
 		return ErrAgentNotRunning
 	}
+	if draining {
+		return ErrAgentDraining
+	}
 
 	a.processor.AddTask(task)
 	a.logger.Debug("Task added to queue", "taskID", task.ID, "type", task.Type)
 	return nil
 }
 
+// Processor returns the agent's task processor, e.g. for inspecting or
+// replaying dead-lettered tasks
+func (a *Agent) Processor() *Processor {
+	return a.processor
+}
+
+// State returns the agent's state
+func (a *Agent) State() *State {
+	return a.state
+}
+
+// ExportState saves a full snapshot of the agent's state under the
+// configured memory persist path and returns the file written
+func (a *Agent) ExportState() (string, error) {
+	if a.config.MemoryPersistPath == "" {
+		return "", fmt.Errorf("%w: memory persist path not set", ErrInvalidConfig)
+	}
+	if err := os.MkdirAll(a.config.MemoryPersistPath, 0755); err != nil {
+		return "", fmt.Errorf("error creating snapshot directory: %w", err)
+	}
+
+	name := fmt.Sprintf("%s-%s.json", a.ID, time.Now().UTC().Format("20060102T150405.000000000"))
+	path := filepath.Join(a.config.MemoryPersistPath, name)
+	if err := a.state.SaveSnapshot(path); err != nil {
+		return "", err
+	}
+
+	a.logger.Info("Agent state exported", "id", a.ID, "path", path)
+	return path, nil
+}
+
+// ProcessInterval returns how long the agent currently waits between
+// processing passes
+func (a *Agent) ProcessInterval() time.Duration {
+	return time.Duration(a.interval.Load())
+}
+
 // GetStatus returns the current status of the agent
 func (a *Agent) GetStatus() AgentStatus {
 	a.mu.RLock()
 	defer a.mu.RUnlock()
 
-	return AgentStatus{
-		ID:             a.ID,
-		Status:         a.state.Status,
-		TasksProcessed: a.state.TasksProcessed,
-		Uptime:        time.Since(a.startTime),
-		LastActivity:   a.state.LastActivity,
-		LastError:      a.state.LastError,
+	status := AgentStatus{
+		ID:        a.ID,
+		Uptime:    time.Since(a.startTime),
+		LastError: a.state.LastError(),
+
+		ProcessInterval: a.ProcessInterval(),
 	}
+
+	a.state.mu.RLock()
+	status.Status = a.state.Status
+	status.TasksProcessed = a.state.TasksProcessed
+	status.LastActivity = a.state.LastActivity
+	a.state.mu.RUnlock()
+
+	return status
 }
 
 // Internal methods
 
-func (a *Agent) run() {
-	ticker := time.NewTicker(a.config.ProcessInterval)
-	defer ticker.Stop()
+func (a *Agent) run(done chan struct{}) {
+	defer close(done)
+
+	timer := time.NewTimer(a.ProcessInterval())
+	defer timer.Stop()
 
 	for {
 		select {
 		case <-a.ctx.Done():
 			a.logger.Info("Agent processing loop stopped", "id", a.ID)
 			return
-		case <-ticker.C:
+		case <-timer.C:
 			if err := a.processor.Process(a.ctx, a.state); err != nil {
-				a.state.LastError = err
+				a.state.SetLastError(err)
 				a.logger.Error("Processing error", "error", err)
 			}
+			// Process runs tasks synchronously, so once the queue is
+			// empty nothing is left in flight
+			queued := a.processor.GetQueueLength()
+			if queued == 0 && a.isDraining() {
+				a.logger.Info("Agent task queue drained", "id", a.ID)
+				return
+			}
+			if a.config.AdaptiveInterval {
+				a.tuneInterval(queued)
+			}
+			timer.Reset(a.ProcessInterval())
 		}
 	}
 }
 
+func (a *Agent) isDraining() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.draining
+}
+
+// tuneInterval adapts the process interval to the queue: it halves while
+// tasks are waiting, to drain them with little latency, and doubles while
+// the queue is empty, to cut idle wakeups, within the configured bounds
+func (a *Agent) tuneInterval(queued int) {
+	interval := a.ProcessInterval()
+	if queued > 0 {
+		interval /= 2
+	} else {
+		interval *= 2
+	}
+
+	if interval < a.config.MinProcessInterval {
+		interval = a.config.MinProcessInterval
+	}
+	if interval > a.config.MaxProcessInterval {
+		interval = a.config.MaxProcessInterval
+	}
+	a.interval.Store(int64(interval))
+}
+
+// memoryCleanup sweeps expired memories every cleanup interval
 func (a *Agent) memoryCleanup() {
-	ticker := time.NewTicker(time.Minute)
+	ticker := time.NewTicker(a.config.CleanupInterval)
 	defer ticker.Stop()
 
 	for {
@@ -149,7 +262,9 @@ func (a *Agent) memoryCleanup() {
 		case <-a.ctx.Done():
 			return
 		case <-ticker.C:
-			a.state.CleanupExpiredMemory()
+			if removed := a.state.CleanupExpiredMemory(); removed > 0 {
+				a.logger.Debug("Expired memories swept", "id", a.ID, "removed", removed)
+			}
 		}
 	}
 }
@@ -180,6 +295,9 @@ type AgentStatus struct {
 	Uptime         time.Duration
 	LastActivity   time.Time
 	LastError      error
+
+	// ProcessInterval is the current wait between processing passes
+	ProcessInterval time.Duration
 }
 
 // Helper functions
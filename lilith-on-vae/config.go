@@ -1,6 +1,7 @@
 package lilith
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -15,6 +16,13 @@ type Config struct {
 	ProcessInterval time.Duration `json:"process_interval"`
 	Environment     string        `json:"environment"`
 
+	// Adaptive interval settings. When enabled the process interval
+	// shortens towards MinProcessInterval while tasks are queued and
+	// lengthens towards MaxProcessInterval while idle.
+	AdaptiveInterval   bool          `json:"adaptive_interval"`
+	MinProcessInterval time.Duration `json:"min_process_interval"`
+	MaxProcessInterval time.Duration `json:"max_process_interval"`
+
 	// Memory Settings
 	MaxShortTermMemory int           `json:"max_short_term_memory"`
 	MaxLongTermMemory  int           `json:"max_long_term_memory"`
@@ -22,6 +30,14 @@ type Config struct {
 	MemoryPersistPath string         `json:"memory_persist_path"`
 	CleanupInterval   time.Duration  `json:"cleanup_interval"`
 
+	// Per-store memory policies, see MemoryPolicy for their defaults.
+	// MaxTotalMemory caps the combined size of the three stores; zero
+	// leaves it uncapped.
+	ShortTermPolicy MemoryPolicy `json:"short_term_policy"`
+	LongTermPolicy  MemoryPolicy `json:"long_term_policy"`
+	VolatilePolicy  MemoryPolicy `json:"volatile_policy"`
+	MaxTotalMemory  int          `json:"max_total_memory"`
+
 	// Processing Settings
 	MaxConcurrentTasks int           `json:"max_concurrent_tasks"`
 	TaskTimeout       time.Duration  `json:"task_timeout"`
@@ -29,6 +45,17 @@ type Config struct {
 	RetryDelay        time.Duration  `json:"retry_delay"`
 	TaskQueueSize     int           `json:"task_queue_size"`
 
+	// OutcomeTTL is how long a finished task's outcome is kept for tasks
+	// depending on it. DependencyWait is how long a task waits on a
+	// dependency that is neither queued, running nor finished before it
+	// fails with ErrDependencyUnknown. Zero uses the defaults.
+	OutcomeTTL     time.Duration `json:"outcome_ttl"`
+	DependencyWait time.Duration `json:"dependency_wait"`
+
+	// MaxDeadLetters caps the failed tasks held for replay; the oldest are
+	// dropped to make room. Zero uses DefaultMaxDeadLetters.
+	MaxDeadLetters int `json:"max_dead_letters"`
+
 	// Security Settings
 	EnableEncryption bool   `json:"enable_encryption"`
 	EncryptionKey   string `json:"encryption_key,omitempty"`
@@ -57,16 +84,24 @@ const (
 	DefaultProcessInterval  = 100 * time.Millisecond
 	DefaultEnvironment      = "development"
 
+	DefaultMinProcessInterval = 10 * time.Millisecond
+	DefaultMaxProcessInterval = 2 * time.Second
+
 	DefaultMaxShortTermMemory = 10000
 	DefaultMaxLongTermMemory  = 100000
 	DefaultMemoryTTL         = 24 * time.Hour
 	DefaultCleanupInterval   = 5 * time.Minute
+	DefaultMaxVolatileMemory = 1000
+	DefaultMaxTotalMemory    = 250000
 
 	DefaultMaxConcurrentTasks = 10
 	DefaultTaskTimeout       = 30 * time.Second
 	DefaultRetryAttempts     = 3
 	DefaultRetryDelay        = 1 * time.Second
 	DefaultTaskQueueSize     = 1000
+	DefaultOutcomeTTL        = 1 * time.Hour
+	DefaultDependencyWait    = 1 * time.Minute
+	DefaultMaxDeadLetters    = 1000
 
 	DefaultMetricsInterval = 1 * time.Minute
 	DefaultTraceSampleRate = 0.1
@@ -84,11 +119,18 @@ func NewDefaultConfig() *Config {
 		ProcessInterval: DefaultProcessInterval,
 		Environment:     DefaultEnvironment,
 
+		// Adaptive Interval Settings
+		AdaptiveInterval:   false,
+		MinProcessInterval: DefaultMinProcessInterval,
+		MaxProcessInterval: DefaultMaxProcessInterval,
+
 		// Memory Settings
 		MaxShortTermMemory: DefaultMaxShortTermMemory,
 		MaxLongTermMemory:  DefaultMaxLongTermMemory,
 		MemoryTTL:         DefaultMemoryTTL,
 		CleanupInterval:   DefaultCleanupInterval,
+		LongTermPolicy:    MemoryPolicy{Persistent: true},
+		MaxTotalMemory:    DefaultMaxTotalMemory,
 
 		// Processing Settings
 		MaxConcurrentTasks: DefaultMaxConcurrentTasks,
@@ -96,6 +138,9 @@ func NewDefaultConfig() *Config {
 		RetryAttempts:     DefaultRetryAttempts,
 		RetryDelay:        DefaultRetryDelay,
 		TaskQueueSize:     DefaultTaskQueueSize,
+		OutcomeTTL:        DefaultOutcomeTTL,
+		DependencyWait:    DefaultDependencyWait,
+		MaxDeadLetters:    DefaultMaxDeadLetters,
 
 		// Security Settings
 		EnableEncryption: false,
@@ -136,14 +181,161 @@ func LoadConfig(path string) (*Config, error) {
 	return config, nil
 }
 
+// plainConfig has Config's fields without its JSON methods
+type plainConfig Config
+
+// configJSON is the JSON form of Config, holding the duration fields as raw
+// JSON so they can be parsed as either duration strings or nanoseconds
+type configJSON struct {
+	*plainConfig
+	ProcessInterval    json.RawMessage `json:"process_interval,omitempty"`
+	MinProcessInterval json.RawMessage `json:"min_process_interval,omitempty"`
+	MaxProcessInterval json.RawMessage `json:"max_process_interval,omitempty"`
+	MemoryTTL          json.RawMessage `json:"memory_ttl,omitempty"`
+	CleanupInterval    json.RawMessage `json:"cleanup_interval,omitempty"`
+	TaskTimeout        json.RawMessage `json:"task_timeout,omitempty"`
+	RetryDelay         json.RawMessage `json:"retry_delay,omitempty"`
+	MetricsInterval    json.RawMessage `json:"metrics_interval,omitempty"`
+	OutcomeTTL         json.RawMessage `json:"outcome_ttl,omitempty"`
+	DependencyWait     json.RawMessage `json:"dependency_wait,omitempty"`
+}
+
+// durationFields pairs each JSON duration field with its Config field
+func (c *Config) durationFields(d *configJSON) []struct {
+	name  string
+	raw   *json.RawMessage
+	value *time.Duration
+} {
+	return []struct {
+		name  string
+		raw   *json.RawMessage
+		value *time.Duration
+	}{
+		{"process_interval", &d.ProcessInterval, &c.ProcessInterval},
+		{"min_process_interval", &d.MinProcessInterval, &c.MinProcessInterval},
+		{"max_process_interval", &d.MaxProcessInterval, &c.MaxProcessInterval},
+		{"memory_ttl", &d.MemoryTTL, &c.MemoryTTL},
+		{"cleanup_interval", &d.CleanupInterval, &c.CleanupInterval},
+		{"task_timeout", &d.TaskTimeout, &c.TaskTimeout},
+		{"retry_delay", &d.RetryDelay, &c.RetryDelay},
+		{"metrics_interval", &d.MetricsInterval, &c.MetricsInterval},
+		{"outcome_ttl", &d.OutcomeTTL, &c.OutcomeTTL},
+		{"dependency_wait", &d.DependencyWait, &c.DependencyWait},
+	}
+}
+
+// UnmarshalJSON accepts durations as strings such as "100ms" or, for
+// backward compatibility, as integer nanoseconds. Fields missing from data
+// keep their current values.
+func (c *Config) UnmarshalJSON(data []byte) error {
+	aux := configJSON{plainConfig: (*plainConfig)(c)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	for _, field := range c.durationFields(&aux) {
+		raw := *field.raw
+		if len(raw) == 0 || string(raw) == "null" {
+			continue
+		}
+
+		d, err := parseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("%s: %w", field.name, err)
+		}
+		*field.value = d
+	}
+
+	return nil
+}
+
+// MarshalJSON writes durations as strings so saved configs round-trip
+// unambiguously
+func (c *Config) MarshalJSON() ([]byte, error) {
+	aux := configJSON{plainConfig: (*plainConfig)(c)}
+
+	for _, field := range c.durationFields(&aux) {
+		raw, err := json.Marshal(field.value.String())
+		if err != nil {
+			return nil, err
+		}
+		*field.raw = raw
+	}
+
+	return json.Marshal(aux)
+}
+
+// parseDuration parses a JSON duration string or integer nanoseconds
+func parseDuration(raw json.RawMessage) (time.Duration, error) {
+	if raw[0] == '"' {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return 0, err
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return d, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+
+	var n json.Number
+	if err := dec.Decode(&n); err != nil {
+		return 0, fmt.Errorf("invalid duration %s", raw)
+	}
+	ns, err := n.Int64()
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %s: numeric durations must be whole nanoseconds", raw)
+	}
+	return time.Duration(ns), nil
+}
+
 // Validate checks if the configuration is valid
 func (c *Config) Validate() error {
 	if c.Name == "" {
 		return fmt.Errorf("name cannot be empty")
 	}
 
+	for _, field := range c.durationFields(&configJSON{}) {
+		if *field.value < 0 {
+			return fmt.Errorf("%s cannot be negative", field.name)
+		}
+	}
+
+	// Numeric JSON durations are nanoseconds, so a value meant as
+	// milliseconds usually ends up here
 	if c.ProcessInterval < 10*time.Millisecond {
-		return fmt.Errorf("process interval too small (minimum 10ms)")
+		return fmt.Errorf("process interval too small (minimum 10ms, got %s)", c.ProcessInterval)
+	}
+
+	if c.AdaptiveInterval {
+		if c.MinProcessInterval < 10*time.Millisecond {
+			return fmt.Errorf("min process interval too small (minimum 10ms, got %s)", c.MinProcessInterval)
+		}
+		if c.MinProcessInterval > c.MaxProcessInterval {
+			return fmt.Errorf("min process interval (%s) exceeds max process interval (%s)",
+				c.MinProcessInterval, c.MaxProcessInterval)
+		}
+		if c.ProcessInterval < c.MinProcessInterval || c.ProcessInterval > c.MaxProcessInterval {
+			return fmt.Errorf("process interval %s outside adaptive bounds %s to %s",
+				c.ProcessInterval, c.MinProcessInterval, c.MaxProcessInterval)
+		}
+	}
+
+	if err := c.validateMemory(); err != nil {
+		return err
+	}
+
+	if err := c.validateHandlers(); err != nil {
+		return err
+	}
+
+	if c.EnableMetrics && c.MetricsInterval <= 0 {
+		return fmt.Errorf("metrics interval must be positive, got %s", c.MetricsInterval)
 	}
 
 	if c.MaxConcurrentTasks < 1 {
@@ -154,6 +346,14 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("task timeout must be at least 1 second")
 	}
 
+	if c.OutcomeTTL < 0 || c.DependencyWait < 0 {
+		return fmt.Errorf("outcome TTL and dependency wait cannot be negative")
+	}
+
+	if c.MaxDeadLetters < 0 {
+		return fmt.Errorf("max dead letters cannot be negative")
+	}
+
 	if c.EnableEncryption && c.EncryptionKey == "" {
 		return fmt.Errorf("encryption key required when encryption is enabled")
 	}
@@ -161,6 +361,169 @@ func (c *Config) Validate() error {
 	return nil
 }
 
+// EvictionStrategy picks which memories a full store evicts first
+type EvictionStrategy string
+
+const (
+	// EvictionLeastUsed evicts the memories accessed least often for their
+	// priority and age
+	EvictionLeastUsed EvictionStrategy = "least_used"
+	// EvictionLRU evicts the least recently accessed memories
+	EvictionLRU EvictionStrategy = "lru"
+	// EvictionOldest evicts the earliest stored memories
+	EvictionOldest EvictionStrategy = "oldest"
+)
+
+// MemoryPolicy configures one of the memory stores
+type MemoryPolicy struct {
+	// MaxSize is how many memories the store holds before evicting. Zero
+	// falls back to MaxShortTermMemory, MaxLongTermMemory or
+	// DefaultMaxVolatileMemory.
+	MaxSize int `json:"max_size"`
+	// Persistent stores are written under MemoryPersistPath on shutdown
+	Persistent bool `json:"persistent"`
+	// DefaultTTL applies to memories remembered without a TTL. Zero keeps
+	// them until they are evicted.
+	DefaultTTL time.Duration `json:"default_ttl"`
+	// Eviction defaults to EvictionLeastUsed
+	Eviction EvictionStrategy `json:"eviction,omitempty"`
+}
+
+// plainMemoryPolicy has MemoryPolicy's fields without its JSON methods
+type plainMemoryPolicy MemoryPolicy
+
+// memoryPolicyJSON is the JSON form of MemoryPolicy, holding DefaultTTL as
+// raw JSON so it parses like Config's durations
+type memoryPolicyJSON struct {
+	*plainMemoryPolicy
+	DefaultTTL json.RawMessage `json:"default_ttl,omitempty"`
+}
+
+// UnmarshalJSON accepts DefaultTTL as a duration string or nanoseconds
+func (p *MemoryPolicy) UnmarshalJSON(data []byte) error {
+	aux := memoryPolicyJSON{plainMemoryPolicy: (*plainMemoryPolicy)(p)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	if len(aux.DefaultTTL) == 0 || string(aux.DefaultTTL) == "null" {
+		return nil
+	}
+	d, err := parseDuration(aux.DefaultTTL)
+	if err != nil {
+		return fmt.Errorf("default_ttl: %w", err)
+	}
+	p.DefaultTTL = d
+	return nil
+}
+
+// MarshalJSON writes DefaultTTL as a duration string
+func (p MemoryPolicy) MarshalJSON() ([]byte, error) {
+	raw, err := json.Marshal(p.DefaultTTL.String())
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(memoryPolicyJSON{plainMemoryPolicy: (*plainMemoryPolicy)(&p), DefaultTTL: raw})
+}
+
+// StorePolicy returns the policy of the memoryType store with its defaults
+// filled in
+func (c *Config) StorePolicy(memoryType MemoryType) MemoryPolicy {
+	var policy MemoryPolicy
+	var maxSize int
+	switch memoryType {
+	case MemoryTypeShortTerm:
+		policy, maxSize = c.ShortTermPolicy, c.MaxShortTermMemory
+	case MemoryTypeLongTerm:
+		policy, maxSize = c.LongTermPolicy, c.MaxLongTermMemory
+	default:
+		policy, maxSize = c.VolatilePolicy, DefaultMaxVolatileMemory
+	}
+
+	if policy.MaxSize == 0 {
+		policy.MaxSize = maxSize
+	}
+	if policy.Eviction == "" {
+		policy.Eviction = EvictionLeastUsed
+	}
+	return policy
+}
+
+// validateMemory checks the memory store limits. A limit below 1 would
+// evict every memory as soon as it is stored.
+func (c *Config) validateMemory() error {
+	if c.MaxShortTermMemory < 1 {
+		return fmt.Errorf("%w: max short-term memory must be at least 1, got %d", ErrInvalidMemoryConfig, c.MaxShortTermMemory)
+	}
+	if c.MaxLongTermMemory < 1 {
+		return fmt.Errorf("%w: max long-term memory must be at least 1, got %d", ErrInvalidMemoryConfig, c.MaxLongTermMemory)
+	}
+	if c.MaxShortTermMemory > c.MaxLongTermMemory {
+		return fmt.Errorf("%w: max short-term memory (%d) exceeds max long-term memory (%d)",
+			ErrInvalidMemoryConfig, c.MaxShortTermMemory, c.MaxLongTermMemory)
+	}
+
+	if err := c.validateMemoryPolicies(); err != nil {
+		return err
+	}
+
+	if c.MemoryTTL < time.Second {
+		return fmt.Errorf("%w: memory TTL must be at least 1 second, got %s", ErrInvalidMemoryConfig, c.MemoryTTL)
+	}
+	if c.CleanupInterval <= 0 {
+		return fmt.Errorf("%w: cleanup interval must be positive, got %s", ErrInvalidMemoryConfig, c.CleanupInterval)
+	}
+	// Otherwise most memories outlive their TTL waiting for cleanup
+	if c.CleanupInterval > c.MemoryTTL {
+		return fmt.Errorf("%w: cleanup interval (%s) exceeds memory TTL (%s)",
+			ErrInvalidMemoryConfig, c.CleanupInterval, c.MemoryTTL)
+	}
+
+	return nil
+}
+
+// validateMemoryPolicies checks each store's policy and that together the
+// stores fit in MaxTotalMemory
+func (c *Config) validateMemoryPolicies() error {
+	stores := []struct {
+		name       string
+		memoryType MemoryType
+	}{
+		{"short-term", MemoryTypeShortTerm},
+		{"long-term", MemoryTypeLongTerm},
+		{"volatile", MemoryTypeVolatile},
+	}
+
+	total := 0
+	for _, store := range stores {
+		policy := c.StorePolicy(store.memoryType)
+		if policy.MaxSize < 1 {
+			return fmt.Errorf("%w: %s memory max size must be at least 1, got %d",
+				ErrInvalidMemoryConfig, store.name, policy.MaxSize)
+		}
+		if policy.DefaultTTL < 0 {
+			return fmt.Errorf("%w: %s memory default TTL cannot be negative, got %s",
+				ErrInvalidMemoryConfig, store.name, policy.DefaultTTL)
+		}
+		switch policy.Eviction {
+		case EvictionLeastUsed, EvictionLRU, EvictionOldest:
+		default:
+			return fmt.Errorf("%w: unknown %s memory eviction strategy %q",
+				ErrInvalidMemoryConfig, store.name, policy.Eviction)
+		}
+		total += policy.MaxSize
+	}
+
+	if c.MaxTotalMemory < 0 {
+		return fmt.Errorf("%w: max total memory cannot be negative, got %d", ErrInvalidMemoryConfig, c.MaxTotalMemory)
+	}
+	if c.MaxTotalMemory > 0 && total > c.MaxTotalMemory {
+		return fmt.Errorf("%w: memory stores hold %d items combined, exceeding max total memory (%d)",
+			ErrInvalidMemoryConfig, total, c.MaxTotalMemory)
+	}
+	return nil
+}
+
 // SaveConfig saves the configuration to a JSON file
 func (c *Config) SaveConfig(path string) error {
 	data, err := json.MarshalIndent(c, "", "  ")
@@ -199,6 +562,23 @@ var (
 	ErrInvalidEnvironment  = fmt.Errorf("invalid environment")
 	ErrInvalidLogLevel     = fmt.Errorf("invalid log level")
 	ErrInvalidMemoryConfig = fmt.Errorf("invalid memory configuration")
+
+	ErrAgentAlreadyRunning = fmt.Errorf("agent is already running")
+	ErrAgentNotRunning     = fmt.Errorf("agent is not running")
+	ErrAgentDraining       = fmt.Errorf("agent is draining")
+	ErrAgentNotFound       = fmt.Errorf("agent not found")
+	ErrAgentExists         = fmt.Errorf("agent already registered")
+	ErrUnknownTaskType     = fmt.Errorf("unknown task type")
+	ErrUnknownHandler      = fmt.Errorf("unknown handler")
+	ErrDependencyCycle     = fmt.Errorf("task dependency cycle")
+	ErrDependencyFailed    = fmt.Errorf("task dependency failed")
+	ErrDependencyUnknown   = fmt.Errorf("task dependency unknown")
+	ErrTaskValueMissing    = fmt.Errorf("task value missing")
+	ErrTaskValueType       = fmt.Errorf("task value has wrong type")
+
+	ErrInvalidMemoryType = fmt.Errorf("invalid memory type")
+	ErrMemoryNotFound    = fmt.Errorf("memory not found")
+	ErrMemoryExpired     = fmt.Errorf("memory expired")
 )
 
 // IsProduction returns whether the current environment is production
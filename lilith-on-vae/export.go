@@ -0,0 +1,161 @@
+package lilith
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RedactedValue replaces sensitive values in exported memory
+const RedactedValue = "[REDACTED]"
+
+// sensitiveKeys are the key fragments whose values are redacted on export,
+// matched case-insensitively
+var sensitiveKeys = []string{
+	"password", "passphrase", "secret", "token", "api_key", "apikey",
+	"private_key", "privatekey", "mnemonic", "seed", "credential",
+	"authorization",
+}
+
+// MemoryEntry is a memory item as exported for inspection
+type MemoryEntry struct {
+	Key         string      `json:"key"`
+	Value       interface{} `json:"value"`
+	CreatedAt   time.Time   `json:"created_at"`
+	ExpiresAt   *time.Time  `json:"expires_at,omitempty"`
+	AccessCount int         `json:"access_count"`
+	LastAccess  time.Time   `json:"last_access"`
+}
+
+// MemoryPage is one page of an exported memory store. Entries are ordered
+// by key, and NextCursor is the key to pass as after to get the next page.
+type MemoryPage struct {
+	Entries    []MemoryEntry `json:"entries"`
+	Total      int           `json:"total"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+// ParseMemoryType parses a memory store name: short, long or volatile, or
+// short_term and long_term
+func ParseMemoryType(name string) (MemoryType, error) {
+	switch strings.ToLower(name) {
+	case "short", "short_term":
+		return MemoryTypeShortTerm, nil
+	case "long", "long_term":
+		return MemoryTypeLongTerm, nil
+	case "volatile":
+		return MemoryTypeVolatile, nil
+	default:
+		return 0, fmt.Errorf("%w: %q", ErrInvalidMemoryType, name)
+	}
+}
+
+// Store returns the memory store of memoryType
+func (s *State) Store(memoryType MemoryType) (*MemoryStore, error) {
+	switch memoryType {
+	case MemoryTypeShortTerm:
+		return s.ShortTerm, nil
+	case MemoryTypeLongTerm:
+		return s.LongTerm, nil
+	case MemoryTypeVolatile:
+		return s.Volatile, nil
+	default:
+		return nil, ErrInvalidMemoryType
+	}
+}
+
+// ExportMemory returns up to limit unexpired items of the memoryType store
+// with keys after the given one, with sensitive values redacted. Reading
+// items through the export doesn't count as accessing them.
+func (s *State) ExportMemory(memoryType MemoryType, after string, limit int) (MemoryPage, error) {
+	store, err := s.Store(memoryType)
+	if err != nil {
+		return MemoryPage{}, err
+	}
+
+	now := time.Now()
+	items := store.Items()
+	keys := make([]string, 0, len(items))
+	for key, item := range items {
+		if item.ExpiresAt != nil && now.After(*item.ExpiresAt) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	page := MemoryPage{Total: len(keys), Entries: []MemoryEntry{}}
+	start := sort.SearchStrings(keys, after)
+	if start < len(keys) && keys[start] == after {
+		start++
+	}
+	for _, key := range keys[start:] {
+		if limit > 0 && len(page.Entries) == limit {
+			page.NextCursor = page.Entries[len(page.Entries)-1].Key
+			break
+		}
+
+		item := items[key]
+		value := interface{}(RedactedValue)
+		if !isSensitiveKey(key) {
+			value = redactValue(item.Value)
+		}
+		page.Entries = append(page.Entries, MemoryEntry{
+			Key:         key,
+			Value:       value,
+			CreatedAt:   item.CreatedAt,
+			ExpiresAt:   item.ExpiresAt,
+			AccessCount: item.AccessCount,
+			LastAccess:  item.LastAccess,
+		})
+	}
+
+	return page, nil
+}
+
+// redactValue returns a copy of value with the fields of any object whose
+// keys look sensitive replaced by RedactedValue. The value is copied
+// through JSON, so structs are redacted by their JSON field names.
+func redactValue(value interface{}) interface{} {
+	data, err := json.Marshal(value)
+	if err != nil {
+		// Values that can't be shown safely aren't shown at all
+		return RedactedValue
+	}
+
+	var copied interface{}
+	if err := json.Unmarshal(data, &copied); err != nil {
+		return RedactedValue
+	}
+	return redactFields(copied)
+}
+
+func redactFields(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if isSensitiveKey(key) {
+				v[key] = RedactedValue
+			} else {
+				v[key] = redactFields(field)
+			}
+		}
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = redactFields(elem)
+		}
+	}
+	return value
+}
+
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(key, sensitive) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package lilith
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// TaskHandlersParameter is the custom parameter mapping task types to named
+// handlers, e.g. {"task_handlers": {"ping": "noop"}}. Mapping a type to ""
+// or null disables it, including the built-in system handlers.
+const TaskHandlersParameter = "task_handlers"
+
+var (
+	namedHandlersMu sync.RWMutex
+	namedHandlers   = map[string]TaskHandler{
+		"noop":   noopHandler,
+		"memory": memoryHandler,
+	}
+)
+
+// RegisterNamedHandler makes handler available to configs under name. Call
+// it before loading configs that reference the handler, typically from an
+// init function.
+func RegisterNamedHandler(name string, handler TaskHandler) {
+	namedHandlersMu.Lock()
+	defer namedHandlersMu.Unlock()
+	namedHandlers[name] = handler
+}
+
+// NamedHandlers returns the names of the handlers configs can reference
+func NamedHandlers() []string {
+	namedHandlersMu.RLock()
+	defer namedHandlersMu.RUnlock()
+
+	names := make([]string, 0, len(namedHandlers))
+	for name := range namedHandlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookupNamedHandler(name string) (TaskHandler, bool) {
+	namedHandlersMu.RLock()
+	defer namedHandlersMu.RUnlock()
+	handler, ok := namedHandlers[name]
+	return handler, ok
+}
+
+// TaskHandlers returns the task type to handler name mapping from the
+// task_handlers custom parameter. Disabled task types map to "".
+func (c *Config) TaskHandlers() (map[string]string, error) {
+	value, exists := c.GetCustomParameter(TaskHandlersParameter)
+	if !exists || value == nil {
+		return nil, nil
+	}
+
+	mapping := make(map[string]string)
+	switch v := value.(type) {
+	case map[string]string:
+		for taskType, name := range v {
+			mapping[taskType] = name
+		}
+	case map[string]interface{}:
+		for taskType, name := range v {
+			switch n := name.(type) {
+			case string:
+				mapping[taskType] = n
+			case nil:
+				mapping[taskType] = ""
+			default:
+				return nil, fmt.Errorf("%w: handler for task type %q must be a string, got %T",
+					ErrInvalidConfig, taskType, name)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s must map task types to handler names, got %T",
+			ErrInvalidConfig, TaskHandlersParameter, value)
+	}
+	return mapping, nil
+}
+
+// validateHandlers checks that every handler the config references exists
+func (c *Config) validateHandlers() error {
+	mapping, err := c.TaskHandlers()
+	if err != nil {
+		return err
+	}
+
+	for taskType, name := range mapping {
+		if taskType == "" {
+			return fmt.Errorf("%w: %s has an empty task type", ErrInvalidConfig, TaskHandlersParameter)
+		}
+		if name == "" {
+			continue
+		}
+		if _, ok := lookupNamedHandler(name); !ok {
+			return fmt.Errorf("%w: %q for task type %q", ErrUnknownHandler, name, taskType)
+		}
+	}
+	return nil
+}
+
+// registerConfiguredHandlers registers the handlers named in the config and
+// removes the ones it disables
+func (a *Agent) registerConfiguredHandlers() error {
+	mapping, err := a.config.TaskHandlers()
+	if err != nil {
+		return err
+	}
+
+	for taskType, name := range mapping {
+		if name == "" {
+			a.processor.UnregisterHandler(taskType)
+			a.logger.Info("Task type disabled by config", "taskType", taskType)
+			continue
+		}
+
+		handler, ok := lookupNamedHandler(name)
+		if !ok {
+			return fmt.Errorf("%w: %q for task type %q", ErrUnknownHandler, name, taskType)
+		}
+		a.processor.RegisterHandler(taskType, handler)
+		a.logger.Info("Configured handler registered", "taskType", taskType, "handler", name)
+	}
+	return nil
+}
+
+// Built-in named handlers
+
+// noopHandler accepts a task without doing anything, for task types that
+// only need to be acknowledged
+func noopHandler(ctx context.Context, state *State, task Task) error {
+	return nil
+}
+
+// memoryHandler stores the task's "value" in short-term memory under its
+// "key", without expiry
+func memoryHandler(ctx context.Context, state *State, task Task) error {
+	key, err := TaskValue[string](task, "key")
+	if err != nil {
+		return err
+	}
+	value, ok := task.Data["value"]
+	if !ok {
+		return fmt.Errorf("%w: value", ErrTaskValueMissing)
+	}
+	return state.Remember(key, value, MemoryTypeShortTerm, 0)
+}
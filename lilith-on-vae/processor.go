@@ -2,23 +2,59 @@ package lilith
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sort"
 	"sync"
 	"time"
 
-	"github.com/alone-labs/pkg/logger"
+	"github.com/labs-alone/alone-main/pkg/logger"
 )
 
 // Processor handles task processing and execution for the Lilith agent
 type Processor struct {
+	// tasks is the queue, kept sorted by taskBefore at all times. It is only
+	// changed under mu through insertTask and removeTask, so a task popped by
+	// Process is always the best ready one even while others are added.
 	tasks     []Task
 	mu        sync.RWMutex
 	handlers  map[string]TaskHandler
-	logger    *logger.Logger
+	streaming map[string]StreamingTaskHandler
+	logger    logger.Logger
 	semaphore chan struct{} // For limiting concurrent tasks
+
+	// Queue wait metrics, overall and per priority
+	waitTime           WaitTimeStats
+	waitTimeByPriority map[int]*WaitTimeStats
+
+	// Outcome of finished tasks by ID, for resolving dependencies. Outcomes
+	// older than outcomeTTL are swept, at most once per outcomeTTL. running
+	// holds the IDs of tasks taken from the queue that haven't finished.
+	outcomes       map[string]taskOutcome
+	outcomeTTL     time.Duration
+	lastSweep      time.Time
+	running        map[string]bool
+	dependencyWait time.Duration
+
+	// Subscribers to streaming task updates by ID
+	subscribers map[int]*updateSubscriber
+	nextSubID   int
+
+	// Tasks that failed, held until they are replayed. At most
+	// maxDeadLetters are kept; droppedDeadLetters counts the oldest ones
+	// dropped to make room.
+	deadLetters        []DeadLetter
+	maxDeadLetters     int
+	droppedDeadLetters uint64
+
+	// Recent task results and the subscribers to new ones by ID
+	results    *resultHistory
+	resultSubs map[int]chan TaskResult
 }
 
+// minQueueCapacity is the smallest backing array the task queue shrinks to
+const minQueueCapacity = 64
+
 // Task represents a unit of work for the agent to process
 type Task struct {
 	ID        string                 `json:"id"`
@@ -29,14 +65,115 @@ type Task struct {
 	StartedAt *time.Time            `json:"started_at,omitempty"`
 	Deadline  *time.Time            `json:"deadline,omitempty"`
 	Attempts  int                   `json:"attempts"`
+
+	// DependsOn lists task IDs that must succeed before this task runs
+	DependsOn []string `json:"depends_on,omitempty"`
 }
 
 // TaskHandler defines the function signature for task handlers
 type TaskHandler func(context.Context, *State, Task) error
 
+// StreamingTaskHandler is a task handler that reports incremental output on
+// updates while it runs. It must not send on updates after returning, and
+// should stop sending once the context is done.
+type StreamingTaskHandler func(context.Context, *State, Task, chan<- TaskUpdate) error
+
+// TaskUpdate is incremental output from a streaming task. The processor
+// fills in TaskID, Sequence and, if unset, Time.
+type TaskUpdate struct {
+	TaskID   string                 `json:"task_id"`
+	Sequence int                    `json:"sequence"`
+	Progress float64                `json:"progress,omitempty"` // 0 to 1
+	Message  string                 `json:"message,omitempty"`
+	Data     map[string]interface{} `json:"data,omitempty"`
+	Time     time.Time              `json:"time"`
+}
+
+// updateSubscriber receives task updates until done is closed
+type updateSubscriber struct {
+	updates chan TaskUpdate
+	done    chan struct{}
+}
+
+// DeadLetter is a failed task held out of the queue until it is replayed
+type DeadLetter struct {
+	Task     Task      `json:"task"`
+	Error    string    `json:"error"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// PermanentError marks a task failure that replaying can't fix, such as
+// invalid task data. Tasks failing with one aren't dead-lettered.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent wraps err in a *PermanentError, for handlers to return when a
+// task can never succeed. It returns nil for a nil err.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+// retryable reports whether a failed task might succeed if replayed. Tasks
+// with no handler or unusable data, and failures marked with Permanent,
+// won't.
+func retryable(err error) bool {
+	var permanent *PermanentError
+	switch {
+	case errors.As(err, &permanent),
+		errors.Is(err, ErrUnknownTaskType),
+		errors.Is(err, ErrTaskValueMissing),
+		errors.Is(err, ErrTaskValueType):
+		return false
+	}
+	return true
+}
+
+// DeadLetterFilter selects dead-lettered tasks to replay. A task matches if
+// its ID or type is listed; an empty filter matches every task.
+type DeadLetterFilter struct {
+	IDs   []string `json:"ids,omitempty"`
+	Types []string `json:"types,omitempty"`
+}
+
+func (f DeadLetterFilter) matches(task Task) bool {
+	if len(f.IDs) == 0 && len(f.Types) == 0 {
+		return true
+	}
+	for _, id := range f.IDs {
+		if task.ID == id {
+			return true
+		}
+	}
+	for _, taskType := range f.Types {
+		if task.Type == taskType {
+			return true
+		}
+	}
+	return false
+}
+
+// taskOutcome records whether a finished task succeeded, and when
+type taskOutcome struct {
+	succeeded  bool
+	finishedAt time.Time
+}
+
 // TaskResult represents the outcome of task processing
 type TaskResult struct {
 	TaskID    string
+	Type      string
 	Success   bool
 	Error     error
 	StartTime time.Time
@@ -44,16 +181,42 @@ type TaskResult struct {
 }
 
 // NewProcessor creates a new task processor
-func NewProcessor(config *Config, logger *logger.Logger) *Processor {
+func NewProcessor(config *Config, logger logger.Logger) *Processor {
+	outcomeTTL := config.OutcomeTTL
+	if outcomeTTL <= 0 {
+		outcomeTTL = DefaultOutcomeTTL
+	}
+	dependencyWait := config.DependencyWait
+	if dependencyWait <= 0 {
+		dependencyWait = DefaultDependencyWait
+	}
+	maxDeadLetters := config.MaxDeadLetters
+	if maxDeadLetters <= 0 {
+		maxDeadLetters = DefaultMaxDeadLetters
+	}
+
 	return &Processor{
 		tasks:     make([]Task, 0),
 		handlers:  make(map[string]TaskHandler),
+		streaming: make(map[string]StreamingTaskHandler),
 		logger:    logger,
 		semaphore: make(chan struct{}, config.MaxConcurrentTasks),
+
+		waitTimeByPriority: make(map[int]*WaitTimeStats),
+		outcomes:           make(map[string]taskOutcome),
+		outcomeTTL:         outcomeTTL,
+		lastSweep:          time.Now(),
+		running:            make(map[string]bool),
+		dependencyWait:     dependencyWait,
+		maxDeadLetters:     maxDeadLetters,
+		subscribers:        make(map[int]*updateSubscriber),
+		results:            newResultHistory(ResultHistorySize),
+		resultSubs:         make(map[int]chan TaskResult),
 	}
 }
 
-// AddTask adds a new task to the processing queue
+// AddTask adds a new task to the processing queue. Tasks whose
+// dependencies would form a cycle with queued tasks are rejected.
 func (p *Processor) AddTask(task Task) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -65,8 +228,11 @@ func (p *Processor) AddTask(task Task) error {
 		task.CreatedAt = time.Now()
 	}
 
-	p.tasks = append(p.tasks, task)
-	p.sortTasks()
+	if p.hasDependencyCycle(task) {
+		return fmt.Errorf("%w: %s", ErrDependencyCycle, task.ID)
+	}
+
+	p.insertTask(task)
 
 	p.logger.Debug("Task added to queue", 
 		"taskID", task.ID,
@@ -79,19 +245,32 @@ func (p *Processor) AddTask(task Task) error {
 
 // Process handles the main task processing loop
 func (p *Processor) Process(ctx context.Context, state *State) error {
+	// The task is picked and removed under one lock so concurrent AddTask
+	// calls can't shift the queue in between
 	p.mu.Lock()
-	if len(p.tasks) == 0 {
+
+	// Get next task whose dependencies have all finished
+	i, dep, depErr := p.nextReadyTask(time.Now())
+	if i < 0 {
 		p.mu.Unlock()
 		return nil
 	}
 
-	// Get next task
-	task := p.tasks[0]
-	p.tasks = p.tasks[1:]
+	task := p.removeTask(i)
+
+	if depErr != nil {
+		p.outcomes[task.ID] = taskOutcome{succeeded: false, finishedAt: time.Now()}
+		p.mu.Unlock()
+
+		p.logger.Warn("Task dependency not met", "taskID", task.ID, "dependency", dep, "error", depErr)
+		return fmt.Errorf("%w: %s depends on %s", depErr, task.ID, dep)
+	}
+	p.running[task.ID] = true
 	p.mu.Unlock()
 
 	// Check if task has expired
 	if task.Deadline != nil && time.Now().After(*task.Deadline) {
+		p.recordOutcome(task.ID, false)
 		p.logger.Warn("Task expired", "taskID", task.ID)
 		return fmt.Errorf("task expired: %s", task.ID)
 	}
@@ -101,11 +280,63 @@ func (p *Processor) Process(ctx context.Context, state *State) error {
 	case p.semaphore <- struct{}{}:
 		defer func() { <-p.semaphore }()
 	case <-ctx.Done():
+		p.recordOutcome(task.ID, false)
 		return ctx.Err()
 	}
 
 	// Process task
-	return p.executeTask(ctx, state, task)
+	err := p.executeTask(ctx, state, &task)
+	p.recordOutcome(task.ID, err == nil)
+	if err != nil {
+		if retryable(err) {
+			p.deadLetter(task, err)
+		} else {
+			p.logger.Warn("Task failed permanently, not dead-lettered", "taskID", task.ID, "error", err)
+		}
+	}
+	return err
+}
+
+// DeadLetters returns the failed tasks awaiting replay, oldest first
+func (p *Processor) DeadLetters() []DeadLetter {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return append([]DeadLetter(nil), p.deadLetters...)
+}
+
+// ReplayDeadLetters moves the dead-lettered tasks matching filter back into
+// the queue with their attempt counter reset, and returns them. Their
+// recorded failure is forgotten so tasks depending on them wait for the
+// replay.
+func (p *Processor) ReplayDeadLetters(filter DeadLetterFilter) []Task {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var replayed []Task
+	kept := p.deadLetters[:0]
+	for _, dead := range p.deadLetters {
+		if !filter.matches(dead.Task) {
+			kept = append(kept, dead)
+			continue
+		}
+
+		task := dead.Task
+		task.Attempts = 0
+		task.StartedAt = nil
+		delete(p.outcomes, task.ID)
+
+		p.insertTask(task)
+		replayed = append(replayed, task)
+	}
+	for i := len(kept); i < len(p.deadLetters); i++ {
+		p.deadLetters[i] = DeadLetter{}
+	}
+	p.deadLetters = kept
+
+	if len(replayed) > 0 {
+		p.logger.Info("Dead-lettered tasks replayed", "count", len(replayed))
+	}
+	return replayed
 }
 
 // RegisterHandler adds a new task handler
@@ -113,14 +344,66 @@ func (p *Processor) RegisterHandler(taskType string, handler TaskHandler) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	p.handlers[taskType] = handler
+	delete(p.streaming, taskType)
 	p.logger.Debug("Handler registered", "taskType", taskType)
 }
 
+// UnregisterHandler removes the handler for taskType, so tasks of that type
+// fail with ErrUnknownTaskType
+func (p *Processor) UnregisterHandler(taskType string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.handlers, taskType)
+	delete(p.streaming, taskType)
+	p.logger.Debug("Handler unregistered", "taskType", taskType)
+}
+
+// RegisterStreamingHandler adds a task handler whose updates are forwarded
+// to subscribers. It replaces any handler registered for taskType.
+func (p *Processor) RegisterStreamingHandler(taskType string, handler StreamingTaskHandler) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.streaming[taskType] = handler
+	delete(p.handlers, taskType)
+	p.logger.Debug("Streaming handler registered", "taskType", taskType)
+}
+
+// Subscribe returns a channel receiving updates from every streaming task,
+// in the order each task sends them, and a function that ends the
+// subscription. Updates are delivered without dropping, so a subscriber that
+// stops reading holds up streaming tasks until it unsubscribes or the task
+// times out.
+func (p *Processor) Subscribe(buffer int) (<-chan TaskUpdate, func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	id := p.nextSubID
+	p.nextSubID++
+	sub := &updateSubscriber{
+		updates: make(chan TaskUpdate, buffer),
+		done:    make(chan struct{}),
+	}
+	p.subscribers[id] = sub
+
+	var once sync.Once
+	return sub.updates, func() {
+		once.Do(func() {
+			p.mu.Lock()
+			delete(p.subscribers, id)
+			p.mu.Unlock()
+			close(sub.done)
+		})
+	}
+}
+
 // Internal methods
 
-func (p *Processor) executeTask(ctx context.Context, state *State, task Task) error {
+func (p *Processor) executeTask(ctx context.Context, state *State, task *Task) error {
+	p.mu.RLock()
 	handler, exists := p.handlers[task.Type]
-	if !exists {
+	streaming, isStreaming := p.streaming[task.Type]
+	p.mu.RUnlock()
+	if !exists && !isStreaming {
 		return fmt.Errorf("%w: %s", ErrUnknownTaskType, task.Type)
 	}
 
@@ -128,21 +411,29 @@ func (p *Processor) executeTask(ctx context.Context, state *State, task Task) er
 	task.StartedAt = &startTime
 	task.Attempts++
 
+	p.recordWaitTime(task.Priority, startTime.Sub(task.CreatedAt))
+
 	p.logger.Debug("Executing task", 
 		"taskID", task.ID,
 		"type", task.Type,
 		"attempt", task.Attempts,
 	)
 
-	// Create task context with timeout
-	taskCtx, cancel := context.WithTimeout(ctx, p.getTaskTimeout(task))
+	// Create task context with timeout, carrying the task's metadata
+	taskCtx, cancel := context.WithTimeout(withTaskInfo(ctx, *task), p.getTaskTimeout(*task))
 	defer cancel()
 
 	// Execute handler
-	err := handler(taskCtx, state, task)
+	var err error
+	if isStreaming {
+		err = p.executeStreaming(taskCtx, state, *task, streaming)
+	} else {
+		err = handler(taskCtx, state, *task)
+	}
 
 	result := TaskResult{
 		TaskID:    task.ID,
+		Type:      task.Type,
 		Success:   err == nil,
 		Error:     err,
 		StartTime: startTime,
@@ -155,7 +446,54 @@ func (p *Processor) executeTask(ctx context.Context, state *State, task Task) er
 	return err
 }
 
+// executeStreaming runs a streaming handler, forwarding its updates to
+// subscribers until it returns
+func (p *Processor) executeStreaming(ctx context.Context, state *State, task Task, handler StreamingTaskHandler) error {
+	updates := make(chan TaskUpdate)
+	forwarded := make(chan struct{})
+
+	go func() {
+		defer close(forwarded)
+		sequence := 0
+		for update := range updates {
+			sequence++
+			update.TaskID = task.ID
+			update.Sequence = sequence
+			if update.Time.IsZero() {
+				update.Time = time.Now()
+			}
+			p.publish(ctx, update)
+		}
+	}()
+
+	err := handler(ctx, state, task, updates)
+	close(updates)
+	<-forwarded
+	return err
+}
+
+// publish delivers an update to every current subscriber
+func (p *Processor) publish(ctx context.Context, update TaskUpdate) {
+	p.mu.RLock()
+	subs := make([]*updateSubscriber, 0, len(p.subscribers))
+	for _, sub := range p.subscribers {
+		subs = append(subs, sub)
+	}
+	p.mu.RUnlock()
+
+	for _, sub := range subs {
+		select {
+		case sub.updates <- update:
+		case <-sub.done:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
 func (p *Processor) handleTaskResult(result TaskResult) {
+	p.recordResult(result)
+
 	if result.Success {
 		p.logger.Debug("Task completed successfully",
 			"taskID", result.TaskID,
@@ -170,16 +508,173 @@ func (p *Processor) handleTaskResult(result TaskResult) {
 	}
 }
 
-func (p *Processor) sortTasks() {
-	sort.SliceStable(p.tasks, func(i, j int) bool {
-		// Higher priority first, then earlier creation time
-		if p.tasks[i].Priority != p.tasks[j].Priority {
-			return p.tasks[i].Priority > p.tasks[j].Priority
+// recordWaitTime adds a queue wait sample to the overall and per-priority stats
+func (p *Processor) recordWaitTime(priority int, wait time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.waitTime.observe(wait)
+
+	stats, ok := p.waitTimeByPriority[priority]
+	if !ok {
+		stats = &WaitTimeStats{}
+		p.waitTimeByPriority[priority] = stats
+	}
+	stats.observe(wait)
+}
+
+// nextReadyTask returns the index of the first queued task whose
+// dependencies have all succeeded, or that can't run along with the
+// dependency at fault and ErrDependencyFailed or ErrDependencyUnknown. A
+// dependency is unknown if it is neither queued, running nor finished once
+// the task has waited dependencyWait, as is one whose outcome has expired.
+// It returns -1 if every task is still waiting. Callers must hold p.mu.
+func (p *Processor) nextReadyTask(now time.Time) (int, string, error) {
+	var queued map[string]bool
+next:
+	for i, task := range p.tasks {
+		for _, dep := range task.DependsOn {
+			outcome, finished := p.outcomes[dep]
+			if finished && now.Sub(outcome.finishedAt) > p.outcomeTTL {
+				finished = false
+			}
+			if finished {
+				if !outcome.succeeded {
+					return i, dep, ErrDependencyFailed
+				}
+				continue
+			}
+
+			if queued == nil {
+				queued = make(map[string]bool, len(p.tasks))
+				for _, t := range p.tasks {
+					queued[t.ID] = true
+				}
+			}
+			if !queued[dep] && !p.running[dep] && now.Sub(task.CreatedAt) >= p.dependencyWait {
+				return i, dep, ErrDependencyUnknown
+			}
+			continue next
 		}
-		return p.tasks[i].CreatedAt.Before(p.tasks[j].CreatedAt)
+		return i, "", nil
+	}
+	return -1, "", nil
+}
+
+// hasDependencyCycle reports whether adding task would create a dependency
+// cycle through the queued tasks. Callers must hold p.mu.
+func (p *Processor) hasDependencyCycle(task Task) bool {
+	if len(task.DependsOn) == 0 {
+		return false
+	}
+
+	queued := make(map[string]Task, len(p.tasks))
+	for _, t := range p.tasks {
+		queued[t.ID] = t
+	}
+
+	visited := make(map[string]bool)
+	stack := append([]string{}, task.DependsOn...)
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if id == task.ID {
+			return true
+		}
+		if visited[id] {
+			continue
+		}
+		visited[id] = true
+
+		if dep, ok := queued[id]; ok {
+			stack = append(stack, dep.DependsOn...)
+		}
+	}
+	return false
+}
+
+// deadLetter holds a failed task out of the queue until it is replayed,
+// dropping the oldest dead letter if maxDeadLetters are held
+func (p *Processor) deadLetter(task Task, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.deadLetters) >= p.maxDeadLetters {
+		dropped := p.deadLetters[0]
+		copy(p.deadLetters, p.deadLetters[1:])
+		p.deadLetters[len(p.deadLetters)-1] = DeadLetter{}
+		p.deadLetters = p.deadLetters[:len(p.deadLetters)-1]
+		p.droppedDeadLetters++
+		p.logger.Warn("Dead letter dropped, too many held", "taskID", dropped.Task.ID, "max", p.maxDeadLetters)
+	}
+	p.deadLetters = append(p.deadLetters, DeadLetter{
+		Task:     task,
+		Error:    err.Error(),
+		FailedAt: time.Now(),
 	})
 }
 
+// recordOutcome stores whether a finished task succeeded so dependent tasks
+// can be released or failed, sweeping expired outcomes once per outcomeTTL
+func (p *Processor) recordOutcome(taskID string, succeeded bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	delete(p.running, taskID)
+	p.outcomes[taskID] = taskOutcome{succeeded: succeeded, finishedAt: now}
+
+	if now.Sub(p.lastSweep) < p.outcomeTTL {
+		return
+	}
+	for id, outcome := range p.outcomes {
+		if now.Sub(outcome.finishedAt) > p.outcomeTTL {
+			delete(p.outcomes, id)
+		}
+	}
+	p.lastSweep = now
+}
+
+// removeTask removes the task at index i from the queue, keeping the rest in
+// order. The vacated slot is cleared so the removed task's data can be
+// collected, and the backing array shrinks as the queue drains so a burst of
+// tasks doesn't pin memory. Callers must hold p.mu.
+func (p *Processor) removeTask(i int) Task {
+	task := p.tasks[i]
+	last := len(p.tasks) - 1
+	copy(p.tasks[i:], p.tasks[i+1:])
+	p.tasks[last] = Task{}
+	p.tasks = p.tasks[:last]
+
+	if cap(p.tasks) > minQueueCapacity && len(p.tasks) < cap(p.tasks)/4 {
+		shrunk := make([]Task, len(p.tasks), cap(p.tasks)/2)
+		copy(shrunk, p.tasks)
+		p.tasks = shrunk
+	}
+	return task
+}
+
+// insertTask adds task to the queue at its sorted position, after any tasks
+// that compare equal so they keep their order. Callers must hold p.mu.
+func (p *Processor) insertTask(task Task) {
+	i := sort.Search(len(p.tasks), func(i int) bool {
+		return taskBefore(task, p.tasks[i])
+	})
+	p.tasks = append(p.tasks, Task{})
+	copy(p.tasks[i+1:], p.tasks[i:])
+	p.tasks[i] = task
+}
+
+// taskBefore reports whether a runs before b: higher priority first, then
+// earlier creation time
+func taskBefore(a, b Task) bool {
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	return a.CreatedAt.Before(b.CreatedAt)
+}
+
 func (p *Processor) getTaskTimeout(task Task) time.Duration {
 	if task.Deadline != nil {
 		return time.Until(*task.Deadline)
@@ -200,9 +695,13 @@ func (p *Processor) GetQueueStatus() QueueStatus {
 	defer p.mu.RUnlock()
 
 	status := QueueStatus{
-		TotalTasks:     len(p.tasks),
-		PriorityLevels: make(map[int]int),
-		TaskTypes:      make(map[string]int),
+		TotalTasks:         len(p.tasks),
+		DeadLetters:        len(p.deadLetters),
+		DroppedDeadLetters: p.droppedDeadLetters,
+		PriorityLevels:     make(map[int]int),
+		TaskTypes:          make(map[string]int),
+		WaitTime:           p.waitTime,
+		WaitTimeByPriority: make(map[int]WaitTimeStats, len(p.waitTimeByPriority)),
 	}
 
 	for _, task := range p.tasks {
@@ -210,12 +709,48 @@ func (p *Processor) GetQueueStatus() QueueStatus {
 		status.TaskTypes[task.Type]++
 	}
 
+	for priority, stats := range p.waitTimeByPriority {
+		status.WaitTimeByPriority[priority] = *stats
+	}
+
 	return status
 }
 
 // QueueStatus represents the current state of the task queue
 type QueueStatus struct {
 	TotalTasks     int
+	DeadLetters    int
 	PriorityLevels map[int]int
 	TaskTypes      map[string]int
+
+	// Dead letters dropped to stay under MaxDeadLetters
+	DroppedDeadLetters uint64
+
+	// Time tasks spent queued between CreatedAt and StartedAt
+	WaitTime           WaitTimeStats
+	WaitTimeByPriority map[int]WaitTimeStats
+}
+
+// WaitTimeStats summarizes how long tasks waited before execution
+type WaitTimeStats struct {
+	Count   uint64
+	Total   time.Duration
+	Min     time.Duration
+	Max     time.Duration
+	Average time.Duration
+}
+
+func (w *WaitTimeStats) observe(wait time.Duration) {
+	if wait < 0 {
+		wait = 0
+	}
+	if w.Count == 0 || wait < w.Min {
+		w.Min = wait
+	}
+	if wait > w.Max {
+		w.Max = wait
+	}
+	w.Count++
+	w.Total += wait
+	w.Average = w.Total / time.Duration(w.Count)
 }
\ No newline at end of file
@@ -0,0 +1,65 @@
+package lilith
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Registry tracks running agents by ID so they can be looked up, e.g. by
+// the admin API
+type Registry struct {
+	mu     sync.RWMutex
+	agents map[string]*Agent
+}
+
+// NewRegistry creates an empty agent registry
+func NewRegistry() *Registry {
+	return &Registry{
+		agents: make(map[string]*Agent),
+	}
+}
+
+// Register adds an agent to the registry
+func (r *Registry) Register(agent *Agent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.agents[agent.ID]; exists {
+		return fmt.Errorf("%w: %s", ErrAgentExists, agent.ID)
+	}
+	r.agents[agent.ID] = agent
+	return nil
+}
+
+// Unregister removes an agent from the registry
+func (r *Registry) Unregister(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.agents, id)
+}
+
+// Get returns the agent registered under id
+func (r *Registry) Get(id string) (*Agent, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	agent, exists := r.agents[id]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrAgentNotFound, id)
+	}
+	return agent, nil
+}
+
+// IDs returns the IDs of the registered agents in sorted order
+func (r *Registry) IDs() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ids := make([]string, 0, len(r.agents))
+	for id := range r.agents {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -0,0 +1,124 @@
+package lilith
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// ResultHistorySize is how many recent task results a processor keeps for
+// late subscribers
+const ResultHistorySize = 100
+
+// resultHistory is a ring buffer of the most recent task results
+type resultHistory struct {
+	items []TaskResult
+	next  int
+	full  bool
+}
+
+func newResultHistory(size int) *resultHistory {
+	return &resultHistory{items: make([]TaskResult, size)}
+}
+
+func (h *resultHistory) add(result TaskResult) {
+	h.items[h.next] = result
+	h.next = (h.next + 1) % len(h.items)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// recent returns up to n of the latest results, oldest first
+func (h *resultHistory) recent(n int) []TaskResult {
+	count := h.next
+	if h.full {
+		count = len(h.items)
+	}
+	if n < 0 || n > count {
+		n = count
+	}
+
+	out := make([]TaskResult, n)
+	start := h.next - n
+	if start < 0 {
+		start += len(h.items)
+	}
+	for i := range out {
+		out[i] = h.items[(start+i)%len(h.items)]
+	}
+	return out
+}
+
+// MarshalJSON writes the error as its message and adds the duration
+func (r TaskResult) MarshalJSON() ([]byte, error) {
+	var errMsg string
+	if r.Error != nil {
+		errMsg = r.Error.Error()
+	}
+
+	return json.Marshal(struct {
+		TaskID    string    `json:"task_id"`
+		Type      string    `json:"type"`
+		Success   bool      `json:"success"`
+		Error     string    `json:"error,omitempty"`
+		StartTime time.Time `json:"start_time"`
+		EndTime   time.Time `json:"end_time"`
+		Duration  string    `json:"duration"`
+	}{
+		TaskID:    r.TaskID,
+		Type:      r.Type,
+		Success:   r.Success,
+		Error:     errMsg,
+		StartTime: r.StartTime,
+		EndTime:   r.EndTime,
+		Duration:  r.EndTime.Sub(r.StartTime).String(),
+	})
+}
+
+// RecentResults returns up to n of the latest task results, oldest first. A
+// negative n returns every result kept.
+func (p *Processor) RecentResults(n int) []TaskResult {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.results.recent(n)
+}
+
+// SubscribeResults returns up to backlog of the latest task results and a
+// channel receiving every result after them, with no gap or overlap between
+// the two. Results are dropped for a subscriber whose buffer is full rather
+// than holding up processing. The returned function ends the subscription
+// and closes the channel.
+func (p *Processor) SubscribeResults(backlog, buffer int) ([]TaskResult, <-chan TaskResult, func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	id := p.nextSubID
+	p.nextSubID++
+	results := make(chan TaskResult, buffer)
+	p.resultSubs[id] = results
+
+	var once sync.Once
+	return p.results.recent(backlog), results, func() {
+		once.Do(func() {
+			p.mu.Lock()
+			delete(p.resultSubs, id)
+			p.mu.Unlock()
+			close(results)
+		})
+	}
+}
+
+// recordResult adds a result to the history and sends it to subscribers
+func (p *Processor) recordResult(result TaskResult) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.results.add(result)
+	for _, sub := range p.resultSubs {
+		select {
+		case sub <- result:
+		default:
+		}
+	}
+}
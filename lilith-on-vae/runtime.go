@@ -0,0 +1,142 @@
+package lilith
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// DefaultShutdownTimeout bounds how long a signal-triggered shutdown waits
+// for queued tasks to drain
+const DefaultShutdownTimeout = 30 * time.Second
+
+// Names of the files persistent memory stores are written to under the
+// memory persist path
+const (
+	LongTermMemoryFile  = "long_term_memory.json"
+	ShortTermMemoryFile = "short_term_memory.json"
+	VolatileMemoryFile  = "volatile_memory.json"
+)
+
+// StopGracefully stops accepting tasks, waits for the queued tasks to
+// finish and then stops the agent. If ctx ends first the agent is stopped
+// anyway, cancelling any running task, and the remaining tasks are dropped.
+func (a *Agent) StopGracefully(ctx context.Context) error {
+	a.mu.Lock()
+	if !a.isRunning {
+		a.mu.Unlock()
+		return ErrAgentNotRunning
+	}
+	a.draining = true
+	done := a.runDone
+	a.mu.Unlock()
+
+	a.logger.Info("Draining agent tasks", "id", a.ID, "queued", a.processor.GetQueueLength())
+
+	var drainErr error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		drainErr = fmt.Errorf("drain interrupted with %d tasks queued: %w",
+			a.processor.GetQueueLength(), ctx.Err())
+	}
+
+	if err := a.Stop(); err != nil {
+		return err
+	}
+	return drainErr
+}
+
+// PersistMemory writes each persistent memory store under the configured
+// memory persist path and returns the files written
+func (a *Agent) PersistMemory() ([]string, error) {
+	if a.config.MemoryPersistPath == "" {
+		return nil, fmt.Errorf("%w: memory persist path not set", ErrInvalidConfig)
+	}
+	if err := os.MkdirAll(a.config.MemoryPersistPath, 0755); err != nil {
+		return nil, fmt.Errorf("error creating memory directory: %w", err)
+	}
+
+	stores := []struct {
+		memoryType MemoryType
+		store      *MemoryStore
+		file       string
+	}{
+		{MemoryTypeShortTerm, a.state.ShortTerm, ShortTermMemoryFile},
+		{MemoryTypeLongTerm, a.state.LongTerm, LongTermMemoryFile},
+		{MemoryTypeVolatile, a.state.Volatile, VolatileMemoryFile},
+	}
+
+	var paths []string
+	for _, s := range stores {
+		if !s.store.Persistent() {
+			continue
+		}
+		path := filepath.Join(a.config.MemoryPersistPath, s.file)
+		if err := a.state.PersistStore(s.memoryType, path); err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// Shutdown drains the agent's tasks for up to timeout and then persists
+// the persistent memory stores. Memory is persisted even if draining times
+// out; the returned error covers both steps, wrapping the drain error if
+// both fail.
+func (a *Agent) Shutdown(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	drainErr := a.StopGracefully(ctx)
+	if drainErr != nil {
+		a.logger.Error("Agent drain incomplete", "id", a.ID, "error", drainErr)
+	} else {
+		a.logger.Info("Agent drained", "id", a.ID, "duration", time.Since(start))
+	}
+
+	var persistErr error
+	if a.config.MemoryPersistPath != "" {
+		paths, err := a.PersistMemory()
+		if err != nil {
+			persistErr = fmt.Errorf("persisting memory: %w", err)
+			a.logger.Error("Agent memory not persisted", "id", a.ID, "error", err)
+		} else {
+			a.logger.Info("Agent memory persisted", "id", a.ID, "paths", paths)
+		}
+	} else {
+		a.logger.Warn("Agent memory not persisted: no memory persist path", "id", a.ID)
+	}
+
+	switch {
+	case drainErr != nil && persistErr != nil:
+		return fmt.Errorf("%w; %v", drainErr, persistErr)
+	case drainErr != nil:
+		return drainErr
+	default:
+		return persistErr
+	}
+}
+
+// RunUntilSignal blocks until the process receives SIGINT or SIGTERM, or
+// ctx ends, and then shuts the agent down with the given drain timeout
+func (a *Agent) RunUntilSignal(ctx context.Context, timeout time.Duration) error {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+
+	select {
+	case sig := <-sigChan:
+		a.logger.Info("Received shutdown signal", "id", a.ID, "signal", sig.String())
+	case <-ctx.Done():
+		a.logger.Info("Agent context done, shutting down", "id", a.ID)
+	}
+
+	return a.Shutdown(timeout)
+}
@@ -2,10 +2,14 @@ package lilith
 
 import (
 	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 
-	"github.com/alone-labs/pkg/logger"
+	"github.com/labs-alone/alone-main/pkg/logger"
 )
 
 // State manages the agent's current state and memory systems
@@ -14,8 +18,8 @@ type State struct {
 
 	// Core state
 	Status      Status
-	LastError   error
 	LastUpdated time.Time
+	lastError   error
 
 	// Memory systems
 	ShortTerm  *MemoryStore
@@ -26,7 +30,7 @@ type State struct {
 	TasksProcessed uint64
 	LastActivity   time.Time
 
-	logger *logger.Logger
+	logger logger.Logger
 }
 
 // MemoryStore represents a specific type of memory storage
@@ -35,6 +39,8 @@ type MemoryStore struct {
 	data       map[string]MemoryItem
 	maxSize    int
 	persistent bool
+	defaultTTL time.Duration
+	eviction   EvictionStrategy
 }
 
 // MemoryItem represents a single memory entry
@@ -48,32 +54,34 @@ type MemoryItem struct {
 }
 
 // NewState creates a new state instance
-func NewState(config *Config, logger *logger.Logger) *State {
+func NewState(config *Config, logger logger.Logger) *State {
 	return &State{
 		Status:      StatusIdle,
 		LastUpdated: time.Now(),
-		ShortTerm: NewMemoryStore(
-			config.MaxShortTermMemory,
-			false,
-		),
-		LongTerm: NewMemoryStore(
-			config.MaxLongTermMemory,
-			true,
-		),
-		Volatile: NewMemoryStore(
-			1000, // Small size for temporary data
-			false,
-		),
-		logger: logger,
+		ShortTerm:   NewMemoryStoreWithPolicy(config.StorePolicy(MemoryTypeShortTerm)),
+		LongTerm:    NewMemoryStoreWithPolicy(config.StorePolicy(MemoryTypeLongTerm)),
+		Volatile:    NewMemoryStoreWithPolicy(config.StorePolicy(MemoryTypeVolatile)),
+		logger:      logger,
 	}
 }
 
 // NewMemoryStore creates a new memory store
 func NewMemoryStore(maxSize int, persistent bool) *MemoryStore {
+	return NewMemoryStoreWithPolicy(MemoryPolicy{MaxSize: maxSize, Persistent: persistent})
+}
+
+// NewMemoryStoreWithPolicy creates a memory store configured by policy
+func NewMemoryStoreWithPolicy(policy MemoryPolicy) *MemoryStore {
+	eviction := policy.Eviction
+	if eviction == "" {
+		eviction = EvictionLeastUsed
+	}
 	return &MemoryStore{
 		data:       make(map[string]MemoryItem),
-		maxSize:    maxSize,
-		persistent: persistent,
+		maxSize:    policy.MaxSize,
+		persistent: policy.Persistent,
+		defaultTTL: policy.DefaultTTL,
+		eviction:   eviction,
 	}
 }
 
@@ -96,6 +104,9 @@ func (s *State) Remember(key string, value interface{}, memoryType MemoryType, t
 		return ErrInvalidMemoryType
 	}
 
+	if ttl <= 0 {
+		ttl = store.defaultTTL
+	}
 	var expiresAt *time.Time
 	if ttl > 0 {
 		t := time.Now().Add(ttl)
@@ -190,6 +201,31 @@ func (m *MemoryStore) Get(key string) (interface{}, error) {
 	return item.Value, nil
 }
 
+// Persistent reports whether the store is written to disk on shutdown
+func (m *MemoryStore) Persistent() bool {
+	return m.persistent
+}
+
+// Len returns the number of items held, including expired items not yet
+// cleaned up
+func (m *MemoryStore) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.data)
+}
+
+// Items returns a copy of the stored items by key
+func (m *MemoryStore) Items() map[string]MemoryItem {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	items := make(map[string]MemoryItem, len(m.data))
+	for key, item := range m.data {
+		items[key] = item
+	}
+	return items
+}
+
 func (m *MemoryStore) Delete(key string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -204,28 +240,43 @@ func (m *MemoryStore) Delete(key string) error {
 
 // Maintenance Operations
 
-func (m *MemoryStore) cleanup() {
-	// Remove expired items
-	now := time.Now()
+// SweepExpired removes every expired item, whether or not it has been
+// accessed, and returns how many were removed
+func (m *MemoryStore) SweepExpired() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.removeExpired(time.Now())
+}
+
+// removeExpired deletes items expired as of now. Callers must hold m.mu.
+func (m *MemoryStore) removeExpired(now time.Time) int {
+	removed := 0
 	for key, item := range m.data {
 		if item.ExpiresAt != nil && now.After(*item.ExpiresAt) {
 			delete(m.data, key)
+			removed++
 		}
 	}
+	return removed
+}
 
-	// If still over capacity, remove least accessed items
+func (m *MemoryStore) cleanup() {
+	// Remove expired items
+	m.removeExpired(time.Now())
+
+	// If still over capacity, evict by the store's strategy
 	if len(m.data) >= m.maxSize {
+		now := time.Now()
 		items := make([]struct {
 			key   string
 			score float64
 		}, 0, len(m.data))
 
 		for key, item := range m.data {
-			score := float64(item.Priority) * float64(item.AccessCount) / time.Since(item.LastAccess).Seconds()
 			items = append(items, struct {
 				key   string
 				score float64
-			}{key, score})
+			}{key, m.evictionScore(item, now)})
 		}
 
 		// Sort by score ascending (lowest first)
@@ -240,6 +291,29 @@ func (m *MemoryStore) cleanup() {
 	}
 }
 
+// evictionScore ranks item for eviction as of now, lowest first
+func (m *MemoryStore) evictionScore(item MemoryItem, now time.Time) float64 {
+	switch m.eviction {
+	case EvictionLRU:
+		return -float64(now.Sub(item.LastAccess))
+	case EvictionOldest:
+		return -float64(now.Sub(item.CreatedAt))
+	default:
+		return float64(item.Priority) * float64(item.AccessCount) / now.Sub(item.LastAccess).Seconds()
+	}
+}
+
+// CleanupExpiredMemory sweeps expired items from every memory store and
+// returns how many were removed. Get only expires the item it reads, so
+// without a sweep expired items that are never read again linger until a
+// store fills up.
+func (s *State) CleanupExpiredMemory() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.ShortTerm.SweepExpired() + s.LongTerm.SweepExpired() + s.Volatile.SweepExpired()
+}
+
 // State Management
 
 func (s *State) UpdateStatus(status Status) {
@@ -251,27 +325,142 @@ func (s *State) UpdateStatus(status Status) {
 	s.LastActivity = time.Now()
 }
 
-// Serialization
+// SetLastError records the most recent processing error
+func (s *State) SetLastError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-func (s *State) MarshalJSON() ([]byte, error) {
+	s.lastError = err
+	s.LastUpdated = time.Now()
+}
+
+// LastError returns the most recent processing error, or nil
+func (s *State) LastError() error {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	type StateSnapshot struct {
-		Status         Status    `json:"status"`
-		LastUpdated    time.Time `json:"last_updated"`
-		TasksProcessed uint64    `json:"tasks_processed"`
-		LastActivity   time.Time `json:"last_activity"`
-	}
+	return s.lastError
+}
+
+// Serialization
+
+// StateSnapshot is a point-in-time copy of the agent state. Memory holds the
+// stored items and is only filled in by a full snapshot.
+type StateSnapshot struct {
+	Status         Status          `json:"status"`
+	LastUpdated    time.Time       `json:"last_updated"`
+	TasksProcessed uint64          `json:"tasks_processed"`
+	LastActivity   time.Time       `json:"last_activity"`
+	MemorySizes    MemorySizes     `json:"memory_sizes"`
+	Memory         *MemorySnapshot `json:"memory,omitempty"`
+}
+
+// MemorySizes counts the items in each memory store
+type MemorySizes struct {
+	ShortTerm int `json:"short_term"`
+	LongTerm  int `json:"long_term"`
+	Volatile  int `json:"volatile"`
+}
+
+// MemorySnapshot holds the items in each memory store by key
+type MemorySnapshot struct {
+	ShortTerm map[string]MemoryItem `json:"short_term"`
+	LongTerm  map[string]MemoryItem `json:"long_term"`
+	Volatile  map[string]MemoryItem `json:"volatile"`
+}
+
+// Snapshot returns a copy of the state. If full is set it includes every
+// stored memory item, not just the store sizes.
+func (s *State) Snapshot(full bool) StateSnapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
 	snapshot := StateSnapshot{
 		Status:         s.Status,
 		LastUpdated:    s.LastUpdated,
 		TasksProcessed: s.TasksProcessed,
 		LastActivity:   s.LastActivity,
+		MemorySizes: MemorySizes{
+			ShortTerm: s.ShortTerm.Len(),
+			LongTerm:  s.LongTerm.Len(),
+			Volatile:  s.Volatile.Len(),
+		},
+	}
+
+	if full {
+		snapshot.Memory = &MemorySnapshot{
+			ShortTerm: s.ShortTerm.Items(),
+			LongTerm:  s.LongTerm.Items(),
+			Volatile:  s.Volatile.Items(),
+		}
+	}
+
+	return snapshot
+}
+
+// SaveSnapshot writes a full snapshot of the state to path as JSON
+func (s *State) SaveSnapshot(path string) error {
+	data, err := json.MarshalIndent(s.Snapshot(true), "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling state snapshot: %w", err)
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("error writing state snapshot: %w", err)
+	}
+	return nil
+}
+
+// PersistLongTerm writes the long-term memory items to path as JSON
+func (s *State) PersistLongTerm(path string) error {
+	return s.PersistStore(MemoryTypeLongTerm, path)
+}
+
+// PersistStore writes the items of the memoryType store to path as JSON
+func (s *State) PersistStore(memoryType MemoryType, path string) error {
+	var store *MemoryStore
+	var name string
+	switch memoryType {
+	case MemoryTypeShortTerm:
+		store, name = s.ShortTerm, "short-term"
+	case MemoryTypeLongTerm:
+		store, name = s.LongTerm, "long-term"
+	case MemoryTypeVolatile:
+		store, name = s.Volatile, "volatile"
+	default:
+		return ErrInvalidMemoryType
 	}
 
-	return json.Marshal(snapshot)
+	data, err := json.MarshalIndent(store.Items(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling %s memory: %w", name, err)
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("error writing %s memory: %w", name, err)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces path with data through a temporary file, so a
+// crash can't leave a partially written file behind
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *State) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Snapshot(false))
 }
 
 // Types and Constants
@@ -0,0 +1,123 @@
+package lilith
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+type taskContextKey struct{}
+
+// taskInfo is the task metadata carried in a handler's context
+type taskInfo struct {
+	id       string
+	attempt  int
+	deadline *time.Time
+}
+
+// withTaskInfo returns ctx carrying task's metadata for handlers
+func withTaskInfo(ctx context.Context, task Task) context.Context {
+	return context.WithValue(ctx, taskContextKey{}, taskInfo{
+		id:       task.ID,
+		attempt:  task.Attempts,
+		deadline: task.Deadline,
+	})
+}
+
+func taskInfoFrom(ctx context.Context) (taskInfo, bool) {
+	info, ok := ctx.Value(taskContextKey{}).(taskInfo)
+	return info, ok
+}
+
+// TaskID returns the ID of the task a handler is running, or "" outside a
+// task handler
+func TaskID(ctx context.Context) string {
+	info, _ := taskInfoFrom(ctx)
+	return info.id
+}
+
+// TaskAttempt returns which attempt at the task this is, starting at 1, or
+// 0 outside a task handler
+func TaskAttempt(ctx context.Context) int {
+	info, _ := taskInfoFrom(ctx)
+	return info.attempt
+}
+
+// TaskDeadline returns the deadline set on the task, if any. The handler's
+// context may end sooner, at the processor's task timeout.
+func TaskDeadline(ctx context.Context) (time.Time, bool) {
+	info, ok := taskInfoFrom(ctx)
+	if !ok || info.deadline == nil {
+		return time.Time{}, false
+	}
+	return *info.deadline, true
+}
+
+// TaskValue returns task.Data[key] as a T. Numbers convert between numeric
+// types when no precision is lost, since data decoded from JSON holds every
+// number as a float64. It returns ErrTaskValueMissing if the key is absent
+// or nil and ErrTaskValueType if the value can't be used as a T.
+func TaskValue[T any](task Task, key string) (T, error) {
+	var zero T
+
+	value, ok := task.Data[key]
+	if !ok || value == nil {
+		return zero, fmt.Errorf("%w: %s", ErrTaskValueMissing, key)
+	}
+	if typed, ok := value.(T); ok {
+		return typed, nil
+	}
+
+	if converted, ok := convertNumber(value, reflect.TypeOf(zero)); ok {
+		return converted.(T), nil
+	}
+	return zero, fmt.Errorf("%w: %s is %T, not %T", ErrTaskValueType, key, value, zero)
+}
+
+// convertNumber converts a numeric value to the numeric type target if the
+// value survives the round trip unchanged
+func convertNumber(value interface{}, target reflect.Type) (interface{}, bool) {
+	v := reflect.ValueOf(value)
+	if target == nil || !isNumeric(v.Kind()) || !isNumeric(target.Kind()) {
+		return nil, false
+	}
+
+	converted := v.Convert(target)
+	if !converted.Convert(v.Type()).Equal(v) {
+		return nil, false
+	}
+	// Negative values wrap around when converted to unsigned types
+	if isUnsigned(target.Kind()) && isNegative(v) {
+		return nil, false
+	}
+	return converted.Interface(), true
+}
+
+func isNumeric(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func isUnsigned(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+func isNegative(v reflect.Value) bool {
+	switch {
+	case v.CanInt():
+		return v.Int() < 0
+	case v.CanFloat():
+		return v.Float() < 0
+	}
+	return false
+}
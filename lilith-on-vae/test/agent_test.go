@@ -0,0 +1,350 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	lilith "github.com/labs-alone/alone-main/lilith-on-vae"
+	"github.com/labs-alone/alone-main/pkg/logger"
+)
+
+func setupAgent(t *testing.T) *lilith.Agent {
+	config := lilith.NewDefaultConfig()
+	config.ProcessInterval = 10 * time.Millisecond
+
+	agent, err := lilith.NewAgent(config, logger.New())
+	require.NoError(t, err)
+	require.NoError(t, agent.Start())
+	t.Cleanup(func() { agent.Stop() })
+
+	return agent
+}
+
+// TestAgentLastErrorRace is meant to run with -race: failing tasks set the
+// last error while status is polled concurrently
+func TestAgentLastErrorRace(t *testing.T) {
+	agent := setupAgent(t)
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					agent.GetStatus()
+				}
+			}
+		}()
+	}
+
+	// Tasks without a registered handler fail in the run loop
+	for i := 0; i < 10; i++ {
+		require.NoError(t, agent.AddTask(lilith.Task{
+			ID:        fmt.Sprintf("task-%d", i),
+			Type:      "unregistered",
+			CreatedAt: time.Now(),
+		}))
+	}
+
+	assert.Eventually(t, func() bool {
+		return agent.GetStatus().LastError != nil
+	}, 2*time.Second, 10*time.Millisecond)
+
+	close(done)
+	wg.Wait()
+
+	assert.ErrorIs(t, agent.GetStatus().LastError, lilith.ErrUnknownTaskType)
+}
+
+func TestStateLastError(t *testing.T) {
+	state := lilith.NewState(lilith.NewDefaultConfig(), logger.New())
+	assert.NoError(t, state.LastError())
+
+	err := fmt.Errorf("processing failed")
+	state.SetLastError(err)
+	assert.Equal(t, err, state.LastError())
+
+	state.SetLastError(nil)
+	assert.NoError(t, state.LastError())
+}
+
+func TestAgentAdaptiveInterval(t *testing.T) {
+	config := lilith.NewDefaultConfig()
+	config.AdaptiveInterval = true
+	config.ProcessInterval = 40 * time.Millisecond
+	config.MinProcessInterval = 10 * time.Millisecond
+	config.MaxProcessInterval = 160 * time.Millisecond
+
+	agent, err := lilith.NewAgent(config, logger.New())
+	require.NoError(t, err)
+	assert.Equal(t, 40*time.Millisecond, agent.ProcessInterval())
+
+	require.NoError(t, agent.Start())
+	t.Cleanup(func() { agent.Stop() })
+
+	// A backlog drives the interval down to the floor
+	for i := 0; i < 30; i++ {
+		require.NoError(t, agent.AddTask(lilith.Task{
+			ID:   fmt.Sprintf("health-%d", i),
+			Type: "system.health",
+		}))
+	}
+	assert.Eventually(t, func() bool {
+		return agent.ProcessInterval() == config.MinProcessInterval
+	}, 2*time.Second, 5*time.Millisecond)
+
+	// Once drained, idle passes back off to the ceiling
+	assert.Eventually(t, func() bool {
+		return agent.ProcessInterval() == config.MaxProcessInterval
+	}, 3*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 0, agent.Processor().GetQueueLength())
+	assert.Equal(t, config.MaxProcessInterval, agent.GetStatus().ProcessInterval)
+}
+
+func TestAgentAdaptiveIntervalBounds(t *testing.T) {
+	config := lilith.NewDefaultConfig()
+	config.AdaptiveInterval = true
+	config.MinProcessInterval = time.Second
+	config.MaxProcessInterval = 500 * time.Millisecond
+	assert.ErrorContains(t, config.Validate(), "exceeds max process interval")
+
+	config.MinProcessInterval = 10 * time.Millisecond
+	config.ProcessInterval = time.Second
+	assert.ErrorContains(t, config.Validate(), "outside adaptive bounds")
+
+	// Bounds are ignored unless adaptive mode is on
+	config.AdaptiveInterval = false
+	assert.NoError(t, config.Validate())
+}
+
+func setupDrainingAgent(t *testing.T, handler lilith.TaskHandler) (*lilith.Agent, *lilith.Config) {
+	config := lilith.NewDefaultConfig()
+	config.ProcessInterval = 10 * time.Millisecond
+	config.MemoryPersistPath = t.TempDir()
+
+	agent, err := lilith.NewAgent(config, logger.New())
+	require.NoError(t, err)
+	agent.Processor().RegisterHandler("work", handler)
+	require.NoError(t, agent.Start())
+
+	return agent, config
+}
+
+// TestAgentShutdownDrainsAndPersists exercises the SIGTERM path through
+// Shutdown, which RunUntilSignal calls once a signal arrives
+func TestAgentShutdownDrainsAndPersists(t *testing.T) {
+	var completed int32
+	agent, config := setupDrainingAgent(t, func(ctx context.Context, s *lilith.State, task lilith.Task) error {
+		select {
+		case <-time.After(20 * time.Millisecond):
+			atomic.AddInt32(&completed, 1)
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+
+	require.NoError(t, agent.State().Remember("lesson", "drain first", lilith.MemoryTypeLongTerm, 0))
+	for i := 0; i < 5; i++ {
+		require.NoError(t, agent.AddTask(lilith.Task{ID: fmt.Sprintf("work-%d", i), Type: "work"}))
+	}
+
+	require.NoError(t, agent.Shutdown(5*time.Second))
+
+	assert.Equal(t, int32(5), atomic.LoadInt32(&completed))
+	assert.Equal(t, 0, agent.Processor().GetQueueLength())
+	assert.Equal(t, lilith.StatusStopped, agent.GetStatus().Status)
+	assert.ErrorIs(t, agent.AddTask(lilith.Task{Type: "work"}), lilith.ErrAgentNotRunning)
+
+	data, err := os.ReadFile(filepath.Join(config.MemoryPersistPath, lilith.LongTermMemoryFile))
+	require.NoError(t, err)
+	var persisted map[string]lilith.MemoryItem
+	require.NoError(t, json.Unmarshal(data, &persisted))
+	assert.Equal(t, "drain first", persisted["lesson"].Value)
+}
+
+func TestAgentShutdownTimeout(t *testing.T) {
+	started := make(chan struct{})
+	agent, config := setupDrainingAgent(t, func(ctx context.Context, s *lilith.State, task lilith.Task) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	require.NoError(t, agent.AddTask(lilith.Task{ID: "stuck", Type: "work"}))
+	<-started
+
+	err := agent.Shutdown(50 * time.Millisecond)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Memory is persisted even though draining timed out
+	_, err = os.Stat(filepath.Join(config.MemoryPersistPath, lilith.LongTermMemoryFile))
+	assert.NoError(t, err)
+	assert.ErrorIs(t, agent.AddTask(lilith.Task{Type: "work"}), lilith.ErrAgentNotRunning)
+}
+
+func TestStateSweepsExpiredMemory(t *testing.T) {
+	state := lilith.NewState(lilith.NewDefaultConfig(), logger.New())
+
+	require.NoError(t, state.Remember("stale-1", "a", lilith.MemoryTypeShortTerm, time.Millisecond))
+	require.NoError(t, state.Remember("stale-2", "b", lilith.MemoryTypeLongTerm, time.Millisecond))
+	require.NoError(t, state.Remember("fresh", "c", lilith.MemoryTypeShortTerm, time.Hour))
+	require.NoError(t, state.Remember("forever", "d", lilith.MemoryTypeVolatile, 0))
+	time.Sleep(5 * time.Millisecond)
+
+	// The expired items are removed without ever being read
+	assert.Equal(t, 2, state.CleanupExpiredMemory())
+	assert.Equal(t, 1, state.ShortTerm.Len())
+	assert.Equal(t, 0, state.LongTerm.Len())
+	assert.Equal(t, 1, state.Volatile.Len())
+	assert.Equal(t, 0, state.CleanupExpiredMemory())
+
+	value, err := state.Recall("fresh", lilith.MemoryTypeShortTerm)
+	require.NoError(t, err)
+	assert.Equal(t, "c", value)
+}
+
+func TestStateMemoryPolicies(t *testing.T) {
+	config := lilith.NewDefaultConfig()
+	config.ShortTermPolicy = lilith.MemoryPolicy{MaxSize: 2, Eviction: lilith.EvictionOldest}
+	config.LongTermPolicy = lilith.MemoryPolicy{MaxSize: 2, Eviction: lilith.EvictionLRU}
+	config.VolatilePolicy = lilith.MemoryPolicy{MaxSize: 10, DefaultTTL: time.Millisecond}
+	require.NoError(t, config.Validate())
+
+	state := lilith.NewState(config, logger.New())
+
+	// The oldest memory is evicted even though it was just read
+	require.NoError(t, state.Remember("first", 1, lilith.MemoryTypeShortTerm, 0))
+	require.NoError(t, state.Remember("second", 2, lilith.MemoryTypeShortTerm, 0))
+	_, err := state.Recall("first", lilith.MemoryTypeShortTerm)
+	require.NoError(t, err)
+	require.NoError(t, state.Remember("third", 3, lilith.MemoryTypeShortTerm, 0))
+	_, err = state.Recall("first", lilith.MemoryTypeShortTerm)
+	assert.ErrorIs(t, err, lilith.ErrMemoryNotFound)
+	assert.Equal(t, 2, state.ShortTerm.Len())
+
+	// The least recently read memory is evicted
+	require.NoError(t, state.Remember("first", 1, lilith.MemoryTypeLongTerm, 0))
+	require.NoError(t, state.Remember("second", 2, lilith.MemoryTypeLongTerm, 0))
+	_, err = state.Recall("first", lilith.MemoryTypeLongTerm)
+	require.NoError(t, err)
+	require.NoError(t, state.Remember("third", 3, lilith.MemoryTypeLongTerm, 0))
+	_, err = state.Recall("second", lilith.MemoryTypeLongTerm)
+	assert.ErrorIs(t, err, lilith.ErrMemoryNotFound)
+	_, err = state.Recall("first", lilith.MemoryTypeLongTerm)
+	assert.NoError(t, err)
+
+	// Memories without a TTL get the store's default
+	require.NoError(t, state.Remember("scratch", "x", lilith.MemoryTypeVolatile, 0))
+	require.NoError(t, state.Remember("kept", "y", lilith.MemoryTypeVolatile, time.Hour))
+	time.Sleep(5 * time.Millisecond)
+	_, err = state.Recall("scratch", lilith.MemoryTypeVolatile)
+	assert.ErrorIs(t, err, lilith.ErrMemoryExpired)
+	_, err = state.Recall("kept", lilith.MemoryTypeVolatile)
+	assert.NoError(t, err)
+}
+
+func TestAgentPersistsConfiguredStores(t *testing.T) {
+	config := lilith.NewDefaultConfig()
+	config.MemoryPersistPath = t.TempDir()
+	config.ShortTermPolicy.Persistent = true
+	config.LongTermPolicy.Persistent = false
+
+	agent, err := lilith.NewAgent(config, logger.New())
+	require.NoError(t, err)
+	require.NoError(t, agent.State().Remember("goal", "ship it", lilith.MemoryTypeShortTerm, 0))
+
+	paths, err := agent.PersistMemory()
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(config.MemoryPersistPath, lilith.ShortTermMemoryFile)}, paths)
+
+	data, err := os.ReadFile(paths[0])
+	require.NoError(t, err)
+	var persisted map[string]lilith.MemoryItem
+	require.NoError(t, json.Unmarshal(data, &persisted))
+	assert.Equal(t, "ship it", persisted["goal"].Value)
+
+	_, err = os.Stat(filepath.Join(config.MemoryPersistPath, lilith.LongTermMemoryFile))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestAgentSweepsExpiredMemory(t *testing.T) {
+	config := lilith.NewDefaultConfig()
+	config.CleanupInterval = time.Second
+
+	agent, err := lilith.NewAgent(config, logger.New())
+	require.NoError(t, err)
+	require.NoError(t, agent.State().Remember("stale", "a", lilith.MemoryTypeShortTerm, time.Millisecond))
+	require.NoError(t, agent.Start())
+	t.Cleanup(func() { agent.Stop() })
+
+	assert.Eventually(t, func() bool {
+		return agent.State().ShortTerm.Len() == 0
+	}, 3*time.Second, 50*time.Millisecond)
+}
+
+func TestAgentConfiguredHandlers(t *testing.T) {
+	lilith.RegisterNamedHandler("test.count", func(ctx context.Context, s *lilith.State, task lilith.Task) error {
+		return s.Remember("counted", task.ID, lilith.MemoryTypeShortTerm, 0)
+	})
+	assert.Contains(t, lilith.NamedHandlers(), "test.count")
+
+	config := lilith.NewDefaultConfig()
+	config.SetCustomParameter(lilith.TaskHandlersParameter, map[string]string{
+		"count":         "test.count",
+		"note":          "memory",
+		"system.health": "",
+	})
+
+	agent, err := lilith.NewAgent(config, logger.New())
+	require.NoError(t, err)
+	processor, state := agent.Processor(), agent.State()
+	ctx := context.Background()
+
+	require.NoError(t, processor.AddTask(lilith.Task{ID: "c1", Type: "count"}))
+	require.NoError(t, processor.Process(ctx, state))
+	counted, err := state.Recall("counted", lilith.MemoryTypeShortTerm)
+	require.NoError(t, err)
+	assert.Equal(t, "c1", counted)
+
+	require.NoError(t, processor.AddTask(lilith.Task{
+		ID:   "n1",
+		Type: "note",
+		Data: map[string]interface{}{"key": "greeting", "value": "hello"},
+	}))
+	require.NoError(t, processor.Process(ctx, state))
+	note, err := state.Recall("greeting", lilith.MemoryTypeShortTerm)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", note)
+
+	// Disabled by config
+	require.NoError(t, processor.AddTask(lilith.Task{ID: "h1", Type: "system.health"}))
+	assert.ErrorIs(t, processor.Process(ctx, state), lilith.ErrUnknownTaskType)
+
+	// Still registered by default
+	require.NoError(t, processor.AddTask(lilith.Task{ID: "r1", Type: "system.reset"}))
+	assert.NoError(t, processor.Process(ctx, state))
+}
+
+func TestAgentUnknownConfiguredHandler(t *testing.T) {
+	config := lilith.NewDefaultConfig()
+	config.SetCustomParameter(lilith.TaskHandlersParameter, map[string]string{"count": "does.not.exist"})
+
+	_, err := lilith.NewAgent(config, logger.New())
+	assert.ErrorIs(t, err, lilith.ErrUnknownHandler)
+}
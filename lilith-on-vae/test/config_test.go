@@ -0,0 +1,281 @@
+package test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	lilith "github.com/labs-alone/alone-main/lilith-on-vae"
+)
+
+func TestConfigDurationParsing(t *testing.T) {
+	testCases := []struct {
+		name     string
+		json     string
+		expected time.Duration
+	}{
+		{
+			name:     "Duration String",
+			json:     `{"process_interval": "250ms"}`,
+			expected: 250 * time.Millisecond,
+		},
+		{
+			name:     "Numeric Nanoseconds",
+			json:     `{"process_interval": 250000000}`,
+			expected: 250 * time.Millisecond,
+		},
+		{
+			name:     "Missing Keeps Default",
+			json:     `{"name": "agent"}`,
+			expected: lilith.DefaultProcessInterval,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := lilith.NewDefaultConfig()
+			require.NoError(t, json.Unmarshal([]byte(tc.json), config))
+			assert.Equal(t, tc.expected, config.ProcessInterval)
+			assert.NoError(t, config.Validate())
+		})
+	}
+}
+
+func TestConfigDurationErrors(t *testing.T) {
+	config := lilith.NewDefaultConfig()
+	err := json.Unmarshal([]byte(`{"task_timeout": "soon"}`), config)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "task_timeout")
+
+	config = lilith.NewDefaultConfig()
+	err = json.Unmarshal([]byte(`{"task_timeout": 1.5}`), config)
+	assert.Error(t, err)
+
+	// A bare number meant as milliseconds parses as nanoseconds and is
+	// caught by validation
+	config = lilith.NewDefaultConfig()
+	require.NoError(t, json.Unmarshal([]byte(`{"process_interval": 100}`), config))
+	err = config.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "100ns")
+
+	config = lilith.NewDefaultConfig()
+	require.NoError(t, json.Unmarshal([]byte(`{"retry_delay": "-1s"}`), config))
+	assert.Error(t, config.Validate())
+
+	config = lilith.NewDefaultConfig()
+	require.NoError(t, json.Unmarshal([]byte(`{"enable_metrics": true, "metrics_interval": 0}`), config))
+	assert.ErrorContains(t, config.Validate(), "metrics interval must be positive")
+}
+
+func TestConfigShortIntervals(t *testing.T) {
+	// Sub-second cleanup and metrics intervals are the caller's choice
+	config := lilith.NewDefaultConfig()
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"cleanup_interval": "200ms",
+		"enable_metrics": true,
+		"metrics_interval": "500ms"
+	}`), config))
+	assert.NoError(t, config.Validate())
+	assert.Equal(t, 200*time.Millisecond, config.CleanupInterval)
+	assert.Equal(t, 500*time.Millisecond, config.MetricsInterval)
+}
+
+func TestConfigSaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+
+	config := lilith.NewDefaultConfig()
+	config.ProcessInterval = 500 * time.Millisecond
+	config.MemoryTTL = 2 * time.Hour
+	require.NoError(t, config.SaveConfig(path))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"process_interval": "500ms"`)
+
+	loaded, err := lilith.LoadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, 500*time.Millisecond, loaded.ProcessInterval)
+	assert.Equal(t, 2*time.Hour, loaded.MemoryTTL)
+}
+
+func TestConfigMemoryValidation(t *testing.T) {
+	testCases := []struct {
+		name   string
+		modify func(*lilith.Config)
+		detail string
+	}{
+		{
+			name:   "Zero Short-Term",
+			modify: func(c *lilith.Config) { c.MaxShortTermMemory = 0 },
+			detail: "short-term memory must be at least 1",
+		},
+		{
+			name:   "Negative Long-Term",
+			modify: func(c *lilith.Config) { c.MaxLongTermMemory = -5 },
+			detail: "long-term memory must be at least 1, got -5",
+		},
+		{
+			name: "Inverted Sizes",
+			modify: func(c *lilith.Config) {
+				c.MaxShortTermMemory = 500
+				c.MaxLongTermMemory = 100
+			},
+			detail: "(500) exceeds max long-term memory (100)",
+		},
+		{
+			name:   "Zero TTL",
+			modify: func(c *lilith.Config) { c.MemoryTTL = 0 },
+			detail: "memory TTL must be at least 1 second",
+		},
+		{
+			name:   "Cleanup Longer Than TTL",
+			modify: func(c *lilith.Config) { c.MemoryTTL = time.Minute },
+			detail: "cleanup interval (5m0s) exceeds memory TTL (1m0s)",
+		},
+		{
+			name:   "Zero Cleanup Interval",
+			modify: func(c *lilith.Config) { c.CleanupInterval = 0 },
+			detail: "cleanup interval must be positive, got 0s",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := lilith.NewDefaultConfig()
+			tc.modify(config)
+
+			err := config.Validate()
+			assert.ErrorIs(t, err, lilith.ErrInvalidMemoryConfig)
+			assert.ErrorContains(t, err, tc.detail)
+		})
+	}
+
+	// Equal sizes are allowed
+	config := lilith.NewDefaultConfig()
+	config.MaxShortTermMemory = config.MaxLongTermMemory
+	assert.NoError(t, config.Validate())
+}
+
+func TestConfigMemoryPolicies(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	data := `{
+		"name": "lilith",
+		"short_term_policy": {"max_size": 50, "eviction": "lru", "default_ttl": "10m"},
+		"volatile_policy": {"persistent": true, "default_ttl": 1000000000}
+	}`
+	require.NoError(t, os.WriteFile(path, []byte(data), 0644))
+
+	config, err := lilith.LoadConfig(path)
+	require.NoError(t, err)
+
+	short := config.StorePolicy(lilith.MemoryTypeShortTerm)
+	assert.Equal(t, lilith.MemoryPolicy{MaxSize: 50, DefaultTTL: 10 * time.Minute, Eviction: lilith.EvictionLRU}, short)
+
+	// Unset fields keep their defaults
+	long := config.StorePolicy(lilith.MemoryTypeLongTerm)
+	assert.Equal(t, lilith.DefaultMaxLongTermMemory, long.MaxSize)
+	assert.True(t, long.Persistent)
+	assert.Equal(t, lilith.EvictionLeastUsed, long.Eviction)
+
+	volatile := config.StorePolicy(lilith.MemoryTypeVolatile)
+	assert.Equal(t, lilith.DefaultMaxVolatileMemory, volatile.MaxSize)
+	assert.True(t, volatile.Persistent)
+	assert.Equal(t, time.Second, volatile.DefaultTTL)
+
+	// Policies round-trip with durations as strings
+	require.NoError(t, config.SaveConfig(path))
+	saved, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(saved), `"default_ttl": "10m0s"`)
+
+	loaded, err := lilith.LoadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, config.ShortTermPolicy, loaded.ShortTermPolicy)
+	assert.Equal(t, config.VolatilePolicy, loaded.VolatilePolicy)
+}
+
+func TestConfigMemoryPolicyValidation(t *testing.T) {
+	testCases := []struct {
+		name   string
+		modify func(*lilith.Config)
+		detail string
+	}{
+		{
+			name:   "Negative Size",
+			modify: func(c *lilith.Config) { c.VolatilePolicy.MaxSize = -1 },
+			detail: "volatile memory max size must be at least 1, got -1",
+		},
+		{
+			name:   "Negative Default TTL",
+			modify: func(c *lilith.Config) { c.ShortTermPolicy.DefaultTTL = -time.Second },
+			detail: "short-term memory default TTL cannot be negative",
+		},
+		{
+			name:   "Unknown Eviction",
+			modify: func(c *lilith.Config) { c.LongTermPolicy.Eviction = "random" },
+			detail: `unknown long-term memory eviction strategy "random"`,
+		},
+		{
+			name: "Combined Sizes Too Large",
+			modify: func(c *lilith.Config) {
+				c.MaxTotalMemory = 1000
+				c.ShortTermPolicy.MaxSize = 400
+				c.LongTermPolicy.MaxSize = 500
+				c.VolatilePolicy.MaxSize = 200
+			},
+			detail: "memory stores hold 1100 items combined, exceeding max total memory (1000)",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := lilith.NewDefaultConfig()
+			tc.modify(config)
+
+			err := config.Validate()
+			assert.ErrorIs(t, err, lilith.ErrInvalidMemoryConfig)
+			assert.ErrorContains(t, err, tc.detail)
+		})
+	}
+
+	// Without a cap the stores can be any size
+	config := lilith.NewDefaultConfig()
+	config.MaxTotalMemory = 0
+	config.LongTermPolicy.MaxSize = 10 * lilith.DefaultMaxTotalMemory
+	assert.NoError(t, config.Validate())
+}
+
+func TestConfigTaskHandlers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	write := func(handlers string) {
+		data := `{"name": "lilith", "custom_parameters": {"task_handlers": ` + handlers + `}}`
+		require.NoError(t, os.WriteFile(path, []byte(data), 0644))
+	}
+
+	write(`{"ping": "noop", "note": "memory", "system.reset": null}`)
+	config, err := lilith.LoadConfig(path)
+	require.NoError(t, err)
+
+	mapping, err := config.TaskHandlers()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"ping": "noop", "note": "memory", "system.reset": ""}, mapping)
+
+	write(`{"ping": "missing"}`)
+	_, err = lilith.LoadConfig(path)
+	assert.ErrorIs(t, err, lilith.ErrUnknownHandler)
+	assert.ErrorContains(t, err, `"missing" for task type "ping"`)
+
+	write(`{"ping": 5}`)
+	_, err = lilith.LoadConfig(path)
+	assert.ErrorIs(t, err, lilith.ErrInvalidConfig)
+
+	write(`["noop"]`)
+	_, err = lilith.LoadConfig(path)
+	assert.ErrorIs(t, err, lilith.ErrInvalidConfig)
+}
@@ -0,0 +1,632 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	lilith "github.com/labs-alone/alone-main/lilith-on-vae"
+	"github.com/labs-alone/alone-main/pkg/logger"
+)
+
+func setupProcessor(t *testing.T) (*lilith.Processor, *lilith.State) {
+	config := lilith.NewDefaultConfig()
+	log := logger.New()
+
+	return lilith.NewProcessor(config, log), lilith.NewState(config, log)
+}
+
+func TestProcessorQueueWaitTime(t *testing.T) {
+	processor, state := setupProcessor(t)
+	processor.RegisterHandler("test", func(ctx context.Context, s *lilith.State, task lilith.Task) error {
+		return nil
+	})
+
+	now := time.Now()
+	require.NoError(t, processor.AddTask(lilith.Task{
+		ID:        "high",
+		Type:      "test",
+		Priority:  5,
+		CreatedAt: now.Add(-200 * time.Millisecond),
+	}))
+	require.NoError(t, processor.AddTask(lilith.Task{
+		ID:        "low",
+		Type:      "test",
+		Priority:  1,
+		CreatedAt: now.Add(-50 * time.Millisecond),
+	}))
+
+	ctx := context.Background()
+	require.NoError(t, processor.Process(ctx, state))
+	require.NoError(t, processor.Process(ctx, state))
+
+	status := processor.GetQueueStatus()
+	assert.Equal(t, 0, status.TotalTasks)
+
+	assert.Equal(t, uint64(2), status.WaitTime.Count)
+	assert.GreaterOrEqual(t, status.WaitTime.Min, 50*time.Millisecond)
+	assert.GreaterOrEqual(t, status.WaitTime.Max, 200*time.Millisecond)
+	assert.Equal(t, status.WaitTime.Total/2, status.WaitTime.Average)
+
+	require.Contains(t, status.WaitTimeByPriority, 5)
+	require.Contains(t, status.WaitTimeByPriority, 1)
+	assert.Equal(t, uint64(1), status.WaitTimeByPriority[5].Count)
+	assert.GreaterOrEqual(t, status.WaitTimeByPriority[5].Max, 200*time.Millisecond)
+	assert.Equal(t, uint64(1), status.WaitTimeByPriority[1].Count)
+	assert.Less(t, status.WaitTimeByPriority[1].Max, status.WaitTimeByPriority[5].Max)
+}
+
+func TestProcessorDependencyChain(t *testing.T) {
+	processor, state := setupProcessor(t)
+
+	var order []string
+	processor.RegisterHandler("test", func(ctx context.Context, s *lilith.State, task lilith.Task) error {
+		order = append(order, task.ID)
+		return nil
+	})
+
+	// Enqueued in reverse, with the last step at the highest priority
+	require.NoError(t, processor.AddTask(lilith.Task{ID: "deploy", Type: "test", Priority: 3, DependsOn: []string{"test"}}))
+	require.NoError(t, processor.AddTask(lilith.Task{ID: "test", Type: "test", Priority: 2, DependsOn: []string{"build"}}))
+	require.NoError(t, processor.AddTask(lilith.Task{ID: "build", Type: "test", Priority: 1}))
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		require.NoError(t, processor.Process(ctx, state))
+	}
+
+	assert.Equal(t, []string{"build", "test", "deploy"}, order)
+	assert.Equal(t, 0, processor.GetQueueLength())
+}
+
+func TestProcessorDependencyWaits(t *testing.T) {
+	processor, state := setupProcessor(t)
+
+	var ran []string
+	processor.RegisterHandler("test", func(ctx context.Context, s *lilith.State, task lilith.Task) error {
+		ran = append(ran, task.ID)
+		return nil
+	})
+
+	require.NoError(t, processor.AddTask(lilith.Task{ID: "child", Type: "test", DependsOn: []string{"parent"}}))
+
+	// The dependency hasn't been enqueued yet
+	require.NoError(t, processor.Process(context.Background(), state))
+	assert.Empty(t, ran)
+	assert.Equal(t, 1, processor.GetQueueLength())
+
+	require.NoError(t, processor.AddTask(lilith.Task{ID: "parent", Type: "test"}))
+	require.NoError(t, processor.Process(context.Background(), state))
+	require.NoError(t, processor.Process(context.Background(), state))
+	assert.Equal(t, []string{"parent", "child"}, ran)
+}
+
+func TestProcessorDependencyFailure(t *testing.T) {
+	processor, state := setupProcessor(t)
+
+	var ran []string
+	processor.RegisterHandler("test", func(ctx context.Context, s *lilith.State, task lilith.Task) error {
+		ran = append(ran, task.ID)
+		if task.ID == "parent" {
+			return fmt.Errorf("parent failed")
+		}
+		return nil
+	})
+
+	require.NoError(t, processor.AddTask(lilith.Task{ID: "parent", Type: "test", Priority: 1}))
+	require.NoError(t, processor.AddTask(lilith.Task{ID: "child", Type: "test", DependsOn: []string{"parent"}}))
+	require.NoError(t, processor.AddTask(lilith.Task{ID: "grandchild", Type: "test", DependsOn: []string{"child"}}))
+
+	ctx := context.Background()
+	assert.Error(t, processor.Process(ctx, state))
+	assert.ErrorIs(t, processor.Process(ctx, state), lilith.ErrDependencyFailed)
+	assert.ErrorIs(t, processor.Process(ctx, state), lilith.ErrDependencyFailed)
+
+	assert.Equal(t, []string{"parent"}, ran)
+	assert.Equal(t, 0, processor.GetQueueLength())
+}
+
+func TestProcessorDependencyUnknown(t *testing.T) {
+	config := lilith.NewDefaultConfig()
+	config.DependencyWait = 50 * time.Millisecond
+	log := logger.New()
+	processor, state := lilith.NewProcessor(config, log), lilith.NewState(config, log)
+
+	var ran []string
+	processor.RegisterHandler("test", func(ctx context.Context, s *lilith.State, task lilith.Task) error {
+		ran = append(ran, task.ID)
+		return nil
+	})
+
+	require.NoError(t, processor.AddTask(lilith.Task{ID: "child", Type: "test", DependsOn: []string{"missing"}}))
+
+	// The dependency may still be enqueued, until the wait is over
+	ctx := context.Background()
+	require.NoError(t, processor.Process(ctx, state))
+	assert.Equal(t, 1, processor.GetQueueLength())
+
+	time.Sleep(60 * time.Millisecond)
+	assert.ErrorIs(t, processor.Process(ctx, state), lilith.ErrDependencyUnknown)
+	assert.Empty(t, ran)
+	assert.Equal(t, 0, processor.GetQueueLength())
+}
+
+func TestProcessorOutcomesExpire(t *testing.T) {
+	config := lilith.NewDefaultConfig()
+	config.OutcomeTTL = 20 * time.Millisecond
+	config.DependencyWait = time.Millisecond
+	log := logger.New()
+	processor, state := lilith.NewProcessor(config, log), lilith.NewState(config, log)
+
+	var ran []string
+	processor.RegisterHandler("test", func(ctx context.Context, s *lilith.State, task lilith.Task) error {
+		ran = append(ran, task.ID)
+		return nil
+	})
+
+	ctx := context.Background()
+	require.NoError(t, processor.AddTask(lilith.Task{ID: "parent", Type: "test"}))
+	require.NoError(t, processor.Process(ctx, state))
+
+	// A dependency finished within the TTL is still known
+	require.NoError(t, processor.AddTask(lilith.Task{ID: "child", Type: "test", DependsOn: []string{"parent"}}))
+	require.NoError(t, processor.Process(ctx, state))
+
+	// Once it expires, it is as unknown as a task never enqueued
+	time.Sleep(30 * time.Millisecond)
+	require.NoError(t, processor.AddTask(lilith.Task{ID: "late", Type: "test", DependsOn: []string{"parent"}}))
+	time.Sleep(2 * time.Millisecond)
+	assert.ErrorIs(t, processor.Process(ctx, state), lilith.ErrDependencyUnknown)
+	assert.Equal(t, []string{"parent", "child"}, ran)
+}
+
+func TestProcessorDependencyCycle(t *testing.T) {
+	processor, _ := setupProcessor(t)
+
+	require.NoError(t, processor.AddTask(lilith.Task{ID: "a", Type: "test", DependsOn: []string{"c"}}))
+	require.NoError(t, processor.AddTask(lilith.Task{ID: "b", Type: "test", DependsOn: []string{"a"}}))
+
+	err := processor.AddTask(lilith.Task{ID: "c", Type: "test", DependsOn: []string{"b"}})
+	assert.ErrorIs(t, err, lilith.ErrDependencyCycle)
+
+	err = processor.AddTask(lilith.Task{ID: "self", Type: "test", DependsOn: []string{"self"}})
+	assert.ErrorIs(t, err, lilith.ErrDependencyCycle)
+
+	assert.Equal(t, 2, processor.GetQueueLength())
+}
+
+func TestProcessorStreamingHandler(t *testing.T) {
+	processor, state := setupProcessor(t)
+
+	processor.RegisterStreamingHandler("generate", func(ctx context.Context, s *lilith.State, task lilith.Task, updates chan<- lilith.TaskUpdate) error {
+		for i := 1; i <= 5; i++ {
+			select {
+			case updates <- lilith.TaskUpdate{
+				Progress: float64(i) / 5,
+				Message:  fmt.Sprintf("chunk %d", i),
+			}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+
+	updates, unsubscribe := processor.Subscribe(10)
+	defer unsubscribe()
+
+	require.NoError(t, processor.AddTask(lilith.Task{ID: "gen-1", Type: "generate"}))
+	require.NoError(t, processor.Process(context.Background(), state))
+
+	for i := 1; i <= 5; i++ {
+		select {
+		case update := <-updates:
+			assert.Equal(t, "gen-1", update.TaskID)
+			assert.Equal(t, i, update.Sequence)
+			assert.Equal(t, fmt.Sprintf("chunk %d", i), update.Message)
+			assert.InDelta(t, float64(i)/5, update.Progress, 1e-9)
+			assert.False(t, update.Time.IsZero())
+		case <-time.After(time.Second):
+			t.Fatalf("update %d not delivered", i)
+		}
+	}
+
+	select {
+	case update := <-updates:
+		t.Fatalf("unexpected update %+v", update)
+	default:
+	}
+}
+
+func TestProcessorStreamingUnsubscribed(t *testing.T) {
+	processor, state := setupProcessor(t)
+
+	processor.RegisterStreamingHandler("generate", func(ctx context.Context, s *lilith.State, task lilith.Task, updates chan<- lilith.TaskUpdate) error {
+		for i := 0; i < 3; i++ {
+			updates <- lilith.TaskUpdate{Message: "tick"}
+		}
+		return nil
+	})
+
+	// An unbuffered subscriber that never reads doesn't block the task once
+	// it has unsubscribed
+	_, unsubscribe := processor.Subscribe(0)
+	unsubscribe()
+	unsubscribe()
+
+	require.NoError(t, processor.AddTask(lilith.Task{ID: "gen-1", Type: "generate"}))
+
+	done := make(chan error, 1)
+	go func() { done <- processor.Process(context.Background(), state) }()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("task blocked on an unsubscribed channel")
+	}
+}
+
+func TestProcessorReplayDeadLetters(t *testing.T) {
+	processor, state := setupProcessor(t)
+
+	fixed := false
+	var ran []string
+	processor.RegisterHandler("flaky", func(ctx context.Context, s *lilith.State, task lilith.Task) error {
+		ran = append(ran, task.ID)
+		if !fixed {
+			return fmt.Errorf("flaky failed")
+		}
+		return nil
+	})
+	processor.RegisterHandler("broken", func(ctx context.Context, s *lilith.State, task lilith.Task) error {
+		ran = append(ran, task.ID)
+		return fmt.Errorf("still broken")
+	})
+
+	require.NoError(t, processor.AddTask(lilith.Task{ID: "flaky-1", Type: "flaky", Priority: 2}))
+	require.NoError(t, processor.AddTask(lilith.Task{ID: "flaky-2", Type: "flaky", Priority: 1}))
+	require.NoError(t, processor.AddTask(lilith.Task{ID: "broken-1", Type: "broken"}))
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		assert.Error(t, processor.Process(ctx, state))
+	}
+
+	dead := processor.DeadLetters()
+	require.Len(t, dead, 3)
+	assert.Equal(t, "flaky-1", dead[0].Task.ID)
+	assert.Equal(t, 1, dead[0].Task.Attempts)
+	assert.Equal(t, "flaky failed", dead[0].Error)
+	assert.Equal(t, 3, processor.GetQueueStatus().DeadLetters)
+
+	// Nothing matches, nothing moves
+	assert.Empty(t, processor.ReplayDeadLetters(lilith.DeadLetterFilter{IDs: []string{"missing"}}))
+	assert.Equal(t, 0, processor.GetQueueLength())
+
+	fixed = true
+	replayed := processor.ReplayDeadLetters(lilith.DeadLetterFilter{Types: []string{"flaky"}})
+	require.Len(t, replayed, 2)
+	for _, task := range replayed {
+		assert.Equal(t, 0, task.Attempts)
+		assert.Nil(t, task.StartedAt)
+	}
+	assert.Equal(t, 2, processor.GetQueueLength())
+
+	// The broken task stays dead-lettered
+	dead = processor.DeadLetters()
+	require.Len(t, dead, 1)
+	assert.Equal(t, "broken-1", dead[0].Task.ID)
+
+	ran = nil
+	require.NoError(t, processor.Process(ctx, state))
+	require.NoError(t, processor.Process(ctx, state))
+	assert.Equal(t, []string{"flaky-1", "flaky-2"}, ran)
+	assert.Len(t, processor.DeadLetters(), 1)
+
+	// Replaying by ID
+	replayed = processor.ReplayDeadLetters(lilith.DeadLetterFilter{IDs: []string{"broken-1"}})
+	require.Len(t, replayed, 1)
+	assert.Empty(t, processor.DeadLetters())
+	assert.Equal(t, 1, processor.GetQueueLength())
+}
+
+func TestProcessorDeadLettersRetryableOnly(t *testing.T) {
+	processor, state := setupProcessor(t)
+	processor.RegisterHandler("test", func(ctx context.Context, s *lilith.State, task lilith.Task) error {
+		switch task.ID {
+		case "invalid":
+			return lilith.Permanent(fmt.Errorf("invalid input"))
+		case "missing-value":
+			_, err := lilith.TaskValue[string](task, "name")
+			return err
+		}
+		return fmt.Errorf("temporarily unavailable")
+	})
+
+	require.NoError(t, processor.AddTask(lilith.Task{ID: "invalid", Type: "test", Priority: 3}))
+	require.NoError(t, processor.AddTask(lilith.Task{ID: "missing-value", Type: "test", Priority: 2}))
+	require.NoError(t, processor.AddTask(lilith.Task{ID: "unhandled", Type: "unknown", Priority: 1}))
+	require.NoError(t, processor.AddTask(lilith.Task{ID: "flaky", Type: "test"}))
+
+	ctx := context.Background()
+	var permanent *lilith.PermanentError
+	assert.ErrorAs(t, processor.Process(ctx, state), &permanent)
+	assert.ErrorIs(t, processor.Process(ctx, state), lilith.ErrTaskValueMissing)
+	assert.ErrorIs(t, processor.Process(ctx, state), lilith.ErrUnknownTaskType)
+	assert.Error(t, processor.Process(ctx, state))
+
+	dead := processor.DeadLetters()
+	require.Len(t, dead, 1, "only the failure a replay could fix is held")
+	assert.Equal(t, "flaky", dead[0].Task.ID)
+}
+
+func TestProcessorDeadLettersCapped(t *testing.T) {
+	config := lilith.NewDefaultConfig()
+	config.MaxDeadLetters = 2
+	log := logger.New()
+	processor, state := lilith.NewProcessor(config, log), lilith.NewState(config, log)
+	processor.RegisterHandler("test", func(ctx context.Context, s *lilith.State, task lilith.Task) error {
+		return fmt.Errorf("failed")
+	})
+
+	ctx := context.Background()
+	for i := 1; i <= 4; i++ {
+		require.NoError(t, processor.AddTask(lilith.Task{ID: fmt.Sprintf("task-%d", i), Type: "test"}))
+		assert.Error(t, processor.Process(ctx, state))
+	}
+
+	// The oldest are dropped
+	dead := processor.DeadLetters()
+	require.Len(t, dead, 2)
+	assert.Equal(t, "task-3", dead[0].Task.ID)
+	assert.Equal(t, "task-4", dead[1].Task.ID)
+	assert.Equal(t, uint64(2), processor.GetQueueStatus().DroppedDeadLetters)
+}
+
+func TestProcessorTaskContext(t *testing.T) {
+	processor, state := setupProcessor(t)
+
+	var (
+		id          string
+		attempt     int
+		deadline    time.Time
+		hasDeadline bool
+	)
+	processor.RegisterHandler("test", func(ctx context.Context, s *lilith.State, task lilith.Task) error {
+		id = lilith.TaskID(ctx)
+		attempt = lilith.TaskAttempt(ctx)
+		deadline, hasDeadline = lilith.TaskDeadline(ctx)
+		return nil
+	})
+
+	due := time.Now().Add(time.Hour)
+	require.NoError(t, processor.AddTask(lilith.Task{ID: "due", Type: "test", Deadline: &due}))
+	require.NoError(t, processor.Process(context.Background(), state))
+	assert.Equal(t, "due", id)
+	assert.Equal(t, 1, attempt)
+	assert.True(t, hasDeadline)
+	assert.True(t, due.Equal(deadline))
+
+	require.NoError(t, processor.AddTask(lilith.Task{ID: "open", Type: "test"}))
+	require.NoError(t, processor.Process(context.Background(), state))
+	assert.Equal(t, "open", id)
+	assert.False(t, hasDeadline)
+
+	// Outside a handler there is no task
+	ctx := context.Background()
+	assert.Empty(t, lilith.TaskID(ctx))
+	assert.Zero(t, lilith.TaskAttempt(ctx))
+	_, ok := lilith.TaskDeadline(ctx)
+	assert.False(t, ok)
+}
+
+func TestTaskValue(t *testing.T) {
+	task := lilith.Task{
+		ID: "values",
+		Data: map[string]interface{}{
+			"name":   "alice",
+			"count":  float64(3),
+			"ratio":  0.5,
+			"minus":  float64(-2),
+			"tags":   []string{"a", "b"},
+			"absent": nil,
+		},
+	}
+
+	name, err := lilith.TaskValue[string](task, "name")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", name)
+
+	tags, err := lilith.TaskValue[[]string](task, "tags")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, tags)
+
+	// JSON numbers convert to integer types when they're whole
+	count, err := lilith.TaskValue[int](task, "count")
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	_, err = lilith.TaskValue[int](task, "ratio")
+	assert.ErrorIs(t, err, lilith.ErrTaskValueType)
+
+	_, err = lilith.TaskValue[uint](task, "minus")
+	assert.ErrorIs(t, err, lilith.ErrTaskValueType)
+
+	_, err = lilith.TaskValue[string](task, "count")
+	assert.ErrorIs(t, err, lilith.ErrTaskValueType)
+
+	for _, key := range []string{"missing", "absent"} {
+		value, err := lilith.TaskValue[string](task, key)
+		assert.ErrorIs(t, err, lilith.ErrTaskValueMissing, key)
+		assert.Empty(t, value)
+	}
+}
+
+func TestProcessorRecentResults(t *testing.T) {
+	processor, state := setupProcessor(t)
+	processor.RegisterHandler("test", func(ctx context.Context, s *lilith.State, task lilith.Task) error {
+		return nil
+	})
+
+	backlog, results, unsubscribe := processor.SubscribeResults(10, lilith.ResultHistorySize+10)
+	assert.Empty(t, backlog)
+
+	ctx := context.Background()
+	total := lilith.ResultHistorySize + 5
+	for i := 0; i < total; i++ {
+		require.NoError(t, processor.AddTask(lilith.Task{ID: fmt.Sprintf("task-%d", i), Type: "test"}))
+		require.NoError(t, processor.Process(ctx, state))
+	}
+
+	// The oldest results fall out of the history
+	all := processor.RecentResults(-1)
+	require.Len(t, all, lilith.ResultHistorySize)
+	assert.Equal(t, "task-5", all[0].TaskID)
+	assert.Equal(t, fmt.Sprintf("task-%d", total-1), all[len(all)-1].TaskID)
+
+	latest := processor.RecentResults(2)
+	require.Len(t, latest, 2)
+	assert.Equal(t, fmt.Sprintf("task-%d", total-2), latest[0].TaskID)
+
+	unsubscribe()
+	received := 0
+	for range results {
+		received++
+	}
+	assert.Equal(t, total, received)
+}
+
+// TestProcessorConcurrentAddAndProcess is meant to run with -race: tasks are
+// added while half of them are processed, then the rest are drained in order
+// to check the queue stayed sorted and no task was lost or run twice
+func TestProcessorConcurrentAddAndProcess(t *testing.T) {
+	const (
+		adders      = 4
+		processors  = 4
+		tasksPerAdd = 200
+	)
+
+	processor, state := setupProcessor(t)
+	var mu sync.Mutex
+	runs := make(map[string]int)
+	var order []lilith.Task
+	var processed, draining int32
+	processor.RegisterHandler("work", func(ctx context.Context, s *lilith.State, task lilith.Task) error {
+		mu.Lock()
+		defer mu.Unlock()
+		runs[task.ID]++
+		if atomic.LoadInt32(&draining) == 1 {
+			order = append(order, task)
+		}
+		atomic.AddInt32(&processed, 1)
+		return nil
+	})
+
+	ctx := context.Background()
+	var processing sync.WaitGroup
+	for i := 0; i < processors; i++ {
+		processing.Add(1)
+		go func() {
+			defer processing.Done()
+			for atomic.LoadInt32(&processed) < adders*tasksPerAdd/2 {
+				assert.NoError(t, processor.Process(ctx, state))
+			}
+		}()
+	}
+
+	var adding sync.WaitGroup
+	for a := 0; a < adders; a++ {
+		adding.Add(1)
+		go func(a int) {
+			defer adding.Done()
+			for i := 0; i < tasksPerAdd; i++ {
+				assert.NoError(t, processor.AddTask(lilith.Task{
+					ID:       fmt.Sprintf("task-%d-%d", a, i),
+					Type:     "work",
+					Priority: (a*tasksPerAdd + i) % 7,
+				}))
+			}
+		}(a)
+	}
+	adding.Wait()
+	processing.Wait()
+
+	atomic.StoreInt32(&draining, 1)
+	for processor.GetQueueLength() > 0 {
+		require.NoError(t, processor.Process(ctx, state))
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Len(t, runs, adders*tasksPerAdd)
+	assert.NotEmpty(t, order)
+	for id, n := range runs {
+		assert.Equal(t, 1, n, "task %s ran %d times", id, n)
+	}
+	for i := 1; i < len(order); i++ {
+		prev, next := order[i-1], order[i]
+		assert.True(t, prev.Priority > next.Priority ||
+			prev.Priority == next.Priority && !next.CreatedAt.Before(prev.CreatedAt),
+			"%s ran before %s", prev.ID, next.ID)
+	}
+}
+
+// BenchmarkProcessorQueueChurn pushes a burst of tasks through the queue and
+// then keeps tasks flowing through it, reporting the live heap at the end.
+// The heap should stay flat as b.N grows, since finished tasks and the
+// burst's backing array are released.
+func BenchmarkProcessorQueueChurn(b *testing.B) {
+	config := lilith.NewDefaultConfig()
+	processor := lilith.NewProcessor(config, logger.New())
+	state := lilith.NewState(config, logger.New())
+	processor.RegisterHandler("churn", func(ctx context.Context, s *lilith.State, task lilith.Task) error {
+		return nil
+	})
+
+	ctx := context.Background()
+	// Task IDs cycle so the outcomes kept for dependency resolution don't
+	// grow with b.N
+	newTask := func(i int) lilith.Task {
+		return lilith.Task{
+			ID:   fmt.Sprintf("churn-%d", i%1000),
+			Type: "churn",
+			Data: map[string]interface{}{"payload": make([]byte, 1024)},
+		}
+	}
+
+	const burst = 10000
+	for i := 0; i < burst; i++ {
+		if err := processor.AddTask(newTask(i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	for processor.GetQueueLength() > 0 {
+		if err := processor.Process(ctx, state); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := processor.AddTask(newTask(i)); err != nil {
+			b.Fatal(err)
+		}
+		if err := processor.Process(ctx, state); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+
+	runtime.GC()
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	b.ReportMetric(float64(mem.HeapAlloc), "heap-bytes")
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+)
+
+// amountPattern matches a decimal number as written in JSON, also allowing
+// leading zeros since quoted amounts aren't held to the JSON grammar
+var amountPattern = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+// maxAmount is the largest Amount as a big.Float for range checks
+var maxAmount = new(big.Float).SetUint64(math.MaxUint64)
+
+// Amount is a token amount in base units. It decodes from a JSON integer,
+// a number in exponent form such as 1e9, or a string holding either, since
+// JavaScript clients can't represent every uint64 as a number. Negative and
+// fractional amounts are rejected.
+type Amount uint64
+
+// AmountError reports a request amount that couldn't be decoded
+type AmountError struct {
+	Value  string
+	Reason string
+}
+
+func (e *AmountError) Error() string {
+	return fmt.Sprintf("invalid amount %s: %s", e.Value, e.Reason)
+}
+
+func (e *AmountError) Unwrap() error {
+	return ErrInvalidAmount
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+
+	s := string(data)
+	if len(data) > 0 && data[0] == '"' {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return &AmountError{Value: string(data), Reason: "must be a number or numeric string"}
+		}
+	}
+
+	amount, reason := parseAmount(s)
+	if reason != "" {
+		return &AmountError{Value: string(data), Reason: reason}
+	}
+	*a = amount
+	return nil
+}
+
+// parseAmount parses a decimal amount, returning why it's invalid if it is
+func parseAmount(s string) (Amount, string) {
+	if !amountPattern.MatchString(s) {
+		return 0, "must be a number or numeric string"
+	}
+
+	// Plain integers are the common case and need no big.Float
+	if n, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return Amount(n), ""
+	}
+
+	f, _, err := big.ParseFloat(s, 10, 256, big.ToNearestEven)
+	if err != nil {
+		return 0, "must be a number or numeric string"
+	}
+	switch {
+	case f.Sign() < 0:
+		return 0, "must not be negative"
+	case f.Cmp(maxAmount) > 0:
+		return 0, "exceeds the maximum amount"
+	case f.Acc() != big.Exact || !f.IsInt():
+		return 0, "must be a whole number of base units"
+	}
+
+	n, _ := f.Uint64()
+	return Amount(n), ""
+}
@@ -0,0 +1,247 @@
+package apitest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	models "github.com/labs-alone/alone-main/internal/core"
+)
+
+// RPCHandler answers a JSON-RPC call. A non-nil *RPCError is sent as the
+// error member instead of a result.
+type RPCHandler func(params json.RawMessage) (interface{}, *RPCError)
+
+// RPCError is a JSON-RPC error response
+type RPCError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// MockRPC is a minimal Solana JSON-RPC node. It answers getHealth with "ok"
+// and getBalance from balances set with SetBalance; other methods need a
+// handler.
+type MockRPC struct {
+	server   *httptest.Server
+	handlers map[string]RPCHandler
+	balances map[string]uint64
+	calls    map[string]int
+	mu       sync.Mutex
+}
+
+// NewMockRPC starts a mock RPC node that is closed when the test ends
+func NewMockRPC(t testing.TB) *MockRPC {
+	m := &MockRPC{
+		handlers: make(map[string]RPCHandler),
+		balances: make(map[string]uint64),
+		calls:    make(map[string]int),
+	}
+	m.On("getHealth", "ok")
+	m.Handle("getBalance", m.getBalance)
+
+	m.server = httptest.NewServer(http.HandlerFunc(m.serveHTTP))
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+// URL returns the node's HTTP endpoint
+func (m *MockRPC) URL() string {
+	return m.server.URL
+}
+
+// On answers method with a fixed result
+func (m *MockRPC) On(method string, result interface{}) {
+	m.Handle(method, func(json.RawMessage) (interface{}, *RPCError) {
+		return result, nil
+	})
+}
+
+// Handle answers method with handler, replacing any earlier handler
+func (m *MockRPC) Handle(method string, handler RPCHandler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handlers[method] = handler
+}
+
+// SetBalance sets the lamports getBalance reports for address
+func (m *MockRPC) SetBalance(address string, lamports uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.balances[address] = lamports
+}
+
+// Calls returns how many times method was called
+func (m *MockRPC) Calls(method string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.calls[method]
+}
+
+func (m *MockRPC) getBalance(params json.RawMessage) (interface{}, *RPCError) {
+	var args []json.RawMessage
+	var address string
+	if json.Unmarshal(params, &args) != nil || len(args) == 0 || json.Unmarshal(args[0], &address) != nil {
+		return nil, &RPCError{Code: -32602, Message: "invalid params"}
+	}
+
+	m.mu.Lock()
+	lamports := m.balances[address]
+	m.mu.Unlock()
+
+	return map[string]interface{}{
+		"context": map[string]interface{}{"slot": 1},
+		"value":   lamports,
+	}, nil
+}
+
+func (m *MockRPC) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID     interface{}     `json:"id"`
+		Method string          `json:"method"`
+		Params json.RawMessage `json:"params"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m.mu.Lock()
+	m.calls[req.Method]++
+	handler, ok := m.handlers[req.Method]
+	m.mu.Unlock()
+
+	resp := map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      req.ID,
+	}
+	if !ok {
+		resp["error"] = RPCError{Code: -32601, Message: "method not found: " + req.Method}
+	} else if result, rpcErr := handler(req.Params); rpcErr != nil {
+		resp["error"] = rpcErr
+	} else {
+		resp["result"] = result
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// MockOpenAI is a minimal OpenAI chat completions API answering every
+// request with the same reply
+type MockOpenAI struct {
+	server   *httptest.Server
+	reply    string
+	requests int
+	mu       sync.Mutex
+}
+
+// NewMockOpenAI starts a mock OpenAI API that is closed when the test ends
+func NewMockOpenAI(t testing.TB) *MockOpenAI {
+	m := &MockOpenAI{reply: "ok"}
+	m.server = httptest.NewServer(http.HandlerFunc(m.serveHTTP))
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+// URL returns the API's base URL
+func (m *MockOpenAI) URL() string {
+	return m.server.URL
+}
+
+// SetReply sets the content of every completion
+func (m *MockOpenAI) SetReply(content string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reply = content
+}
+
+// Requests returns how many completions were requested
+func (m *MockOpenAI) Requests() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.requests
+}
+
+func (m *MockOpenAI) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Model string `json:"model"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":{"message":"invalid request"}}`, http.StatusBadRequest)
+		return
+	}
+
+	m.mu.Lock()
+	m.requests++
+	reply := m.reply
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":    "chatcmpl-apitest",
+		"model": req.Model,
+		"choices": []map[string]interface{}{
+			{"message": map[string]string{"role": "assistant", "content": reply}},
+		},
+		"usage": map[string]int{"total_tokens": 10},
+	})
+}
+
+// MemoryDB is an in-memory user store that also stands in for the database
+// connection checked by self-checks
+type MemoryDB struct {
+	users   []models.User
+	pingErr error
+	mu      sync.RWMutex
+}
+
+// NewMemoryDB creates an empty in-memory database
+func NewMemoryDB() *MemoryDB {
+	return &MemoryDB{}
+}
+
+// AddUser stores user, assigning the next ID if it has none
+func (db *MemoryDB) AddUser(user models.User) models.User {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if user.ID == 0 {
+		user.ID = uint(len(db.users) + 1)
+	}
+	db.users = append(db.users, user)
+	return user
+}
+
+// ListUsers implements api.UserStore
+func (db *MemoryDB) ListUsers(ctx context.Context, offset, limit int) ([]models.User, int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	total := len(db.users)
+	if offset >= total {
+		return nil, total, nil
+	}
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+	return append([]models.User(nil), db.users[offset:end]...), total, nil
+}
+
+// SetPingError makes PingContext fail with err, or succeed if err is nil
+func (db *MemoryDB) SetPingError(err error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.pingErr = err
+}
+
+// PingContext implements api.DatabasePinger
+func (db *MemoryDB) PingContext(ctx context.Context) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.pingErr
+}
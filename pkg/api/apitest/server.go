@@ -0,0 +1,130 @@
+// Package apitest runs the real API router against in-process mocks of the
+// Solana RPC node, the OpenAI API and the database, so tests can exercise
+// routes end to end over HTTP.
+package apitest
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labs-alone/alone-main/internal/middleware"
+	"github.com/labs-alone/alone-main/internal/openai"
+	"github.com/labs-alone/alone-main/internal/solana"
+	"github.com/labs-alone/alone-main/internal/utils"
+	"github.com/labs-alone/alone-main/pkg/api"
+	"github.com/labs-alone/alone-main/pkg/logger"
+)
+
+// Options overrides parts of a test server. Unset fields get mocks.
+type Options struct {
+	// Config is passed to the router, defaulting to a valid config pointing
+	// at the mocks
+	Config *utils.Config
+	// Solana replaces the client backed by the mock RPC node
+	Solana *solana.Client
+	// OpenAI replaces the client backed by the mock OpenAI API
+	OpenAI *openai.Client
+	// Users replaces the in-memory user store
+	Users api.UserStore
+	// Database replaces the in-memory database pinged by self-checks
+	Database api.DatabasePinger
+}
+
+// Server is a running API server wired to mocks. It is closed when the test
+// ends.
+type Server struct {
+	*httptest.Server
+
+	Handler *api.Handler
+	Router  *api.Router
+	// Auth verifies bearer tokens for the routes behind a role; Token
+	// issues them
+	Auth *middleware.AuthMiddleware
+
+	// The mocks in use; each is nil if Options replaced it
+	RPC    *MockRPC
+	OpenAI *MockOpenAI
+	DB     *MemoryDB
+
+	t testing.TB
+}
+
+// NewTestServer starts the API router with its dependencies mocked except
+// where opts overrides them
+func NewTestServer(t testing.TB, opts Options) *Server {
+	t.Helper()
+	s := &Server{t: t}
+
+	solanaClient := opts.Solana
+	if solanaClient == nil {
+		s.RPC = NewMockRPC(t)
+		client, err := solana.NewClient(&solana.ClientConfig{
+			Endpoint:   s.RPC.URL(),
+			Commitment: "confirmed",
+			MaxRetries: 1,
+		})
+		if err != nil {
+			t.Fatalf("apitest: creating Solana client: %v", err)
+		}
+		t.Cleanup(func() { client.Close() })
+		solanaClient = client
+	}
+
+	openaiClient := opts.OpenAI
+	if openaiClient == nil {
+		s.OpenAI = NewMockOpenAI(t)
+		client, err := openai.NewClient(&openai.ClientConfig{
+			APIKey:  testAPIKey,
+			BaseURL: s.OpenAI.URL(),
+		})
+		if err != nil {
+			t.Fatalf("apitest: creating OpenAI client: %v", err)
+		}
+		openaiClient = client
+	}
+
+	users, database := opts.Users, opts.Database
+	if users == nil || database == nil {
+		s.DB = NewMemoryDB()
+		if users == nil {
+			users = s.DB
+		}
+		if database == nil {
+			database = s.DB
+		}
+	}
+
+	config := opts.Config
+	if config == nil {
+		config = &utils.Config{Environment: "test", LogLevel: "error"}
+		if s.RPC != nil {
+			config.Solana.Endpoint = s.RPC.URL()
+		}
+		config.OpenAI.APIKey = testAPIKey
+	}
+
+	s.Handler = api.NewHandler(nil, solanaClient, openaiClient)
+	s.Handler.SetUserStore(users)
+	s.Handler.SetDatabase(database)
+	s.Router = api.NewRouter(s.Handler, config)
+	s.Auth = middleware.NewAuthMiddleware(logger.Nop())
+	s.Router.SetAuthenticator(s.Auth.Authenticate)
+
+	s.Server = httptest.NewServer(s.Router)
+	t.Cleanup(s.Server.Close)
+	return s
+}
+
+// Token returns a bearer token for userID with role, such as "admin" for
+// the user list
+func (s *Server) Token(userID, role string) string {
+	s.t.Helper()
+	token, err := s.Auth.GenerateToken(userID, role)
+	if err != nil {
+		s.t.Fatalf("apitest: generating token: %v", err)
+	}
+	return token
+}
+
+// testAPIKey is a well-formed OpenAI key for the mock API
+const testAPIKey = "sk-apitest000000000000000000000000000000000000000"
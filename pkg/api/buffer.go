@@ -0,0 +1,56 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// maxPooledBuffer is the largest buffer kept for reuse, so one very large
+// response doesn't pin its memory in the pool
+const maxPooledBuffer = 64 << 10
+
+// jsonBuffer is a response buffer with an encoder writing into it
+type jsonBuffer struct {
+	bytes.Buffer
+	enc *json.Encoder
+}
+
+var jsonBufferPool = sync.Pool{
+	New: func() interface{} {
+		buf := &jsonBuffer{}
+		buf.enc = json.NewEncoder(&buf.Buffer)
+		return buf
+	},
+}
+
+func getJSONBuffer() *jsonBuffer {
+	buf := jsonBufferPool.Get().(*jsonBuffer)
+	buf.Reset()
+	return buf
+}
+
+func putJSONBuffer(buf *jsonBuffer) {
+	if buf.Cap() > maxPooledBuffer {
+		return
+	}
+	jsonBufferPool.Put(buf)
+}
+
+// writeJSON encodes v into a pooled buffer before writing it with status, so
+// a value that fails to encode leaves the response untouched for the caller
+// to report. The buffer goes back to the pool even if encoding panics.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) error {
+	buf := getJSONBuffer()
+	defer putJSONBuffer(buf)
+
+	if err := buf.enc.Encode(v); err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+	return nil
+}
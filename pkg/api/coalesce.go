@@ -0,0 +1,268 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// DefaultCoalesceCacheSize bounds the responses the coalescer caches when
+// the config sets no limit
+const DefaultCoalesceCacheSize = 1000
+
+// CoalesceMetrics reports how much request coalescing and response caching
+// saved for one endpoint
+type CoalesceMetrics struct {
+	Requests int64 `json:"requests"`
+	// Coalesced counts requests that shared the response of an identical
+	// request already in flight instead of making their own upstream call
+	Coalesced   int64   `json:"coalesced"`
+	CacheHits   int64   `json:"cache_hits"`
+	CacheMisses int64   `json:"cache_misses"`
+	HitRatio    float64 `json:"cache_hit_ratio"`
+	// AverageSavedLatency is how much sooner coalesced and cached requests
+	// were answered than their upstream call took, on average
+	AverageSavedLatency time.Duration `json:"average_saved_latency"`
+}
+
+// capturedResponse is a response recorded so it can be replayed to other
+// requests for the same resource
+type capturedResponse struct {
+	status  int
+	header  http.Header
+	body    []byte
+	latency time.Duration
+}
+
+func (c *capturedResponse) writeTo(w http.ResponseWriter, cache string) {
+	for k, v := range c.header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("X-Cache", cache)
+	w.WriteHeader(c.status)
+	w.Write(c.body)
+}
+
+// flight is an upstream call other requests for the same resource wait on
+type flight struct {
+	done chan struct{}
+	// resp is nil if the handler panicked
+	resp *capturedResponse
+}
+
+type cachedResponse struct {
+	resp    *capturedResponse
+	expires time.Time
+}
+
+type coalesceStats struct {
+	requests    int64
+	coalesced   int64
+	cacheHits   int64
+	cacheMisses int64
+	saved       time.Duration
+}
+
+// coalescer serves identical GET and HEAD requests made while one is in
+// flight from that request's response, and caches successful responses for
+// ttl when it is positive. At most maxEntries responses are cached; the one
+// closest to expiring makes room for a new one.
+type coalescer struct {
+	ttl        time.Duration
+	maxEntries int
+	flights    map[string]*flight
+	cache      map[string]*cachedResponse
+	stats      map[string]*coalesceStats
+	evictions  int64
+	mu         sync.Mutex
+}
+
+func newCoalescer() *coalescer {
+	return &coalescer{
+		maxEntries: DefaultCoalesceCacheSize,
+		flights:    make(map[string]*flight),
+		cache:      make(map[string]*cachedResponse),
+		stats:      make(map[string]*coalesceStats),
+	}
+}
+
+// setTTL changes how long responses are cached; 0 disables caching
+func (c *coalescer) setTTL(ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttl = ttl
+}
+
+// coalesceMiddleware coalesces and caches GET and HEAD requests. Requests
+// are keyed on their method and URL, and metrics are labelled by method and
+// route template. Requests carrying credentials always reach the handler,
+// as their responses may be specific to the caller.
+func (r *Router) coalesceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			next.ServeHTTP(w, req)
+			return
+		}
+		if req.Header.Get("Authorization") != "" || req.Header.Get("Cookie") != "" {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		endpoint := req.URL.Path
+		if route := mux.CurrentRoute(req); route != nil {
+			if tmpl, err := route.GetPathTemplate(); err == nil {
+				endpoint = tmpl
+			}
+		}
+		r.handler.coalescer.serve(w, req, next, req.Method+" "+endpoint)
+	})
+}
+
+func (c *coalescer) serve(w http.ResponseWriter, req *http.Request, next http.Handler, endpoint string) {
+	key := req.Method + " " + req.URL.String()
+	start := time.Now()
+
+	c.mu.Lock()
+	stats, ok := c.stats[endpoint]
+	if !ok {
+		stats = &coalesceStats{}
+		c.stats[endpoint] = stats
+	}
+	stats.requests++
+
+	if c.ttl > 0 {
+		if cached, ok := c.cache[key]; ok && start.Before(cached.expires) {
+			stats.cacheHits++
+			stats.saved += cached.resp.latency
+			c.mu.Unlock()
+			cached.resp.writeTo(w, "HIT")
+			return
+		}
+		delete(c.cache, key)
+		stats.cacheMisses++
+	}
+
+	if f, ok := c.flights[key]; ok {
+		stats.coalesced++
+		c.mu.Unlock()
+
+		select {
+		case <-f.done:
+		case <-req.Context().Done():
+			return
+		}
+		if f.resp == nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if saved := f.resp.latency - time.Since(start); saved > 0 {
+			c.mu.Lock()
+			stats.saved += saved
+			c.mu.Unlock()
+		}
+		f.resp.writeTo(w, "COALESCED")
+		return
+	}
+
+	f := &flight{done: make(chan struct{})}
+	c.flights[key] = f
+	c.mu.Unlock()
+
+	// Waiters are released even if the handler panics, so they don't hang
+	defer func() {
+		c.mu.Lock()
+		delete(c.flights, key)
+		if c.ttl > 0 && f.resp != nil && f.resp.status == http.StatusOK {
+			c.store(key, &cachedResponse{resp: f.resp, expires: time.Now().Add(c.ttl)})
+		}
+		c.mu.Unlock()
+		close(f.done)
+	}()
+
+	rec := &captureWriter{ResponseWriter: w, status: http.StatusOK}
+	next.ServeHTTP(rec, req)
+
+	f.resp = &capturedResponse{
+		status:  rec.status,
+		header:  w.Header().Clone(),
+		body:    rec.body.Bytes(),
+		latency: time.Since(start),
+	}
+}
+
+// store caches entry under key, first dropping expired entries and then the
+// entry closest to expiring if the cache is full. Callers must hold c.mu.
+func (c *coalescer) store(key string, entry *cachedResponse) {
+	if _, exists := c.cache[key]; !exists && len(c.cache) >= c.maxEntries {
+		now := time.Now()
+		for k, cached := range c.cache {
+			if !now.Before(cached.expires) {
+				delete(c.cache, k)
+			}
+		}
+		if len(c.cache) >= c.maxEntries {
+			var oldest string
+			for k, cached := range c.cache {
+				if oldest == "" || cached.expires.Before(c.cache[oldest].expires) {
+					oldest = k
+				}
+			}
+			delete(c.cache, oldest)
+			c.evictions++
+		}
+	}
+	c.cache[key] = entry
+}
+
+// cacheStats returns how many responses are cached and how many were
+// evicted to make room
+func (c *coalescer) cacheStats() (entries int, evictions int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.cache), c.evictions
+}
+
+// metrics returns the coalescing and caching metrics of each endpoint
+func (c *coalescer) metrics() map[string]CoalesceMetrics {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	metrics := make(map[string]CoalesceMetrics, len(c.stats))
+	for endpoint, s := range c.stats {
+		m := CoalesceMetrics{
+			Requests:    s.requests,
+			Coalesced:   s.coalesced,
+			CacheHits:   s.cacheHits,
+			CacheMisses: s.cacheMisses,
+		}
+		if lookups := s.cacheHits + s.cacheMisses; lookups > 0 {
+			m.HitRatio = float64(s.cacheHits) / float64(lookups)
+		}
+		if served := s.coalesced + s.cacheHits; served > 0 {
+			m.AverageSavedLatency = s.saved / time.Duration(served)
+		}
+		metrics[endpoint] = m
+	}
+	return metrics
+}
+
+// captureWriter writes a response through while keeping a copy of it
+type captureWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (c *captureWriter) WriteHeader(code int) {
+	c.status = code
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *captureWriter) Write(b []byte) (int, error) {
+	c.body.Write(b)
+	return c.ResponseWriter.Write(b)
+}
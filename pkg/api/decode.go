@@ -0,0 +1,201 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/labs-alone/alone-main/internal/validate"
+)
+
+// Request body decode failures, matched with errors.Is on a *RequestError
+var (
+	ErrEmptyBody        = errors.New("empty_body")
+	ErrMalformedJSON    = errors.New("malformed_json")
+	ErrUnknownField     = errors.New("unknown_field")
+	ErrInvalidFieldType = errors.New("invalid_type")
+	ErrMultipleObjects  = errors.New("multiple_objects")
+	ErrBodyTooLarge     = errors.New("body_too_large")
+	ErrTooManyItems     = errors.New("too_many_items")
+	ErrInvalidAmount    = errors.New("invalid_amount")
+	// ErrInvalidFields is a request that decoded but failed validation.
+	// Its details list each invalid field under fields.
+	ErrInvalidFields = errors.New("invalid_fields")
+)
+
+// RequestError describes a problem with a client request body in terms the
+// client can act on. Err is one of the decode failure sentinels and is also
+// reported to the client as details.reason.
+type RequestError struct {
+	Err     error
+	Message string
+	Details map[string]interface{}
+}
+
+func (e *RequestError) Error() string {
+	return e.Message
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// newRequestError builds a RequestError whose details carry the reason
+func newRequestError(kind error, message string, details map[string]interface{}) *RequestError {
+	if details == nil {
+		details = make(map[string]interface{})
+	}
+	details["reason"] = kind.Error()
+	return &RequestError{Err: kind, Message: message, Details: details}
+}
+
+// decodeJSON decodes a single JSON object from the request body into dst,
+// rejecting unknown fields. Decode failures are returned as *RequestError.
+func decodeJSON(r *http.Request, dst interface{}) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(dst); err != nil {
+		return translateDecodeError(err)
+	}
+
+	if err := dec.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
+		return newRequestError(ErrMultipleObjects, "request body must contain a single JSON object", nil)
+	}
+
+	return nil
+}
+
+// decodeJSONArray decodes a JSON array from the request body one element at
+// a time, so a huge payload is rejected once it passes maxItems elements or
+// an element passes maxItemSize bytes rather than after buffering it all
+func decodeJSONArray[T any](w http.ResponseWriter, r *http.Request, maxItems int, maxItemSize int64) ([]T, error) {
+	// Bound the whole body too, since a single element is decoded in full
+	// before its size can be checked
+	body := http.MaxBytesReader(w, r.Body, int64(maxItems)*maxItemSize+1024)
+	dec := json.NewDecoder(body)
+	dec.DisallowUnknownFields()
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, translateStreamError(err)
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return nil, newRequestError(ErrInvalidFieldType, "request body must be a JSON array", nil)
+	}
+
+	var items []T
+	for dec.More() {
+		if len(items) == maxItems {
+			return nil, newRequestError(ErrTooManyItems,
+				fmt.Sprintf("request body must contain at most %d items", maxItems),
+				map[string]interface{}{"max_items": maxItems},
+			)
+		}
+
+		start := dec.InputOffset()
+		var item T
+		if err := dec.Decode(&item); err != nil {
+			reqErr := translateStreamError(err)
+			if re, ok := reqErr.(*RequestError); ok {
+				re.Details["index"] = len(items)
+			}
+			return nil, reqErr
+		}
+		if size := dec.InputOffset() - start; size > maxItemSize {
+			return nil, newRequestError(ErrBodyTooLarge,
+				fmt.Sprintf("item %d is %d bytes, the limit is %d", len(items), size, maxItemSize),
+				map[string]interface{}{"index": len(items), "max_item_size": maxItemSize},
+			)
+		}
+		items = append(items, item)
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return nil, translateStreamError(err)
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return nil, newRequestError(ErrMultipleObjects, "request body must contain a single JSON array", nil)
+	}
+
+	return items, nil
+}
+
+// invalidFieldsError converts validate.Errors into a RequestError listing
+// each invalid field
+func invalidFieldsError(err error) error {
+	var errs validate.Errors
+	if !errors.As(err, &errs) {
+		return err
+	}
+	return newRequestError(ErrInvalidFields, errs.Error(),
+		map[string]interface{}{"fields": errs},
+	)
+}
+
+// translateStreamError is translateDecodeError plus the body size limit
+func translateStreamError(err error) error {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return newRequestError(ErrBodyTooLarge,
+			fmt.Sprintf("request body must be at most %d bytes", maxErr.Limit),
+			map[string]interface{}{"limit": maxErr.Limit},
+		)
+	}
+	return translateDecodeError(err)
+}
+
+// translateDecodeError converts encoding/json errors into RequestErrors
+func translateDecodeError(err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var amountErr *AmountError
+
+	switch {
+	case errors.Is(err, io.EOF):
+		return newRequestError(ErrEmptyBody, "request body is empty", nil)
+
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return newRequestError(ErrMalformedJSON, "request body contains malformed JSON", nil)
+
+	case errors.As(err, &syntaxErr):
+		return newRequestError(ErrMalformedJSON,
+			fmt.Sprintf("request body contains malformed JSON at position %d", syntaxErr.Offset),
+			map[string]interface{}{"offset": syntaxErr.Offset},
+		)
+
+	case errors.As(err, &typeErr):
+		if typeErr.Field == "" {
+			return newRequestError(ErrInvalidFieldType,
+				fmt.Sprintf("request body must be a JSON object, got %s", typeErr.Value),
+				nil,
+			)
+		}
+		return newRequestError(ErrInvalidFieldType,
+			fmt.Sprintf("field %q must be of type %s", typeErr.Field, typeErr.Type),
+			map[string]interface{}{
+				"field":    typeErr.Field,
+				"expected": typeErr.Type.String(),
+				"got":      typeErr.Value,
+			},
+		)
+
+	case errors.As(err, &amountErr):
+		return newRequestError(ErrInvalidAmount, amountErr.Error(),
+			map[string]interface{}{"got": amountErr.Value},
+		)
+
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return newRequestError(ErrUnknownField,
+			fmt.Sprintf("unknown field %q", field),
+			map[string]interface{}{"field": field},
+		)
+
+	default:
+		return newRequestError(ErrMalformedJSON, "invalid request body", nil)
+	}
+}
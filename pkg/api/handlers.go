@@ -1,23 +1,58 @@
 package api
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/labs-alone/alone-main/internal/core"
 	"github.com/labs-alone/alone-main/internal/solana"
 	"github.com/labs-alone/alone-main/internal/openai"
 	"github.com/labs-alone/alone-main/internal/utils"
+	"github.com/labs-alone/alone-main/internal/validate"
+	"github.com/labs-alone/alone-main/pkg/logger"
 )
 
 // Handler manages API request handling
 type Handler struct {
 	engine  *core.Engine
 	solana  *solana.Client
+	wallet  *solana.Wallet
+	swap    *solana.SwapClient
 	openai  *openai.Client
-	logger  *utils.Logger
+	users   UserStore
+	prompts *openai.PromptManager
+	db      DatabasePinger
+	health  HealthConfig
+	started time.Time
+	logger  logger.Logger
 	metrics *Metrics
+
+	// coalescer shares and caches responses to read-only Solana requests
+	coalescer *coalescer
+
+	// lastSelfCheck is the latest self-check report, from startup or a
+	// refresh. selfCheckMu guards it and selfCheckRun serializes checks run
+	// by the self-check endpoint.
+	lastSelfCheck *SelfCheckReport
+	selfCheckMu   sync.Mutex
+	selfCheckRun  sync.Mutex
+}
+
+// HealthConfig controls the optional fields of the health response. The
+// status field is always present.
+type HealthConfig struct {
+	Service     string
+	Version     string
+	Environment string
+	// IncludeUptime adds uptime_seconds since the handler was created
+	IncludeUptime bool
+	// OmitTimestamp drops the timestamp for clients that cache on the body
+	OmitTimestamp bool
 }
 
 // Metrics tracks API usage
@@ -33,6 +68,7 @@ type Response struct {
 	Success bool        `json:"success"`
 	Data    interface{} `json:"data,omitempty"`
 	Error   string     `json:"error,omitempty"`
+	Details interface{} `json:"details,omitempty"`
 }
 
 // NewHandler creates a new API handler
@@ -41,75 +77,578 @@ func NewHandler(engine *core.Engine, solana *solana.Client, openai *openai.Clien
 		engine:  engine,
 		solana:  solana,
 		openai:  openai,
+		started: time.Now(),
 		logger:  utils.NewLogger(),
 		metrics: &Metrics{},
+
+		coalescer: newCoalescer(),
 	}
 }
 
+// SetPromptManager configures the prompt templates whose usage is reported
+// by the metrics endpoint
+func (h *Handler) SetPromptManager(prompts *openai.PromptManager) {
+	h.prompts = prompts
+}
+
+// UserStore provides paginated access to users
+type UserStore interface {
+	ListUsers(ctx context.Context, offset, limit int) ([]core.User, int, error)
+}
+
+// SetUserStore configures the source for the user list endpoint
+func (h *Handler) SetUserStore(users UserStore) {
+	h.users = users
+}
+
+// SetWallet configures the server wallet used to re-sign transfers
+func (h *Handler) SetWallet(wallet *solana.Wallet) {
+	h.wallet = wallet
+}
+
+// SetSwapClient configures the quote source the swap endpoint routes
+// through
+func (h *Handler) SetSwapClient(swap *solana.SwapClient) {
+	h.swap = swap
+}
+
+// SetHealthConfig configures the optional health response fields
+func (h *Handler) SetHealthConfig(config HealthConfig) {
+	h.health = config
+}
+
 // handleHealth handles health check requests
 func (h *Handler) handleHealth(w http.ResponseWriter, r *http.Request) {
+	services := make(map[string]interface{})
+	if h.engine != nil {
+		services["engine"] = h.engine.Status()
+	}
+	if h.solana != nil {
+		services["solana"] = h.solana.Status()
+	}
+	if h.openai != nil {
+		services["openai"] = "connected"
+	}
+
 	status := map[string]interface{}{
-		"status":    "ok",
-		"timestamp": time.Now(),
-		"services": map[string]string{
-			"engine": h.engine.Status(),
-			"solana": h.solana.Status(),
-			"openai": "connected",
-		},
+		"status":   "ok",
+		"services": services,
+	}
+	// A degraded RPC node is reported but doesn't fail the health check;
+	// readiness is where it takes the service out of rotation
+	if h.solana != nil {
+		if err := h.solana.Health(r.Context()); err != nil {
+			status["status"] = "degraded"
+			services["solana_rpc"] = map[string]string{"status": "unhealthy", "error": err.Error()}
+		} else {
+			services["solana_rpc"] = map[string]string{"status": "ok"}
+		}
+	}
+	if !h.health.OmitTimestamp {
+		status["timestamp"] = time.Now()
+	}
+	if h.health.Service != "" {
+		status["service"] = h.health.Service
+	}
+	if h.health.Version != "" {
+		status["version"] = h.health.Version
+	}
+	if h.health.Environment != "" {
+		status["environment"] = h.health.Environment
+	}
+	if h.health.IncludeUptime {
+		status["uptime_seconds"] = time.Since(h.started).Seconds()
 	}
 
 	h.sendJSON(w, Response{Success: true, Data: status})
 }
 
+// handleReady reports whether the service can take traffic, answering 503
+// while a dependency it needs, such as the Solana RPC node, is unhealthy
+func (h *Handler) handleReady(w http.ResponseWriter, r *http.Request) {
+	checks := make(map[string]string)
+	ready := true
+	if h.solana != nil {
+		checks["solana_rpc"] = "ok"
+		if err := h.solana.Health(r.Context()); err != nil {
+			checks["solana_rpc"] = err.Error()
+			ready = false
+		}
+	}
+
+	if !ready {
+		h.sendErrorDetails(w, "service not ready", map[string]interface{}{"checks": checks}, http.StatusServiceUnavailable)
+		return
+	}
+	h.sendJSON(w, Response{Success: true, Data: map[string]interface{}{"status": "ready", "checks": checks}})
+}
+
 // handleSolanaBalance handles balance check requests
 func (h *Handler) handleSolanaBalance(w http.ResponseWriter, r *http.Request) {
-	address := r.URL.Query().Get("address")
-	if address == "" {
-		h.sendError(w, "address parameter is required", http.StatusBadRequest)
+	query := r.URL.Query()
+	address := query.Get("address")
+	unit := query.Get("unit")
+	c := query.Get("commitment")
+
+	var v validate.Validator
+	validate.Field(&v, "address", address, validate.Required[string](), validate.Address())
+	validate.Field(&v, "unit", unit, validate.Optional(validate.OneOf("lamports", "sol")))
+	validate.Field(&v, "commitment", c, validate.Optional(validate.Commitment()))
+	if err := v.Err(); err != nil {
+		h.sendValidationError(w, err)
 		return
 	}
 
-	balance, err := h.solana.GetBalance(r.Context(), address)
+	var balance solana.Lamports
+	var err error
+	if c != "" {
+		// Already validated, so this can't fail
+		commitment, _ := solana.ParseCommitment(c)
+		balance, err = h.solana.GetBalanceWithCommitment(r.Context(), address, commitment)
+	} else {
+		balance, err = h.solana.GetBalance(r.Context(), address)
+	}
 	if err != nil {
 		h.sendError(w, "failed to get balance: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	// Lamports stay the default so existing clients keep getting a number;
+	// SOL is a decimal string to avoid float rounding
+	if unit == "sol" {
+		h.sendJSON(w, Response{Success: true, Data: solana.FormatSOL(balance)})
+		return
+	}
 	h.sendJSON(w, Response{Success: true, Data: balance})
 }
 
-// handleSolanaTransaction handles transaction requests
+// TransactionRequest is the body of a transfer request. With an
+// IdempotencyKey the transfer is tracked so it can be resubmitted if it
+// expires before landing.
+type TransactionRequest struct {
+	From           string `json:"from"`
+	To             string `json:"to"`
+	Amount         Amount `json:"amount"`
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+// Validate checks both addresses and that the amount is positive
+func (req TransactionRequest) Validate() error {
+	var v validate.Validator
+	validate.Field(&v, "from", req.From, validate.Required[string](), validate.Address())
+	validate.Field(&v, "to", req.To, validate.Required[string](), validate.Address())
+	validate.Field(&v, "amount", req.Amount, validate.Positive[Amount]())
+	return v.Err()
+}
+
+// ResubmitRequest is the body of a transfer resubmission request
+type ResubmitRequest struct {
+	IdempotencyKey string `json:"idempotency_key"`
+}
+
+// SubmitRequest is the body of a signed transaction submission. A
+// transaction built with an idempotency key must be submitted with it.
+type SubmitRequest struct {
+	Transaction    string `json:"transaction"`
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+// SwapRequest is the body of a token swap request. With UserPublicKey the
+// swap is returned unsigned for that wallet to sign; without it the server
+// wallet signs and sends it.
+type SwapRequest struct {
+	InputMint     string `json:"input_mint"`
+	OutputMint    string `json:"output_mint"`
+	Amount        Amount `json:"amount"`
+	SlippageBps   int    `json:"slippage_bps,omitempty"`
+	UserPublicKey string `json:"user_public_key,omitempty"`
+}
+
+// Validate checks the mints, amount, slippage and optional signer. That the
+// mints differ is left to the swap client, and whether a signer is required
+// to the endpoint.
+func (req SwapRequest) Validate() error {
+	var v validate.Validator
+	validate.Field(&v, "input_mint", req.InputMint, validate.Required[string](), validate.Address())
+	validate.Field(&v, "output_mint", req.OutputMint, validate.Required[string](), validate.Address())
+	validate.Field(&v, "amount", req.Amount, validate.Positive[Amount]())
+	validate.Field(&v, "slippage_bps", req.SlippageBps, validate.Between(0, solana.MaxSlippageBps))
+	validate.Field(&v, "user_public_key", req.UserPublicKey, validate.Optional(validate.Address()))
+	return v.Err()
+}
+
+// CompletionRequest is the body of an AI completion request
+type CompletionRequest struct {
+	Prompt      string  `json:"prompt"`
+	MaxTokens   int     `json:"max_tokens,omitempty"`
+	Temperature float32 `json:"temperature,omitempty"`
+}
+
+// maxTemperature is the highest sampling temperature the API accepts
+const maxTemperature = 2
+
+// Validate checks the prompt is present and the sampling options are in
+// range
+func (req CompletionRequest) Validate() error {
+	var v validate.Validator
+	validate.Field(&v, "prompt", req.Prompt, validate.Required[string]())
+	validate.Field(&v, "max_tokens", req.MaxTokens, validate.Min(0))
+	validate.Field(&v, "temperature", req.Temperature, validate.Between[float32](0, maxTemperature))
+	return v.Err()
+}
+
+// Batch completion limits
+const (
+	maxBatchCompletions    = 50
+	maxBatchCompletionSize = 64 << 10
+)
+
+// BatchCompletionResult is the outcome of one request in a batch
+type BatchCompletionResult struct {
+	Index      int                            `json:"index"`
+	Completion *openai.ChatCompletionResponse `json:"completion,omitempty"`
+	Error      string                         `json:"error,omitempty"`
+}
+
+// handleSolanaTransaction sends a transfer from the server wallet, which
+// must be the sender
 func (h *Handler) handleSolanaTransaction(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		From   string `json:"from"`
-		To     string `json:"to"`
-		Amount uint64 `json:"amount"`
+	var req TransactionRequest
+
+	if err := decodeJSON(r, &req); err != nil {
+		h.sendDecodeError(w, err)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		h.sendValidationError(w, err)
+		return
+	}
+
+	if h.wallet == nil {
+		h.sendError(w, "server wallet not configured", http.StatusServiceUnavailable)
+		return
+	}
+	if req.From != h.wallet.GetAddress() {
+		h.sendError(w, "sender must be the server wallet; build the transaction to sign it yourself", http.StatusBadRequest)
+		return
+	}
+
+	var data interface{}
+	var err error
+	if req.IdempotencyKey != "" {
+		data, err = h.wallet.SendTransfer(r.Context(), req.IdempotencyKey, req.To, uint64(req.Amount))
+	} else {
+		var signature string
+		signature, err = h.wallet.SendSOL(r.Context(), req.To, solana.Lamports(req.Amount))
+		data = map[string]string{"signature": signature}
+	}
+	if err != nil {
+		var txErr *solana.TransactionError
+		switch {
+		case errors.Is(err, solana.ErrInvalidTransaction):
+			h.sendError(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, solana.ErrProgramNotAllowed):
+			h.sendError(w, err.Error(), http.StatusForbidden)
+		case errors.Is(err, solana.ErrTransferExists):
+			h.sendError(w, err.Error(), http.StatusConflict)
+		case errors.As(err, &txErr):
+			h.sendErrorDetails(w, txErr.Error(), txErr, http.StatusUnprocessableEntity)
+		default:
+			h.sendError(w, "failed to send transaction: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	h.sendJSON(w, Response{Success: true, Data: data})
+}
+
+// handleSolanaBuildTransaction builds an unsigned transfer for the client to
+// sign, so the server never holds the sender's keys
+func (h *Handler) handleSolanaBuildTransaction(w http.ResponseWriter, r *http.Request) {
+	var req TransactionRequest
+
+	if err := decodeJSON(r, &req); err != nil {
+		h.sendDecodeError(w, err)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		h.sendValidationError(w, err)
+		return
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.sendError(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+	var built *solana.UnsignedTransfer
+	var err error
+	if req.IdempotencyKey != "" {
+		built, err = h.solana.PrepareTransfer(r.Context(), req.IdempotencyKey, req.From, req.To, uint64(req.Amount))
+	} else {
+		built, err = h.solana.BuildUnsignedTransfer(r.Context(), req.From, req.To, uint64(req.Amount))
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, solana.ErrInvalidTransaction):
+			h.sendError(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, solana.ErrTransferExists):
+			h.sendError(w, err.Error(), http.StatusConflict)
+		default:
+			h.sendError(w, "failed to build transaction: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	h.sendJSON(w, Response{Success: true, Data: built})
+}
+
+// handleSolanaSubmitTransaction forwards a client-signed transaction
+func (h *Handler) handleSolanaSubmitTransaction(w http.ResponseWriter, r *http.Request) {
+	var req SubmitRequest
+
+	if err := decodeJSON(r, &req); err != nil {
+		h.sendDecodeError(w, err)
+		return
+	}
+
+	if req.Transaction == "" {
+		h.sendError(w, "transaction is required", http.StatusBadRequest)
 		return
 	}
 
-	signature, err := h.solana.SendTransaction(r.Context(), req.From, req.To, req.Amount)
+	var signature string
+	var err error
+	if req.IdempotencyKey != "" {
+		signature, err = h.solana.SubmitPreparedTransfer(r.Context(), req.IdempotencyKey, req.Transaction)
+	} else {
+		signature, err = h.solana.SubmitSignedTransaction(r.Context(), req.Transaction)
+	}
 	if err != nil {
-		h.sendError(w, "failed to send transaction: "+err.Error(), http.StatusInternalServerError)
+		var txErr *solana.TransactionError
+		switch {
+		case errors.Is(err, solana.ErrInvalidTransaction):
+			h.sendError(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, solana.ErrTransferNotFound):
+			h.sendError(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, solana.ErrTransferMismatch),
+			errors.Is(err, solana.ErrTransferInFlight):
+			h.sendError(w, err.Error(), http.StatusConflict)
+		case errors.As(err, &txErr):
+			h.sendErrorDetails(w, txErr.Error(), txErr, http.StatusUnprocessableEntity)
+		default:
+			h.sendError(w, "failed to submit transaction: "+err.Error(), http.StatusInternalServerError)
+		}
 		return
 	}
 
 	h.sendJSON(w, Response{Success: true, Data: map[string]string{"signature": signature}})
 }
 
-// handleOpenAICompletion handles AI completion requests
-func (h *Handler) handleOpenAICompletion(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		Prompt      string  `json:"prompt"`
-		MaxTokens   int     `json:"max_tokens,omitempty"`
-		Temperature float32 `json:"temperature,omitempty"`
+// handleSolanaHistory lists transaction signatures for an address
+func (h *Handler) handleSolanaHistory(w http.ResponseWriter, r *http.Request) {
+	var v validate.Validator
+	address := r.URL.Query().Get("address")
+	validate.Field(&v, "address", address, validate.Required[string](), validate.Address())
+	page, err := parsePagination(r)
+	v.Merge("", err)
+	if err := v.Err(); err != nil {
+		h.sendValidationError(w, err)
+		return
+	}
+
+	history, err := h.solana.GetTransactionHistory(r.Context(), address, page.PerPage, page.Cursor)
+	if err != nil {
+		h.sendError(w, "failed to get transaction history: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var nextCursor string
+	if len(history) == page.PerPage {
+		nextCursor = history[len(history)-1].Signature
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.sendError(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+	h.sendJSON(w, Response{Success: true, Data: NewCursorListResponse(history, page.PerPage, nextCursor)})
+}
+
+// handleListUsers lists users a page at a time
+func (h *Handler) handleListUsers(w http.ResponseWriter, r *http.Request) {
+	if h.users == nil {
+		h.sendError(w, "user store not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	page, err := parsePagination(r)
+	if err != nil {
+		h.sendValidationError(w, err)
+		return
+	}
+
+	users, total, err := h.users.ListUsers(r.Context(), page.Offset(), page.PerPage)
+	if err != nil {
+		h.sendError(w, "failed to list users: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.sendJSON(w, Response{Success: true, Data: NewListResponse(users, page.Page, page.PerPage, total)})
+}
+
+// handleSolanaResubmit rebuilds an expired transfer tracked by idempotency key
+func (h *Handler) handleSolanaResubmit(w http.ResponseWriter, r *http.Request) {
+	var req ResubmitRequest
+
+	if err := decodeJSON(r, &req); err != nil {
+		h.sendDecodeError(w, err)
+		return
+	}
+
+	if req.IdempotencyKey == "" {
+		h.sendError(w, "idempotency_key is required", http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.solana.ResubmitTransfer(r.Context(), req.IdempotencyKey, h.wallet)
+	if err != nil {
+		switch {
+		case errors.Is(err, solana.ErrTransferNotFound):
+			h.sendError(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, solana.ErrProgramNotAllowed):
+			h.sendError(w, err.Error(), http.StatusForbidden)
+		case errors.Is(err, solana.ErrTransferNotExpired),
+			errors.Is(err, solana.ErrTransferFailed),
+			errors.Is(err, solana.ErrSignerRequired),
+			errors.Is(err, solana.ErrResubmitLimit),
+			errors.Is(err, solana.ErrTransferInFlight):
+			h.sendError(w, err.Error(), http.StatusConflict)
+		default:
+			h.sendError(w, "failed to resubmit transaction: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	h.sendJSON(w, Response{Success: true, Data: result})
+}
+
+// handleSolanaSwap quotes a token swap and builds its transaction unsigned
+// for the client's wallet to sign
+func (h *Handler) handleSolanaSwap(w http.ResponseWriter, r *http.Request) {
+	req, ok := h.decodeSwapRequest(w, r)
+	if !ok {
+		return
+	}
+	var v validate.Validator
+	validate.Field(&v, "user_public_key", req.UserPublicKey, validate.Required[string]())
+	if err := v.Err(); err != nil {
+		h.sendValidationError(w, err)
+		return
+	}
+
+	swap, err := h.buildSwap(r.Context(), req, req.UserPublicKey)
+	if err != nil {
+		h.sendSwapError(w, err)
+		return
+	}
+	h.sendJSON(w, Response{Success: true, Data: swap})
+}
+
+// handleSolanaExecuteSwap quotes a token swap from the server wallet, then
+// signs and sends it once the transaction is checked against its quote
+func (h *Handler) handleSolanaExecuteSwap(w http.ResponseWriter, r *http.Request) {
+	req, ok := h.decodeSwapRequest(w, r)
+	if !ok {
+		return
+	}
+	if req.UserPublicKey != "" {
+		var v validate.Validator
+		v.Add("user_public_key", validate.CodeInvalid, "must be empty, the server wallet signs executed swaps")
+		h.sendValidationError(w, v.Err())
+		return
+	}
+	if h.wallet == nil {
+		h.sendError(w, "server wallet not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	swap, err := h.buildSwap(r.Context(), req, h.wallet.GetAddress())
+	if err != nil {
+		h.sendSwapError(w, err)
+		return
+	}
+
+	signature, err := h.solana.ExecuteSwap(r.Context(), swap, h.wallet)
+	if err != nil {
+		h.sendSwapError(w, err)
+		return
+	}
+
+	h.sendJSON(w, Response{Success: true, Data: map[string]interface{}{
+		"signature": signature,
+		"quote":     swap.Quote,
+	}})
+}
+
+// decodeSwapRequest decodes and validates a swap request, sending the
+// error response if it fails
+func (h *Handler) decodeSwapRequest(w http.ResponseWriter, r *http.Request) (SwapRequest, bool) {
+	var req SwapRequest
+
+	if err := decodeJSON(r, &req); err != nil {
+		h.sendDecodeError(w, err)
+		return req, false
+	}
+	if err := req.Validate(); err != nil {
+		h.sendValidationError(w, err)
+		return req, false
+	}
+	if h.swap == nil {
+		h.sendError(w, "swaps are not configured", http.StatusServiceUnavailable)
+		return req, false
+	}
+	return req, true
+}
+
+// buildSwap quotes req and builds its transaction for signer
+func (h *Handler) buildSwap(ctx context.Context, req SwapRequest, signer string) (*solana.UnsignedSwap, error) {
+	quote, err := h.swap.Quote(ctx, solana.SwapParams{
+		InputMint:   req.InputMint,
+		OutputMint:  req.OutputMint,
+		Amount:      uint64(req.Amount),
+		SlippageBps: req.SlippageBps,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return h.swap.BuildSwap(ctx, quote, signer)
+}
+
+// sendSwapError maps a swap failure to its status: bad parameters are the
+// client's, a swap the server wallet won't sign for is forbidden, an
+// unroutable swap or failed transaction can't be processed, and anything
+// else, including a transaction not matching its quote, is the quote API
+// or RPC node failing
+func (h *Handler) sendSwapError(w http.ResponseWriter, err error) {
+	var txErr *solana.TransactionError
+	switch {
+	case errors.Is(err, solana.ErrInvalidSwap):
+		h.sendError(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, solana.ErrProgramNotAllowed), errors.Is(err, solana.ErrSwapPolicyRequired):
+		h.sendError(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, solana.ErrNoSwapRoute):
+		h.sendError(w, err.Error(), http.StatusUnprocessableEntity)
+	case errors.As(err, &txErr):
+		h.sendErrorDetails(w, txErr.Error(), txErr, http.StatusUnprocessableEntity)
+	default:
+		h.sendError(w, "failed to swap: "+err.Error(), http.StatusBadGateway)
+	}
+}
+
+// handleOpenAICompletion handles AI completion requests. The upstream
+// request is cancelled with the request context, so a client that
+// disconnects stops the completion and gets no response.
+func (h *Handler) handleOpenAICompletion(w http.ResponseWriter, r *http.Request) {
+	var req CompletionRequest
+
+	if err := decodeJSON(r, &req); err != nil {
+		h.sendDecodeError(w, err)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		h.sendValidationError(w, err)
 		return
 	}
 
@@ -121,6 +660,10 @@ func (h *Handler) handleOpenAICompletion(w http.ResponseWriter, r *http.Request)
 		Temperature: req.Temperature,
 	})
 
+	if clientGone(r) {
+		h.logger.Debug("Client disconnected during completion", "path", r.URL.Path)
+		return
+	}
 	if err != nil {
 		h.sendError(w, "failed to get completion: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -129,16 +672,90 @@ func (h *Handler) handleOpenAICompletion(w http.ResponseWriter, r *http.Request)
 	h.sendJSON(w, Response{Success: true, Data: completion})
 }
 
+// handleOpenAIBatchCompletion runs a JSON array of completion requests. The
+// array is decoded as a stream with limits on item count and size; failures
+// of individual completions are reported per item.
+func (h *Handler) handleOpenAIBatchCompletion(w http.ResponseWriter, r *http.Request) {
+	reqs, err := decodeJSONArray[CompletionRequest](w, r, maxBatchCompletions, maxBatchCompletionSize)
+	if err != nil {
+		h.sendDecodeError(w, err)
+		return
+	}
+
+	if len(reqs) == 0 {
+		h.sendError(w, "batch must contain at least one request", http.StatusBadRequest)
+		return
+	}
+	var v validate.Validator
+	for i, req := range reqs {
+		v.Merge(fmt.Sprintf("[%d].", i), req.Validate())
+	}
+	if err := v.Err(); err != nil {
+		h.sendValidationError(w, err)
+		return
+	}
+
+	completionReqs := make([]*openai.ChatCompletionRequest, len(reqs))
+	for i, req := range reqs {
+		completionReqs[i] = &openai.ChatCompletionRequest{
+			Messages: []openai.ChatMessage{
+				{Role: "user", Content: req.Prompt},
+			},
+			MaxTokens:   req.MaxTokens,
+			Temperature: req.Temperature,
+		}
+	}
+
+	completions, errs := h.openai.CreateChatCompletions(r.Context(), completionReqs)
+	if clientGone(r) {
+		h.logger.Debug("Client disconnected during completion", "path", r.URL.Path)
+		return
+	}
+
+	results := make([]BatchCompletionResult, len(reqs))
+	for i := range reqs {
+		results[i].Index = i
+		if errs[i] != nil {
+			results[i].Error = errs[i].Error()
+			continue
+		}
+		results[i].Completion = completions[i]
+	}
+
+	h.sendJSON(w, Response{Success: true, Data: results})
+}
+
+// clientGone reports whether the client of r disconnected, leaving no one
+// to write a response to. A request that ran out of its own time budget
+// still has a client waiting for the error.
+func clientGone(r *http.Request) bool {
+	return errors.Is(r.Context().Err(), context.Canceled)
+}
+
 // handleMetrics handles metrics requests
 func (h *Handler) handleMetrics(w http.ResponseWriter, r *http.Request) {
 	metrics := map[string]interface{}{
 		"api": h.metrics,
-		"solana": map[string]interface{}{
+	}
+	if h.solana != nil {
+		metrics["solana"] = map[string]interface{}{
 			"requests": h.solana.GetMetrics(),
-		},
-		"openai": map[string]interface{}{
+		}
+	}
+	if h.openai != nil {
+		metrics["openai"] = map[string]interface{}{
 			"requests": h.openai.GetMetrics(),
-		},
+		}
+	}
+	if h.prompts != nil {
+		metrics["prompts"] = h.prompts.Stats()
+	}
+	metrics["coalescing"] = h.coalescer.metrics()
+	entries, evictions := h.coalescer.cacheStats()
+	metrics["coalescing_cache"] = map[string]interface{}{
+		"entries":     entries,
+		"max_entries": h.coalescer.maxEntries,
+		"evictions":   evictions,
 	}
 
 	h.sendJSON(w, Response{Success: true, Data: metrics})
@@ -150,11 +767,10 @@ func (h *Handler) loggerMiddleware(next http.HandlerFunc) http.HandlerFunc {
 		start := time.Now()
 
 		h.logger.Info("Request started",
-			map[string]interface{}{
-				"method": r.Method,
-				"path":   r.URL.Path,
-				"remote": r.RemoteAddr,
-			})
+			"method", r.Method,
+			"path", r.URL.Path,
+			"remote", r.RemoteAddr,
+		)
 
 		next(w, r)
 
@@ -162,30 +778,56 @@ func (h *Handler) loggerMiddleware(next http.HandlerFunc) http.HandlerFunc {
 		h.updateMetrics(duration)
 
 		h.logger.Info("Request completed",
-			map[string]interface{}{
-				"method":   r.Method,
-				"path":     r.URL.Path,
-				"duration": duration,
-			})
+			"method", r.Method,
+			"path", r.URL.Path,
+			"duration", duration,
+		)
 	}
 }
 
 // Helper methods
 func (h *Handler) sendJSON(w http.ResponseWriter, data interface{}) {
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(data); err != nil {
-		h.logger.Error("Failed to encode response", 
-			map[string]interface{}{"error": err.Error()})
+	if err := writeJSON(w, http.StatusOK, data); err != nil {
+		h.logger.Error("Failed to encode response", "error", err.Error())
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 	}
 }
 
 func (h *Handler) sendError(w http.ResponseWriter, message string, code int) {
-	h.metrics.ErrorCount++
+	h.sendErrorDetails(w, message, nil, code)
+}
+
+// sendErrorDetails sends an error response with machine-readable details
+func (h *Handler) sendErrorDetails(w http.ResponseWriter, message string, details interface{}, code int) {
+	atomic.AddUint64(&h.metrics.ErrorCount, 1)
 	h.logger.Error(message)
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	json.NewEncoder(w).Encode(Response{Success: false, Error: message})
+	if err := writeJSON(w, code, Response{Success: false, Error: message, Details: details}); err != nil {
+		// Details that can't be encoded shouldn't lose the error itself
+		writeJSON(w, code, Response{Success: false, Error: message})
+	}
+}
+
+// sendValidationError sends a request that failed validation as a 400
+// listing each invalid field
+func (h *Handler) sendValidationError(w http.ResponseWriter, err error) {
+	h.sendDecodeError(w, invalidFieldsError(err))
+}
+
+func (h *Handler) sendDecodeError(w http.ResponseWriter, err error) {
+	var reqErr *RequestError
+	if !errors.As(err, &reqErr) {
+		h.sendError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	status := http.StatusBadRequest
+	if errors.Is(err, ErrBodyTooLarge) || errors.Is(err, ErrTooManyItems) {
+		status = http.StatusRequestEntityTooLarge
+	}
+
+	atomic.AddUint64(&h.metrics.ErrorCount, 1)
+	h.logger.Error(reqErr.Message)
+	writeJSON(w, status, Response{Success: false, Error: reqErr.Message, Details: reqErr.Details})
 }
 
 func (h *Handler) updateMetrics(duration time.Duration) {
@@ -194,13 +836,15 @@ func (h *Handler) updateMetrics(duration time.Duration) {
 	h.metrics.AverageLatency = (h.metrics.AverageLatency + duration) / 2
 }
 
-// GetRoutes returns the handler routes
+// GetRoutes returns the handler routes. Routes that need an admin token,
+// such as sending from the server wallet or listing users, are only served
+// by Router.
 func (h *Handler) GetRoutes() map[string]http.HandlerFunc {
 	return map[string]http.HandlerFunc{
-		"/health":             h.loggerMiddleware(h.handleHealth),
-		"/solana/balance":     h.loggerMiddleware(h.handleSolanaBalance),
-		"/solana/transaction": h.loggerMiddleware(h.handleSolanaTransaction),
-		"/openai/completion":  h.loggerMiddleware(h.handleOpenAICompletion),
-		"/metrics":           h.loggerMiddleware(h.handleMetrics),
+		"/health":              h.loggerMiddleware(h.handleHealth),
+		"/solana/balance":      h.loggerMiddleware(h.handleSolanaBalance),
+		"/solana/transactions": h.loggerMiddleware(h.handleSolanaHistory),
+		"/openai/completion":   h.loggerMiddleware(h.handleOpenAICompletion),
+		"/metrics":             h.loggerMiddleware(h.handleMetrics),
 	}
 }
\ No newline at end of file
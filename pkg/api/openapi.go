@@ -0,0 +1,396 @@
+package api
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/labs-alone/alone-main/pkg/csp"
+)
+
+// RouteDoc annotates a route for the generated OpenAPI spec. Request and
+// Response are example values whose types are reflected into schemas;
+// Response describes the data field of the success envelope.
+type RouteDoc struct {
+	Summary     string
+	Description string
+	Tags        []string
+	Query       []ParamDoc
+	Request     interface{}
+	Response    interface{}
+}
+
+// ParamDoc describes a query parameter
+type ParamDoc struct {
+	Name        string
+	Description string
+	Required    bool
+	Type        string // OpenAPI type, defaults to string
+}
+
+// OpenAPISpec is an OpenAPI 3 document
+type OpenAPISpec struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       OpenAPIInfo                      `json:"info"`
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components OpenAPIComponents                `json:"components"`
+}
+
+// OpenAPIInfo holds the API title and version
+type OpenAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// OpenAPIComponents holds shared schemas
+type OpenAPIComponents struct {
+	Schemas map[string]*Schema `json:"schemas"`
+}
+
+// Operation describes a single method on a path
+type Operation struct {
+	Summary     string               `json:"summary,omitempty"`
+	Description string               `json:"description,omitempty"`
+	Tags        []string             `json:"tags,omitempty"`
+	Parameters  []Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*APIReply `json:"responses"`
+}
+
+// Parameter describes a path or query parameter
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required"`
+	Schema      *Schema `json:"schema"`
+}
+
+// RequestBody describes a JSON request body
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+// APIReply describes a response for one status code
+type APIReply struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// MediaType holds the schema for a content type
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is an OpenAPI schema object
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+// Annotate documents the route registered for method and path template
+func (r *Router) Annotate(method, path string, doc RouteDoc) {
+	r.docs[method+" "+path] = doc
+}
+
+// OpenAPISpec generates an OpenAPI 3 spec from the registered routes and
+// their annotations
+func (r *Router) OpenAPISpec() *OpenAPISpec {
+	spec := &OpenAPISpec{
+		OpenAPI: "3.0.3",
+		Info: OpenAPIInfo{
+			Title:   "Alone API",
+			Version: "1.0.0",
+		},
+		Paths: make(map[string]map[string]*Operation),
+		Components: OpenAPIComponents{
+			Schemas: make(map[string]*Schema),
+		},
+	}
+
+	gen := &schemaGenerator{schemas: spec.Components.Schemas}
+	errorSchema := gen.schemaFor(reflect.TypeOf(Response{}))
+
+	r.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+
+		for _, method := range methods {
+			doc := r.docs[method+" "+path]
+			op := &Operation{
+				Summary:     doc.Summary,
+				Description: doc.Description,
+				Tags:        doc.Tags,
+				Parameters:  pathParameters(path),
+				Responses: map[string]*APIReply{
+					"200": {
+						Description: "Success",
+						Content: map[string]MediaType{
+							"application/json": {Schema: gen.envelope(doc.Response)},
+						},
+					},
+					"default": {
+						Description: "Error",
+						Content: map[string]MediaType{
+							"application/json": {Schema: errorSchema},
+						},
+					},
+				},
+			}
+			if op.Summary == "" {
+				op.Summary = method + " " + path
+			}
+
+			for _, q := range doc.Query {
+				typ := q.Type
+				if typ == "" {
+					typ = "string"
+				}
+				op.Parameters = append(op.Parameters, Parameter{
+					Name:        q.Name,
+					In:          "query",
+					Description: q.Description,
+					Required:    q.Required,
+					Schema:      &Schema{Type: typ},
+				})
+			}
+
+			if doc.Request != nil {
+				op.RequestBody = &RequestBody{
+					Required: true,
+					Content: map[string]MediaType{
+						"application/json": {Schema: gen.schemaFor(reflect.TypeOf(doc.Request))},
+					},
+				}
+			}
+
+			if spec.Paths[path] == nil {
+				spec.Paths[path] = make(map[string]*Operation)
+			}
+			spec.Paths[path][strings.ToLower(method)] = op
+		}
+		return nil
+	})
+
+	return spec
+}
+
+var pathParamPattern = regexp.MustCompile(`\{([^}:]+)(?::[^}]+)?\}`)
+
+// pathParameters returns the parameters for {name} segments in a template
+func pathParameters(path string) []Parameter {
+	var params []Parameter
+	for _, match := range pathParamPattern.FindAllStringSubmatch(path, -1) {
+		params = append(params, Parameter{
+			Name:     match[1],
+			In:       "path",
+			Required: true,
+			Schema:   &Schema{Type: "string"},
+		})
+	}
+	return params
+}
+
+// schemaGenerator reflects Go types into schemas, registering named structs
+// as shared components
+type schemaGenerator struct {
+	schemas map[string]*Schema
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// envelope wraps the schema for data in the standard success Response
+func (g *schemaGenerator) envelope(data interface{}) *Schema {
+	schema := &Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			"success": {Type: "boolean"},
+		},
+		Required: []string{"success"},
+	}
+	if data != nil {
+		schema.Properties["data"] = g.schemaFor(reflect.TypeOf(data))
+	}
+	return schema
+}
+
+func (g *schemaGenerator) schemaFor(t reflect.Type) *Schema {
+	if t == nil {
+		return &Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := g.schemaFor(t.Elem())
+		if schema.Ref != "" {
+			return schema
+		}
+		schema.Nullable = true
+		return schema
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		zero := 0.0
+		return &Schema{Type: "integer", Format: "int64", Minimum: &zero}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: g.schemaFor(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.schemaFor(t.Elem())}
+	case reflect.Struct:
+		if t == timeType {
+			return &Schema{Type: "string", Format: "date-time"}
+		}
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+
+		name := schemaName(t)
+		if _, ok := g.schemas[name]; !ok {
+			// Register before recursing so self-referencing types terminate
+			g.schemas[name] = &Schema{}
+			*g.schemas[name] = *g.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + name}
+	default:
+		// interface{} and anything else accepts any value
+		return &Schema{}
+	}
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) *Schema {
+	schema := &Schema{
+		Type:       "object",
+		Properties: make(map[string]*Schema),
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+
+		// Embedded structs without a name have their fields promoted
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			embedded := g.structSchema(field.Type)
+			for k, v := range embedded.Properties {
+				schema.Properties[k] = v
+			}
+			schema.Required = append(schema.Required, embedded.Required...)
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		schema.Properties[name] = g.schemaFor(field.Type)
+		if !strings.Contains(opts, "omitempty") {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+
+	sort.Strings(schema.Required)
+	return schema
+}
+
+// schemaName turns a type name into a component name, dropping package
+// paths from generic type arguments, e.g. ListResponse[core.User] becomes
+// ListResponse_User
+func schemaName(t reflect.Type) string {
+	name := t.Name()
+	open := strings.Index(name, "[")
+	if open < 0 {
+		return name
+	}
+
+	args := strings.Split(strings.TrimSuffix(name[open+1:], "]"), ",")
+	for i, arg := range args {
+		if dot := strings.LastIndex(arg, "."); dot >= 0 {
+			arg = arg[dot+1:]
+		}
+		args[i] = strings.Trim(arg, "*[] ")
+	}
+	return name[:open] + "_" + strings.Join(args, "_")
+}
+
+// swaggerUI loads Swagger UI from a CDN pointed at the generated spec. Its
+// scripts carry the request's CSP nonce.
+var swaggerUI = template.Must(template.New("docs").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Alone API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script nonce="{{.Nonce}}" src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script nonce="{{.Nonce}}">
+    window.ui = SwaggerUIBundle({ url: "swagger.json", dom_id: "#swagger-ui" });
+  </script>
+</body>
+</html>
+`))
+
+// docsPolicy is the CSP of the docs page. Scripts need the nonce; Swagger
+// UI's stylesheet comes from the CDN and it sets inline styles.
+const docsPolicy = "default-src 'self'; script-src 'nonce-" + csp.NoncePlaceholder + "'; " +
+	"style-src 'self' 'unsafe-inline' https://unpkg.com; img-src 'self' data:; object-src 'none'; base-uri 'self'"
+
+func (r *Router) handleDocs() http.Handler {
+	docs := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		data := struct{ Nonce string }{csp.NonceFromContext(req.Context())}
+		if err := swaggerUI.Execute(w, data); err != nil {
+			r.logger.Error("Failed to render docs", "error", err)
+		}
+	})
+	return csp.Nonce(docsPolicy, r.logger)(docs)
+}
+
+func (r *Router) handleSwagger() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(r.OpenAPISpec()); err != nil {
+			r.handler.sendError(w, "failed to encode spec: "+err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
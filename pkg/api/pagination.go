@@ -0,0 +1,89 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/labs-alone/alone-main/internal/validate"
+)
+
+const (
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+// ListResponse is the envelope shared by all list endpoints. Page-numbered
+// lists set Page and Total; cursor-based lists set NextCursor only.
+type ListResponse[T any] struct {
+	Items      []T    `json:"items"`
+	Page       int    `json:"page,omitempty"`
+	PerPage    int    `json:"per_page"`
+	Total      int    `json:"total,omitempty"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// NewListResponse builds a page-numbered envelope. NextCursor holds the next
+// page number while more items remain.
+func NewListResponse[T any](items []T, page, perPage, total int) ListResponse[T] {
+	if items == nil {
+		items = []T{}
+	}
+
+	resp := ListResponse[T]{
+		Items:   items,
+		Page:    page,
+		PerPage: perPage,
+		Total:   total,
+	}
+	if page*perPage < total {
+		resp.NextCursor = strconv.Itoa(page + 1)
+	}
+	return resp
+}
+
+// NewCursorListResponse builds a cursor-based envelope for sources that
+// can't report a total
+func NewCursorListResponse[T any](items []T, perPage int, nextCursor string) ListResponse[T] {
+	if items == nil {
+		items = []T{}
+	}
+
+	return ListResponse[T]{
+		Items:      items,
+		PerPage:    perPage,
+		NextCursor: nextCursor,
+	}
+}
+
+// Pagination holds the parsed list query parameters
+type Pagination struct {
+	Page    int
+	PerPage int
+	Cursor  string
+}
+
+// Offset returns the index of the first item on the page
+func (p Pagination) Offset() int {
+	return (p.Page - 1) * p.PerPage
+}
+
+// parsePagination reads page, per_page and cursor from the query string.
+// Invalid parameters are returned as validate.Errors.
+func parsePagination(r *http.Request) (Pagination, error) {
+	query := r.URL.Query()
+	p := Pagination{
+		Page:    1,
+		PerPage: defaultPerPage,
+		Cursor:  query.Get("cursor"),
+	}
+
+	var v validate.Validator
+	if s := query.Get("page"); s != "" {
+		p.Page, _ = validate.Int(&v, "page", s, validate.Min(1))
+	}
+	if s := query.Get("per_page"); s != "" {
+		p.PerPage, _ = validate.Int(&v, "per_page", s, validate.Between(1, maxPerPage))
+	}
+
+	return p, v.Err()
+}
@@ -1,21 +1,34 @@
 package api
 
 import (
-	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/labs-alone/alone-main/internal/core"
+	"github.com/labs-alone/alone-main/internal/openai"
+	"github.com/labs-alone/alone-main/internal/solana"
 	"github.com/labs-alone/alone-main/internal/utils"
+	"github.com/labs-alone/alone-main/pkg/auth"
+	"github.com/labs-alone/alone-main/pkg/logger"
+	"github.com/labs-alone/alone-main/pkg/maintenance"
 )
 
 // Router manages API routing
 type Router struct {
 	router  *mux.Router
 	handler *Handler
-	logger  *utils.Logger
+	logger  logger.Logger
 	config  *utils.Config
+	docs    map[string]RouteDoc
+
+	// authenticate verifies bearer tokens; see SetAuthenticator
+	authenticate func(http.Handler) http.Handler
+	maintenance  *maintenance.Mode
+	// hsts is the Strict-Transport-Security header value, empty to omit it
+	hsts string
 }
 
 // RouterConfig holds router configuration
@@ -35,14 +48,63 @@ func NewRouter(handler *Handler, config *utils.Config) *Router {
 		handler: handler,
 		logger:  utils.NewLogger(),
 		config:  config,
+		docs:    make(map[string]RouteDoc),
+	}
+	r.maintenance = maintenance.New(config.Maintenance.Enabled, config.Maintenance.AllowedUsers, r.logger)
+	r.hsts = hstsHeader(config)
+
+	// Identical Solana reads are always coalesced; the cache setting also
+	// keeps their responses for the TTL
+	if config.Cache.Enabled && config.Cache.TTL > 0 {
+		handler.coalescer.ttl = time.Duration(config.Cache.TTL) * time.Second
 	}
+	if config.Cache.MaxEntries > 0 {
+		handler.coalescer.maxEntries = config.Cache.MaxEntries
+	}
+	r.followConfig()
 
 	r.setupRoutes()
+	r.warnDuplicateRoutes()
+	r.setupDocs()
 	r.setupMiddleware()
 
 	return r
 }
 
+// followConfig applies runtime config patches to the loggers and the
+// response cache
+func (r *Router) followConfig() {
+	if l, ok := r.logger.(*utils.Logger); ok {
+		l.FollowLevel(r.config)
+	}
+	if l, ok := r.handler.logger.(*utils.Logger); ok {
+		l.FollowLevel(r.config)
+	}
+
+	coalescer := r.handler.coalescer
+	r.config.OnPatch(func(patched *utils.Config, keys []string) {
+		for _, key := range keys {
+			if key == "cache.ttl" && patched.Cache.Enabled {
+				coalescer.setTTL(time.Duration(patched.Cache.TTL) * time.Second)
+			}
+		}
+	})
+}
+
+// SetAuthenticator configures the middleware verifying bearer tokens, such
+// as the internal middleware package's AuthMiddleware.Authenticate. It must
+// store the verified claims with auth.WithClaims. Requests without an
+// Authorization header skip it and are served anonymously.
+func (r *Router) SetAuthenticator(authenticate func(http.Handler) http.Handler) {
+	r.authenticate = authenticate
+}
+
+// Maintenance returns the maintenance mode built from the config, so it can
+// be toggled at runtime or shared with the admin router
+func (r *Router) Maintenance() *maintenance.Mode {
+	return r.maintenance
+}
+
 // setupRoutes configures all API routes
 func (r *Router) setupRoutes() {
 	// API version prefix
@@ -50,33 +112,211 @@ func (r *Router) setupRoutes() {
 
 	// Health and metrics
 	api.HandleFunc("/health", r.handler.handleHealth).Methods(http.MethodGet)
+	api.HandleFunc("/ready", r.handler.handleReady).Methods(http.MethodGet)
 	api.HandleFunc("/metrics", r.handler.handleMetrics).Methods(http.MethodGet)
+	api.HandleFunc("/users", r.requireRole("admin", r.handler.handleListUsers)).Methods(http.MethodGet)
+	api.HandleFunc("/selfcheck", r.handleSelfCheck()).Methods(http.MethodGet)
 
 	// Solana endpoints
 	solana := api.PathPrefix("/solana").Subrouter()
+	solana.Use(r.coalesceMiddleware)
 	solana.HandleFunc("/balance", r.handler.handleSolanaBalance).Methods(http.MethodGet)
-	solana.HandleFunc("/transaction", r.handler.handleSolanaTransaction).Methods(http.MethodPost)
+	solana.HandleFunc("/transaction", r.requireRole("admin", r.handler.handleSolanaTransaction)).Methods(http.MethodPost)
+	solana.HandleFunc("/transaction/resubmit", r.requireRole("admin", r.handler.handleSolanaResubmit)).Methods(http.MethodPost)
+	solana.HandleFunc("/transaction/build", r.handler.handleSolanaBuildTransaction).Methods(http.MethodPost)
+	solana.HandleFunc("/transaction/submit", r.handler.handleSolanaSubmitTransaction).Methods(http.MethodPost)
+	solana.HandleFunc("/transactions", r.handler.handleSolanaHistory).Methods(http.MethodGet)
+	solana.HandleFunc("/swap", r.handler.handleSolanaSwap).Methods(http.MethodPost)
+	solana.HandleFunc("/swap/execute", r.requireRole("admin", r.handler.handleSolanaExecuteSwap)).Methods(http.MethodPost)
 	solana.HandleFunc("/account/{address}", r.handleSolanaAccount()).Methods(http.MethodGet)
 	solana.HandleFunc("/transaction/{signature}", r.handleSolanaTransactionStatus()).Methods(http.MethodGet)
 
 	// OpenAI endpoints
 	ai := api.PathPrefix("/ai").Subrouter()
 	ai.HandleFunc("/completion", r.handler.handleOpenAICompletion).Methods(http.MethodPost)
+	ai.HandleFunc("/completion/batch", r.handler.handleOpenAIBatchCompletion).Methods(http.MethodPost)
 	ai.HandleFunc("/analyze", r.handleAIAnalysis()).Methods(http.MethodPost)
 
 	// Documentation
-	api.HandleFunc("/docs", r.handleDocs()).Methods(http.MethodGet)
+	api.Handle("/docs", r.handleDocs()).Methods(http.MethodGet)
 	api.HandleFunc("/swagger.json", r.handleSwagger()).Methods(http.MethodGet)
 }
 
+// warnDuplicateRoutes logs each method and path registered more than once.
+// mux only ever serves the first, so the others are wiring mistakes.
+func (r *Router) warnDuplicateRoutes() {
+	seen := make(map[string]bool)
+	r.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+
+		for _, method := range methods {
+			key := method + " " + path
+			if seen[key] {
+				r.logger.Warn("Duplicate route registered",
+					"method", method,
+					"path", path,
+				)
+			}
+			seen[key] = true
+		}
+		return nil
+	})
+}
+
+// setupDocs annotates routes for the generated OpenAPI spec
+func (r *Router) setupDocs() {
+	address := ParamDoc{Name: "address", Description: "Base58 account address", Required: true}
+	pagination := []ParamDoc{
+		{Name: "page", Description: "Page number, starting at 1", Type: "integer"},
+		{Name: "per_page", Description: "Items per page, at most 100", Type: "integer"},
+	}
+
+	r.Annotate(http.MethodGet, "/api/v1/health", RouteDoc{
+		Summary: "Service health",
+		Tags:    []string{"system"},
+	})
+	r.Annotate(http.MethodGet, "/api/v1/ready", RouteDoc{
+		Summary:     "Service readiness",
+		Description: "Returns 503 while the Solana RPC node is unhealthy.",
+		Tags:        []string{"system"},
+	})
+	r.Annotate(http.MethodGet, "/api/v1/metrics", RouteDoc{
+		Summary:     "API, Solana and OpenAI usage metrics",
+		Description: "The coalescing section reports, per method and route, the Solana reads answered from an identical request in flight or from the response cache; coalescing_cache reports the size of that cache and its evictions.",
+		Tags:        []string{"system"},
+	})
+	r.Annotate(http.MethodGet, "/api/v1/selfcheck", RouteDoc{
+		Summary:     "Subsystem self-check report",
+		Description: "Returns the latest report without check messages. refresh=true runs the checks again and returns their messages, and requires an admin token. Failed reports are returned with 503.",
+		Tags:        []string{"system"},
+		Query: []ParamDoc{
+			{Name: "refresh", Description: "true to run the checks again", Type: "boolean"},
+		},
+		Response: SelfCheckReport{},
+	})
+	r.Annotate(http.MethodGet, "/api/v1/users", RouteDoc{
+		Summary:     "List users",
+		Description: "Requires an admin token, as users include their email addresses.",
+		Tags:        []string{"users"},
+		Query:       pagination,
+		Response:    ListResponse[core.User]{},
+	})
+	r.Annotate(http.MethodGet, "/api/v1/solana/balance", RouteDoc{
+		Summary:     "Get an account balance",
+		Description: "Returns lamports as a number by default, or SOL as a decimal string with unit=sol.",
+		Tags:        []string{"solana"},
+		Query: []ParamDoc{
+			address,
+			{Name: "unit", Description: "lamports (default) or sol"},
+			{Name: "commitment", Description: "processed, confirmed or finalized; defaults to the client commitment"},
+		},
+		Response: uint64(0),
+	})
+	r.Annotate(http.MethodPost, "/api/v1/solana/transaction", RouteDoc{
+		Summary:     "Send a transfer from the server wallet",
+		Description: "Requires an admin token. The sender must be the server wallet; build a transaction to send from another account. With an idempotency_key the transfer is tracked so it can be resubmitted if it expires.",
+		Tags:        []string{"solana"},
+		Request:     TransactionRequest{},
+		Response:    map[string]string{},
+	})
+	r.Annotate(http.MethodPost, "/api/v1/solana/transaction/resubmit", RouteDoc{
+		Summary:     "Resubmit an expired transfer by idempotency key",
+		Description: "Requires an admin token, as transfers from the server wallet are signed and sent again.",
+		Tags:        []string{"solana"},
+		Request:     ResubmitRequest{},
+		Response:    solana.ResubmitResult{},
+	})
+	r.Annotate(http.MethodPost, "/api/v1/solana/transaction/build", RouteDoc{
+		Summary:     "Build an unsigned transfer for the client to sign",
+		Description: "With an idempotency_key the transfer is tracked so it can be resubmitted if it expires.",
+		Tags:        []string{"solana"},
+		Request:     TransactionRequest{},
+		Response:    solana.UnsignedTransfer{},
+	})
+	r.Annotate(http.MethodPost, "/api/v1/solana/transaction/submit", RouteDoc{
+		Summary:     "Submit a client-signed transaction",
+		Description: "A transfer built with an idempotency_key must be submitted with it, so a resubmission can see whether it landed.",
+		Tags:        []string{"solana"},
+		Request:     SubmitRequest{},
+		Response:    map[string]string{},
+	})
+	r.Annotate(http.MethodPost, "/api/v1/solana/swap", RouteDoc{
+		Summary:     "Build a token swap",
+		Description: "Routes the swap through the configured quote API and returns the transaction unsigned for the wallet in user_public_key to sign.",
+		Tags:        []string{"solana"},
+		Request:     SwapRequest{},
+		Response:    solana.UnsignedSwap{},
+	})
+	r.Annotate(http.MethodPost, "/api/v1/solana/swap/execute", RouteDoc{
+		Summary:     "Swap tokens from the server wallet",
+		Description: "Requires an admin token and a program allowlist. The transaction from the quote API is checked against its quote before the server wallet signs and sends it.",
+		Tags:        []string{"solana"},
+		Request:     SwapRequest{},
+		Response:    map[string]interface{}{},
+	})
+	r.Annotate(http.MethodGet, "/api/v1/solana/transactions", RouteDoc{
+		Summary: "List transaction signatures for an address",
+		Tags:    []string{"solana"},
+		Query: []ParamDoc{
+			address,
+			{Name: "per_page", Description: "Items per page, at most 100", Type: "integer"},
+			{Name: "cursor", Description: "Signature to list transactions before"},
+		},
+		Response: ListResponse[solana.SignatureInfo]{},
+	})
+	r.Annotate(http.MethodGet, "/api/v1/solana/account/{address}", RouteDoc{
+		Summary: "Get account information",
+		Tags:    []string{"solana"},
+	})
+	r.Annotate(http.MethodGet, "/api/v1/solana/transaction/{signature}", RouteDoc{
+		Summary: "Get transaction status",
+		Tags:    []string{"solana"},
+	})
+	r.Annotate(http.MethodPost, "/api/v1/ai/completion", RouteDoc{
+		Summary:  "Create a chat completion",
+		Tags:     []string{"ai"},
+		Request:  CompletionRequest{},
+		Response: openai.ChatCompletionResponse{},
+	})
+	r.Annotate(http.MethodPost, "/api/v1/ai/completion/batch", RouteDoc{
+		Summary:     "Create chat completions for a batch of prompts",
+		Description: "Takes a JSON array of at most 50 completion requests of up to 64KiB each.",
+		Tags:        []string{"ai"},
+		Request:     []CompletionRequest{},
+		Response:    []BatchCompletionResult{},
+	})
+	r.Annotate(http.MethodPost, "/api/v1/ai/analyze", RouteDoc{
+		Summary: "Analyze content",
+		Tags:    []string{"ai"},
+	})
+	r.Annotate(http.MethodGet, "/api/v1/docs", RouteDoc{
+		Summary: "Swagger UI",
+		Tags:    []string{"docs"},
+	})
+	r.Annotate(http.MethodGet, "/api/v1/swagger.json", RouteDoc{
+		Summary: "OpenAPI specification",
+		Tags:    []string{"docs"},
+	})
+}
+
 // setupMiddleware configures global middleware
 func (r *Router) setupMiddleware() {
 	r.router.Use(r.loggingMiddleware)
 	r.router.Use(r.recoveryMiddleware)
 	r.router.Use(r.corsMiddleware)
 	r.router.Use(r.securityMiddleware)
+	r.router.Use(r.versionMiddleware)
 	r.router.Use(r.rateLimitMiddleware)
 	r.router.Use(r.timeoutMiddleware)
+	r.router.Use(r.authMiddleware)
+	r.router.Use(r.maintenance.Handle)
 }
 
 // Middleware implementations
@@ -91,13 +331,12 @@ func (r *Router) loggingMiddleware(next http.Handler) http.Handler {
 
 		duration := time.Since(start)
 		r.logger.Info("Request processed",
-			map[string]interface{}{
-				"method":   req.Method,
-				"path":     req.URL.Path,
-				"status":   rw.status,
-				"duration": duration,
-				"ip":       req.RemoteAddr,
-			})
+			"method", req.Method,
+			"path", req.URL.Path,
+			"status", rw.status,
+			"duration", duration,
+			"ip", req.RemoteAddr,
+		)
 	})
 }
 
@@ -105,8 +344,7 @@ func (r *Router) recoveryMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		defer func() {
 			if err := recover(); err != nil {
-				r.logger.Error("Panic recovered",
-					map[string]interface{}{"error": fmt.Sprint(err)})
+				r.logger.Error("Panic recovered", "error", fmt.Sprint(err))
 				http.Error(w, "Internal server error", http.StatusInternalServerError)
 			}
 		}()
@@ -134,20 +372,79 @@ func (r *Router) securityMiddleware(next http.Handler) http.Handler {
 		w.Header().Set("X-Content-Type-Options", "nosniff")
 		w.Header().Set("X-Frame-Options", "DENY")
 		w.Header().Set("X-XSS-Protection", "1; mode=block")
-		w.Header().Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
+		if r.hsts != "" {
+			w.Header().Set("Strict-Transport-Security", r.hsts)
+		}
 
 		next.ServeHTTP(w, req)
 	})
 }
 
+// defaultHSTSMaxAge is the HSTS max-age, in seconds, used when the config
+// sets none
+const defaultHSTSMaxAge = 365 * 24 * 60 * 60
+
+// hstsHeader builds the Strict-Transport-Security header from the security
+// config
+func hstsHeader(config *utils.Config) string {
+	maxAge := config.Security.HSTSMaxAge
+	switch {
+	case maxAge < 0:
+		return ""
+	case maxAge == 0:
+		maxAge = defaultHSTSMaxAge
+	}
+	hsts := fmt.Sprintf("max-age=%d", maxAge)
+	if !config.Security.HSTSExcludeSubdomains {
+		hsts += "; includeSubDomains"
+	}
+	return hsts
+}
+
 func (r *Router) rateLimitMiddleware(next http.Handler) http.Handler {
 	// Implement rate limiting logic here
 	return next
 }
 
+// authMiddleware verifies the bearer token of requests carrying one, so the
+// maintenance allowlist and routes requiring auth see the caller's claims
+func (r *Router) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if r.authenticate == nil || req.Header.Get("Authorization") == "" {
+			next.ServeHTTP(w, req)
+			return
+		}
+		r.authenticate(next).ServeHTTP(w, req)
+	})
+}
+
+// requireRole serves next only to requests authenticated with role, or to
+// any authenticated request if role is empty. Without an authenticator the
+// route is unavailable rather than open.
+func (r *Router) requireRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if r.authenticate == nil {
+			r.handler.sendError(w, "authentication not configured", http.StatusServiceUnavailable)
+			return
+		}
+		if _, ok := auth.ClaimsFromContext(req.Context()); !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			r.handler.sendError(w, "authentication required", http.StatusUnauthorized)
+			return
+		}
+		if role != "" && auth.Role(req.Context()) != role {
+			r.handler.sendError(w, "insufficient permissions", http.StatusForbidden)
+			return
+		}
+		next(w, req)
+	}
+}
+
+// timeoutMiddleware gives each request a deadline budget that the Solana
+// and OpenAI calls made while serving it share
 func (r *Router) timeoutMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		ctx, cancel := context.WithTimeout(req.Context(), 30*time.Second)
+		ctx, cancel := utils.WithBudget(req.Context(), 30*time.Second)
 		defer cancel()
 
 		next.ServeHTTP(w, req.WithContext(ctx))
@@ -173,24 +470,44 @@ func (r *Router) handleSolanaTransactionStatus() http.HandlerFunc {
 	}
 }
 
-func (r *Router) handleAIAnalysis() http.HandlerFunc {
+// handleSelfCheck serves the latest self-check report with its messages
+// redacted. Running the checks again with refresh=true, which also returns
+// the messages, requires an admin token.
+func (r *Router) handleSelfCheck() http.HandlerFunc {
+	refresh := r.requireRole("admin", func(w http.ResponseWriter, req *http.Request) {
+		report := r.handler.refreshSelfCheck(req.Context(), r.config, true)
+		r.writeSelfCheck(w, report)
+	})
+
 	return func(w http.ResponseWriter, req *http.Request) {
-		// Implement AI analysis
-		r.handler.sendJSON(w, Response{Success: true, Data: "Analysis completed"})
+		if req.URL.Query().Get("refresh") == "true" {
+			refresh(w, req)
+			return
+		}
+
+		report := r.handler.latestSelfCheck()
+		if report == nil {
+			fresh := r.handler.refreshSelfCheck(req.Context(), r.config, false)
+			report = &fresh
+		}
+		r.writeSelfCheck(w, report.redacted())
 	}
 }
 
-func (r *Router) handleDocs() http.HandlerFunc {
-	return func(w http.ResponseWriter, req *http.Request) {
-		// Serve API documentation
-		r.handler.sendJSON(w, Response{Success: true, Data: "API Documentation"})
+func (r *Router) writeSelfCheck(w http.ResponseWriter, report SelfCheckReport) {
+	if !report.Passed {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(Response{Success: false, Error: "self-check failed", Data: report})
+		return
 	}
+	r.handler.sendJSON(w, Response{Success: true, Data: report})
 }
 
-func (r *Router) handleSwagger() http.HandlerFunc {
+func (r *Router) handleAIAnalysis() http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
-		// Serve Swagger JSON
-		r.handler.sendJSON(w, Response{Success: true, Data: "Swagger specification"})
+		// Implement AI analysis
+		r.handler.sendJSON(w, Response{Success: true, Data: "Analysis completed"})
 	}
 }
 
@@ -0,0 +1,184 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/labs-alone/alone-main/internal/openai"
+	"github.com/labs-alone/alone-main/internal/utils"
+)
+
+// selfCheckTimeout bounds each subsystem check so a hung dependency can't
+// stall startup
+const selfCheckTimeout = 5 * time.Second
+
+// CheckStatus is the outcome of a single self-check
+type CheckStatus string
+
+const (
+	CheckPass CheckStatus = "pass"
+	CheckFail CheckStatus = "fail"
+	// CheckSkip means the subsystem isn't configured
+	CheckSkip CheckStatus = "skip"
+)
+
+// CheckResult reports one subsystem in a self-check
+type CheckResult struct {
+	Name   string      `json:"name"`
+	Status CheckStatus `json:"status"`
+	// Critical checks fail the report; others only warn
+	Critical bool   `json:"critical"`
+	Message  string `json:"message,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// SelfCheckReport is the result of checking every subsystem
+type SelfCheckReport struct {
+	// Passed is false if any critical check failed
+	Passed    bool          `json:"passed"`
+	Checks    []CheckResult `json:"checks"`
+	CheckedAt time.Time     `json:"checked_at"`
+}
+
+// Failed returns the critical checks that failed
+func (r SelfCheckReport) Failed() []CheckResult {
+	var failed []CheckResult
+	for _, check := range r.Checks {
+		if check.Critical && check.Status == CheckFail {
+			failed = append(failed, check)
+		}
+	}
+	return failed
+}
+
+// DatabasePinger is the database connection checked by SelfCheck, satisfied
+// by *sql.DB
+type DatabasePinger interface {
+	PingContext(ctx context.Context) error
+}
+
+// SetDatabase configures the database checked by SelfCheck
+func (h *Handler) SetDatabase(db DatabasePinger) {
+	h.db = db
+}
+
+// SelfCheck validates config, pings the database, checks the Solana node's
+// health and verifies the OpenAI key format. Subsystems that aren't
+// configured are skipped.
+func (h *Handler) SelfCheck(ctx context.Context, config *utils.Config) SelfCheckReport {
+	report := SelfCheckReport{Passed: true, CheckedAt: time.Now()}
+
+	run := func(name string, critical bool, configured bool, check func(ctx context.Context) error) {
+		result := CheckResult{Name: name, Critical: critical, Status: CheckSkip, Duration: "0s"}
+		if configured {
+			checkCtx, cancel := context.WithTimeout(ctx, selfCheckTimeout)
+			start := time.Now()
+			err := check(checkCtx)
+			cancel()
+
+			result.Duration = time.Since(start).String()
+			result.Status = CheckPass
+			if err != nil {
+				result.Status = CheckFail
+				result.Message = err.Error()
+				if critical {
+					report.Passed = false
+				}
+			}
+		}
+		report.Checks = append(report.Checks, result)
+	}
+
+	run("config", true, config != nil, func(context.Context) error {
+		return config.Validate()
+	})
+	run("database", true, h.db != nil, func(ctx context.Context) error {
+		return h.db.PingContext(ctx)
+	})
+	run("solana", true, h.solana != nil, func(ctx context.Context) error {
+		return h.solana.Health(ctx)
+	})
+	run("openai", false, config != nil, func(context.Context) error {
+		return openai.ValidateAPIKey(config.OpenAI.APIKey)
+	})
+
+	return report
+}
+
+// RunSelfCheck runs SelfCheck at startup, before serving, logging one line
+// per subsystem. The report is kept for the self-check endpoint. If a
+// critical check fails it returns an error when the config asks to fail
+// fast, and otherwise logs a warning and carries on.
+func (h *Handler) RunSelfCheck(ctx context.Context, config *utils.Config) (SelfCheckReport, error) {
+	report := h.SelfCheck(ctx, config)
+	h.setLastSelfCheck(report)
+
+	for _, check := range report.Checks {
+		fields := []interface{}{
+			"check", check.Name,
+			"status", check.Status,
+			"duration", check.Duration,
+		}
+		switch {
+		case check.Status != CheckFail:
+			h.logger.Info("Self-check", fields...)
+		case check.Critical:
+			h.logger.Error("Self-check", append(fields, "error", check.Message)...)
+		default:
+			h.logger.Warn("Self-check", append(fields, "error", check.Message)...)
+		}
+	}
+
+	if report.Passed {
+		return report, nil
+	}
+
+	failed := report.Failed()
+	if config != nil && config.Startup.FailOnSelfCheck {
+		return report, fmt.Errorf("self-check failed: %s: %s", failed[0].Name, failed[0].Message)
+	}
+	h.logger.Warn("Starting with failed self-checks", "failed", len(failed))
+	return report, nil
+}
+
+// setLastSelfCheck keeps report for the self-check endpoint
+func (h *Handler) setLastSelfCheck(report SelfCheckReport) {
+	h.selfCheckMu.Lock()
+	defer h.selfCheckMu.Unlock()
+	h.lastSelfCheck = &report
+}
+
+// latestSelfCheck returns the kept report, or nil if no check has run
+func (h *Handler) latestSelfCheck() *SelfCheckReport {
+	h.selfCheckMu.Lock()
+	defer h.selfCheckMu.Unlock()
+	return h.lastSelfCheck
+}
+
+// refreshSelfCheck runs SelfCheck and keeps the report. Unless force is
+// set, a report kept while waiting for another run is returned instead, so
+// concurrent requests share one run.
+func (h *Handler) refreshSelfCheck(ctx context.Context, config *utils.Config, force bool) SelfCheckReport {
+	h.selfCheckRun.Lock()
+	defer h.selfCheckRun.Unlock()
+
+	if report := h.latestSelfCheck(); report != nil && !force {
+		return *report
+	}
+	report := h.SelfCheck(ctx, config)
+	h.setLastSelfCheck(report)
+	return report
+}
+
+// redacted returns the report without check messages, which can carry
+// dependency errors such as connection strings, for anonymous callers
+func (r SelfCheckReport) redacted() SelfCheckReport {
+	checks := make([]CheckResult, len(r.Checks))
+	for i, check := range r.Checks {
+		check.Message = ""
+		checks[i] = check
+	}
+	r.Checks = checks
+	return r
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// APIVersionHeader carries the requested API version, e.g. "v1" or "1",
+// and stamps the negotiated version on responses
+const APIVersionHeader = "X-API-Version"
+
+// DefaultAPIVersion is used when neither the request headers nor the URL
+// name a version
+const DefaultAPIVersion = "v1"
+
+// supportedAPIVersions lists the versions the API serves
+var supportedAPIVersions = []string{"v1"}
+
+var (
+	// vendorMediaType matches a versioned media type such as
+	// application/vnd.alone.v2+json
+	vendorMediaType = regexp.MustCompile(`^application/vnd\.alone\.(v[0-9]+)\+json$`)
+	// pathVersion matches the version prefix of an API path
+	pathVersion = regexp.MustCompile(`^/api/(v[0-9]+)(/|$)`)
+)
+
+type apiVersionKey struct{}
+
+// APIVersion returns the version negotiated for the request, so handlers
+// can branch on it
+func APIVersion(ctx context.Context) string {
+	if version, ok := ctx.Value(apiVersionKey{}).(string); ok {
+		return version
+	}
+	return DefaultAPIVersion
+}
+
+// negotiateVersion picks the version a request asks for. The X-API-Version
+// header wins over a versioned Accept media type, which wins over the URL
+// prefix. The second result is false if the request only names versions the
+// API doesn't support.
+func negotiateVersion(req *http.Request) (string, bool) {
+	if header := strings.TrimSpace(req.Header.Get(APIVersionHeader)); header != "" {
+		version := strings.ToLower(header)
+		if !strings.HasPrefix(version, "v") {
+			version = "v" + version
+		}
+		return version, isSupportedVersion(version)
+	}
+
+	// Take the first supported version from the Accept header. Clients
+	// asking only for versions we don't serve can't be satisfied.
+	var requested string
+	for _, part := range strings.Split(req.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		match := vendorMediaType.FindStringSubmatch(mediaType)
+		if match == nil {
+			continue
+		}
+		if isSupportedVersion(match[1]) {
+			return match[1], true
+		}
+		if requested == "" {
+			requested = match[1]
+		}
+	}
+	if requested != "" {
+		return requested, false
+	}
+
+	if match := pathVersion.FindStringSubmatch(req.URL.Path); match != nil {
+		return match[1], isSupportedVersion(match[1])
+	}
+	return DefaultAPIVersion, true
+}
+
+func isSupportedVersion(version string) bool {
+	for _, supported := range supportedAPIVersions {
+		if version == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// versionMiddleware negotiates the API version, rejecting unsupported
+// versions with 406, and records the result in the request context and the
+// X-API-Version response header
+func (r *Router) versionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		version, ok := negotiateVersion(req)
+		if !ok {
+			r.handler.sendErrorDetails(w,
+				fmt.Sprintf("unsupported API version %q", version),
+				map[string]interface{}{"supported": supportedAPIVersions},
+				http.StatusNotAcceptable,
+			)
+			return
+		}
+
+		w.Header().Set(APIVersionHeader, version)
+		ctx := context.WithValue(req.Context(), apiVersionKey{}, version)
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
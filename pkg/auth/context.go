@@ -0,0 +1,44 @@
+// Package auth carries the claims of an authenticated request in its
+// context, so handlers read them the same way whichever auth middleware
+// verified the token.
+package auth
+
+import (
+	"context"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type claimsKey struct{}
+
+// WithClaims returns a copy of ctx carrying the verified token claims.
+// Claims from any jwt version's MapClaims can be passed as is.
+func WithClaims(ctx context.Context, claims map[string]interface{}) context.Context {
+	return context.WithValue(ctx, claimsKey{}, jwt.MapClaims(claims))
+}
+
+// ClaimsFromContext returns the claims stored by WithClaims, and false if
+// the request wasn't authenticated
+func ClaimsFromContext(ctx context.Context) (jwt.MapClaims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(jwt.MapClaims)
+	return claims, ok && claims != nil
+}
+
+// UserID returns the "user_id" claim, or "" if there isn't one
+func UserID(ctx context.Context) string {
+	return stringClaim(ctx, "user_id")
+}
+
+// Role returns the "role" claim, or "" if there isn't one
+func Role(ctx context.Context) string {
+	return stringClaim(ctx, "role")
+}
+
+func stringClaim(ctx context.Context, name string) string {
+	claims, ok := ClaimsFromContext(ctx)
+	if !ok {
+		return ""
+	}
+	value, _ := claims[name].(string)
+	return value
+}
@@ -0,0 +1,179 @@
+// Package logger defines the logging interface the rest of the module
+// depends on. Both the zap logger in pkg/utils and the console logger in
+// internal/utils implement it.
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Logger logs messages with structured context. Context is given as
+// alternating keys and values, and a map[string]interface{} is accepted in
+// place of a pair to add all of its entries. Implementations must not keep
+// keysAndValues after returning, so callers can reuse the slice.
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+	Info(msg string, keysAndValues ...interface{})
+	Warn(msg string, keysAndValues ...interface{})
+	Error(msg string, keysAndValues ...interface{})
+	// Fatal logs the message and exits the process
+	Fatal(msg string, keysAndValues ...interface{})
+	// With returns a logger that adds keysAndValues to every message
+	With(keysAndValues ...interface{}) Logger
+}
+
+// MissingValue is logged as the value of a key given without one
+const MissingValue = "MISSING"
+
+// ErrMalformedFields is returned by ParseFields for key-value pairs with a
+// missing value or a key that isn't a string
+var ErrMalformedFields = errors.New("malformed log fields")
+
+// badKey is the key of a value logged without one, as zap's sugared logger
+// names it
+const badKey = "!BADKEY"
+
+// Fields collects keysAndValues into a map, keeping what it can of
+// malformed pairs as ParseFields does
+func Fields(keysAndValues ...interface{}) map[string]interface{} {
+	fields, _ := ParseFields(keysAndValues...)
+	return fields
+}
+
+// ParseFields collects keysAndValues into a map. Malformed pairs are kept
+// rather than dropped: a trailing key without a value is paired with
+// MissingValue, and a value in place of a key is kept under "!BADKEY".
+// Either returns ErrMalformedFields with the fields, so loggers can warn
+// about the call. A lone error needs no key and is kept under "error", so
+// calls like Fatal("failed:", err) aren't malformed.
+func ParseFields(keysAndValues ...interface{}) (map[string]interface{}, error) {
+	if len(keysAndValues) == 0 {
+		return nil, nil
+	}
+
+	var err error
+	fields := make(map[string]interface{}, len(keysAndValues)/2)
+	for i := 0; i < len(keysAndValues); i++ {
+		switch arg := keysAndValues[i].(type) {
+		case map[string]interface{}:
+			for k, v := range arg {
+				fields[k] = v
+			}
+		case string:
+			if i+1 < len(keysAndValues) {
+				fields[arg] = keysAndValues[i+1]
+				i++
+				continue
+			}
+			fields[arg] = MissingValue
+			if err == nil {
+				err = fmt.Errorf("%w: key %q has no value", ErrMalformedFields, arg)
+			}
+		case error:
+			fields["error"] = arg
+		default:
+			fields[badKey] = arg
+			if err == nil {
+				err = fmt.Errorf("%w: %v (%T) is not a key", ErrMalformedFields, arg, arg)
+			}
+		}
+	}
+	return fields, err
+}
+
+// stdLogger writes key=value lines through the standard library logger
+type stdLogger struct {
+	out    *log.Logger
+	fields map[string]interface{}
+}
+
+// New returns a logger writing to stderr
+func New() Logger {
+	return &stdLogger{out: log.New(os.Stderr, "", log.LstdFlags)}
+}
+
+// Nop returns a logger that discards everything without formatting it.
+// Fatal still exits.
+func Nop() Logger {
+	return nopLogger{}
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...interface{}) {}
+func (nopLogger) Info(string, ...interface{})  {}
+func (nopLogger) Warn(string, ...interface{})  {}
+func (nopLogger) Error(string, ...interface{}) {}
+func (nopLogger) Fatal(string, ...interface{}) { os.Exit(1) }
+func (n nopLogger) With(...interface{}) Logger { return n }
+
+func (l *stdLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.log("DEBUG", msg, keysAndValues)
+}
+
+func (l *stdLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.log("INFO", msg, keysAndValues)
+}
+
+func (l *stdLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.log("WARN", msg, keysAndValues)
+}
+
+func (l *stdLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.log("ERROR", msg, keysAndValues)
+}
+
+func (l *stdLogger) Fatal(msg string, keysAndValues ...interface{}) {
+	l.log("FATAL", msg, keysAndValues)
+	os.Exit(1)
+}
+
+func (l *stdLogger) With(keysAndValues ...interface{}) Logger {
+	fields := make(map[string]interface{}, len(l.fields))
+	for k, v := range l.fields {
+		fields[k] = v
+	}
+	for k, v := range Fields(keysAndValues...) {
+		fields[k] = v
+	}
+	return &stdLogger{out: l.out, fields: fields}
+}
+
+func (l *stdLogger) log(level, msg string, keysAndValues []interface{}) {
+	fields, err := ParseFields(keysAndValues...)
+	l.write(level, msg, fields)
+	if err != nil {
+		l.write("WARN", "Malformed log fields", map[string]interface{}{"error": err, "message": msg})
+	}
+}
+
+func (l *stdLogger) write(level, msg string, fields map[string]interface{}) {
+	for k, v := range l.fields {
+		if _, ok := fields[k]; !ok {
+			if fields == nil {
+				fields = make(map[string]interface{}, len(l.fields))
+			}
+			fields[k] = v
+		}
+	}
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(level)
+	b.WriteString(" ")
+	b.WriteString(msg)
+	for _, k := range keys {
+		fmt.Fprintf(&b, " %s=%v", k, fields[k])
+	}
+	l.out.Print(b.String())
+}
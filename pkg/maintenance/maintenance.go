@@ -0,0 +1,127 @@
+// Package maintenance puts the API into read-only mode at runtime, shared
+// by the API router and the admin endpoint that toggles it.
+package maintenance
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/labs-alone/alone-main/pkg/auth"
+	"github.com/labs-alone/alone-main/pkg/logger"
+)
+
+// Response is the body returned for writes rejected during maintenance
+type Response struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+// Status is the JSON form of the maintenance mode settings
+type Status struct {
+	Enabled      bool     `json:"enabled"`
+	AllowedUsers []string `json:"allowed_users"`
+}
+
+// Mode puts the API into read-only mode at runtime. While it is enabled,
+// requests other than GET, HEAD and OPTIONS are answered with 503 unless
+// they come from an allowlisted user or target an exempt path.
+type Mode struct {
+	log          logger.Logger
+	enabled      bool
+	allowedUsers map[string]bool
+	exempt       map[string]bool
+	mu           sync.RWMutex
+}
+
+// New creates a maintenance mode, initially enabled or not, whose
+// allowedUsers bypass it
+func New(enabled bool, allowedUsers []string, log logger.Logger) *Mode {
+	m := &Mode{
+		log:          log,
+		enabled:      enabled,
+		allowedUsers: make(map[string]bool, len(allowedUsers)),
+		exempt:       make(map[string]bool),
+	}
+	for _, user := range allowedUsers {
+		m.allowedUsers[user] = true
+	}
+	return m
+}
+
+// Enabled reports whether maintenance mode is on
+func (m *Mode) Enabled() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.enabled
+}
+
+// SetEnabled turns maintenance mode on or off
+func (m *Mode) SetEnabled(enabled bool) {
+	m.mu.Lock()
+	changed := m.enabled != enabled
+	m.enabled = enabled
+	m.mu.Unlock()
+
+	if changed {
+		m.log.Info("Maintenance mode changed", "enabled", enabled)
+	}
+}
+
+// Status returns the current settings
+func (m *Mode) Status() Status {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	users := make([]string, 0, len(m.allowedUsers))
+	for user := range m.allowedUsers {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+
+	return Status{Enabled: m.enabled, AllowedUsers: users}
+}
+
+// Exempt lets writes to path through during maintenance, e.g. the endpoint
+// that turns it off again
+func (m *Mode) Exempt(path string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.exempt[path] = true
+}
+
+// Handle rejects writes while maintenance mode is on. It reads the user from
+// the request context, so it must run after authentication for the
+// allowlist to apply.
+func (m *Mode) Handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.allows(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(Response{
+			Error:   "MAINTENANCE",
+			Message: "the API is in maintenance mode; writes are temporarily disabled",
+		})
+	})
+}
+
+func (m *Mode) allows(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if !m.enabled || m.exempt[r.URL.Path] {
+		return true
+	}
+	userID := auth.UserID(r.Context())
+	return userID != "" && m.allowedUsers[userID]
+}
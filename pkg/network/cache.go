@@ -0,0 +1,112 @@
+package network
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// DefaultCacheContentTypes are the media types Cache stores when
+// Cache.ContentTypes is empty
+var DefaultCacheContentTypes = []string{"application/json", "text/", "image/"}
+
+// DefaultCacheMethods are the request methods Cache caches when
+// Cache.Methods is empty
+var DefaultCacheMethods = []string{http.MethodGet, http.MethodHead}
+
+// cachedHeaders are the response headers stored with a cache entry. They
+// describe the body, unlike per-request headers such as Set-Cookie.
+var cachedHeaders = []string{
+	"Content-Type",
+	"Content-Encoding",
+	"Content-Language",
+	"Cache-Control",
+	"ETag",
+	"Last-Modified",
+	"Vary",
+}
+
+// cachedHeader copies the cachedHeaders out of h. A response without a
+// Content-Type gets the one net/http sniffs from body, as it's what the
+// client was sent.
+func cachedHeader(h http.Header, body []byte) http.Header {
+	cached := make(http.Header, len(cachedHeaders))
+	for _, name := range cachedHeaders {
+		for _, value := range h.Values(name) {
+			cached.Add(name, value)
+		}
+	}
+	if cached.Get("Content-Type") == "" {
+		cached.Set("Content-Type", http.DetectContentType(body))
+	}
+	return cached
+}
+
+// cacheKey returns the key r's response is cached under, and false if r
+// mustn't be served from or stored in the cache. Authorized requests, when
+// allowed, are keyed by a hash of their Authorization header so one
+// caller's response is never served to another.
+func (m *MiddlewareManager) cacheKey(r *http.Request) (string, bool) {
+	methods := m.config.Cache.Methods
+	if len(methods) == 0 {
+		methods = DefaultCacheMethods
+	}
+	allowed := false
+	for _, method := range methods {
+		if strings.EqualFold(method, r.Method) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return "", false
+	}
+
+	key := fmt.Sprintf("%s:%s", r.Method, r.URL.String())
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		if !m.config.Cache.AllowAuthorized {
+			return "", false
+		}
+		sum := sha256.Sum256([]byte(authorization))
+		key += ":" + hex.EncodeToString(sum[:])
+	}
+	return key, true
+}
+
+// cacheable reports whether a response with status and contentType may be
+// stored under the Cache config
+func (m *MiddlewareManager) cacheable(status int, contentType string) bool {
+	statuses := m.config.Cache.StatusCodes
+	if len(statuses) == 0 {
+		statuses = []int{http.StatusOK}
+	}
+	allowed := false
+	for _, code := range statuses {
+		if code == status {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	types := m.config.Cache.ContentTypes
+	if len(types) == 0 {
+		types = DefaultCacheContentTypes
+	}
+	for _, t := range types {
+		t = strings.ToLower(t)
+		if mediaType == t || (strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t)) {
+			return true
+		}
+	}
+	return false
+}
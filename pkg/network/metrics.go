@@ -0,0 +1,49 @@
+package network
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Metrics holds the Prometheus metrics recorded by the metrics middleware
+type Metrics struct {
+	RequestsTotal   *prometheus.CounterVec
+	RequestDuration *prometheus.HistogramVec
+	ResponseSize    *prometheus.HistogramVec
+	// StatusClassTotal counts responses by method, route template and
+	// status class (2xx, 3xx, 4xx, 5xx)
+	StatusClassTotal *prometheus.CounterVec
+}
+
+// NewMetrics creates the HTTP metrics without registering them
+func NewMetrics() *Metrics {
+	return &Metrics{
+		RequestsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "http_requests_total",
+				Help: "Total number of HTTP requests",
+			},
+			[]string{"method", "path", "status"},
+		),
+		RequestDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "http_request_duration_seconds",
+				Help:    "HTTP request duration in seconds",
+				Buckets: prometheus.DefBuckets,
+			},
+			[]string{"method", "path"},
+		),
+		ResponseSize: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "http_response_size_bytes",
+				Help:    "HTTP response size in bytes",
+				Buckets: prometheus.ExponentialBuckets(100, 10, 8),
+			},
+			[]string{"method", "path"},
+		),
+		StatusClassTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "http_responses_by_class_total",
+				Help: "Total number of HTTP responses by status class",
+			},
+			[]string{"method", "path", "class"},
+		),
+	}
+}
@@ -1,8 +1,8 @@
 package network
 
 import (
+	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"net/http"
 	"runtime/debug"
@@ -11,9 +11,10 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/gorilla/mux"
+	"github.com/labs-alone/alone-main/pkg/auth"
 	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
-	"golang.org/x/time/rate"
 )
 
 // MiddlewareConfig holds middleware configuration
@@ -26,18 +27,58 @@ type MiddlewareConfig struct {
 	RateLimit struct {
 		RequestsPerSecond int
 		BurstSize        int
+		// Store selects where limiter state lives: "memory" (default) or
+		// "redis" to share limits across instances
+		Store  string
+		// Algorithm is RateLimitTokenBucket (default) or
+		// RateLimitSlidingWindow, which ignores BurstSize. The redis store
+		// always uses a sliding window.
+		Algorithm string
+		// Window is the span a sliding window counts requests over,
+		// defaulting to a second
+		Window time.Duration
+		// SweepInterval is how often idle in-memory limiters are dropped
+		// once Start is called. Zero disables sweeping.
+		SweepInterval time.Duration
+		Redis  struct {
+			Addr      string
+			Password  string
+			DB        int
+			KeyPrefix string
+		}
 	}
 	Security struct {
 		AllowedOrigins []string
 		AllowedMethods []string
 		AllowedHeaders []string
 		MaxAge         int
+		// Headers are the security headers SecurityHeaders sets. Nil sets
+		// DefaultSecurityHeaders.
+		Headers *SecurityHeadersConfig
 	}
 	Cache struct {
 		Enabled     bool
 		DefaultTTL  time.Duration
 		MaxSize     int
 		PurgeInterval time.Duration
+		// ContentTypes are the media types stored, such as "text/plain".
+		// An entry ending in "/" matches the whole type, as "image/" does.
+		// Empty is DefaultCacheContentTypes.
+		ContentTypes []string
+		// StatusCodes are the response statuses stored. Empty is only 200.
+		StatusCodes []int
+		// Methods are the request methods cached. Empty is GET and HEAD;
+		// methods that change state should never be listed.
+		Methods []string
+		// AllowAuthorized caches requests carrying an Authorization header,
+		// separately for each header value and with Vary: Authorization.
+		// By default they always reach the handler.
+		AllowAuthorized bool
+	}
+	Metrics struct {
+		// Exemplars attaches the trace ID of requests traced upstream,
+		// from their W3C traceparent header, to their duration observation
+		Exemplars bool
 	}
 }
 
@@ -48,12 +89,22 @@ type MiddlewareManager struct {
 	metrics   *Metrics
 	cache     *sync.Map
 	limiters  *sync.Map
+	rateStore RateLimitStore
 	blacklist *sync.Map
+
+	// ctx bounds the manager's lifetime; cancel ends the goroutines
+	// started by Start
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	lifecycle sync.Mutex
 }
 
-// NewMiddlewareManager creates a new middleware manager
-func NewMiddlewareManager(config *MiddlewareConfig, logger *zap.Logger, metrics *Metrics) *MiddlewareManager {
-	return &MiddlewareManager{
+// NewMiddlewareManager creates a new middleware manager. Background work
+// started by Start stops when ctx is done or Close is called.
+func NewMiddlewareManager(ctx context.Context, config *MiddlewareConfig, logger *zap.Logger, metrics *Metrics) *MiddlewareManager {
+	m := &MiddlewareManager{
+		ctx:       ctx,
 		config:    config,
 		logger:    logger,
 		metrics:   metrics,
@@ -61,24 +112,89 @@ func NewMiddlewareManager(config *MiddlewareConfig, logger *zap.Logger, metrics
 		limiters:  &sync.Map{},
 		blacklist: &sync.Map{},
 	}
+
+	store, err := newRateLimitStore(config, m.limiters)
+	if err != nil {
+		logger.Warn("falling back to in-memory rate limiting", zap.Error(err))
+		store = NewMemoryRateLimitStore(m.limiters, config.RateLimit.RequestsPerSecond, config.RateLimit.BurstSize)
+	}
+	m.rateStore = store
+
+	return m
 }
 
-// Security Middleware
+// Start launches the background work of the features enabled in the
+// config: purging expired cache entries every Cache.PurgeInterval and
+// sweeping idle in-memory rate limiters every RateLimit.SweepInterval. They
+// run until Close or until the manager's context is done. Calling it again
+// before Close does nothing.
+func (m *MiddlewareManager) Start() {
+	m.lifecycle.Lock()
+	defer m.lifecycle.Unlock()
+
+	if m.cancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(m.ctx)
+	m.cancel = cancel
 
-func (m *MiddlewareManager) SecurityHeaders() func(http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Set security headers
-			w.Header().Set("X-Content-Type-Options", "nosniff")
-			w.Header().Set("X-Frame-Options", "DENY")
-			w.Header().Set("X-XSS-Protection", "1; mode=block")
-			w.Header().Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
-			w.Header().Set("Content-Security-Policy", "default-src 'self'")
-			w.Header().Set("Referrer-Policy", "strict-origin-when-cross-origin")
+	if m.config.Cache.Enabled && m.config.Cache.PurgeInterval > 0 {
+		m.every(ctx, m.config.Cache.PurgeInterval, m.purgeCache)
+	}
+	if store, ok := m.rateStore.(interface{ sweep() }); ok && m.config.RateLimit.SweepInterval > 0 {
+		m.every(ctx, m.config.RateLimit.SweepInterval, store.sweep)
+	}
+}
 
-			next.ServeHTTP(w, r)
-		})
+// Close stops the goroutines started by Start and waits for them to exit.
+// It is safe to call more than once or without Start, and Start may be
+// called again afterwards.
+func (m *MiddlewareManager) Close() {
+	m.lifecycle.Lock()
+	defer m.lifecycle.Unlock()
+
+	if m.cancel == nil {
+		return
 	}
+	m.cancel()
+	m.wg.Wait()
+	m.cancel = nil
+}
+
+// every runs fn each interval until ctx is done
+func (m *MiddlewareManager) every(ctx context.Context, interval time.Duration, fn func()) {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				fn()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// purgeCache drops expired cache entries
+func (m *MiddlewareManager) purgeCache() {
+	m.cache.Range(func(key, value interface{}) bool {
+		if value.(*CacheEntry).Expired() {
+			m.cache.Delete(key)
+		}
+		return true
+	})
+}
+
+// Security Middleware
+
+// SecurityHeaders sets the configured security headers on every response
+func (m *MiddlewareManager) SecurityHeaders() func(http.Handler) http.Handler {
+	return SecurityHeaders(m.config.Security.Headers)
 }
 
 // Authentication Middleware
@@ -111,8 +227,7 @@ func (m *MiddlewareManager) JWTAuth() func(http.Handler) http.Handler {
 				return
 			}
 
-			ctx := context.WithValue(r.Context(), "user", claims)
-			next.ServeHTTP(w, r.WithContext(ctx))
+			next.ServeHTTP(w, r.WithContext(auth.WithClaims(r.Context(), claims)))
 		})
 	}
 }
@@ -122,18 +237,26 @@ func (m *MiddlewareManager) JWTAuth() func(http.Handler) http.Handler {
 func (m *MiddlewareManager) RateLimit() func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Get or create rate limiter for IP
 			ip := r.RemoteAddr
-			limiter, _ := m.limiters.LoadOrStore(ip, rate.NewLimiter(
-				rate.Limit(m.config.RateLimit.RequestsPerSecond),
-				m.config.RateLimit.BurstSize,
-			))
+			result, err := m.rateStore.Take(r.Context(), ip)
+			if err != nil {
+				// Fail open so a store outage doesn't take the API down
+				m.logger.Warn("rate limit store error", zap.String("ip", ip), zap.Error(err))
+				next.ServeHTTP(w, r)
+				return
+			}
 
-			if !limiter.(*rate.Limiter).Allow() {
-				http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+			if !result.Allowed {
+				m.logger.Warn("rate limit exceeded",
+					zap.String("ip", ip),
+					zap.String("path", r.URL.Path),
+					zap.Duration("retry_after", result.RetryAfter),
+				)
+				writeRateLimited(w, result)
 				return
 			}
 
+			setRateLimitHeaders(w, result)
 			next.ServeHTTP(w, r)
 		})
 	}
@@ -141,6 +264,12 @@ func (m *MiddlewareManager) RateLimit() func(http.Handler) http.Handler {
 
 // Caching Middleware
 
+// Cache serves responses from memory for ttl, keyed by method and URL,
+// replaying their status and content headers. Only requests with a
+// configured method, by default without an Authorization header, are
+// cached, and only responses with a configured status and content type are
+// stored. Each response carries X-Cache: HIT when served from the cache and
+// MISS otherwise, including those that are never cached.
 func (m *MiddlewareManager) Cache(ttl time.Duration) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -149,33 +278,51 @@ func (m *MiddlewareManager) Cache(ttl time.Duration) func(http.Handler) http.Han
 				return
 			}
 
-			// Generate cache key
-			key := fmt.Sprintf("%s:%s", r.Method, r.URL.String())
+			key, cacheable := m.cacheKey(r)
+			if !cacheable {
+				w.Header().Set("X-Cache", "MISS")
+				next.ServeHTTP(w, r)
+				return
+			}
 
 			// Check cache
 			if cached, ok := m.cache.Load(key); ok {
 				entry := cached.(*CacheEntry)
 				if !entry.Expired() {
-					w.Header().Set("Content-Type", "application/json")
+					for name, values := range entry.Header {
+						w.Header()[name] = values
+					}
 					w.Header().Set("X-Cache", "HIT")
+					w.WriteHeader(entry.StatusCode)
 					w.Write(entry.Data)
 					return
 				}
 			}
 
+			// Set before the handler runs, as its first write sends the headers
+			w.Header().Set("X-Cache", "MISS")
+			if r.Header.Get("Authorization") != "" {
+				w.Header().Add("Vary", "Authorization")
+			}
+
 			// Create response recorder
 			rec := &ResponseRecorder{
 				ResponseWriter: w,
 				StatusCode:    http.StatusOK,
+				Body:          &bytes.Buffer{},
 			}
 
 			next.ServeHTTP(rec, r)
 
-			// Cache response if successful
-			if rec.StatusCode == http.StatusOK {
+			// Cache response if its status and content type are cacheable
+			body := rec.Body.Bytes()
+			header := cachedHeader(rec.Header(), body)
+			if m.cacheable(rec.StatusCode, header.Get("Content-Type")) {
 				m.cache.Store(key, &CacheEntry{
-					Data:    rec.Body.Bytes(),
-					Expires: time.Now().Add(ttl),
+					Data:       body,
+					StatusCode: rec.StatusCode,
+					Header:     header,
+					Expires:    time.Now().Add(ttl),
 				})
 			}
 		})
@@ -196,13 +343,62 @@ func (m *MiddlewareManager) Metrics() func(http.Handler) http.Handler {
 			next.ServeHTTP(rec, r)
 
 			duration := time.Since(start).Seconds()
-			m.metrics.RequestDuration.WithLabelValues(r.Method, r.URL.Path).Observe(duration)
-			m.metrics.RequestsTotal.WithLabelValues(r.Method, r.URL.Path, fmt.Sprintf("%d", rec.StatusCode)).Inc()
-			m.metrics.ResponseSize.WithLabelValues(r.Method, r.URL.Path).Observe(float64(rec.Body.Len()))
+			route := routeLabel(r)
+			observer := m.metrics.RequestDuration.WithLabelValues(r.Method, route)
+			exemplars, canExemplar := observer.(prometheus.ExemplarObserver)
+			if id, traced := traceID(r); traced && canExemplar && m.config.Metrics.Exemplars {
+				exemplars.ObserveWithExemplar(duration, prometheus.Labels{"trace_id": id})
+			} else {
+				observer.Observe(duration)
+			}
+			m.metrics.RequestsTotal.WithLabelValues(r.Method, route, fmt.Sprintf("%d", rec.StatusCode)).Inc()
+			m.metrics.StatusClassTotal.WithLabelValues(r.Method, route, StatusClass(rec.StatusCode)).Inc()
+			m.metrics.ResponseSize.WithLabelValues(r.Method, route).Observe(float64(rec.Size))
 		})
 	}
 }
 
+// unmatchedRoute labels requests that didn't match a route, so paths probed
+// by scanners don't each add a series
+const unmatchedRoute = "unmatched"
+
+// routeLabel returns the template of the route r matched, keeping metric
+// label cardinality bounded by the number of routes
+func routeLabel(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if tmpl, err := route.GetPathTemplate(); err == nil {
+			return tmpl
+		}
+	}
+	return unmatchedRoute
+}
+
+// statusClasses are the status class labels by the status code's first
+// digit
+var statusClasses = [...]string{"1xx", "2xx", "3xx", "4xx", "5xx"}
+
+// StatusClass returns the class of an HTTP status code, such as "5xx"
+func StatusClass(code int) string {
+	if code < 100 || code > 599 {
+		return "other"
+	}
+	return statusClasses[code/100-1]
+}
+
+// traceID returns the trace ID of a request's W3C traceparent header,
+// formatted version-traceid-parentid-flags. The all-zero ID is invalid.
+func traceID(r *http.Request) (string, bool) {
+	parts := strings.Split(r.Header.Get("Traceparent"), "-")
+	if len(parts) != 4 || len(parts[1]) != 32 {
+		return "", false
+	}
+	id := strings.ToLower(parts[1])
+	if strings.Trim(id, "0") == "" || strings.Trim(id, "0123456789abcdef") != "" {
+		return "", false
+	}
+	return id, true
+}
+
 // Recovery Middleware
 
 func (m *MiddlewareManager) Recovery() func(http.Handler) http.Handler {
@@ -227,7 +423,10 @@ func (m *MiddlewareManager) Recovery() func(http.Handler) http.Handler {
 // Helper types and functions
 
 type CacheEntry struct {
-	Data    []byte
+	Data       []byte
+	StatusCode int
+	// Header holds the cachedHeaders of the response, replayed on a hit
+	Header  http.Header
 	Expires time.Time
 }
 
@@ -235,23 +434,41 @@ func (c *CacheEntry) Expired() bool {
 	return time.Now().After(c.Expires)
 }
 
+// ResponseRecorder records the status and size of a response as it's
+// written, and its body if Body is set. Like http.ResponseWriter, only the
+// first WriteHeader, or the implied 200 of a Write before one, counts;
+// later calls are dropped rather than passed on.
 type ResponseRecorder struct {
 	http.ResponseWriter
-	StatusCode int
-	Body       *bytes.Buffer
+	StatusCode  int
+	Body        *bytes.Buffer
+	Size        int
+	wroteHeader bool
 }
 
 func (r *ResponseRecorder) WriteHeader(statusCode int) {
+	if r.wroteHeader {
+		return
+	}
+	r.wroteHeader = true
 	r.StatusCode = statusCode
 	r.ResponseWriter.WriteHeader(statusCode)
 }
 
 func (r *ResponseRecorder) Write(b []byte) (int, error) {
-	r.Body.Write(b)
-	return r.ResponseWriter.Write(b)
+	// The implied 200 is left to the ResponseWriter, which also sniffs the
+	// Content-Type from b
+	r.wroteHeader = true
+	if r.Body != nil {
+		r.Body.Write(b)
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.Size += n
+	return n, err
 }
 
-// Cleanup function for middleware manager
+// Cleanup clears the manager's caches and limiters. It doesn't stop the
+// goroutines started by Start; see Close.
 func (m *MiddlewareManager) Cleanup() {
 	// Clear caches
 	m.cache.Range(func(key, value interface{}) bool {
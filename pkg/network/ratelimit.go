@@ -0,0 +1,359 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/time/rate"
+)
+
+// Rate limit store types
+const (
+	RateLimitStoreMemory = "memory"
+	RateLimitStoreRedis  = "redis"
+)
+
+// Rate limit algorithms
+const (
+	// RateLimitTokenBucket refills RequestsPerSecond tokens a second up to
+	// BurstSize, so idle clients can save up for a burst
+	RateLimitTokenBucket = "token_bucket"
+	// RateLimitSlidingWindow allows RequestsPerSecond times Window requests
+	// in any Window-long span, so requests can't be saved up
+	RateLimitSlidingWindow = "sliding_window"
+)
+
+// RateLimitStore tracks rate limit state for a key
+type RateLimitStore interface {
+	// Allow records a request for key and reports whether it is within the limit
+	Allow(ctx context.Context, key string) (bool, error)
+	// Take is Allow plus the limit state reported in rate limit headers
+	Take(ctx context.Context, key string) (RateLimitResult, error)
+}
+
+// RateLimitResult is the outcome of recording a request against a limit
+type RateLimitResult struct {
+	Allowed bool
+	// Limit is the most requests allowed at once
+	Limit int
+	// Remaining is how many more requests are allowed right now
+	Remaining int
+	// RetryAfter is how long a rejected client should wait before the
+	// next request can succeed
+	RetryAfter time.Duration
+	// Reset is how long until the full limit is available again
+	Reset time.Duration
+}
+
+// MemoryRateLimitStore keeps a token bucket per key in process memory
+type MemoryRateLimitStore struct {
+	limiters *sync.Map
+	limit    rate.Limit
+	burst    int
+}
+
+// NewMemoryRateLimitStore creates an in-memory store backed by limiters
+func NewMemoryRateLimitStore(limiters *sync.Map, requestsPerSecond, burst int) *MemoryRateLimitStore {
+	if limiters == nil {
+		limiters = &sync.Map{}
+	}
+	return &MemoryRateLimitStore{
+		limiters: limiters,
+		limit:    rate.Limit(requestsPerSecond),
+		burst:    burst,
+	}
+}
+
+// Allow implements RateLimitStore
+func (s *MemoryRateLimitStore) Allow(ctx context.Context, key string) (bool, error) {
+	result, err := s.Take(ctx, key)
+	return result.Allowed, err
+}
+
+// Take implements RateLimitStore
+func (s *MemoryRateLimitStore) Take(ctx context.Context, key string) (RateLimitResult, error) {
+	value, _ := s.limiters.LoadOrStore(key, rate.NewLimiter(s.limit, s.burst))
+	limiter := value.(*rate.Limiter)
+
+	now := time.Now()
+	result := RateLimitResult{Limit: s.burst}
+	if limiter.AllowN(now, 1) {
+		result.Allowed = true
+	} else if reservation := limiter.ReserveN(now, 1); reservation.OK() {
+		// Only reserved to learn when a token frees up
+		result.RetryAfter = reservation.DelayFrom(now)
+		reservation.CancelAt(now)
+	}
+
+	tokens := limiter.TokensAt(now)
+	if tokens >= 1 {
+		result.Remaining = int(tokens)
+	}
+	if missing := float64(s.burst) - tokens; missing > 0 && s.limit > 0 && s.limit != rate.Inf {
+		result.Reset = time.Duration(missing / float64(s.limit) * float64(time.Second))
+	}
+	return result, nil
+}
+
+// sweep drops limiters whose bucket has refilled. A full bucket behaves
+// exactly like the new one Take would create, so only memory is lost.
+func (s *MemoryRateLimitStore) sweep() {
+	now := time.Now()
+	s.limiters.Range(func(key, value interface{}) bool {
+		if value.(*rate.Limiter).TokensAt(now) >= float64(s.burst) {
+			s.limiters.Delete(key)
+		}
+		return true
+	})
+}
+
+// slidingWindowScript trims entries older than the window, then records the
+// request only if the window still has room. It runs atomically in Redis so
+// every instance sees the same count. It returns whether the request was
+// allowed, the count in the window, if rejected the milliseconds until the
+// oldest entry leaves the window, and the milliseconds until the newest does
+// and the window is empty again.
+var slidingWindowScript = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+
+redis.call("ZREMRANGEBYSCORE", key, 0, now - window)
+local count = redis.call("ZCARD", key)
+if count >= limit then
+	local retry = window
+	local reset = window
+	local oldest = redis.call("ZRANGE", key, 0, 0, "WITHSCORES")
+	if oldest[2] then
+		retry = tonumber(oldest[2]) + window - now
+	end
+	local newest = redis.call("ZRANGE", key, -1, -1, "WITHSCORES")
+	if newest[2] then
+		reset = tonumber(newest[2]) + window - now
+	end
+	return {0, count, retry, reset}
+end
+
+redis.call("ZADD", key, now, ARGV[4])
+redis.call("PEXPIRE", key, window)
+return {1, count + 1, 0, window}
+`)
+
+// RedisRateLimitStore enforces a sliding window limit shared across instances
+type RedisRateLimitStore struct {
+	client redis.UniversalClient
+	prefix string
+	limit  int
+	window time.Duration
+}
+
+// NewRedisRateLimitStore creates a Redis-backed sliding window store that
+// allows limit requests per window for each key
+func NewRedisRateLimitStore(client redis.UniversalClient, prefix string, limit int, window time.Duration) *RedisRateLimitStore {
+	if prefix == "" {
+		prefix = "ratelimit:"
+	}
+	return &RedisRateLimitStore{
+		client: client,
+		prefix: prefix,
+		limit:  limit,
+		window: window,
+	}
+}
+
+// Allow implements RateLimitStore
+func (s *RedisRateLimitStore) Allow(ctx context.Context, key string) (bool, error) {
+	result, err := s.Take(ctx, key)
+	return result.Allowed, err
+}
+
+// Take implements RateLimitStore
+func (s *RedisRateLimitStore) Take(ctx context.Context, key string) (RateLimitResult, error) {
+	now := time.Now().UnixMilli()
+	reply, err := slidingWindowScript.Run(ctx, s.client,
+		[]string{s.prefix + key},
+		now,
+		s.window.Milliseconds(),
+		s.limit,
+		fmt.Sprintf("%d-%s", now, uuid.New().String()),
+	).Int64Slice()
+	if err != nil {
+		return RateLimitResult{}, fmt.Errorf("failed to evaluate rate limit: %w", err)
+	}
+	if len(reply) != 4 {
+		return RateLimitResult{}, fmt.Errorf("failed to evaluate rate limit: unexpected reply %v", reply)
+	}
+
+	result := RateLimitResult{
+		Allowed:    reply[0] == 1,
+		Limit:      s.limit,
+		RetryAfter: time.Duration(reply[2]) * time.Millisecond,
+		Reset:      time.Duration(reply[3]) * time.Millisecond,
+	}
+	if remaining := s.limit - int(reply[1]); remaining > 0 {
+		result.Remaining = remaining
+	}
+	return result, nil
+}
+
+// SlidingWindowRateLimitStore allows limit requests in any window-long span
+// for each key, in process memory. It runs the algorithm of
+// RedisRateLimitStore without sharing state across instances.
+type SlidingWindowRateLimitStore struct {
+	windows *sync.Map
+	limit   int
+	window  time.Duration
+}
+
+// slidingWindow holds the times of a key's requests within the window,
+// oldest first
+type slidingWindow struct {
+	mu    sync.Mutex
+	times []time.Time
+	// removed is set once sweep drops the window from the map, so a Take
+	// that loaded it before then retries with the window replacing it
+	removed bool
+}
+
+// NewSlidingWindowRateLimitStore creates an in-memory sliding window store
+// that allows limit requests per window for each key, keeping its state in
+// windows
+func NewSlidingWindowRateLimitStore(windows *sync.Map, limit int, window time.Duration) *SlidingWindowRateLimitStore {
+	if windows == nil {
+		windows = &sync.Map{}
+	}
+	return &SlidingWindowRateLimitStore{
+		windows: windows,
+		limit:   limit,
+		window:  window,
+	}
+}
+
+// Allow implements RateLimitStore
+func (s *SlidingWindowRateLimitStore) Allow(ctx context.Context, key string) (bool, error) {
+	result, err := s.Take(ctx, key)
+	return result.Allowed, err
+}
+
+// Take implements RateLimitStore
+func (s *SlidingWindowRateLimitStore) Take(ctx context.Context, key string) (RateLimitResult, error) {
+	w := s.lockWindow(key)
+	defer w.mu.Unlock()
+
+	now := time.Now()
+	w.expire(now.Add(-s.window))
+
+	result := RateLimitResult{Limit: s.limit}
+	if len(w.times) < s.limit {
+		w.times = append(w.times, now)
+		result.Allowed = true
+	} else {
+		result.RetryAfter = w.times[0].Add(s.window).Sub(now)
+	}
+
+	result.Remaining = s.limit - len(w.times)
+	if n := len(w.times); n > 0 {
+		result.Reset = w.times[n-1].Add(s.window).Sub(now)
+	}
+	return result, nil
+}
+
+// lockWindow returns the window of key, locked and still in the map
+func (s *SlidingWindowRateLimitStore) lockWindow(key string) *slidingWindow {
+	for {
+		value, _ := s.windows.LoadOrStore(key, &slidingWindow{})
+		w := value.(*slidingWindow)
+
+		w.mu.Lock()
+		if !w.removed {
+			return w
+		}
+		w.mu.Unlock()
+	}
+}
+
+// expire drops request times at or before cutoff. The caller must hold mu.
+func (w *slidingWindow) expire(cutoff time.Time) {
+	i := 0
+	for i < len(w.times) && !w.times[i].After(cutoff) {
+		i++
+	}
+	n := copy(w.times, w.times[i:])
+	w.times = w.times[:n]
+}
+
+// sweep drops the windows of keys without requests in the last window,
+// which behave exactly like the new ones Take would create
+func (s *SlidingWindowRateLimitStore) sweep() {
+	cutoff := time.Now().Add(-s.window)
+	s.windows.Range(func(key, value interface{}) bool {
+		w := value.(*slidingWindow)
+		w.mu.Lock()
+		defer w.mu.Unlock()
+
+		// Range may still yield a window a previous sweep removed, and the
+		// key may map to a newer one by now, which mustn't be deleted
+		if current, ok := s.windows.Load(key); !ok || current != w {
+			return true
+		}
+		w.expire(cutoff)
+		if len(w.times) == 0 {
+			s.windows.Delete(key)
+			w.removed = true
+		}
+		return true
+	})
+}
+
+// windowLimit converts a per-second rate to a request count per window,
+// with a window of a second unless one is configured
+func windowLimit(requestsPerSecond int, window time.Duration) (int, time.Duration) {
+	if window <= 0 {
+		window = time.Second
+	}
+	limit := int(math.Round(float64(requestsPerSecond) * window.Seconds()))
+	if limit < 1 {
+		limit = 1
+	}
+	return limit, window
+}
+
+// newRateLimitStore builds the store selected by config
+func newRateLimitStore(config *MiddlewareConfig, limiters *sync.Map) (RateLimitStore, error) {
+	rl := config.RateLimit
+
+	switch rl.Algorithm {
+	case "", RateLimitTokenBucket, RateLimitSlidingWindow:
+	default:
+		return nil, fmt.Errorf("unknown rate limit algorithm: %s", rl.Algorithm)
+	}
+
+	switch rl.Store {
+	case "", RateLimitStoreMemory:
+		if rl.Algorithm == RateLimitSlidingWindow {
+			limit, window := windowLimit(rl.RequestsPerSecond, rl.Window)
+			return NewSlidingWindowRateLimitStore(limiters, limit, window), nil
+		}
+		return NewMemoryRateLimitStore(limiters, rl.RequestsPerSecond, rl.BurstSize), nil
+	case RateLimitStoreRedis:
+		if rl.Algorithm == RateLimitTokenBucket {
+			return nil, fmt.Errorf("the redis rate limit store only supports the %s algorithm", RateLimitSlidingWindow)
+		}
+		limit, window := windowLimit(rl.RequestsPerSecond, rl.Window)
+
+		client := redis.NewClient(&redis.Options{
+			Addr:     rl.Redis.Addr,
+			Password: rl.Redis.Password,
+			DB:       rl.Redis.DB,
+		})
+		return NewRedisRateLimitStore(client, rl.Redis.KeyPrefix, limit, window), nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit store: %s", rl.Store)
+	}
+}
@@ -0,0 +1,81 @@
+package network
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// APIResponse is the standard JSON response envelope
+type APIResponse struct {
+	Success bool        `json:"success"`
+	Data    interface{} `json:"data,omitempty"`
+	Error   *APIError   `json:"error,omitempty"`
+}
+
+// APIError describes a failed request with a machine-readable code
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Details any    `json:"details,omitempty"`
+}
+
+// Error codes
+const (
+	ErrCodeRateLimited = "rate_limited"
+)
+
+// writeError writes an APIResponse error envelope with the given status
+func writeError(w http.ResponseWriter, status int, code, message string, details any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: false,
+		Error: &APIError{
+			Code:    code,
+			Message: message,
+			Details: details,
+		},
+	})
+}
+
+// setRateLimitHeaders reports the client's limit, remaining requests and
+// seconds until the limit is fully available again
+func setRateLimitHeaders(w http.ResponseWriter, result RateLimitResult) {
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.Itoa(resetSeconds(result.Reset)))
+}
+
+// writeRateLimited rejects a throttled request with 429, a Retry-After
+// header in whole seconds and a rate_limited error envelope
+func writeRateLimited(w http.ResponseWriter, result RateLimitResult) {
+	retryAfter := retryAfterSeconds(result.RetryAfter)
+	setRateLimitHeaders(w, result)
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+
+	writeError(w, http.StatusTooManyRequests, ErrCodeRateLimited, "rate limit exceeded", map[string]interface{}{
+		"limit":       result.Limit,
+		"remaining":   result.Remaining,
+		"retry_after": retryAfter,
+		"reset":       resetSeconds(result.Reset),
+	})
+}
+
+// retryAfterSeconds rounds a wait up to whole seconds, at least 1, since
+// Retry-After can't express fractions and 0 invites an immediate retry
+func retryAfterSeconds(wait time.Duration) int {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
+
+// resetSeconds rounds the wait until the limit is fully available up to
+// whole seconds, for X-RateLimit-Reset
+func resetSeconds(reset time.Duration) int {
+	return int(math.Ceil(reset.Seconds()))
+}
@@ -1,17 +1,21 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"gopkg.in/natefinch/lumberjack.v2"
+
+	"github.com/labs-alone/alone-main/pkg/logger"
 )
 
 // LogConfig holds logger configuration
@@ -28,6 +32,9 @@ type LogConfig struct {
 	EnableStacktrace bool
 	SamplingInitial int
 	SamplingThereafter int
+	// Console is where logs are written besides the file, defaulting to
+	// os.Stdout
+	Console zapcore.WriteSyncer
 }
 
 // Logger wraps zap logger with additional functionality
@@ -39,6 +46,8 @@ type Logger struct {
 	fileLogger *lumberjack.Logger
 }
 
+var _ logger.Logger = (*Logger)(nil)
+
 // DefaultConfig returns default logger configuration
 func DefaultConfig() *LogConfig {
 	return &LogConfig{
@@ -57,10 +66,12 @@ func DefaultConfig() *LogConfig {
 	}
 }
 
-// NewLogger creates a new logger instance
+// NewLogger creates a new logger instance. Without a config it returns a
+// no-op logger, so library code doesn't create log files unless asked to;
+// pass DefaultConfig() for the standard file and console output.
 func NewLogger(config *LogConfig) (*Logger, error) {
 	if config == nil {
-		config = DefaultConfig()
+		return NewNopLogger(), nil
 	}
 
 	// Create logs directory if it doesn't exist
@@ -77,6 +88,11 @@ func NewLogger(config *LogConfig) (*Logger, error) {
 		Compress:   config.Compress,
 	}
 
+	console := config.Console
+	if console == nil {
+		console = zapcore.AddSync(os.Stdout)
+	}
+
 	// Create encoder config
 	encoderConfig := zapcore.EncoderConfig{
 		TimeKey:        "timestamp",
@@ -104,7 +120,7 @@ func NewLogger(config *LogConfig) (*Logger, error) {
 		core = zapcore.NewCore(
 			zapcore.NewJSONEncoder(encoderConfig),
 			zapcore.NewMultiWriteSyncer(
-				zapcore.AddSync(os.Stdout),
+				consoleSyncer{console},
 				zapcore.AddSync(fileLogger),
 			),
 			level,
@@ -113,7 +129,7 @@ func NewLogger(config *LogConfig) (*Logger, error) {
 		core = zapcore.NewCore(
 			zapcore.NewConsoleEncoder(encoderConfig),
 			zapcore.NewMultiWriteSyncer(
-				zapcore.AddSync(os.Stdout),
+				consoleSyncer{console},
 				zapcore.AddSync(fileLogger),
 			),
 			level,
@@ -135,6 +151,16 @@ func NewLogger(config *LogConfig) (*Logger, error) {
 	}, nil
 }
 
+// NewNopLogger returns a logger that discards everything and never touches
+// the filesystem, for tests and libraries
+func NewNopLogger() *Logger {
+	return &Logger{
+		Logger: zap.NewNop(),
+		config: &LogConfig{Level: "info"},
+		fields: make(map[string]interface{}),
+	}
+}
+
 // WithFields adds fields to the logger
 func (l *Logger) WithFields(fields map[string]interface{}) *Logger {
 	l.mu.Lock()
@@ -168,58 +194,131 @@ func (l *Logger) WithFields(fields map[string]interface{}) *Logger {
 }
 
 // Debug logs a debug message
-func (l *Logger) Debug(msg string, fields ...map[string]interface{}) {
-	l.Logger.Debug(msg, l.convertFields(fields...)...)
+func (l *Logger) Debug(msg string, keysAndValues ...interface{}) {
+	l.Logger.Debug(msg, l.convertFields(msg, keysAndValues)...)
 }
 
 // Info logs an info message
-func (l *Logger) Info(msg string, fields ...map[string]interface{}) {
-	l.Logger.Info(msg, l.convertFields(fields...)...)
+func (l *Logger) Info(msg string, keysAndValues ...interface{}) {
+	l.Logger.Info(msg, l.convertFields(msg, keysAndValues)...)
 }
 
 // Warn logs a warning message
-func (l *Logger) Warn(msg string, fields ...map[string]interface{}) {
-	l.Logger.Warn(msg, l.convertFields(fields...)...)
+func (l *Logger) Warn(msg string, keysAndValues ...interface{}) {
+	l.Logger.Warn(msg, l.convertFields(msg, keysAndValues)...)
 }
 
 // Error logs an error message
-func (l *Logger) Error(msg string, fields ...map[string]interface{}) {
-	l.Logger.Error(msg, l.convertFields(fields...)...)
+func (l *Logger) Error(msg string, keysAndValues ...interface{}) {
+	l.Logger.Error(msg, l.convertFields(msg, keysAndValues)...)
 }
 
 // Fatal logs a fatal message and exits
-func (l *Logger) Fatal(msg string, fields ...map[string]interface{}) {
-	l.Logger.Fatal(msg, l.convertFields(fields...)...)
+func (l *Logger) Fatal(msg string, keysAndValues ...interface{}) {
+	l.Logger.Fatal(msg, l.convertFields(msg, keysAndValues)...)
+}
+
+// With creates a new logger with additional fields given as key-value pairs
+func (l *Logger) With(keysAndValues ...interface{}) logger.Logger {
+	return l.WithFields(logger.Fields(keysAndValues...))
 }
 
-// convertFields converts map fields to zap fields
-func (l *Logger) convertFields(fields ...map[string]interface{}) []zap.Field {
+// convertFields converts key-value pairs and map fields to zap fields. If
+// the pairs are malformed it logs a warning, attributed to the caller of the
+// logging method, before the message itself.
+func (l *Logger) convertFields(msg string, keysAndValues []interface{}) []zap.Field {
+	fields, err := logger.ParseFields(keysAndValues...)
+	if err != nil {
+		l.Logger.WithOptions(zap.AddCallerSkip(2)).Warn("Malformed log fields",
+			zap.Error(err),
+			zap.String("message", msg),
+		)
+	}
 	if len(fields) == 0 {
 		return nil
 	}
 
-	zapFields := make([]zap.Field, 0)
-	for _, fieldMap := range fields {
-		for k, v := range fieldMap {
-			zapFields = append(zapFields, zap.Any(k, v))
-		}
+	zapFields := make([]zap.Field, 0, len(fields))
+	for k, v := range fields {
+		zapFields = append(zapFields, zap.Any(k, v))
 	}
 	return zapFields
 }
 
+// consoleSyncer ignores the errors syncing a terminal or pipe returns, so
+// only failures to flush the log file are reported
+type consoleSyncer struct {
+	zapcore.WriteSyncer
+}
+
+func (c consoleSyncer) Sync() error {
+	if err := c.WriteSyncer.Sync(); err != nil && !isBenignSyncError(err) {
+		return err
+	}
+	return nil
+}
+
+// isBenignSyncError reports whether err is what fsync returns for a file
+// that can't be synced, such as stdout attached to a terminal or pipe
+func isBenignSyncError(err error) bool {
+	return errors.Is(err, syscall.EINVAL) ||
+		errors.Is(err, syscall.ENOTTY) ||
+		errors.Is(err, syscall.ENOTSUP)
+}
+
 // Sync flushes any buffered log entries
 func (l *Logger) Sync() error {
 	return l.Logger.Sync()
 }
 
-// Close closes the logger and cleans up resources
+// Close flushes the logger and closes the log file
 func (l *Logger) Close() error {
 	if err := l.Sync(); err != nil {
 		return fmt.Errorf("failed to sync logger: %v", err)
 	}
+	if l.fileLogger == nil {
+		return nil
+	}
+	if err := l.fileLogger.Close(); err != nil {
+		return fmt.Errorf("failed to close log file: %v", err)
+	}
+	return nil
+}
+
+// Rotate starts a new log file, keeping the current one as a timestamped
+// backup as happens when it reaches MaxSize. It does nothing for a no-op
+// logger.
+func (l *Logger) Rotate() error {
+	if l.fileLogger == nil {
+		return nil
+	}
+	if err := l.fileLogger.Rotate(); err != nil {
+		return fmt.Errorf("failed to rotate log file: %v", err)
+	}
 	return nil
 }
 
+// CurrentLogFile returns the path of the file being logged to and its size,
+// which is zero until something has been written to it. A no-op logger has
+// no file and returns an empty path.
+func (l *Logger) CurrentLogFile() (string, int64) {
+	if l.fileLogger == nil {
+		return "", 0
+	}
+
+	path := l.fileLogger.Filename
+	if path == "" {
+		// lumberjack's default when no path is configured
+		path = filepath.Join(os.TempDir(), filepath.Base(os.Args[0])+"-lumberjack.log")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return path, 0
+	}
+	return path, info.Size()
+}
+
 // GetLogLevel returns the current log level
 func (l *Logger) GetLogLevel() string {
 	return l.config.Level
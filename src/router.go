@@ -1,13 +1,22 @@
 package network
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"go.uber.org/zap"
+	"golang.org/x/time/rate"
 )
 
 // RouteConfig holds configuration for a route
@@ -33,8 +42,19 @@ type Router struct {
 	logger     *zap.Logger
 	metrics    *Metrics
 	middleware map[string][]mux.MiddlewareFunc
+
+	// Guards the warning about requests missing a request ID
+	missingRequestID sync.Once
 }
 
+// ErrDuplicateRoute is returned when a route is added for a method and path
+// that are already served
+var ErrDuplicateRoute = errors.New("duplicate route")
+
+// unknownRequestID stands in for the ID of a request that has none, e.g.
+// because requestIDMiddleware isn't installed
+const unknownRequestID = "unknown"
+
 // APIResponse represents a standard API response
 type APIResponse struct {
 	Success bool        `json:"success"`
@@ -82,11 +102,17 @@ func (r *Router) setupDefaultMiddleware() {
 	r.Use(r.recoveryMiddleware)
 
 	// Request logging middleware
-	r.Use(r.loggingMiddleware)
+	r.Use(r.LoggingMiddleware)
 }
 
-// AddRoute adds a new route with configuration
+// AddRoute adds a new route with configuration. It returns
+// ErrDuplicateRoute if the method and path are already served, since the
+// new route would never be reached.
 func (r *Router) AddRoute(config RouteConfig) error {
+	if hasRoute(r.Router, config.Method, config.Path) {
+		return fmt.Errorf("%w: %s %s", ErrDuplicateRoute, config.Method, config.Path)
+	}
+
 	route := r.HandleFunc(config.Path, r.wrapHandler(config))
 	route.Methods(config.Method)
 
@@ -108,13 +134,43 @@ func (r *Router) AddRoute(config RouteConfig) error {
 	return nil
 }
 
+// hasRoute reports whether router already serves method on path, through a
+// route for that method or one without a method matcher. An empty method
+// matches any route on path.
+func hasRoute(router *mux.Router, method, path string) bool {
+	found := false
+	router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		// Subrouter mounts have no handler of their own
+		if found || route.GetHandler() == nil {
+			return nil
+		}
+		template, err := route.GetPathTemplate()
+		if err != nil || template != path {
+			return nil
+		}
+
+		methods, err := route.GetMethods()
+		if err != nil || method == "" {
+			found = true
+			return nil
+		}
+		for _, m := range methods {
+			if strings.EqualFold(m, method) {
+				found = true
+			}
+		}
+		return nil
+	})
+	return found
+}
+
 // wrapHandler wraps the handler with standard response formatting
 func (r *Router) wrapHandler(config RouteConfig) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
 		var response APIResponse
 		response.Meta = &MetaData{
 			Timestamp: time.Now().UTC(),
-			RequestID: req.Context().Value("request_id").(string),
+			RequestID: r.requestID(req),
 		}
 
 		// Validate request if required
@@ -136,11 +192,17 @@ func (r *Router) wrapHandler(config RouteConfig) http.HandlerFunc {
 
 // sendError sends an error response
 func (r *Router) sendError(w http.ResponseWriter, err error, status int) {
+	r.sendErrorCode(w, fmt.Sprintf("ERR_%d", status), err.Error(), nil, status)
+}
+
+// sendErrorCode sends an error response with a specific error code
+func (r *Router) sendErrorCode(w http.ResponseWriter, code, message string, details any, status int) {
 	response := APIResponse{
 		Success: false,
 		Error: &APIError{
-			Code:    fmt.Sprintf("ERR_%d", status),
-			Message: err.Error(),
+			Code:    code,
+			Message: message,
+			Details: details,
 		},
 		Meta: &MetaData{
 			Timestamp: time.Now().UTC(),
@@ -180,12 +242,67 @@ func (r *Router) requestIDMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+func (r *Router) recoveryMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				r.logger.Error("Panic recovered",
+					zap.Any("error", err),
+					zap.String("stack", string(debug.Stack())),
+					zap.String("request_id", r.requestID(req)),
+				)
+				r.sendError(w, fmt.Errorf("internal server error"), http.StatusInternalServerError)
+			}
+		}()
+		next.ServeHTTP(w, req)
+	})
+}
+
 func (r *Router) rateLimitMiddleware(limit *RateLimit) mux.MiddlewareFunc {
 	limiter := rate.NewLimiter(rate.Every(limit.Window), limit.Requests)
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-			if !limiter.Allow() {
-				r.sendError(w, fmt.Errorf("rate limit exceeded"), http.StatusTooManyRequests)
+			now := time.Now()
+			allowed := limiter.AllowN(now, 1)
+
+			tokens := limiter.TokensAt(now)
+			remaining := int(tokens)
+			if remaining < 0 {
+				remaining = 0
+			}
+			// A token frees up every Window, so the bucket is full again
+			// once the missing ones have
+			reset := int(math.Ceil((float64(limit.Requests) - tokens) * limit.Window.Seconds()))
+			if reset < 0 {
+				reset = 0
+			}
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limit.Requests))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+			w.Header().Set("X-RateLimit-Reset", strconv.Itoa(reset))
+
+			if !allowed {
+				// Reserve only to learn when a token frees up
+				retryAfter := 1
+				if reservation := limiter.ReserveN(now, 1); reservation.OK() {
+					if seconds := int(math.Ceil(reservation.DelayFrom(now).Seconds())); seconds > 1 {
+						retryAfter = seconds
+					}
+					reservation.CancelAt(now)
+				}
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+
+				r.logger.Warn("Rate limit exceeded",
+					zap.String("method", req.Method),
+					zap.String("path", req.URL.Path),
+					zap.String("remote_addr", req.RemoteAddr),
+					zap.Int("retry_after", retryAfter),
+				)
+				r.sendErrorCode(w, "rate_limited", "rate limit exceeded", map[string]interface{}{
+					"limit":       limit.Requests,
+					"remaining":   remaining,
+					"retry_after": retryAfter,
+					"reset":       reset,
+				}, http.StatusTooManyRequests)
 				return
 			}
 			next.ServeHTTP(w, req)
@@ -205,7 +322,9 @@ func (r *Router) authMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-func (r *Router) loggingMiddleware(next http.Handler) http.Handler {
+// LoggingMiddleware logs each request once it has been served. NewRouter
+// installs it; it is exported for wrapping handlers outside the router.
+func (r *Router) LoggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		start := time.Now()
 		sw := &statusWriter{ResponseWriter: w}
@@ -217,9 +336,26 @@ func (r *Router) loggingMiddleware(next http.Handler) http.Handler {
 			zap.String("path", req.URL.Path),
 			zap.Int("status", sw.status),
 			zap.Duration("duration", time.Since(start)),
-			zap.String("request_id", req.Context().Value("request_id").(string)),
+			zap.String("request_id", r.requestID(req)),
+		)
+	})
+}
+
+// requestID returns the ID requestIDMiddleware stored for req. Requests
+// without one get unknownRequestID, and the first such request is logged so
+// a router missing the middleware gets noticed.
+func (r *Router) requestID(req *http.Request) string {
+	if id, ok := req.Context().Value("request_id").(string); ok && id != "" {
+		return id
+	}
+
+	r.missingRequestID.Do(func() {
+		r.logger.Warn("Request has no request ID, is the request ID middleware installed?",
+			zap.String("method", req.Method),
+			zap.String("path", req.URL.Path),
 		)
 	})
+	return unknownRequestID
 }
 
 // Helper types and functions
@@ -2,20 +2,27 @@ package network
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"runtime/debug"
+	"strconv"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
+	httpmw "github.com/labs-alone/alone-main/pkg/network"
 	"github.com/rs/cors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 // ServerConfig holds the server configuration
@@ -30,6 +37,53 @@ type ServerConfig struct {
 	MetricsPath     string
 	EnableHealth    bool
 	HealthPath      string
+	TLS             *TLSConfig
+
+	// EnableH2C serves cleartext HTTP/2 alongside HTTP/1.1 for internal
+	// clients. It is ignored with TLS, where HTTP/2 is always negotiated.
+	EnableH2C bool
+
+	// ReadHeaderTimeout bounds reading a request's headers, dropping
+	// clients that trickle them in (slowloris). Defaults to
+	// DefaultReadHeaderTimeout.
+	ReadHeaderTimeout time.Duration
+	// MaxHeaderBytes caps the size of a request's headers; larger requests
+	// get a 431. Defaults to DefaultMaxHeaderBytes.
+	MaxHeaderBytes int
+
+	// SecurityHeaders are the security headers set on every response. Nil
+	// sets DefaultSecurityHeaders; set Disabled to send none.
+	SecurityHeaders *SecurityHeadersConfig
+}
+
+// SecurityHeadersConfig controls the security headers set on every
+// response. It is shared with the middleware in pkg/network.
+type SecurityHeadersConfig = httpmw.SecurityHeadersConfig
+
+// DefaultSecurityHeaders returns the headers set when none are configured
+func DefaultSecurityHeaders() *SecurityHeadersConfig {
+	return httpmw.DefaultSecurityHeaders()
+}
+
+const (
+	// DefaultReadHeaderTimeout is used when ServerConfig.ReadHeaderTimeout
+	// is unset
+	DefaultReadHeaderTimeout = 5 * time.Second
+	// DefaultMaxHeaderBytes is used when ServerConfig.MaxHeaderBytes is
+	// unset
+	DefaultMaxHeaderBytes = 64 << 10
+)
+
+// TLSConfig enables TLS termination in the server
+type TLSConfig struct {
+	CertFile   string
+	KeyFile    string
+	MinVersion uint16 // defaults to tls.VersionTLS12
+
+	// RedirectHTTP starts a plain HTTP listener on RedirectPort that
+	// redirects every request to HTTPS
+	RedirectHTTP bool
+	RedirectPort int
 }
 
 // Server represents the HTTP server
@@ -37,19 +91,47 @@ type Server struct {
 	config     *ServerConfig
 	router     *mux.Router
 	server     *http.Server
+	redirect   *http.Server
+	certs      *certReloader
 	logger     *zap.Logger
 	metrics    *Metrics
 	middleware []mux.MiddlewareFunc
+	rotator    LogRotator
 	mu         sync.RWMutex
 }
 
-// Metrics holds the Prometheus metrics
+// LogRotator is a log whose file can be rotated on SIGHUP, such as
+// pkg/utils.Logger
+type LogRotator interface {
+	Rotate() error
+}
+
+// Metrics holds the Prometheus metrics. The request, size and status class
+// metrics are shared with pkg/network so both servers export the same series.
 type Metrics struct {
-	RequestsTotal    *prometheus.CounterVec
-	RequestDuration  *prometheus.HistogramVec
-	ResponseSize     *prometheus.HistogramVec
-	ActiveConnGauge  prometheus.Gauge
-	ErrorsTotal      *prometheus.CounterVec
+	*httpmw.Metrics
+	ActiveConnGauge prometheus.Gauge
+	ErrorsTotal     *prometheus.CounterVec
+}
+
+// NewMetrics creates the HTTP metrics without registering them
+func NewMetrics() *Metrics {
+	return &Metrics{
+		Metrics: httpmw.NewMetrics(),
+		ActiveConnGauge: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "http_active_connections",
+				Help: "Number of active HTTP connections",
+			},
+		),
+		ErrorsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "http_errors_total",
+				Help: "Total number of HTTP errors",
+			},
+			[]string{"method", "path", "error_type"},
+		),
+	}
 }
 
 // NewServer creates a new server instance
@@ -88,44 +170,7 @@ func (s *Server) initializeMetrics() {
 		return
 	}
 
-	s.metrics = &Metrics{
-		RequestsTotal: prometheus.NewCounterVec(
-			prometheus.CounterOpts{
-				Name: "http_requests_total",
-				Help: "Total number of HTTP requests",
-			},
-			[]string{"method", "path", "status"},
-		),
-		RequestDuration: prometheus.NewHistogramVec(
-			prometheus.HistogramOpts{
-				Name:    "http_request_duration_seconds",
-				Help:    "HTTP request duration in seconds",
-				Buckets: prometheus.DefBuckets,
-			},
-			[]string{"method", "path"},
-		),
-		ResponseSize: prometheus.NewHistogramVec(
-			prometheus.HistogramOpts{
-				Name:    "http_response_size_bytes",
-				Help:    "HTTP response size in bytes",
-				Buckets: prometheus.ExponentialBuckets(100, 10, 8),
-			},
-			[]string{"method", "path"},
-		),
-		ActiveConnGauge: prometheus.NewGauge(
-			prometheus.GaugeOpts{
-				Name: "http_active_connections",
-				Help: "Number of active HTTP connections",
-			},
-		),
-		ErrorsTotal: prometheus.NewCounterVec(
-			prometheus.CounterOpts{
-				Name: "http_errors_total",
-				Help: "Total number of HTTP errors",
-			},
-			[]string{"method", "path", "error_type"},
-		),
-	}
+	s.metrics = NewMetrics()
 
 	// Register metrics with Prometheus
 	prometheus.MustRegister(
@@ -134,6 +179,7 @@ func (s *Server) initializeMetrics() {
 		s.metrics.ResponseSize,
 		s.metrics.ActiveConnGauge,
 		s.metrics.ErrorsTotal,
+		s.metrics.StatusClassTotal,
 	)
 }
 
@@ -151,6 +197,8 @@ func (s *Server) setupMiddleware() {
 		s.router.Use(corsMiddleware.Handler)
 	}
 
+	s.router.Use(httpmw.SecurityHeaders(s.config.SecurityHeaders))
+
 	// Add metrics middleware
 	if s.config.EnableMetrics {
 		s.router.Use(s.metricsMiddleware)
@@ -176,63 +224,276 @@ func (s *Server) setupRoutes() {
 	}
 }
 
-// Start starts the HTTP server
+// Start starts the HTTP server and blocks until it receives a shutdown
+// signal or fails. SIGHUP reloads the TLS certificate without downtime and
+// rotates the log file set with SetLogRotator.
 func (s *Server) Start() error {
-	s.server = &http.Server{
-		Addr:         fmt.Sprintf(":%d", s.config.Port),
-		Handler:      s.router,
-		ReadTimeout:  s.config.ReadTimeout,
-		WriteTimeout: s.config.WriteTimeout,
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", s.config.Port))
+	if err != nil {
+		return fmt.Errorf("server error: %v", err)
 	}
 
 	// Channel for shutdown signals
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 
+	// Channel for certificate reload signals
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	defer signal.Stop(reload)
+
 	// Channel for server errors
-	errChan := make(chan error, 1)
+	errChan := make(chan error, 2)
 
 	// Start server in goroutine
 	go func() {
-		s.logger.Info("Starting server", zap.Int("port", s.config.Port))
-		if err := s.server.ListenAndServe(); err != http.ErrServerClosed {
+		s.logger.Info("Starting server",
+			zap.Int("port", s.config.Port),
+			zap.Bool("tls", s.config.TLS != nil),
+		)
+		if err := s.Serve(ln); err != nil {
 			errChan <- err
 		}
 	}()
 
+	if s.config.TLS != nil && s.config.TLS.RedirectHTTP {
+		go func() {
+			if err := s.serveRedirect(); err != nil {
+				errChan <- err
+			}
+		}()
+	}
+
 	// Wait for shutdown signal or error
-	select {
-	case err := <-errChan:
-		return fmt.Errorf("server error: %v", err)
-	case <-stop:
-		s.logger.Info("Shutting down server...")
-		return s.Shutdown()
+	for {
+		select {
+		case err := <-errChan:
+			return fmt.Errorf("server error: %v", err)
+		case <-reload:
+			s.reload()
+		case <-stop:
+			s.logger.Info("Shutting down server...")
+			return s.Shutdown()
+		}
 	}
 }
 
+// Serve accepts connections on ln until the server is shut down, using TLS
+// when configured. HTTP/2 is negotiated over TLS, or spoken in cleartext
+// with EnableH2C. It does not handle signals; see Start.
+func (s *Server) Serve(ln net.Listener) error {
+	var handler http.Handler = s.router
+	if s.config.EnableH2C && s.config.TLS == nil {
+		handler = h2c.NewHandler(handler, &http2.Server{})
+	}
+
+	server := s.httpServer(handler)
+	if s.config.TLS != nil {
+		tlsConfig, err := s.tlsConfig()
+		if err != nil {
+			return err
+		}
+		server.TLSConfig = tlsConfig
+	}
+
+	s.mu.Lock()
+	s.server = server
+	s.mu.Unlock()
+
+	var err error
+	if server.TLSConfig != nil {
+		// The certificate comes from TLSConfig.GetCertificate
+		err = server.ServeTLS(ln, "", "")
+	} else {
+		err = server.Serve(ln)
+	}
+
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// reload handles SIGHUP, reloading the TLS certificate and rotating the log
+// file when each is configured
+func (s *Server) reload() {
+	if s.config.TLS != nil {
+		if err := s.ReloadCertificates(); err != nil {
+			s.logger.Error("Failed to reload TLS certificate", zap.Error(err))
+		} else {
+			s.logger.Info("TLS certificate reloaded")
+		}
+	}
+
+	s.mu.RLock()
+	rotator := s.rotator
+	s.mu.RUnlock()
+
+	if rotator != nil {
+		if err := rotator.Rotate(); err != nil {
+			s.logger.Error("Failed to rotate log file", zap.Error(err))
+		} else {
+			s.logger.Info("Log file rotated")
+		}
+	}
+}
+
+// SetLogRotator rotates log's file whenever the server receives SIGHUP
+func (s *Server) SetLogRotator(log LogRotator) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rotator = log
+}
+
+// ReloadCertificates reloads the TLS certificate and key from disk. New
+// connections use the new certificate; existing ones are unaffected.
+func (s *Server) ReloadCertificates() error {
+	s.mu.RLock()
+	certs := s.certs
+	s.mu.RUnlock()
+
+	if certs == nil {
+		return fmt.Errorf("TLS is not configured")
+	}
+	return certs.reload()
+}
+
+// tlsConfig builds the TLS configuration with modern defaults
+func (s *Server) tlsConfig() (*tls.Config, error) {
+	certs, err := newCertReloader(s.config.TLS.CertFile, s.config.TLS.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.certs = certs
+	s.mu.Unlock()
+
+	minVersion := s.config.TLS.MinVersion
+	if minVersion == 0 {
+		minVersion = tls.VersionTLS12
+	}
+
+	return &tls.Config{
+		MinVersion:     minVersion,
+		NextProtos:     []string{"h2", "http/1.1"},
+		GetCertificate: certs.getCertificate,
+		CurvePreferences: []tls.CurveID{
+			tls.X25519,
+			tls.CurveP256,
+		},
+		// Only used for TLS 1.2; TLS 1.3 suites are not configurable.
+		// HTTP/2 requires TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256.
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+	}, nil
+}
+
+// httpServer returns an http.Server for handler with the configured
+// timeouts and header limits
+func (s *Server) httpServer(handler http.Handler) *http.Server {
+	readHeaderTimeout := s.config.ReadHeaderTimeout
+	if readHeaderTimeout <= 0 {
+		readHeaderTimeout = DefaultReadHeaderTimeout
+	}
+	maxHeaderBytes := s.config.MaxHeaderBytes
+	if maxHeaderBytes <= 0 {
+		maxHeaderBytes = DefaultMaxHeaderBytes
+	}
+
+	return &http.Server{
+		Handler:           handler,
+		ReadTimeout:       s.config.ReadTimeout,
+		ReadHeaderTimeout: readHeaderTimeout,
+		WriteTimeout:      s.config.WriteTimeout,
+		MaxHeaderBytes:    maxHeaderBytes,
+	}
+}
+
+// serveRedirect serves plain HTTP on the redirect port, sending every
+// request to the HTTPS listener
+func (s *Server) serveRedirect() error {
+	redirect := s.httpServer(http.HandlerFunc(s.redirectHandler))
+	redirect.Addr = fmt.Sprintf(":%d", s.config.TLS.RedirectPort)
+
+	s.mu.Lock()
+	s.redirect = redirect
+	s.mu.Unlock()
+
+	s.logger.Info("Starting HTTPS redirect", zap.Int("port", s.config.TLS.RedirectPort))
+	if err := redirect.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// redirectHandler redirects a plain HTTP request to its HTTPS equivalent
+func (s *Server) redirectHandler(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if s.config.Port != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(s.config.Port))
+	}
+
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+}
+
 // Shutdown gracefully shuts down the server
 func (s *Server) Shutdown() error {
 	ctx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
 	defer cancel()
 
+	s.mu.RLock()
+	server, redirect := s.server, s.redirect
+	s.mu.RUnlock()
+
+	if redirect != nil {
+		if err := redirect.Shutdown(ctx); err != nil {
+			return fmt.Errorf("redirect shutdown error: %v", err)
+		}
+	}
+
 	// Shutdown server
-	if err := s.server.Shutdown(ctx); err != nil {
-		return fmt.Errorf("server shutdown error: %v", err)
+	if server != nil {
+		if err := server.Shutdown(ctx); err != nil {
+			return fmt.Errorf("server shutdown error: %v", err)
+		}
 	}
 
 	s.logger.Info("Server shutdown complete")
 	return nil
 }
 
-// AddRoute adds a new route to the server
-func (s *Server) AddRoute(method, path string, handler http.HandlerFunc, middleware ...mux.MiddlewareFunc) {
+// AddRoute adds a new route to the server. Route middleware run in order
+// after the server-wide middleware. It returns ErrDuplicateRoute if the
+// method and path are already served, including by the health and metrics
+// endpoints.
+func (s *Server) AddRoute(method, path string, handler http.HandlerFunc, middleware ...mux.MiddlewareFunc) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	route := s.router.HandleFunc(path, handler).Methods(method)
-	for _, m := range middleware {
-		route.Handler(m(handler))
+	if hasRoute(s.router, method, path) {
+		return fmt.Errorf("%w: %s %s", ErrDuplicateRoute, method, path)
 	}
+	s.router.Handle(path, chainMiddleware(handler, middleware...)).Methods(method)
+	return nil
+}
+
+// chainMiddleware wraps handler so that middleware run in the order given,
+// matching the order of router.Use
+func chainMiddleware(handler http.Handler, middleware ...mux.MiddlewareFunc) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
 }
 
 // healthHandler handles health check requests
@@ -253,13 +514,31 @@ func (s *Server) metricsMiddleware(next http.Handler) http.Handler {
 		s.metrics.ActiveConnGauge.Inc()
 		defer s.metrics.ActiveConnGauge.Dec()
 
-		next.ServeHTTP(w, r)
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
 
+		status := sw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		route := routeLabel(r)
 		duration := time.Since(start).Seconds()
-		s.metrics.RequestDuration.WithLabelValues(r.Method, r.URL.Path).Observe(duration)
+		s.metrics.RequestDuration.WithLabelValues(r.Method, route).Observe(duration)
+		s.metrics.StatusClassTotal.WithLabelValues(r.Method, route, httpmw.StatusClass(status)).Inc()
 	})
 }
 
+// routeLabel returns the template of the route r matched, keeping metric
+// label cardinality bounded by the number of routes
+func routeLabel(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if tmpl, err := route.GetPathTemplate(); err == nil {
+			return tmpl
+		}
+	}
+	return "unmatched"
+}
+
 // loggingMiddleware logs request information
 func (s *Server) loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,46 @@
+package network
+
+import (
+	"crypto/tls"
+	"fmt"
+	"sync"
+)
+
+// certReloader serves a certificate that can be swapped at runtime
+type certReloader struct {
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+	mu       sync.RWMutex
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload loads the key pair from disk, keeping the current certificate if
+// the new one is invalid
+func (r *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
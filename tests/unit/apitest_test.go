@@ -0,0 +1,66 @@
+package unit
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	models "github.com/labs-alone/alone-main/internal/core"
+	"github.com/labs-alone/alone-main/pkg/api"
+	"github.com/labs-alone/alone-main/pkg/api/apitest"
+)
+
+// getJSON fetches path from the test server and decodes the API response
+func getJSON(t *testing.T, server *apitest.Server, path string) (int, api.Response) {
+	resp, err := http.Get(server.URL + path)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var body api.Response
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	return resp.StatusCode, body
+}
+
+func TestTestServerEndToEnd(t *testing.T) {
+	server := apitest.NewTestServer(t, apitest.Options{})
+
+	status, resp := getJSON(t, server, "/api/v1/health")
+	require.Equal(t, http.StatusOK, status)
+	health := resp.Data.(map[string]interface{})
+	assert.Equal(t, "ok", health["status"])
+
+	address := "11111111111111111111111111111111"
+	server.RPC.SetBalance(address, 1_500_000_000)
+
+	status, resp = getJSON(t, server, "/api/v1/solana/balance?address="+address)
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, float64(1_500_000_000), resp.Data)
+
+	status, resp = getJSON(t, server, "/api/v1/solana/balance?address="+address+"&unit=sol")
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "1.5", resp.Data)
+	assert.Equal(t, 2, server.RPC.Calls("getBalance"))
+}
+
+func TestTestServerOverrides(t *testing.T) {
+	users := newMockUserStore(3)
+	server := apitest.NewTestServer(t, apitest.Options{Users: users})
+	assert.NotNil(t, server.DB, "the database is still mocked")
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/api/v1/users", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+server.Token("admin-1", "admin"))
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var body struct {
+		Data api.ListResponse[models.User] `json:"data"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Len(t, body.Data.Items, 3)
+}
@@ -0,0 +1,190 @@
+package unit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	middleware "github.com/labs-alone/alone-main/internal/middleware"
+	"github.com/labs-alone/alone-main/pkg/auth"
+	"github.com/labs-alone/alone-main/pkg/logger"
+	"github.com/labs-alone/alone-main/pkg/network"
+)
+
+func TestClaimsFromContextAcrossMiddlewares(t *testing.T) {
+	const secret = "test-secret"
+
+	authMiddleware := middleware.NewAuthMiddleware(logger.Nop())
+	internalToken, err := authMiddleware.GenerateToken("user-1", "admin")
+	require.NoError(t, err)
+
+	config := &network.MiddlewareConfig{}
+	config.JWT.Secret = secret
+	manager := network.NewMiddlewareManager(context.Background(), config, zap.NewNop(), nil)
+	networkToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": "user-1",
+		"role":    "admin",
+		"exp":     time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte(secret))
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name  string
+		wrap  func(http.Handler) http.Handler
+		token string
+	}{
+		{name: "Internal Authenticate", wrap: authMiddleware.Authenticate, token: internalToken},
+		{name: "Network JWTAuth", wrap: manager.JWTAuth(), token: networkToken},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var claims jwt.MapClaims
+			var found bool
+			var userID, role string
+			handler := tc.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				claims, found = auth.ClaimsFromContext(r.Context())
+				userID = auth.UserID(r.Context())
+				role = auth.Role(r.Context())
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			require.True(t, found)
+			assert.Equal(t, "user-1", claims["user_id"])
+			assert.Equal(t, "admin", claims["role"])
+			assert.Contains(t, claims, "exp")
+			assert.Equal(t, "user-1", userID)
+			assert.Equal(t, "admin", role)
+
+			// Role checks work after either middleware
+			rec := httptest.NewRecorder()
+			tc.wrap(authMiddleware.RequireRole("admin")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			}))).ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusNoContent, rec.Code)
+		})
+	}
+}
+
+func TestClaimsFromContextUnauthenticated(t *testing.T) {
+	claims, ok := auth.ClaimsFromContext(context.Background())
+	assert.False(t, ok)
+	assert.Nil(t, claims)
+	assert.Empty(t, auth.UserID(context.Background()))
+
+	// Claims without the conventional fields are still available
+	ctx := auth.WithClaims(context.Background(), map[string]interface{}{"sub": "svc", "role": 7})
+	claims, ok = auth.ClaimsFromContext(ctx)
+	require.True(t, ok)
+	assert.Equal(t, "svc", claims["sub"])
+	assert.Empty(t, auth.UserID(ctx))
+	assert.Empty(t, auth.Role(ctx), "a non-string role isn't a role")
+}
+
+func TestAuthKeyRotation(t *testing.T) {
+	oldKey := middleware.JWTKey{ID: "2024-01", Secret: []byte("old-secret")}
+	newKey := middleware.JWTKey{ID: "2024-06", Secret: []byte("new-secret")}
+
+	before, err := middleware.NewAuthMiddlewareWithConfig(&middleware.AuthConfig{
+		Keys: []middleware.JWTKey{oldKey},
+	}, logger.Nop())
+	require.NoError(t, err)
+	oldToken, err := before.GenerateToken("user-1", "user")
+	require.NoError(t, err)
+
+	after, err := middleware.NewAuthMiddlewareWithConfig(&middleware.AuthConfig{
+		Keys: []middleware.JWTKey{newKey, oldKey},
+	}, logger.Nop())
+	require.NoError(t, err)
+	newToken, err := after.GenerateToken("user-2", "user")
+	require.NoError(t, err)
+
+	// New tokens are signed with the primary key and name it
+	parsed, _, err := jwt.NewParser().ParseUnverified(newToken, jwt.MapClaims{})
+	require.NoError(t, err)
+	assert.Equal(t, "2024-06", parsed.Header["kid"])
+
+	// Tokens signed before the rotation still validate
+	claims, err := after.ValidateToken(oldToken)
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", claims["user_id"])
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+oldToken)
+	after.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})).ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	claims, err = after.ValidateToken(newToken)
+	require.NoError(t, err)
+	assert.Equal(t, "user-2", claims["user_id"])
+
+	// Once the old key is retired its tokens are rejected
+	retired, err := middleware.NewAuthMiddlewareWithConfig(&middleware.AuthConfig{
+		Keys: []middleware.JWTKey{newKey},
+	}, logger.Nop())
+	require.NoError(t, err)
+	_, err = retired.ValidateToken(oldToken)
+	assert.ErrorContains(t, err, "unknown key")
+	_, err = retired.ValidateToken(newToken)
+	assert.NoError(t, err)
+
+	// A token naming a key must be signed by that key
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": "user-3",
+		"exp":     time.Now().Add(time.Hour).Unix(),
+	})
+	forged.Header["kid"] = newKey.ID
+	forgedToken, err := forged.SignedString(oldKey.Secret)
+	require.NoError(t, err)
+	_, err = after.ValidateToken(forgedToken)
+	assert.ErrorIs(t, err, jwt.ErrTokenSignatureInvalid)
+
+	// Tokens without a key ID are tried against every key
+	legacy, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": "user-4",
+		"exp":     time.Now().Add(time.Hour).Unix(),
+	}).SignedString(oldKey.Secret)
+	require.NoError(t, err)
+	claims, err = after.ValidateToken(legacy)
+	require.NoError(t, err)
+	assert.Equal(t, "user-4", claims["user_id"])
+}
+
+func TestAuthConfigValidation(t *testing.T) {
+	secret := []byte("secret")
+
+	testCases := []struct {
+		name          string
+		keys          []middleware.JWTKey
+		expectedError string
+	}{
+		{name: "No Keys", expectedError: "at least one JWT key is required"},
+		{name: "Missing ID", keys: []middleware.JWTKey{{Secret: secret}}, expectedError: "JWT key 0 has no ID"},
+		{name: "Missing Secret", keys: []middleware.JWTKey{{ID: "a"}}, expectedError: `JWT key "a" has no secret`},
+		{
+			name:          "Duplicate ID",
+			keys:          []middleware.JWTKey{{ID: "a", Secret: secret}, {ID: "a", Secret: []byte("other")}},
+			expectedError: `duplicate JWT key ID "a"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := middleware.NewAuthMiddlewareWithConfig(&middleware.AuthConfig{Keys: tc.keys}, logger.Nop())
+			assert.EqualError(t, err, tc.expectedError)
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/labs-alone/alone-main/internal/openai"
+	"github.com/labs-alone/alone-main/internal/solana"
+	"github.com/labs-alone/alone-main/internal/utils"
+)
+
+func TestRemainingBudget(t *testing.T) {
+	_, ok := utils.RemainingBudget(context.Background())
+	assert.False(t, ok)
+
+	ctx, cancel := utils.WithBudget(context.Background(), time.Second)
+	defer cancel()
+	remaining, ok := utils.RemainingBudget(ctx)
+	require.True(t, ok)
+	assert.LessOrEqual(t, remaining, time.Second)
+	assert.Greater(t, remaining, 900*time.Millisecond)
+
+	// A shorter outer deadline is the budget
+	inner, cancel := utils.WithBudget(ctx, time.Hour)
+	defer cancel()
+	remaining, _ = utils.RemainingBudget(inner)
+	assert.LessOrEqual(t, remaining, time.Second)
+
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	remaining, ok = utils.RemainingBudget(expired)
+	assert.True(t, ok)
+	assert.Zero(t, remaining)
+}
+
+func TestWithCallTimeout(t *testing.T) {
+	ctx, cancel := utils.WithCallTimeout(context.Background(), time.Minute)
+	defer cancel()
+	remaining, ok := utils.RemainingBudget(ctx)
+	require.True(t, ok)
+	assert.Greater(t, remaining, 59*time.Second)
+
+	budget, cancel := utils.WithBudget(context.Background(), time.Second)
+	defer cancel()
+	ctx, cancel = utils.WithCallTimeout(budget, time.Minute)
+	defer cancel()
+	remaining, _ = utils.RemainingBudget(ctx)
+	assert.LessOrEqual(t, remaining, time.Second)
+
+	ctx, cancel = utils.WithCallTimeout(context.Background(), 0)
+	defer cancel()
+	_, ok = utils.RemainingBudget(ctx)
+	assert.False(t, ok)
+}
+
+// deadlineTransport records the budget left on each request it sends
+type deadlineTransport struct {
+	remaining []time.Duration
+	mu        sync.Mutex
+}
+
+func (d *deadlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	remaining, _ := utils.RemainingBudget(req.Context())
+	d.mu.Lock()
+	d.remaining = append(d.remaining, remaining)
+	d.mu.Unlock()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func (d *deadlineTransport) Remaining() []time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]time.Duration(nil), d.remaining...)
+}
+
+func TestDownstreamCallsShareBudget(t *testing.T) {
+	const (
+		budget    = 2 * time.Second
+		rpcDelay  = 300 * time.Millisecond
+		aiTimeout = 30 * time.Second
+	)
+
+	rpc := newMockRPC(t)
+	rpc.handle("getBalance", func(json.RawMessage) (interface{}, *rpcError) {
+		time.Sleep(rpcDelay)
+		return rpcContext(42), nil
+	})
+	solanaClient := setupMockSolanaClient(t, rpc)
+	defer solanaClient.Close()
+
+	server, _ := setupMockOpenAI(t, nil)
+	transport := &deadlineTransport{}
+	aiClient, err := openai.NewClient(&openai.ClientConfig{
+		APIKey:    "test-key",
+		BaseURL:   server.URL,
+		Timeout:   aiTimeout,
+		Transport: transport,
+	})
+	require.NoError(t, err)
+
+	req := &openai.ChatCompletionRequest{
+		Model:    "gpt-4",
+		Messages: []openai.ChatMessage{{Role: "user", Content: "hi"}},
+	}
+
+	ctx, cancel := utils.WithBudget(context.Background(), budget)
+	defer cancel()
+
+	_, err = solanaClient.GetBalance(ctx, "11111111111111111111111111111111")
+	require.NoError(t, err)
+	_, err = aiClient.CreateChatCompletion(ctx, req)
+	require.NoError(t, err)
+
+	// Without a budget the call gets the client's full timeout
+	_, err = aiClient.CreateChatCompletion(context.Background(), req)
+	require.NoError(t, err)
+
+	remaining := transport.Remaining()
+	require.Len(t, remaining, 2)
+	assert.LessOrEqual(t, remaining[0], budget-rpcDelay, "second call gets what the first left")
+	assert.Greater(t, remaining[0], time.Duration(0))
+	assert.Greater(t, remaining[1], budget)
+	assert.LessOrEqual(t, remaining[1], aiTimeout)
+}
+
+func TestDownstreamCallExhaustsBudget(t *testing.T) {
+	rpc := newMockRPC(t)
+	rpc.handle("getBalance", func(json.RawMessage) (interface{}, *rpcError) {
+		time.Sleep(200 * time.Millisecond)
+		return rpcContext(42), nil
+	})
+
+	// The client timeout bounds the call when there's no shorter budget
+	client, err := solana.NewClient(&solana.ClientConfig{
+		Endpoint:   rpc.server.URL,
+		Commitment: "confirmed",
+		Timeout:    50 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer client.Close()
+
+	_, err = client.GetBalance(context.Background(), "11111111111111111111111111111111")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Once the budget is spent, later calls fail without a fresh timeout
+	server, models := setupMockOpenAI(t, nil)
+	aiClient, err := openai.NewClient(&openai.ClientConfig{APIKey: "test-key", BaseURL: server.URL})
+	require.NoError(t, err)
+
+	ctx, cancel := utils.WithBudget(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	<-ctx.Done()
+
+	_, err = aiClient.CreateChatCompletion(ctx, &openai.ChatCompletionRequest{Model: "gpt-4"})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Empty(t, *models)
+}
@@ -0,0 +1,243 @@
+package unit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/labs-alone/alone-main/pkg/network"
+)
+
+// headerCountingWriter counts the WriteHeader calls reaching the client
+type headerCountingWriter struct {
+	*httptest.ResponseRecorder
+	headerWrites int
+}
+
+func (w *headerCountingWriter) WriteHeader(statusCode int) {
+	w.headerWrites++
+	w.ResponseRecorder.WriteHeader(statusCode)
+}
+
+func TestCacheMiddlewareHeader(t *testing.T) {
+	config := &network.MiddlewareConfig{}
+	config.Cache.Enabled = true
+	manager := network.NewMiddlewareManager(context.Background(), config, zap.NewNop(), nil)
+
+	calls := 0
+	handler := manager.Cache(time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch r.URL.Path {
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"error":"not found"}`))
+		default:
+			w.Write([]byte(`{"ok":true}`))
+		}
+	}))
+
+	serve := func(path string) *headerCountingWriter {
+		rec := &headerCountingWriter{ResponseRecorder: httptest.NewRecorder()}
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	testCases := []struct {
+		name     string
+		path     string
+		status   int
+		xCache   string
+		body     string
+		handlers int
+	}{
+		{name: "Miss", path: "/ok", status: http.StatusOK, xCache: "MISS", body: `{"ok":true}`, handlers: 1},
+		{name: "Hit", path: "/ok", status: http.StatusOK, xCache: "HIT", body: `{"ok":true}`, handlers: 1},
+		{name: "Not Cacheable", path: "/missing", status: http.StatusNotFound, xCache: "MISS", body: `{"error":"not found"}`, handlers: 2},
+		{name: "Not Cacheable Again", path: "/missing", status: http.StatusNotFound, xCache: "MISS", body: `{"error":"not found"}`, handlers: 3},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := serve(tc.path)
+			require.Equal(t, tc.status, rec.Code)
+			assert.Equal(t, tc.xCache, rec.Header().Get("X-Cache"))
+			assert.Equal(t, tc.body, rec.Body.String())
+			assert.LessOrEqual(t, rec.headerWrites, 1)
+			assert.Equal(t, tc.handlers, calls)
+		})
+	}
+}
+
+func TestCacheMiddlewareContentTypes(t *testing.T) {
+	config := &network.MiddlewareConfig{}
+	config.Cache.Enabled = true
+	config.Cache.ContentTypes = []string{"text/plain", "image/"}
+	config.Cache.StatusCodes = []int{http.StatusOK, http.StatusNotFound}
+	manager := network.NewMiddlewareManager(context.Background(), config, zap.NewNop(), nil)
+
+	png := []byte("\x89PNG\r\n\x1a\n")
+	calls := make(map[string]int)
+	handler := manager.Cache(time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls[r.URL.Path]++
+		switch r.URL.Path {
+		case "/text":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Set-Cookie", "session=abc")
+			w.Write([]byte("hello"))
+		case "/gone":
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("gone"))
+		case "/image":
+			// Sniffed, as the handler sets no Content-Type
+			w.Write(png)
+		case "/json":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"ok":true}`))
+		case "/error":
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("failed"))
+		}
+	}))
+
+	testCases := []struct {
+		name        string
+		path        string
+		cached      bool
+		status      int
+		contentType string
+		body        string
+	}{
+		{name: "Text", path: "/text", cached: true, status: http.StatusOK, contentType: "text/plain; charset=utf-8", body: "hello"},
+		{name: "Configured Status", path: "/gone", cached: true, status: http.StatusNotFound, contentType: "text/plain", body: "gone"},
+		{name: "Sniffed Image", path: "/image", cached: true, status: http.StatusOK, contentType: "image/png", body: string(png)},
+		{name: "Type Not Configured", path: "/json", status: http.StatusOK, contentType: "application/json", body: `{"ok":true}`},
+		{name: "Status Not Configured", path: "/error", status: http.StatusInternalServerError, contentType: "text/plain", body: "failed"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for i := 0; i < 2; i++ {
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+				assert.Equal(t, tc.status, rec.Code)
+				assert.Equal(t, tc.contentType, rec.Header().Get("Content-Type"))
+				assert.Equal(t, tc.body, rec.Body.String())
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			if tc.cached {
+				assert.Equal(t, "HIT", rec.Header().Get("X-Cache"))
+				assert.Equal(t, 1, calls[tc.path])
+			} else {
+				assert.Equal(t, "MISS", rec.Header().Get("X-Cache"))
+				assert.Equal(t, 3, calls[tc.path])
+			}
+		})
+	}
+
+	// Content headers are replayed on a hit, per-request ones aren't
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/text", nil))
+	assert.Equal(t, `"v1"`, rec.Header().Get("ETag"))
+	assert.Empty(t, rec.Header().Get("Set-Cookie"))
+}
+
+func TestCacheMiddlewareMethods(t *testing.T) {
+	newHandler := func(configure func(*network.MiddlewareConfig)) (func(method, authorization string) *httptest.ResponseRecorder, *int) {
+		config := &network.MiddlewareConfig{}
+		config.Cache.Enabled = true
+		if configure != nil {
+			configure(config)
+		}
+		manager := network.NewMiddlewareManager(context.Background(), config, zap.NewNop(), nil)
+
+		calls := 0
+		handler := manager.Cache(time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"user":"` + r.Header.Get("Authorization") + `"}`))
+		}))
+
+		serve := func(method, authorization string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, "/items", nil)
+			if authorization != "" {
+				req.Header.Set("Authorization", authorization)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			return rec
+		}
+		return serve, &calls
+	}
+
+	t.Run("POST Never Cached", func(t *testing.T) {
+		serve, calls := newHandler(nil)
+		assert.Equal(t, "MISS", serve(http.MethodGet, "").Header().Get("X-Cache"))
+		for i := 0; i < 2; i++ {
+			rec := serve(http.MethodPost, "")
+			assert.Equal(t, "MISS", rec.Header().Get("X-Cache"))
+		}
+		assert.Equal(t, 3, *calls)
+		assert.Equal(t, "HIT", serve(http.MethodGet, "").Header().Get("X-Cache"))
+		assert.Equal(t, "MISS", serve(http.MethodPost, "").Header().Get("X-Cache"))
+		assert.Equal(t, 4, *calls)
+	})
+
+	t.Run("HEAD Cached", func(t *testing.T) {
+		serve, calls := newHandler(nil)
+		serve(http.MethodHead, "")
+		assert.Equal(t, "HIT", serve(http.MethodHead, "").Header().Get("X-Cache"))
+		assert.Equal(t, 1, *calls)
+	})
+
+	t.Run("Configured Methods", func(t *testing.T) {
+		serve, calls := newHandler(func(c *network.MiddlewareConfig) {
+			c.Cache.Methods = []string{http.MethodGet}
+		})
+		serve(http.MethodHead, "")
+		assert.Equal(t, "MISS", serve(http.MethodHead, "").Header().Get("X-Cache"))
+		assert.Equal(t, 2, *calls)
+	})
+
+	t.Run("Authorized Not Cached", func(t *testing.T) {
+		serve, calls := newHandler(nil)
+		serve(http.MethodGet, "Bearer alice")
+		rec := serve(http.MethodGet, "Bearer alice")
+		assert.Equal(t, "MISS", rec.Header().Get("X-Cache"))
+		assert.Equal(t, 2, *calls)
+
+		// Nor served from an anonymous entry
+		serve(http.MethodGet, "")
+		assert.Equal(t, "MISS", serve(http.MethodGet, "Bearer alice").Header().Get("X-Cache"))
+		assert.Equal(t, 4, *calls)
+	})
+
+	t.Run("Authorized Allowed", func(t *testing.T) {
+		serve, calls := newHandler(func(c *network.MiddlewareConfig) {
+			c.Cache.AllowAuthorized = true
+		})
+		first := serve(http.MethodGet, "Bearer alice")
+		assert.Equal(t, "Authorization", first.Header().Get("Vary"))
+
+		rec := serve(http.MethodGet, "Bearer alice")
+		assert.Equal(t, "HIT", rec.Header().Get("X-Cache"))
+		assert.Equal(t, "Authorization", rec.Header().Get("Vary"))
+		assert.Equal(t, `{"user":"Bearer alice"}`, rec.Body.String())
+
+		rec = serve(http.MethodGet, "Bearer bob")
+		assert.Equal(t, "MISS", rec.Header().Get("X-Cache"))
+		assert.Equal(t, `{"user":"Bearer bob"}`, rec.Body.String())
+		assert.Equal(t, 2, *calls)
+	})
+}
@@ -0,0 +1,168 @@
+package unit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/labs-alone/alone-main/internal/utils"
+	"github.com/labs-alone/alone-main/pkg/api"
+)
+
+const balancePath = "/api/v1/solana/balance?address=11111111111111111111111111111111"
+
+// coalescingMetrics returns the coalescing metrics reported for GET endpoint
+func coalescingMetrics(t *testing.T, router http.Handler, endpoint string) map[string]interface{} {
+	rec, resp := doRequest(router, http.MethodGet, "/api/v1/metrics", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	coalescing := resp.Data.(map[string]interface{})["coalescing"].(map[string]interface{})
+	metrics, _ := coalescing["GET "+endpoint].(map[string]interface{})
+	return metrics
+}
+
+func TestCoalescedRequestsSaved(t *testing.T) {
+	const requests = 4
+
+	release := make(chan struct{})
+	var once sync.Once
+	t.Cleanup(func() { once.Do(func() { close(release) }) })
+
+	rpc := newMockRPC(t)
+	rpc.handle("getBalance", func(json.RawMessage) (interface{}, *rpcError) {
+		<-release
+		return rpcContext(42), nil
+	})
+	router := setupTestRouter(t, api.NewHandler(nil, setupMockSolanaClient(t, rpc), nil))
+
+	recs := make([]*httptest.ResponseRecorder, requests)
+	var wg sync.WaitGroup
+	get := func(i int) {
+		defer wg.Done()
+		recs[i], _ = doRequest(router, http.MethodGet, balancePath, "")
+	}
+
+	// The first request is upstream before the others arrive
+	wg.Add(1)
+	go get(0)
+	require.Eventually(t, func() bool { return rpc.callCount("getBalance") == 1 },
+		time.Second, 5*time.Millisecond)
+
+	wg.Add(requests - 1)
+	for i := 1; i < requests; i++ {
+		go get(i)
+	}
+	require.Eventually(t, func() bool {
+		metrics := coalescingMetrics(t, router, "/api/v1/solana/balance")
+		return metrics != nil && metrics["coalesced"] == float64(requests-1)
+	}, time.Second, 5*time.Millisecond)
+
+	once.Do(func() { close(release) })
+	wg.Wait()
+
+	for _, rec := range recs {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, recs[0].Body.String(), rec.Body.String())
+	}
+	assert.Equal(t, "COALESCED", recs[1].Header().Get("X-Cache"))
+	assert.Equal(t, 1, rpc.callCount("getBalance"))
+
+	metrics := coalescingMetrics(t, router, "/api/v1/solana/balance")
+	assert.Equal(t, float64(requests), metrics["requests"])
+	assert.Equal(t, float64(requests-1), metrics["coalesced"])
+	assert.Equal(t, float64(0), metrics["cache_hits"])
+}
+
+func TestCachedResponsesHitRatio(t *testing.T) {
+	rpc := newMockRPC(t)
+	rpc.on("getBalance", rpcContext(42))
+
+	config := &utils.Config{}
+	config.Cache.Enabled = true
+	config.Cache.TTL = 60
+	router := api.NewRouter(api.NewHandler(nil, setupMockSolanaClient(t, rpc), nil), config)
+
+	first, _ := doRequest(router, http.MethodGet, balancePath, "")
+	require.Equal(t, http.StatusOK, first.Code)
+	second, _ := doRequest(router, http.MethodGet, balancePath, "")
+	require.Equal(t, http.StatusOK, second.Code)
+
+	assert.Equal(t, "HIT", second.Header().Get("X-Cache"))
+	assert.JSONEq(t, first.Body.String(), second.Body.String())
+	assert.Equal(t, 1, rpc.callCount("getBalance"))
+
+	// A different query is a different resource
+	rec, _ := doRequest(router, http.MethodGet, balancePath+"&unit=sol", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 2, rpc.callCount("getBalance"))
+
+	metrics := coalescingMetrics(t, router, "/api/v1/solana/balance")
+	assert.Equal(t, float64(3), metrics["requests"])
+	assert.Equal(t, float64(1), metrics["cache_hits"])
+	assert.Equal(t, float64(2), metrics["cache_misses"])
+	assert.InDelta(t, 1.0/3, metrics["cache_hit_ratio"], 0.001)
+}
+
+func TestCachedResponsesBounded(t *testing.T) {
+	rpc := newMockRPC(t)
+	rpc.on("getBalance", rpcContext(42))
+
+	config := &utils.Config{}
+	config.Cache.Enabled = true
+	config.Cache.TTL = 60
+	config.Cache.MaxEntries = 2
+	router := api.NewRouter(api.NewHandler(nil, setupMockSolanaClient(t, rpc), nil), config)
+
+	for _, query := range []string{"", "&unit=lamports", "&unit=sol"} {
+		rec, _ := doRequest(router, http.MethodGet, balancePath+query, "")
+		require.Equal(t, http.StatusOK, rec.Code)
+	}
+	assert.Equal(t, 3, rpc.callCount("getBalance"))
+
+	// The first response made room for the third
+	rec, _ := doRequest(router, http.MethodGet, balancePath+"&unit=sol", "")
+	assert.Equal(t, "HIT", rec.Header().Get("X-Cache"))
+	rec, _ = doRequest(router, http.MethodGet, balancePath, "")
+	assert.Empty(t, rec.Header().Get("X-Cache"))
+	assert.Equal(t, 4, rpc.callCount("getBalance"))
+
+	_, resp := doRequest(router, http.MethodGet, "/api/v1/metrics", "")
+	cache := resp.Data.(map[string]interface{})["coalescing_cache"].(map[string]interface{})
+	assert.Equal(t, float64(2), cache["entries"])
+	assert.Equal(t, float64(2), cache["max_entries"])
+	assert.Equal(t, float64(2), cache["evictions"])
+}
+
+func TestCachedResponsesSkipCredentials(t *testing.T) {
+	rpc := newMockRPC(t)
+	rpc.on("getBalance", rpcContext(42))
+
+	config := &utils.Config{}
+	config.Cache.Enabled = true
+	config.Cache.TTL = 60
+	router := api.NewRouter(api.NewHandler(nil, setupMockSolanaClient(t, rpc), nil), config)
+
+	get := func(header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, balancePath, nil)
+		req.Header.Set(header, value)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+		return rec
+	}
+
+	// Anonymous responses are cached, but never served to callers with
+	// credentials, whose responses aren't cached either
+	doRequest(router, http.MethodGet, balancePath, "")
+	for i := 0; i < 2; i++ {
+		assert.Empty(t, get("Authorization", "Bearer alice").Header().Get("X-Cache"))
+		assert.Empty(t, get("Cookie", "session=alice").Header().Get("X-Cache"))
+	}
+	assert.Equal(t, 5, rpc.callCount("getBalance"))
+}
@@ -0,0 +1,633 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	sol "github.com/gagliardetto/solana-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/labs-alone/alone-main/internal/middleware"
+	"github.com/labs-alone/alone-main/internal/openai"
+	"github.com/labs-alone/alone-main/internal/solana"
+	"github.com/labs-alone/alone-main/internal/utils"
+	"github.com/labs-alone/alone-main/pkg/api"
+	"github.com/labs-alone/alone-main/pkg/logger"
+	"github.com/labs-alone/alone-main/pkg/maintenance"
+)
+
+func setupTestRouter(t *testing.T, handler *api.Handler) *api.Router {
+	if handler == nil {
+		handler = api.NewHandler(nil, nil, nil)
+	}
+	return api.NewRouter(handler, &utils.Config{})
+}
+
+// setupAuthRouter returns a test router verifying bearer tokens, with tokens
+// for an admin and a regular user
+func setupAuthRouter(t *testing.T, handler *api.Handler) (router *api.Router, adminToken, userToken string) {
+	router = setupTestRouter(t, handler)
+	authMiddleware := middleware.NewAuthMiddleware(logger.Nop())
+	router.SetAuthenticator(authMiddleware.Authenticate)
+
+	adminToken, err := authMiddleware.GenerateToken("admin-1", "admin")
+	require.NoError(t, err)
+	userToken, err = authMiddleware.GenerateToken("user-1", "user")
+	require.NoError(t, err)
+	return router, adminToken, userToken
+}
+
+func doRequest(router http.Handler, method, path, body string) (*httptest.ResponseRecorder, api.Response) {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	var resp api.Response
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	return rec, resp
+}
+
+func TestDecodeErrors(t *testing.T) {
+	router := setupTestRouter(t, nil)
+
+	testCases := []struct {
+		name            string
+		path            string
+		body            string
+		expectedMessage string
+		expectedReason  string
+		expectedField   string
+	}{
+		{
+			name:            "Empty Body",
+			body:            "",
+			expectedMessage: "request body is empty",
+			expectedReason:  "empty_body",
+		},
+		{
+			name:            "Whitespace Body",
+			body:            " \n\t",
+			expectedMessage: "request body is empty",
+			expectedReason:  "empty_body",
+		},
+		{
+			name:            "Truncated JSON",
+			body:            `{"prompt":"a",`,
+			expectedMessage: "request body contains malformed JSON",
+			expectedReason:  "malformed_json",
+		},
+		{
+			name:            "Syntax Error",
+			body:            `{"prompt" "a"}`,
+			expectedMessage: "request body contains malformed JSON at position 11",
+			expectedReason:  "malformed_json",
+		},
+		{
+			name:            "Unknown Field",
+			body:            `{"memo":"hi"}`,
+			expectedMessage: `unknown field "memo"`,
+			expectedReason:  "unknown_field",
+			expectedField:   "memo",
+		},
+		{
+			name:            "Not An Object",
+			body:            `[1, 2]`,
+			expectedMessage: "request body must be a JSON object, got array",
+			expectedReason:  "invalid_type",
+		},
+		{
+			name:            "Wrong Type",
+			path:            "/api/v1/solana/transaction/build",
+			body:            `{"from":5,"to":"b","amount":1}`,
+			expectedMessage: `field "from" must be of type string`,
+			expectedReason:  "invalid_type",
+			expectedField:   "from",
+		},
+		{
+			name:            "Invalid Amount",
+			path:            "/api/v1/solana/transaction/build",
+			body:            `{"from":"a","to":"b","amount":"lots"}`,
+			expectedMessage: `invalid amount "lots": must be a number or numeric string`,
+			expectedReason:  "invalid_amount",
+		},
+		{
+			name:            "Wrong Type Completion",
+			path:            "/api/v1/ai/completion",
+			body:            `{"prompt":"hi","max_tokens":"many"}`,
+			expectedMessage: `field "max_tokens" must be of type int`,
+			expectedReason:  "invalid_type",
+			expectedField:   "max_tokens",
+		},
+		{
+			name:            "Multiple Objects",
+			body:            `{}{}`,
+			expectedMessage: "request body must contain a single JSON object",
+			expectedReason:  "multiple_objects",
+		},
+	}
+
+	// Cases without a path apply to every JSON endpoint
+	paths := []string{
+		"/api/v1/solana/transaction/build",
+		"/api/v1/solana/transaction/submit",
+		"/api/v1/ai/completion",
+	}
+
+	for _, tc := range testCases {
+		casePaths := paths
+		if tc.path != "" {
+			casePaths = []string{tc.path}
+		}
+
+		for _, path := range casePaths {
+			t.Run(tc.name+" "+path, func(t *testing.T) {
+				rec, resp := doRequest(router, http.MethodPost, path, tc.body)
+
+				assert.Equal(t, http.StatusBadRequest, rec.Code)
+				assert.False(t, resp.Success)
+				assert.Equal(t, tc.expectedMessage, resp.Error)
+				assert.NotContains(t, resp.Error, "json:")
+
+				details, ok := resp.Details.(map[string]interface{})
+				require.True(t, ok)
+				assert.Equal(t, tc.expectedReason, details["reason"])
+				if tc.expectedField != "" {
+					assert.Equal(t, tc.expectedField, details["field"])
+				}
+			})
+		}
+	}
+}
+
+func TestSolanaBalanceUnits(t *testing.T) {
+	rpc := newMockRPC(t)
+
+	var mu sync.Mutex
+	var commitments []string
+	rpc.handle("getBalance", func(params json.RawMessage) (interface{}, *rpcError) {
+		var args []json.RawMessage
+		json.Unmarshal(params, &args)
+
+		var config struct {
+			Commitment string `json:"commitment"`
+		}
+		if len(args) > 1 {
+			json.Unmarshal(args[1], &config)
+		}
+		mu.Lock()
+		commitments = append(commitments, config.Commitment)
+		mu.Unlock()
+		return rpcContext(1500000001), nil
+	})
+
+	router := setupTestRouter(t, api.NewHandler(nil, setupMockSolanaClient(t, rpc), nil))
+	address := sol.NewWallet().PublicKey().String()
+
+	testCases := []struct {
+		name               string
+		query              string
+		expectedStatus     int
+		expectedData       interface{}
+		expectedCommitment string
+	}{
+		{
+			name:               "Lamports Default",
+			query:              "",
+			expectedStatus:     http.StatusOK,
+			expectedData:       float64(1500000001),
+			expectedCommitment: "confirmed",
+		},
+		{
+			name:               "SOL",
+			query:              "&unit=sol&commitment=finalized",
+			expectedStatus:     http.StatusOK,
+			expectedData:       "1.500000001",
+			expectedCommitment: "finalized",
+		},
+		{
+			name:           "Invalid Unit",
+			query:          "&unit=btc",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid Commitment",
+			query:          "&commitment=eventually",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mu.Lock()
+			commitments = nil
+			mu.Unlock()
+
+			rec, resp := doRequest(router, http.MethodGet, "/api/v1/solana/balance?address="+address+tc.query, "")
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			require.Equal(t, tc.expectedStatus, rec.Code)
+			if tc.expectedStatus != http.StatusOK {
+				assert.False(t, resp.Success)
+				assert.Empty(t, commitments, "no RPC call for invalid params")
+				return
+			}
+
+			assert.Equal(t, tc.expectedData, resp.Data)
+			assert.Equal(t, []string{tc.expectedCommitment}, commitments)
+		})
+	}
+}
+
+func TestFormatSOL(t *testing.T) {
+	assert.Equal(t, "0", solana.FormatSOL(0))
+	assert.Equal(t, "0.000000001", solana.FormatSOL(1))
+	assert.Equal(t, "1.5", solana.FormatSOL(1500000000))
+	assert.Equal(t, "18446744073.709551615", solana.FormatSOL(solana.Lamports(^uint64(0))))
+}
+
+func TestLamports(t *testing.T) {
+	lamports, err := solana.LamportsFromSOL(1.5)
+	require.NoError(t, err)
+	assert.Equal(t, solana.Lamports(1500000000), lamports)
+	assert.Equal(t, 1.5, lamports.SOL())
+
+	lamports, err = solana.LamportsFromSOL(0.000000001)
+	require.NoError(t, err)
+	assert.Equal(t, solana.Lamports(1), lamports)
+
+	for _, invalid := range []float64{-1, math.NaN(), math.Inf(1), 1e12} {
+		_, err := solana.LamportsFromSOL(invalid)
+		assert.Error(t, err, "%v", invalid)
+	}
+
+	// JSON keeps the raw integer
+	data, err := json.Marshal(struct {
+		Balance solana.Lamports `json:"balance"`
+	}{Balance: 1500000000})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"balance": 1500000000}`, string(data))
+
+	var decoded struct {
+		Balance solana.Lamports `json:"balance"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(`{"balance": 42}`), &decoded))
+	assert.Equal(t, solana.Lamports(42), decoded.Balance)
+}
+
+func TestGetRoutesOmitsAdminRoutes(t *testing.T) {
+	routes := api.NewHandler(nil, nil, nil).GetRoutes()
+
+	// Without Router's role checks these would be open to anyone
+	for _, path := range []string{"/solana/transaction", "/solana/transaction/resubmit", "/users"} {
+		assert.NotContains(t, routes, path)
+	}
+	assert.Contains(t, routes, "/health")
+}
+
+func TestHealthConfig(t *testing.T) {
+	handler := api.NewHandler(nil, nil, nil)
+	handler.SetHealthConfig(api.HealthConfig{
+		Service:       "alone-api",
+		Version:       "1.4.2",
+		Environment:   "staging",
+		IncludeUptime: true,
+		OmitTimestamp: true,
+	})
+	router := setupTestRouter(t, handler)
+
+	rec, resp := doRequest(router, http.MethodGet, "/api/v1/health", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	first, ok := resp.Data.(map[string]interface{})
+	require.True(t, ok)
+	assert.Equal(t, "ok", first["status"])
+	assert.Equal(t, "alone-api", first["service"])
+	assert.Equal(t, "1.4.2", first["version"])
+	assert.Equal(t, "staging", first["environment"])
+	assert.NotContains(t, first, "timestamp")
+
+	time.Sleep(10 * time.Millisecond)
+
+	_, resp = doRequest(router, http.MethodGet, "/api/v1/health", "")
+	second, ok := resp.Data.(map[string]interface{})
+	require.True(t, ok)
+	assert.Greater(t, second["uptime_seconds"], first["uptime_seconds"])
+}
+
+func TestHealthDefaults(t *testing.T) {
+	router := setupTestRouter(t, nil)
+
+	_, resp := doRequest(router, http.MethodGet, "/api/v1/health", "")
+	data, ok := resp.Data.(map[string]interface{})
+	require.True(t, ok)
+	assert.Equal(t, "ok", data["status"])
+	assert.Contains(t, data, "timestamp")
+	assert.NotContains(t, data, "uptime_seconds")
+	assert.NotContains(t, data, "service")
+}
+
+func TestRouterHSTS(t *testing.T) {
+	testCases := []struct {
+		name      string
+		configure func(*utils.Config)
+		want      string
+	}{
+		{name: "Default", want: "max-age=31536000; includeSubDomains"},
+		{name: "Max Age", configure: func(c *utils.Config) { c.Security.HSTSMaxAge = 3600 }, want: "max-age=3600; includeSubDomains"},
+		{name: "Exclude Subdomains", configure: func(c *utils.Config) { c.Security.HSTSExcludeSubdomains = true }, want: "max-age=31536000"},
+		{name: "Disabled", configure: func(c *utils.Config) { c.Security.HSTSMaxAge = -1 }},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := &utils.Config{}
+			if tc.configure != nil {
+				tc.configure(config)
+			}
+			router := api.NewRouter(api.NewHandler(nil, nil, nil), config)
+
+			rec, _ := doRequest(router, http.MethodGet, "/api/v1/health", "")
+			assert.Equal(t, tc.want, rec.Header().Get("Strict-Transport-Security"))
+			assert.Equal(t, "nosniff", rec.Header().Get("X-Content-Type-Options"))
+		})
+	}
+}
+
+func TestRouterMaintenance(t *testing.T) {
+	config := &utils.Config{}
+	config.Maintenance.Enabled = true
+	config.Maintenance.AllowedUsers = []string{"ops-1"}
+	router := api.NewRouter(api.NewHandler(nil, nil, nil), config)
+
+	authMiddleware := middleware.NewAuthMiddleware(logger.Nop())
+	router.SetAuthenticator(authMiddleware.Authenticate)
+	opsToken, err := authMiddleware.GenerateToken("ops-1", "admin")
+	require.NoError(t, err)
+	userToken, err := authMiddleware.GenerateToken("user-1", "user")
+	require.NoError(t, err)
+
+	// Writes are refused before reaching the handler, reads still work
+	rec := doAdminRequest(router, http.MethodPost, "/api/v1/solana/transaction/build", "", "")
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	var resp maintenance.Response
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, "MAINTENANCE", resp.Error)
+
+	rec = doAdminRequest(router, http.MethodPost, "/api/v1/solana/transaction/build", userToken, "")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	rec = doAdminRequest(router, http.MethodGet, "/api/v1/health", "", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Allowlisted users get through to the handler, which rejects the
+	// empty body
+	rec = doAdminRequest(router, http.MethodPost, "/api/v1/solana/transaction/build", opsToken, "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// A token that doesn't verify is refused outright
+	rec = doAdminRequest(router, http.MethodGet, "/api/v1/health", "not-a-token", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	router.Maintenance().SetEnabled(false)
+	rec = doAdminRequest(router, http.MethodPost, "/api/v1/solana/transaction/build", "", "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestBatchCompletion(t *testing.T) {
+	server, _ := setupMockOpenAI(t, nil)
+	client, err := openai.NewClient(&openai.ClientConfig{
+		APIKey:  "test-key",
+		BaseURL: server.URL,
+	})
+	require.NoError(t, err)
+
+	router := setupTestRouter(t, api.NewHandler(nil, nil, client))
+
+	rec, resp := doRequest(router, http.MethodPost, "/api/v1/ai/completion/batch",
+		`[{"prompt":"hi"},{"prompt":"there","max_tokens":5}]`)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	results, ok := resp.Data.([]interface{})
+	require.True(t, ok)
+	require.Len(t, results, 2)
+	assert.Equal(t, float64(1), results[1].(map[string]interface{})["index"])
+	assert.Contains(t, results[0], "completion")
+}
+
+func TestCompletionClientDisconnect(t *testing.T) {
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+		close(cancelled)
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := openai.NewClient(&openai.ClientConfig{
+		APIKey:  "test-key",
+		BaseURL: server.URL,
+	})
+	require.NoError(t, err)
+	router := setupTestRouter(t, api.NewHandler(nil, nil, client))
+	before := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/ai/completion", strings.NewReader(`{"prompt":"hi"}`)).WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		router.ServeHTTP(rec, req)
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("completion never reached upstream")
+	}
+	cancel()
+
+	for name, ch := range map[string]chan struct{}{"upstream request cancelled": cancelled, "handler returned": done} {
+		select {
+		case <-ch:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s", name)
+		}
+	}
+
+	assert.Empty(t, rec.Body.String())
+	assert.Empty(t, rec.Header().Get("Content-Type"))
+	waitForGoroutines(t, before)
+}
+
+func TestBatchCompletionLimits(t *testing.T) {
+	router := setupTestRouter(t, nil)
+
+	items := make([]string, 51)
+	for i := range items {
+		items[i] = `{"prompt":"hi"}`
+	}
+	tooMany := "[" + strings.Join(items, ",") + "]"
+
+	testCases := []struct {
+		name           string
+		body           string
+		expectedStatus int
+		expectedReason string
+	}{
+		{
+			name:           "Too Many Items",
+			body:           tooMany,
+			expectedStatus: http.StatusRequestEntityTooLarge,
+			expectedReason: "too_many_items",
+		},
+		{
+			name:           "Oversized Item",
+			body:           `[{"prompt":"` + strings.Repeat("a", 70<<10) + `"}]`,
+			expectedStatus: http.StatusRequestEntityTooLarge,
+			expectedReason: "body_too_large",
+		},
+		{
+			name:           "Oversized Body",
+			body:           `[{"prompt":"` + strings.Repeat("a", 4<<20) + `"}]`,
+			expectedStatus: http.StatusRequestEntityTooLarge,
+			expectedReason: "body_too_large",
+		},
+		{
+			name:           "Not An Array",
+			body:           `{"prompt":"hi"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedReason: "invalid_type",
+		},
+		{
+			name:           "Malformed Item",
+			body:           `[{"prompt":"hi"},{"prompt":}]`,
+			expectedStatus: http.StatusBadRequest,
+			expectedReason: "malformed_json",
+		},
+		{
+			name:           "Empty Batch",
+			body:           `[]`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Missing Prompt",
+			body:           `[{"prompt":"hi"},{"max_tokens":5}]`,
+			expectedStatus: http.StatusBadRequest,
+			expectedReason: "invalid_fields",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec, resp := doRequest(router, http.MethodPost, "/api/v1/ai/completion/batch", tc.body)
+			assert.Equal(t, tc.expectedStatus, rec.Code)
+			assert.False(t, resp.Success)
+
+			if tc.expectedReason != "" {
+				details, ok := resp.Details.(map[string]interface{})
+				require.True(t, ok)
+				assert.Equal(t, tc.expectedReason, details["reason"])
+			}
+		})
+	}
+}
+
+func TestMetricsPromptStats(t *testing.T) {
+	prompts := openai.NewPromptManager(context.Background())
+	t.Cleanup(func() { prompts.Close() })
+	require.NoError(t, prompts.AddTemplate("greet", "Hello {{name}}"))
+	for i := 0; i < 2; i++ {
+		_, err := prompts.GeneratePrompt("greet", map[string]string{"name": "Ada"}, nil)
+		require.NoError(t, err)
+	}
+
+	handler := api.NewHandler(nil, nil, nil)
+	handler.SetPromptManager(prompts)
+	router := setupTestRouter(t, handler)
+
+	rec, resp := doRequest(router, http.MethodGet, "/api/v1/metrics", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	metrics, ok := resp.Data.(map[string]interface{})
+	require.True(t, ok)
+	stats, ok := metrics["prompts"].(map[string]interface{})
+	require.True(t, ok)
+	greet, ok := stats["greet"].(map[string]interface{})
+	require.True(t, ok)
+	assert.Equal(t, float64(2), greet["generations"])
+	assert.Equal(t, float64(1), greet["cache_hits"])
+}
+
+func TestResponsesUnderConcurrentUse(t *testing.T) {
+	handler := api.NewHandler(nil, nil, nil)
+	handler.SetUserStore(newMockUserStore(100))
+	router, adminToken, _ := setupAuthRouter(t, handler)
+	listUsers := func(query string) (*httptest.ResponseRecorder, api.Response) {
+		rec := doAdminRequest(router, http.MethodGet, "/api/v1/users"+query, adminToken, "")
+		var resp api.Response
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec, resp
+	}
+
+	// Pooled response buffers must never leak one response into another
+	var wg sync.WaitGroup
+	for i := 1; i <= 100; i++ {
+		wg.Add(2)
+		go func(page int) {
+			defer wg.Done()
+			rec, resp := listUsers(fmt.Sprintf("?page=%d&per_page=1", page))
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.True(t, resp.Success)
+
+			items := resp.Data.(map[string]interface{})["items"].([]interface{})
+			if assert.Len(t, items, 1) {
+				assert.Equal(t, fmt.Sprintf("user%d", page), items[0].(map[string]interface{})["username"])
+			}
+		}(i)
+		go func(page int) {
+			defer wg.Done()
+			rec, resp := listUsers(fmt.Sprintf("?page=%d&per_page=%d", page, 1000+page))
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.False(t, resp.Success)
+			assert.Contains(t, resp.Error, "per_page")
+		}(i)
+	}
+	wg.Wait()
+}
+
+func BenchmarkSendJSON(b *testing.B) {
+	handler := api.NewHandler(nil, nil, nil)
+	handler.SetUserStore(newMockUserStore(100))
+	router := api.NewRouter(handler, &utils.Config{})
+	authMiddleware := middleware.NewAuthMiddleware(logger.Nop())
+	router.SetAuthenticator(authMiddleware.Authenticate)
+	token, err := authMiddleware.GenerateToken("admin-1", "admin")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/users?per_page=20", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			router.ServeHTTP(httptest.NewRecorder(), req)
+		}
+	})
+}
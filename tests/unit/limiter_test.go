@@ -0,0 +1,66 @@
+package unit
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/labs-alone/alone-main/internal/utils"
+)
+
+func TestConcurrencyLimiterBound(t *testing.T) {
+	const capacity = 5
+	limiter := utils.NewConcurrencyLimiter(capacity)
+
+	var inFlight, peak int64
+	var wg sync.WaitGroup
+	for i := 0; i < 200; i++ {
+		weight := int64(i%3 + 1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !assert.NoError(t, limiter.Acquire(context.Background(), weight)) {
+				return
+			}
+			defer limiter.Release(weight)
+
+			current := atomic.AddInt64(&inFlight, weight)
+			for {
+				seen := atomic.LoadInt64(&peak)
+				if current <= seen || atomic.CompareAndSwapInt64(&peak, seen, current) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt64(&inFlight, -weight)
+		}()
+	}
+	wg.Wait()
+
+	assert.LessOrEqual(t, peak, int64(capacity))
+	stats := limiter.Stats()
+	assert.Equal(t, int64(0), stats.InFlight)
+	assert.LessOrEqual(t, stats.Peak, int64(capacity))
+	assert.Equal(t, 0, stats.Waiting)
+}
+
+func TestConcurrencyLimiterCancel(t *testing.T) {
+	limiter := utils.NewConcurrencyLimiter(2)
+	require.NoError(t, limiter.Acquire(context.Background(), 2))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, limiter.Acquire(ctx, 1), context.DeadlineExceeded)
+	assert.Equal(t, 0, limiter.Stats().Waiting)
+
+	assert.Error(t, limiter.Acquire(context.Background(), 3), "weight above capacity")
+
+	limiter.Release(2)
+	require.NoError(t, limiter.Acquire(context.Background(), 1))
+	assert.Equal(t, int64(1), limiter.Stats().InFlight)
+}
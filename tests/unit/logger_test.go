@@ -0,0 +1,320 @@
+package unit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/labs-alone/alone-main/internal/utils"
+	"github.com/labs-alone/alone-main/pkg/logger"
+	zaplog "github.com/labs-alone/alone-main/pkg/utils"
+)
+
+func TestLoggerPerOutputLevels(t *testing.T) {
+	var console, file bytes.Buffer
+
+	logger := utils.NewLogger(
+		utils.WithOutput(&console, utils.INFO),
+		utils.WithOutput(&file, utils.DEBUG),
+	)
+
+	logger.Debug("debug details", map[string]interface{}{"key": "value"})
+	logger.Info("service started")
+
+	assert.Contains(t, file.String(), "debug details")
+	assert.Contains(t, file.String(), "service started")
+
+	assert.NotContains(t, console.String(), "debug details")
+	assert.Contains(t, console.String(), "service started")
+}
+
+func TestLoggerAddOutputLevel(t *testing.T) {
+	var errorsOnly bytes.Buffer
+
+	logger := utils.NewLogger()
+	logger.AddOutput(&errorsOnly, utils.ERROR)
+
+	logger.Warn("disk almost full")
+	logger.Error("disk full")
+
+	assert.NotContains(t, errorsOnly.String(), "disk almost full")
+	assert.Contains(t, errorsOnly.String(), "disk full")
+}
+
+// failingConsole is a console whose Sync fails with err
+type failingConsole struct {
+	bytes.Buffer
+	err error
+}
+
+func (c *failingConsole) Sync() error {
+	return c.err
+}
+
+func TestZapLoggerCloseSyncErrors(t *testing.T) {
+	testCases := []struct {
+		name        string
+		syncErr     error
+		expectError bool
+	}{
+		{
+			name:    "Invalid Argument",
+			syncErr: &os.PathError{Op: "sync", Path: "/dev/stdout", Err: syscall.EINVAL},
+		},
+		{
+			name:    "Not A Terminal",
+			syncErr: &os.PathError{Op: "sync", Path: "/dev/stdout", Err: syscall.ENOTTY},
+		},
+		{
+			name:        "Real Failure",
+			syncErr:     &os.PathError{Op: "sync", Path: "/dev/stdout", Err: syscall.EIO},
+			expectError: true,
+		},
+		{
+			name:        "Unknown Error",
+			syncErr:     errors.New("disk on fire"),
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			console := &failingConsole{err: tc.syncErr}
+			config := zaplog.DefaultConfig()
+			config.OutputPath = filepath.Join(t.TempDir(), "app.log")
+			config.Console = console
+
+			logger, err := zaplog.NewLogger(config)
+			require.NoError(t, err)
+			logger.Info("shutting down")
+			assert.Contains(t, console.String(), "shutting down")
+
+			err = logger.Close()
+			if tc.expectError {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.syncErr.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestZapLoggerRotate(t *testing.T) {
+	dir := t.TempDir()
+	config := zaplog.DefaultConfig()
+	config.OutputPath = filepath.Join(dir, "app.log")
+	config.Compress = false
+	config.Console = &failingConsole{}
+
+	logger, err := zaplog.NewLogger(config)
+	require.NoError(t, err)
+	defer logger.Close()
+
+	logger.Info("before rotation")
+	path, size := logger.CurrentLogFile()
+	assert.Equal(t, config.OutputPath, path)
+	assert.Greater(t, size, int64(0))
+
+	require.NoError(t, logger.Rotate())
+
+	// The old file is kept as a backup and logging continues in a new one
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	path, size = logger.CurrentLogFile()
+	assert.Equal(t, config.OutputPath, path)
+	assert.Zero(t, size)
+
+	logger.Info("after rotation")
+	data, err := os.ReadFile(config.OutputPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "after rotation")
+	assert.NotContains(t, string(data), "before rotation")
+}
+
+func TestZapNopLogger(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+
+	defaulted, err := zaplog.NewLogger(nil)
+	require.NoError(t, err)
+
+	for _, logger := range []*zaplog.Logger{zaplog.NewNopLogger(), defaulted} {
+		logger.WithFields(map[string]interface{}{"key": "value"}).Info("discarded")
+		logger.Error("also discarded")
+		assert.NoError(t, logger.Rotate())
+
+		path, size := logger.CurrentLogFile()
+		assert.Empty(t, path)
+		assert.Zero(t, size)
+		assert.NoError(t, logger.Close())
+	}
+
+	// Not even the log directory is created
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestLoggersImplementInterface(t *testing.T) {
+	testCases := []struct {
+		name string
+		// setup returns a logger writing to the returned buffer
+		setup func(t *testing.T) (logger.Logger, *bytes.Buffer)
+		// field is how a string field named key with value value is written
+		field func(key, value string) string
+	}{
+		{
+			name: "Console",
+			setup: func(t *testing.T) (logger.Logger, *bytes.Buffer) {
+				var out bytes.Buffer
+				return utils.NewLogger(utils.WithOutput(&out, utils.DEBUG)), &out
+			},
+			field: func(key, value string) string { return key + "=" + value },
+		},
+		{
+			name: "Zap",
+			setup: func(t *testing.T) (logger.Logger, *bytes.Buffer) {
+				console := &failingConsole{}
+				config := zaplog.DefaultConfig()
+				config.Level = "debug"
+				config.OutputPath = filepath.Join(t.TempDir(), "app.log")
+				config.Console = console
+
+				log, err := zaplog.NewLogger(config)
+				require.NoError(t, err)
+				t.Cleanup(func() { log.Close() })
+				return log, &console.Buffer
+			},
+			field: func(key, value string) string {
+				data, _ := json.Marshal(map[string]string{key: value})
+				return string(data[1 : len(data)-1])
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			log, out := tc.setup(t)
+
+			scoped := log.With("component", "agent")
+			scoped.Debug("task queued", "task", "t-1")
+			scoped.Info("task started", map[string]interface{}{"task": "t-2"})
+			log.Warn("queue backing up", "queue", "default")
+			log.Error("task failed", "cause", "timeout")
+
+			lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+			require.Len(t, lines, 4)
+			assert.Contains(t, lines[0], "task queued")
+			assert.Contains(t, lines[0], tc.field("component", "agent"))
+			assert.Contains(t, lines[0], tc.field("task", "t-1"))
+			assert.Contains(t, lines[1], tc.field("component", "agent"))
+			assert.Contains(t, lines[1], tc.field("task", "t-2"))
+			assert.Contains(t, lines[2], tc.field("queue", "default"))
+			assert.Contains(t, lines[3], tc.field("cause", "timeout"))
+
+			// With doesn't change the logger it's called on
+			assert.NotContains(t, lines[2], tc.field("component", "agent"))
+		})
+	}
+}
+
+func TestLoggerFields(t *testing.T) {
+	err := errors.New("connection refused")
+
+	assert.Nil(t, logger.Fields())
+	assert.Equal(t, map[string]interface{}{"a": 1, "b": "two"}, logger.Fields("a", 1, "b", "two"))
+	assert.Equal(t, map[string]interface{}{"a": 1, "b": 2}, logger.Fields(map[string]interface{}{"a": 1}, "b", 2))
+
+	// Values without keys are still logged
+	assert.Equal(t, map[string]interface{}{"error": err}, logger.Fields(err))
+	assert.Equal(t, map[string]interface{}{"!BADKEY": 42}, logger.Fields(42))
+	assert.Equal(t, map[string]interface{}{"a": 1, "dangling": logger.MissingValue}, logger.Fields("a", 1, "dangling"))
+}
+
+func TestLoggerParseFields(t *testing.T) {
+	testCases := []struct {
+		name          string
+		keysAndValues []interface{}
+		expected      map[string]interface{}
+		malformed     string
+	}{
+		{
+			name:          "Even",
+			keysAndValues: []interface{}{"taskID", "t-1", "attempt", 2},
+			expected:      map[string]interface{}{"taskID": "t-1", "attempt": 2},
+		},
+		{
+			name:          "Odd",
+			keysAndValues: []interface{}{"taskID", "t-1", "attempt"},
+			expected:      map[string]interface{}{"taskID": "t-1", "attempt": logger.MissingValue},
+			malformed:     `key "attempt" has no value`,
+		},
+		{
+			name:          "Single Key",
+			keysAndValues: []interface{}{"taskID"},
+			expected:      map[string]interface{}{"taskID": logger.MissingValue},
+			malformed:     `key "taskID" has no value`,
+		},
+		{
+			name:          "Value As Key",
+			keysAndValues: []interface{}{42, "taskID", "t-1"},
+			expected:      map[string]interface{}{"!BADKEY": 42, "taskID": "t-1"},
+			malformed:     "42 (int) is not a key",
+		},
+		{
+			name:          "Lone Error",
+			keysAndValues: []interface{}{errors.New("timeout")},
+			expected:      map[string]interface{}{"error": errors.New("timeout")},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fields, err := logger.ParseFields(tc.keysAndValues...)
+			assert.Equal(t, tc.expected, fields)
+			if tc.malformed == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, logger.ErrMalformedFields)
+			assert.ErrorContains(t, err, tc.malformed)
+		})
+	}
+}
+
+func TestLoggerWarnsOnMalformedFields(t *testing.T) {
+	var out bytes.Buffer
+	log := utils.NewLogger(utils.WithOutput(&out, utils.DEBUG))
+
+	require.NotPanics(t, func() {
+		log.Info("task queued", "taskID", "t-1", "type")
+	})
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.Contains(t, lines[0], "task queued")
+	assert.Contains(t, lines[0], "taskID=t-1")
+	assert.Contains(t, lines[0], "type=MISSING")
+	assert.Contains(t, lines[1], "WARN")
+	assert.Contains(t, lines[1], "Malformed log fields")
+	assert.Contains(t, lines[1], `key "type" has no value`)
+
+	// Well-formed pairs log without a warning
+	out.Reset()
+	log.Info("task queued", "taskID", "t-1", "type", "sync")
+	assert.Equal(t, 1, strings.Count(out.String(), "\n"))
+}
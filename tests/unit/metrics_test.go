@@ -0,0 +1,111 @@
+package unit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/labs-alone/alone-main/pkg/network"
+)
+
+const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+// setupMetricsRouter returns a router with the metrics middleware whose
+// /items/{id} route responds with the status given in the status query
+func setupMetricsRouter(config *network.MiddlewareConfig) (*mux.Router, *network.Metrics) {
+	metrics := network.NewMetrics()
+	manager := network.NewMiddlewareManager(context.Background(), config, zap.NewNop(), metrics)
+
+	router := mux.NewRouter()
+	router.Use(manager.Metrics())
+	router.HandleFunc("/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		status, err := strconv.Atoi(r.URL.Query().Get("status"))
+		if err != nil {
+			status = http.StatusOK
+		}
+		w.WriteHeader(status)
+		w.Write([]byte("ok"))
+	})
+	return router, metrics
+}
+
+func TestMetricsStatusClassCounters(t *testing.T) {
+	router, metrics := setupMetricsRouter(&network.MiddlewareConfig{})
+
+	for i, status := range []int{200, 201, 302, 404, 500, 503} {
+		req := httptest.NewRequest(http.MethodGet, "/items/"+strconv.Itoa(i)+"?status="+strconv.Itoa(status), nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		require.Equal(t, status, rec.Code)
+	}
+
+	classCount := func(class string) float64 {
+		return testutil.ToFloat64(metrics.StatusClassTotal.WithLabelValues(http.MethodGet, "/items/{id}", class))
+	}
+	assert.Equal(t, float64(2), classCount("2xx"))
+	assert.Equal(t, float64(1), classCount("3xx"))
+	assert.Equal(t, float64(1), classCount("4xx"))
+	assert.Equal(t, float64(2), classCount("5xx"))
+
+	// Every item is counted under the route template rather than its path
+	assert.Equal(t, 4, testutil.CollectAndCount(metrics.StatusClassTotal))
+	assert.Equal(t, 1, testutil.CollectAndCount(metrics.RequestDuration))
+}
+
+func TestMetricsTraceExemplars(t *testing.T) {
+	testCases := []struct {
+		name        string
+		exemplars   bool
+		traceparent string
+		want        bool
+	}{
+		{name: "Traced", exemplars: true, traceparent: traceparent, want: true},
+		{name: "Untraced", exemplars: true},
+		{name: "Disabled", traceparent: traceparent},
+		{name: "InvalidTrace", exemplars: true, traceparent: "00-00000000000000000000000000000000-00f067aa0ba902b7-01"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := &network.MiddlewareConfig{}
+			config.Metrics.Exemplars = tc.exemplars
+			router, metrics := setupMetricsRouter(config)
+
+			req := httptest.NewRequest(http.MethodGet, "/items/1", nil)
+			if tc.traceparent != "" {
+				req.Header.Set("Traceparent", tc.traceparent)
+			}
+			router.ServeHTTP(httptest.NewRecorder(), req)
+
+			registry := prometheus.NewRegistry()
+			require.NoError(t, registry.Register(metrics.RequestDuration))
+			families, err := registry.Gather()
+			require.NoError(t, err)
+			require.Len(t, families, 1)
+			require.Len(t, families[0].GetMetric(), 1)
+
+			var traceIDs []string
+			for _, bucket := range families[0].GetMetric()[0].GetHistogram().GetBucket() {
+				for _, label := range bucket.GetExemplar().GetLabel() {
+					if label.GetName() == "trace_id" {
+						traceIDs = append(traceIDs, label.GetValue())
+					}
+				}
+			}
+			if tc.want {
+				assert.Equal(t, []string{"4bf92f3577b34da6a3ce929d0e0e4736"}, traceIDs)
+			} else {
+				assert.Empty(t, traceIDs)
+			}
+		})
+	}
+}
@@ -0,0 +1,536 @@
+package unit
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/labs-alone/alone-main/internal/openai"
+)
+
+// setupMockOpenAI starts a chat completions server that answers with the
+// given status per model, defaulting to 200
+func setupMockOpenAI(t *testing.T, statuses map[string]int) (*httptest.Server, *[]string) {
+	var mu sync.Mutex
+	var models []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openai.ChatCompletionRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+
+		mu.Lock()
+		models = append(models, req.Model)
+		mu.Unlock()
+
+		if status, ok := statuses[req.Model]; ok && status != http.StatusOK {
+			http.Error(w, `{"error":{"message":"overloaded"}}`, status)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":    "chatcmpl-1",
+			"model": req.Model,
+			"choices": []map[string]interface{}{
+				{"message": map[string]string{"role": "assistant", "content": "hello"}},
+			},
+			"usage": map[string]int{"total_tokens": 10},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &models
+}
+
+func TestChatCompletionFallback(t *testing.T) {
+	server, models := setupMockOpenAI(t, map[string]int{
+		"gpt-4":         http.StatusTooManyRequests,
+		"gpt-4o":        http.StatusServiceUnavailable,
+		"gpt-3.5-turbo": http.StatusOK,
+	})
+
+	client, err := openai.NewClient(&openai.ClientConfig{
+		APIKey:         "test-key",
+		BaseURL:        server.URL,
+		FallbackModels: []string{"gpt-4o", "gpt-3.5-turbo"},
+	})
+	require.NoError(t, err)
+
+	resp, err := client.CreateChatCompletion(context.Background(), &openai.ChatCompletionRequest{
+		Model:    "gpt-4",
+		Messages: []openai.ChatMessage{{Role: "user", Content: "hi"}},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "gpt-3.5-turbo", resp.ServedBy)
+	assert.Equal(t, []string{"gpt-4", "gpt-4o", "gpt-3.5-turbo"}, *models)
+
+	metrics := client.GetMetrics()
+	assert.Equal(t, int64(2), metrics.FallbackCount)
+	assert.Equal(t, int64(1), metrics.RequestCount)
+}
+
+func TestChatCompletionFallbackDisabled(t *testing.T) {
+	server, models := setupMockOpenAI(t, map[string]int{
+		"gpt-4": http.StatusTooManyRequests,
+	})
+
+	client, err := openai.NewClient(&openai.ClientConfig{
+		APIKey:  "test-key",
+		BaseURL: server.URL,
+	})
+	require.NoError(t, err)
+
+	_, err = client.CreateChatCompletion(context.Background(), &openai.ChatCompletionRequest{
+		Model:    "gpt-4",
+		Messages: []openai.ChatMessage{{Role: "user", Content: "hi"}},
+	})
+	require.Error(t, err)
+	assert.True(t, openai.IsOverloaded(err))
+	assert.Equal(t, []string{"gpt-4"}, *models)
+}
+
+func TestChatCompletionNoFallbackOnOtherErrors(t *testing.T) {
+	server, models := setupMockOpenAI(t, map[string]int{
+		"gpt-4": http.StatusBadRequest,
+	})
+
+	client, err := openai.NewClient(&openai.ClientConfig{
+		APIKey:         "test-key",
+		BaseURL:        server.URL,
+		FallbackModels: []string{"gpt-3.5-turbo"},
+	})
+	require.NoError(t, err)
+
+	_, err = client.CreateChatCompletion(context.Background(), &openai.ChatCompletionRequest{
+		Model:    "gpt-4",
+		Messages: []openai.ChatMessage{{Role: "user", Content: "hi"}},
+	})
+
+	var apiErr *openai.APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+	assert.Equal(t, []string{"gpt-4"}, *models)
+}
+
+// TestResetMetricsRace is meant to run with -race
+func TestResetMetricsRace(t *testing.T) {
+	server, _ := setupMockOpenAI(t, nil)
+
+	client, err := openai.NewClient(&openai.ClientConfig{
+		APIKey:  "test-key",
+		BaseURL: server.URL,
+	})
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				_, err := client.CreateChatCompletion(context.Background(), &openai.ChatCompletionRequest{
+					Model:    "gpt-4",
+					Messages: []openai.ChatMessage{{Role: "user", Content: "hi"}},
+				})
+				assert.NoError(t, err)
+			}
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < 20; j++ {
+			client.ResetMetrics()
+			client.GetMetrics()
+		}
+	}()
+
+	wg.Wait()
+
+	client.ResetMetrics()
+	metrics := client.GetMetrics()
+	assert.Zero(t, metrics.RequestCount)
+	assert.Zero(t, metrics.TokensUsed)
+	assert.True(t, metrics.LastRequest.IsZero())
+}
+
+func TestClientProxy(t *testing.T) {
+	server, models := setupMockOpenAI(t, nil)
+
+	// The stub proxy forwards absolute-form requests and records their host
+	var mu sync.Mutex
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		proxied = append(proxied, r.Host)
+		mu.Unlock()
+
+		out := r.Clone(r.Context())
+		out.RequestURI = ""
+		resp, err := http.DefaultTransport.RoundTrip(out)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+
+		for k, v := range resp.Header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	}))
+	t.Cleanup(proxy.Close)
+
+	client, err := openai.NewClient(&openai.ClientConfig{
+		APIKey:   "test-key",
+		BaseURL:  server.URL,
+		ProxyURL: proxy.URL,
+	})
+	require.NoError(t, err)
+
+	_, err = client.CreateChatCompletion(context.Background(), &openai.ChatCompletionRequest{
+		Model:    "gpt-4",
+		Messages: []openai.ChatMessage{{Role: "user", Content: "hi"}},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"gpt-4"}, *models)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{server.Listener.Addr().String()}, proxied)
+}
+
+func TestClientTransportConfigRejected(t *testing.T) {
+	testCases := []struct {
+		name   string
+		config openai.ClientConfig
+	}{
+		{
+			name:   "Transport With Proxy",
+			config: openai.ClientConfig{Transport: http.DefaultTransport, ProxyURL: "http://proxy:8080"},
+		},
+		{
+			name:   "Transport With TLS",
+			config: openai.ClientConfig{Transport: http.DefaultTransport, TLSConfig: &tls.Config{}},
+		},
+		{
+			name:   "Unsupported Proxy Scheme",
+			config: openai.ClientConfig{ProxyURL: "ftp://proxy:21"},
+		},
+		{
+			name:   "Proxy Without Host",
+			config: openai.ClientConfig{ProxyURL: "http://"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.config.APIKey = "test-key"
+			_, err := openai.NewClient(&tc.config)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestChatCompletionsConcurrencyLimit(t *testing.T) {
+	const maxConcurrency = 3
+
+	var mu sync.Mutex
+	inFlight, peak := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > peak {
+			peak = inFlight
+		}
+		mu.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":      "chatcmpl-1",
+			"choices": []map[string]interface{}{{"message": map[string]string{"role": "assistant", "content": "hi"}}},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := openai.NewClient(&openai.ClientConfig{
+		APIKey:         "test-key",
+		BaseURL:        server.URL,
+		MaxConcurrency: maxConcurrency,
+	})
+	require.NoError(t, err)
+
+	reqs := make([]*openai.ChatCompletionRequest, 50)
+	for i := range reqs {
+		reqs[i] = &openai.ChatCompletionRequest{
+			Model:    "gpt-4",
+			Messages: []openai.ChatMessage{{Role: "user", Content: "hi"}},
+		}
+	}
+
+	responses, errs := client.CreateChatCompletions(context.Background(), reqs)
+	for i := range reqs {
+		require.NoError(t, errs[i])
+		require.NotNil(t, responses[i])
+	}
+
+	mu.Lock()
+	assert.LessOrEqual(t, peak, maxConcurrency)
+	assert.Greater(t, peak, 1, "requests ran concurrently")
+	mu.Unlock()
+
+	batch := client.GetMetrics().Batch
+	assert.Equal(t, int64(maxConcurrency), batch.Capacity)
+	assert.Equal(t, int64(0), batch.InFlight)
+	assert.LessOrEqual(t, batch.Peak, int64(maxConcurrency))
+}
+
+// setupThrottledOpenAI starts a chat completions server that answers the
+// first failures requests with a 429 and the given Retry-After header
+func setupThrottledOpenAI(t *testing.T, failures int, retryAfter string) (*httptest.Server, *int32) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if int(atomic.AddInt32(&calls, 1)) <= failures {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			http.Error(w, `{"error":{"message":"rate limited"}}`, http.StatusTooManyRequests)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":      "chatcmpl-1",
+			"choices": []map[string]interface{}{{"message": map[string]string{"role": "assistant", "content": "hi"}}},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &calls
+}
+
+func TestChatCompletionRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	testCases := []struct {
+		name           string
+		failures       int
+		retryAfter     string
+		expectedDelays []time.Duration
+		expectError    bool
+	}{
+		{
+			name:           "Seconds",
+			failures:       2,
+			retryAfter:     "3",
+			expectedDelays: []time.Duration{3 * time.Second, 3 * time.Second},
+		},
+		{
+			name:           "HTTP Date",
+			failures:       1,
+			retryAfter:     now.Add(4 * time.Second).Format(http.TimeFormat),
+			expectedDelays: []time.Duration{4 * time.Second},
+		},
+		{
+			name:           "Missing Header Falls Back To Backoff",
+			failures:       3,
+			expectedDelays: []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond},
+		},
+		{
+			name:           "Invalid Header Falls Back To Backoff",
+			failures:       1,
+			retryAfter:     "soon",
+			expectedDelays: []time.Duration{100 * time.Millisecond},
+		},
+		{
+			name:           "Capped",
+			failures:       1,
+			retryAfter:     "86400",
+			expectedDelays: []time.Duration{5 * time.Second},
+		},
+		{
+			name:           "Overflow Capped",
+			failures:       1,
+			retryAfter:     "99999999999999999999",
+			expectedDelays: []time.Duration{5 * time.Second},
+		},
+		{
+			name:           "Retries Exhausted",
+			failures:       4,
+			retryAfter:     "1",
+			expectedDelays: []time.Duration{time.Second, time.Second, time.Second},
+			expectError:    true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, calls := setupThrottledOpenAI(t, tc.failures, tc.retryAfter)
+
+			clock := &fakeClock{now: now}
+			client, err := openai.NewClient(&openai.ClientConfig{
+				APIKey:        "test-key",
+				BaseURL:       server.URL,
+				MaxRetries:    3,
+				RetryDelay:    100 * time.Millisecond,
+				MaxRetryDelay: 5 * time.Second,
+				Clock:         clock,
+			})
+			require.NoError(t, err)
+
+			_, err = client.CreateChatCompletion(context.Background(), &openai.ChatCompletionRequest{
+				Model:    "gpt-4",
+				Messages: []openai.ChatMessage{{Role: "user", Content: "hi"}},
+			})
+			assert.Equal(t, tc.expectedDelays, clock.Delays())
+			if tc.expectError {
+				assert.True(t, openai.IsOverloaded(err))
+				assert.Equal(t, int32(4), atomic.LoadInt32(calls))
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, int32(tc.failures+1), atomic.LoadInt32(calls))
+		})
+	}
+}
+
+func TestChatCompletionRetryCanceled(t *testing.T) {
+	server, calls := setupThrottledOpenAI(t, 1, "30")
+
+	client, err := openai.NewClient(&openai.ClientConfig{
+		APIKey:     "test-key",
+		BaseURL:    server.URL,
+		MaxRetries: 3,
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err = client.CreateChatCompletion(ctx, &openai.ChatCompletionRequest{
+		Model:    "gpt-4",
+		Messages: []openai.ChatMessage{{Role: "user", Content: "hi"}},
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Equal(t, int32(1), atomic.LoadInt32(calls))
+}
+
+// setupKeyedOpenAI starts a chat completions server that counts requests
+// per API key and rate limits the keys listed
+func setupKeyedOpenAI(t *testing.T, limited ...string) (*httptest.Server, func() map[string]int) {
+	var mu sync.Mutex
+	counts := make(map[string]int)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		mu.Lock()
+		counts[key]++
+		mu.Unlock()
+
+		for _, l := range limited {
+			if key == l {
+				http.Error(w, `{"error":{"message":"rate limited"}}`, http.StatusTooManyRequests)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":      "chatcmpl-1",
+			"choices": []map[string]interface{}{{"message": map[string]string{"role": "assistant", "content": "hi"}}},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() map[string]int {
+		mu.Lock()
+		defer mu.Unlock()
+		copied := make(map[string]int, len(counts))
+		for k, v := range counts {
+			copied[k] = v
+		}
+		return copied
+	}
+}
+
+func TestChatCompletionKeyRotation(t *testing.T) {
+	server, counts := setupKeyedOpenAI(t)
+
+	client, err := openai.NewClient(&openai.ClientConfig{
+		APIKey:  "sk-key-a",
+		APIKeys: []openai.KeyConfig{{Key: "sk-key-b", Weight: 2}},
+		BaseURL: server.URL,
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 30; i++ {
+		_, err := client.CreateChatCompletion(context.Background(), &openai.ChatCompletionRequest{Model: "gpt-4"})
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, map[string]int{"sk-key-a": 10, "sk-key-b": 20}, counts())
+
+	keys := client.GetMetrics().Keys
+	require.Len(t, keys, 2)
+	assert.Equal(t, "...ey-a", keys[0].Key)
+	assert.Equal(t, int64(10), keys[0].Requests)
+	assert.Equal(t, 2, keys[1].Weight)
+	assert.Equal(t, int64(20), keys[1].Requests)
+
+	client.ResetMetrics()
+	assert.Zero(t, client.GetMetrics().Keys[0].Requests)
+}
+
+func TestChatCompletionRateLimitedKeySkipped(t *testing.T) {
+	server, counts := setupKeyedOpenAI(t, "sk-key-a")
+
+	clock := &fakeClock{now: time.Now()}
+	client, err := openai.NewClient(&openai.ClientConfig{
+		APIKeys:     []openai.KeyConfig{{Key: "sk-key-a"}, {Key: "sk-key-b"}, {Key: "sk-key-c"}},
+		KeyCooldown: time.Hour,
+		BaseURL:     server.URL,
+		MaxRetries:  1,
+		RetryDelay:  10 * time.Millisecond,
+		Clock:       clock,
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		_, err := client.CreateChatCompletion(context.Background(), &openai.ChatCompletionRequest{Model: "gpt-4"})
+		require.NoError(t, err)
+	}
+
+	// The limited key was tried once, then sat out while the others served
+	// every request, and the retry didn't wait out the key's cooldown
+	assert.Equal(t, 1, counts()["sk-key-a"])
+	assert.Equal(t, 10, counts()["sk-key-b"]+counts()["sk-key-c"])
+	assert.Equal(t, []time.Duration{10 * time.Millisecond}, clock.Delays())
+
+	keys := client.GetMetrics().Keys
+	assert.Equal(t, int64(1), keys[0].RateLimited)
+	assert.True(t, keys[0].CooldownUntil.After(clock.Now()))
+	assert.Zero(t, keys[1].RateLimited)
+}
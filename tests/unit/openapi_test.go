@@ -0,0 +1,63 @@
+package unit
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenAPISpec(t *testing.T) {
+	router := setupTestRouter(t, nil)
+
+	rec, _ := doRequest(router, http.MethodGet, "/api/v1/swagger.json", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	loader := openapi3.NewLoader()
+	doc, err := loader.LoadFromData(rec.Body.Bytes())
+	require.NoError(t, err)
+	require.NoError(t, doc.Validate(context.Background()))
+
+	transaction := doc.Paths.Find("/api/v1/solana/transaction")
+	require.NotNil(t, transaction)
+	require.NotNil(t, transaction.Post)
+	assert.Equal(t, "Send a transfer from the server wallet", transaction.Post.Summary)
+
+	body := transaction.Post.RequestBody.Value.Content.Get("application/json").Schema.Value
+	assert.Contains(t, body.Properties, "amount")
+	assert.ElementsMatch(t, []string{"amount", "from", "to"}, body.Required)
+
+	account := doc.Paths.Find("/api/v1/solana/account/{address}")
+	require.NotNil(t, account)
+	require.NotNil(t, account.Get)
+	require.Len(t, account.Get.Parameters, 1)
+	assert.Equal(t, "path", account.Get.Parameters[0].Value.In)
+
+	completion := doc.Paths.Find("/api/v1/ai/completion")
+	require.NotNil(t, completion)
+	require.NotNil(t, completion.Post)
+	assert.Contains(t, completion.Post.Tags, "ai")
+
+	assert.NotNil(t, doc.Paths.Find("/api/v1/users"))
+	assert.Contains(t, doc.Components.Schemas, "ListResponse_User")
+	assert.Contains(t, doc.Components.Schemas, "ChatCompletionResponse")
+}
+
+func TestSwaggerUI(t *testing.T) {
+	router := setupTestRouter(t, nil)
+
+	rec, _ := doRequest(router, http.MethodGet, "/api/v1/docs", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Header().Get("Content-Type"), "text/html")
+	assert.Contains(t, rec.Body.String(), `url: "swagger.json"`)
+
+	// The inline bootstrap script carries the nonce the policy allows
+	match := regexp.MustCompile(`'nonce-([^']+)'`).FindStringSubmatch(rec.Header().Get("Content-Security-Policy"))
+	require.Len(t, match, 2)
+	assert.Contains(t, rec.Body.String(), `<script nonce="`+match[1]+`">`)
+}
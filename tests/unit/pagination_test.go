@@ -0,0 +1,168 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	sol "github.com/gagliardetto/solana-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	models "github.com/labs-alone/alone-main/internal/core"
+	"github.com/labs-alone/alone-main/internal/solana"
+	"github.com/labs-alone/alone-main/pkg/api"
+)
+
+type mockUserStore struct {
+	users []models.User
+}
+
+func (m *mockUserStore) ListUsers(ctx context.Context, offset, limit int) ([]models.User, int, error) {
+	if offset >= len(m.users) {
+		return nil, len(m.users), nil
+	}
+	end := offset + limit
+	if end > len(m.users) {
+		end = len(m.users)
+	}
+	return m.users[offset:end], len(m.users), nil
+}
+
+func newMockUserStore(n int) *mockUserStore {
+	store := &mockUserStore{}
+	for i := 1; i <= n; i++ {
+		store.users = append(store.users, models.User{
+			ID:       uint(i),
+			Username: fmt.Sprintf("user%d", i),
+			Email:    fmt.Sprintf("user%d@example.com", i),
+		})
+	}
+	return store
+}
+
+func decodeListResponse[T any](t *testing.T, rec *httptest.ResponseRecorder) api.ListResponse[T] {
+	var resp struct {
+		Success bool                `json:"success"`
+		Data    api.ListResponse[T] `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.True(t, resp.Success)
+	return resp.Data
+}
+
+func TestNewListResponse(t *testing.T) {
+	items := make([]int, 20)
+
+	first := api.NewListResponse(items, 1, 20, 45)
+	assert.Len(t, first.Items, 20)
+	assert.Equal(t, 1, first.Page)
+	assert.Equal(t, 20, first.PerPage)
+	assert.Equal(t, 45, first.Total)
+	assert.Equal(t, "2", first.NextCursor)
+
+	last := api.NewListResponse(items[:5], 3, 20, 45)
+	assert.Len(t, last.Items, 5)
+	assert.Empty(t, last.NextCursor)
+
+	empty := api.NewListResponse[int](nil, 1, 20, 0)
+	assert.NotNil(t, empty.Items)
+	assert.Empty(t, empty.Items)
+}
+
+func TestListUsersPagination(t *testing.T) {
+	handler := api.NewHandler(nil, nil, nil)
+	handler.SetUserStore(newMockUserStore(45))
+	router, adminToken, userToken := setupAuthRouter(t, handler)
+
+	testCases := []struct {
+		name           string
+		query          string
+		expectedLen    int
+		expectedFirst  uint
+		expectedPage   int
+		expectedCursor string
+	}{
+		{
+			name:           "First Page",
+			query:          "?per_page=20",
+			expectedLen:    20,
+			expectedFirst:  1,
+			expectedPage:   1,
+			expectedCursor: "2",
+		},
+		{
+			name:           "Middle Page",
+			query:          "?page=2&per_page=20",
+			expectedLen:    20,
+			expectedFirst:  21,
+			expectedPage:   2,
+			expectedCursor: "3",
+		},
+		{
+			name:           "Last Page",
+			query:          "?page=3&per_page=20",
+			expectedLen:    5,
+			expectedFirst:  41,
+			expectedPage:   3,
+			expectedCursor: "",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := doAdminRequest(router, http.MethodGet, "/api/v1/users"+tc.query, adminToken, "")
+			require.Equal(t, http.StatusOK, rec.Code)
+
+			list := decodeListResponse[models.User](t, rec)
+			assert.Len(t, list.Items, tc.expectedLen)
+			assert.Equal(t, tc.expectedFirst, list.Items[0].ID)
+			assert.Equal(t, tc.expectedPage, list.Page)
+			assert.Equal(t, 20, list.PerPage)
+			assert.Equal(t, 45, list.Total)
+			assert.Equal(t, tc.expectedCursor, list.NextCursor)
+		})
+	}
+
+	rec := doAdminRequest(router, http.MethodGet, "/api/v1/users?per_page=1000", adminToken, "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// Users include their emails, so only admins list them
+	rec = doAdminRequest(router, http.MethodGet, "/api/v1/users", "", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	rec = doAdminRequest(router, http.MethodGet, "/api/v1/users", userToken, "")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func TestTransactionHistoryPagination(t *testing.T) {
+	rpc := newMockRPC(t)
+
+	signatures := make([]map[string]interface{}, 0, 2)
+	for i := 1; i <= 2; i++ {
+		signatures = append(signatures, map[string]interface{}{
+			"signature":          sol.Signature{byte(i)}.String(),
+			"slot":               100 + i,
+			"blockTime":          1700000000 + i,
+			"confirmationStatus": "finalized",
+			"err":                nil,
+		})
+	}
+	rpc.on("getSignaturesForAddress", signatures)
+
+	client := setupMockSolanaClient(t, rpc)
+	router := setupTestRouter(t, api.NewHandler(nil, client, nil))
+
+	address := sol.NewWallet().PublicKey().String()
+	rec, _ := doRequest(router, http.MethodGet, "/api/v1/solana/transactions?per_page=2&address="+address, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	list := decodeListResponse[solana.SignatureInfo](t, rec)
+	require.Len(t, list.Items, 2)
+	assert.Equal(t, 2, list.PerPage)
+	assert.Equal(t, sol.Signature{2}.String(), list.NextCursor)
+	assert.Equal(t, uint64(101), list.Items[0].Slot)
+	assert.Equal(t, "finalized", list.Items[0].ConfirmationStatus)
+}
@@ -0,0 +1,364 @@
+package unit
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/labs-alone/alone-main/internal/openai"
+)
+
+func setupPromptManager(t testing.TB, name, template string) *openai.PromptManager {
+	pm := openai.NewPromptManager(context.Background())
+	t.Cleanup(func() { pm.Close() })
+	require.NoError(t, pm.AddTemplate(name, template))
+	return pm
+}
+
+func TestPromptInterpolation(t *testing.T) {
+	testCases := []struct {
+		name      string
+		template  string
+		variables map[string]string
+		strict    bool
+		expected  string
+		expectErr bool
+	}{
+		{
+			name:      "Known Variables",
+			template:  "Hello {{name}}, welcome to {{place}}.",
+			variables: map[string]string{"name": "Ada", "place": "Alone Labs"},
+			expected:  "Hello Ada, welcome to Alone Labs.",
+		},
+		{
+			name:      "Unknown Token Left Untouched",
+			template:  "Hello {{name}}, your id is {{id}}.",
+			variables: map[string]string{"name": "Ada"},
+			expected:  "Hello Ada, your id is {{id}}.",
+		},
+		{
+			name:      "Unknown Token Strict",
+			template:  "Hello {{name}}, your id is {{id}}.",
+			variables: map[string]string{"name": "Ada"},
+			strict:    true,
+			expectErr: true,
+		},
+		{
+			name:      "Values Are Not Re-Interpolated",
+			template:  "{{a}} {{b}}",
+			variables: map[string]string{"a": "{{b}}", "b": "x"},
+			expected:  "{{b}} x",
+		},
+		{
+			name:      "Extra Braces",
+			template:  "{{{name}}}",
+			variables: map[string]string{"name": "Ada"},
+			expected:  "{Ada}",
+		},
+		{
+			name:      "Unterminated Token",
+			template:  "Hello {{name",
+			variables: map[string]string{"name": "Ada"},
+			expected:  "Hello {{name",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pm := setupPromptManager(t, "test", tc.template)
+
+			messages, err := pm.GeneratePrompt("test", tc.variables, &openai.PromptOptions{
+				SystemPrompt:    "system",
+				StrictVariables: tc.strict,
+			})
+			if tc.expectErr {
+				assert.Error(t, err)
+				assert.Nil(t, messages)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Len(t, messages, 2)
+			assert.Equal(t, tc.expected, messages[1].Content)
+		})
+	}
+}
+
+func largePromptTemplate(vars int) (string, map[string]string) {
+	var template strings.Builder
+	variables := make(map[string]string, vars)
+	for i := 0; i < vars; i++ {
+		key := fmt.Sprintf("var%d", i)
+		variables[key] = fmt.Sprintf("value-%d", i)
+		template.WriteString("Some filler text before the placeholder {{")
+		template.WriteString(key)
+		template.WriteString("}} and some after it.\n")
+	}
+	return template.String(), variables
+}
+
+// replaceAllInterpolate is the previous per-variable implementation, kept as
+// a baseline for the benchmarks below.
+func replaceAllInterpolate(template string, variables map[string]string) string {
+	result := template
+	for key, value := range variables {
+		result = strings.ReplaceAll(result, fmt.Sprintf("{{%s}}", key), value)
+	}
+	return result
+}
+
+func BenchmarkInterpolateReplaceAll(b *testing.B) {
+	template, variables := largePromptTemplate(1000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = replaceAllInterpolate(template, variables)
+	}
+}
+
+func BenchmarkInterpolateSinglePass(b *testing.B) {
+	template, variables := largePromptTemplate(1000)
+	pm := setupPromptManager(b, "large", template)
+	opts := &openai.PromptOptions{SystemPrompt: "system"}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := pm.GeneratePrompt("large", variables, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestLoadTemplatesAtomic(t *testing.T) {
+	pm := setupPromptManager(t, "greet", "Hello {{name}}")
+
+	require.NoError(t, pm.LoadTemplates([]byte(`[
+		{"name": "greet", "template": "Hi {{name}}"},
+		{"name": "farewell", "template": "Bye {{name}}"}
+	]`)))
+
+	messages, err := pm.GeneratePrompt("greet", map[string]string{"name": "Ada"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "Hi Ada", messages[1].Content)
+
+	// One bad template rejects the whole reload
+	err = pm.LoadTemplates([]byte(`[
+		{"name": "greet", "template": "Hey {{name}}"},
+		{"name": "extra", "template": "Extra {{value}}"},
+		{"name": "broken", "template": "Oops {{name"}
+	]`))
+	var invalid openai.TemplateErrors
+	require.ErrorAs(t, err, &invalid)
+	require.Len(t, invalid, 1)
+	assert.Equal(t, 2, invalid[0].Index)
+
+	// Malformed JSON is rejected before anything changes
+	assert.Error(t, pm.LoadTemplates([]byte(`[{"name": "greet"`)))
+
+	templates := pm.Templates()
+	require.Len(t, templates, 2)
+	assert.Equal(t, "farewell", templates[0].Name)
+	assert.Equal(t, "Hi {{name}}", templates[1].Template)
+
+	_, err = pm.GeneratePrompt("extra", nil, nil)
+	assert.Error(t, err)
+}
+
+func TestPromptStats(t *testing.T) {
+	pm := setupPromptManager(t, "greet", "Hello {{name}}")
+	require.NoError(t, pm.AddTemplate("pair", "{{a}} and {{b}}"))
+
+	vars := map[string]string{"name": "Ada"}
+	for i := 0; i < 3; i++ {
+		_, err := pm.GeneratePrompt("greet", vars, nil)
+		require.NoError(t, err)
+	}
+	_, err := pm.GeneratePrompt("pair", map[string]string{"a": "x", "b": "y"}, &openai.PromptOptions{})
+	require.NoError(t, err)
+
+	// Unknown templates are not tracked
+	_, err = pm.GeneratePrompt("missing", nil, nil)
+	require.Error(t, err)
+
+	stats := pm.Stats()
+	require.Len(t, stats, 2)
+
+	greet := stats["greet"]
+	assert.Equal(t, int64(3), greet.Generations)
+	assert.Equal(t, int64(2), greet.CacheHits)
+	assert.Equal(t, int64(1), greet.CacheMisses)
+	assert.Equal(t, 1.0, greet.AverageVariables)
+	assert.False(t, greet.LastUsed.IsZero())
+
+	pair := stats["pair"]
+	assert.Equal(t, int64(1), pair.Generations)
+	assert.Zero(t, pair.CacheHits+pair.CacheMisses, "uncached generations don't touch the cache counters")
+	assert.Equal(t, 2.0, pair.AverageVariables)
+}
+
+func TestPromptCacheLRU(t *testing.T) {
+	pm := setupPromptManager(t, "greet", "Hello {{name}}")
+	t.Cleanup(func() { pm.Close() })
+	pm.SetCacheSize(3)
+
+	generate := func(name string) {
+		_, err := pm.GeneratePrompt("greet", map[string]string{"name": name}, nil)
+		require.NoError(t, err)
+	}
+
+	for i := 0; i < 10; i++ {
+		generate(fmt.Sprintf("user-%d", i))
+		assert.LessOrEqual(t, pm.CacheStats().Size, 3)
+	}
+
+	stats := pm.CacheStats()
+	assert.Equal(t, 3, stats.Size)
+	assert.Equal(t, 3, stats.MaxSize)
+	assert.Equal(t, int64(7), stats.Evictions)
+	assert.Equal(t, int64(10), stats.Misses)
+
+	// Touch user-7 so user-8 becomes the least recently used
+	generate("user-7")
+	assert.Equal(t, int64(1), pm.CacheStats().Hits)
+
+	generate("user-10")
+	generate("user-7")
+	generate("user-9")
+	assert.Equal(t, int64(3), pm.CacheStats().Hits, "user-7 and user-9 survived")
+
+	generate("user-8")
+	stats = pm.CacheStats()
+	assert.Equal(t, int64(3), stats.Hits, "user-8 was evicted")
+	assert.Equal(t, int64(12), stats.Misses)
+
+	// Shrinking the cap evicts immediately
+	pm.SetCacheSize(1)
+	assert.Equal(t, 1, pm.CacheStats().Size)
+}
+
+func TestPromptManagerClose(t *testing.T) {
+	pm := openai.NewPromptManager(context.Background())
+	assert.NoError(t, pm.Close())
+	assert.NoError(t, pm.Close(), "closing twice is safe")
+}
+
+func TestPromptManagerStopsWithContext(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pm := openai.NewPromptManager(ctx)
+	assert.Equal(t, before+1, runtime.NumGoroutine(), "one cleanup goroutine")
+
+	cancel()
+	waitForGoroutines(t, before)
+	assert.NoError(t, pm.Close(), "closing after the context is done is safe")
+}
+
+func TestGeneratePromptNilVariables(t *testing.T) {
+	pm := setupPromptManager(t, "static", "Tell me a joke")
+	t.Cleanup(func() { pm.Close() })
+
+	messages, err := pm.GeneratePrompt("static", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "Tell me a joke", messages[1].Content)
+
+	// nil and empty maps are the same generation
+	_, err = pm.GeneratePrompt("static", map[string]string{}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), pm.CacheStats().Hits)
+
+	messages, err = pm.GeneratePrompt("static", nil, &openai.PromptOptions{StrictVariables: true})
+	require.NoError(t, err)
+	assert.Equal(t, "Tell me a joke", messages[1].Content)
+}
+
+func TestPromptCacheKeyIncludesOptions(t *testing.T) {
+	pm := setupPromptManager(t, "static", "Tell me a joke")
+	t.Cleanup(func() { pm.Close() })
+
+	options := func(systemPrompt string) *openai.PromptOptions {
+		return &openai.PromptOptions{
+			UseCache:     true,
+			CacheTTL:     time.Hour,
+			SystemPrompt: systemPrompt,
+		}
+	}
+
+	first, err := pm.GeneratePrompt("static", nil, options("You are a comedian."))
+	require.NoError(t, err)
+	second, err := pm.GeneratePrompt("static", nil, options("You are a pirate."))
+	require.NoError(t, err)
+
+	assert.Equal(t, "You are a comedian.", first[0].Content)
+	assert.Equal(t, "You are a pirate.", second[0].Content)
+
+	stats := pm.CacheStats()
+	assert.Equal(t, 2, stats.Size)
+	assert.Equal(t, int64(0), stats.Hits)
+
+	again, err := pm.GeneratePrompt("static", nil, options("You are a comedian."))
+	require.NoError(t, err)
+	assert.Equal(t, first, again)
+	assert.Equal(t, int64(1), pm.CacheStats().Hits)
+}
+
+func TestPromptCacheKeyVariableOrder(t *testing.T) {
+	pm := setupPromptManager(t, "pair", "{{a}} and {{b}} and {{c}}")
+	t.Cleanup(func() { pm.Close() })
+
+	vars := map[string]string{"a": "x", "b": "y", "c": "z"}
+	for i := 0; i < 20; i++ {
+		_, err := pm.GeneratePrompt("pair", vars, nil)
+		require.NoError(t, err)
+	}
+
+	stats := pm.CacheStats()
+	assert.Equal(t, 1, stats.Size)
+	assert.Equal(t, int64(19), stats.Hits)
+}
+
+func TestPromptSystemPromptSources(t *testing.T) {
+	pm := openai.NewPromptManager(context.Background())
+	t.Cleanup(func() { pm.Close() })
+	require.NoError(t, pm.ReplaceTemplates([]openai.PromptTemplate{
+		{Name: "plain", Template: "Hello"},
+		{
+			Name:     "support",
+			Template: "Help with {{issue}}",
+			Metadata: map[string]string{openai.SystemPromptMetadataKey: "You are a patient support agent."},
+		},
+	}))
+
+	systemPrompt := func(name string, opts *openai.PromptOptions) string {
+		messages, err := pm.GeneratePrompt(name, map[string]string{"issue": "login"}, opts)
+		require.NoError(t, err)
+		require.Equal(t, "system", messages[0].Role)
+		return messages[0].Content
+	}
+
+	// Nothing configured
+	assert.Equal(t, openai.DefaultSystemPrompt, systemPrompt("plain", nil))
+	assert.Equal(t, openai.DefaultSystemPrompt, systemPrompt("plain", &openai.PromptOptions{}))
+
+	// The template's prompt applies without any per-call option
+	assert.Equal(t, "You are a patient support agent.", systemPrompt("support", nil))
+
+	// The manager's prompt replaces the default, but not a template's own
+	pm.SetSystemPrompt("You are a Solana expert.")
+	assert.Equal(t, "You are a Solana expert.", systemPrompt("plain", nil), "cached default prompts aren't reused")
+	assert.Equal(t, "You are a patient support agent.", systemPrompt("support", nil))
+
+	// A per-call prompt overrides both
+	opts := &openai.PromptOptions{SystemPrompt: "You are a pirate."}
+	assert.Equal(t, "You are a pirate.", systemPrompt("plain", opts))
+	assert.Equal(t, "You are a pirate.", systemPrompt("support", opts))
+
+	pm.SetSystemPrompt("")
+	assert.Equal(t, openai.DefaultSystemPrompt, systemPrompt("plain", nil))
+}
@@ -0,0 +1,383 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/labs-alone/alone-main/pkg/network"
+)
+
+func setupRedisRateLimitStore(t *testing.T, limit int, window time.Duration) *network.RedisRateLimitStore {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	return network.NewRedisRateLimitStore(client, "test:", limit, window)
+}
+
+func TestRateLimitStores(t *testing.T) {
+	const limit = 5
+
+	testCases := []struct {
+		name  string
+		store func(t *testing.T) network.RateLimitStore
+	}{
+		{
+			name: "Memory",
+			store: func(t *testing.T) network.RateLimitStore {
+				return network.NewMemoryRateLimitStore(&sync.Map{}, limit, limit)
+			},
+		},
+		{
+			name: "Sliding Window",
+			store: func(t *testing.T) network.RateLimitStore {
+				return network.NewSlidingWindowRateLimitStore(&sync.Map{}, limit, time.Second)
+			},
+		},
+		{
+			name: "Redis",
+			store: func(t *testing.T) network.RateLimitStore {
+				return setupRedisRateLimitStore(t, limit, time.Second)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := tc.store(t)
+			ctx := context.Background()
+
+			for i := 0; i < limit; i++ {
+				allowed, err := store.Allow(ctx, "10.0.0.1")
+				require.NoError(t, err)
+				assert.True(t, allowed, "request %d should be allowed", i+1)
+			}
+
+			allowed, err := store.Allow(ctx, "10.0.0.1")
+			require.NoError(t, err)
+			assert.False(t, allowed, "request over the limit should be rejected")
+
+			// Other clients have their own budget
+			allowed, err = store.Allow(ctx, "10.0.0.2")
+			require.NoError(t, err)
+			assert.True(t, allowed)
+		})
+	}
+}
+
+func TestRedisRateLimitStoreShared(t *testing.T) {
+	server := miniredis.RunT(t)
+	ctx := context.Background()
+
+	// Two instances pointing at the same Redis share one budget
+	newInstance := func() *network.RedisRateLimitStore {
+		client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+		t.Cleanup(func() { client.Close() })
+		return network.NewRedisRateLimitStore(client, "shared:", 4, time.Second)
+	}
+	first, second := newInstance(), newInstance()
+
+	for i := 0; i < 2; i++ {
+		allowed, err := first.Allow(ctx, "client")
+		require.NoError(t, err)
+		assert.True(t, allowed)
+
+		allowed, err = second.Allow(ctx, "client")
+		require.NoError(t, err)
+		assert.True(t, allowed)
+	}
+
+	allowed, err := first.Allow(ctx, "client")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+
+	allowed, err = second.Allow(ctx, "client")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+}
+
+func TestRedisRateLimitStoreWindowSlides(t *testing.T) {
+	store := setupRedisRateLimitStore(t, 2, 100*time.Millisecond)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		allowed, err := store.Allow(ctx, "client")
+		require.NoError(t, err)
+		assert.True(t, allowed)
+	}
+
+	allowed, err := store.Allow(ctx, "client")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+
+	time.Sleep(150 * time.Millisecond)
+
+	allowed, err = store.Allow(ctx, "client")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+}
+
+func TestRedisRateLimitStoreTake(t *testing.T) {
+	store := setupRedisRateLimitStore(t, 2, time.Second)
+	ctx := context.Background()
+
+	result, err := store.Take(ctx, "client")
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 2, result.Limit)
+	assert.Equal(t, 1, result.Remaining)
+
+	result, err = store.Take(ctx, "client")
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+
+	result, err = store.Take(ctx, "client")
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+	assert.Greater(t, result.RetryAfter, time.Duration(0))
+	assert.LessOrEqual(t, result.RetryAfter, time.Second)
+}
+
+func TestRateLimitMiddlewareRejection(t *testing.T) {
+	config := &network.MiddlewareConfig{}
+	config.RateLimit.RequestsPerSecond = 1
+	config.RateLimit.BurstSize = 2
+
+	manager := network.NewMiddlewareManager(context.Background(), config, zap.NewNop(), nil)
+	handler := manager.RateLimit()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	request := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/health", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := request()
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "1", rec.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, "1", rec.Header().Get("X-RateLimit-Reset"))
+
+	rec = request()
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "0", rec.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, "2", rec.Header().Get("X-RateLimit-Reset"))
+
+	rec = request()
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+	assert.Equal(t, "2", rec.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "0", rec.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, "2", rec.Header().Get("X-RateLimit-Reset"))
+
+	var resp network.APIResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.False(t, resp.Success)
+	require.NotNil(t, resp.Error)
+	assert.Equal(t, network.ErrCodeRateLimited, resp.Error.Code)
+	assert.Equal(t, "rate limit exceeded", resp.Error.Message)
+	assert.Equal(t, map[string]interface{}{
+		"limit":       float64(2),
+		"remaining":   float64(0),
+		"retry_after": float64(1),
+		"reset":       float64(2),
+	}, resp.Error.Details)
+}
+
+func TestRateLimitAlgorithmBursts(t *testing.T) {
+	const window = 100 * time.Millisecond
+
+	testCases := []struct {
+		name            string
+		algorithm       string
+		expectedAllowed int
+	}{
+		// A full bucket of 10 is spent at once
+		{name: "Token Bucket Default", expectedAllowed: 10},
+		{name: "Token Bucket", algorithm: network.RateLimitTokenBucket, expectedAllowed: 10},
+		// 20 a second over 100ms windows is 2 per window, whatever the burst
+		{name: "Sliding Window", algorithm: network.RateLimitSlidingWindow, expectedAllowed: 2},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := &network.MiddlewareConfig{}
+			config.RateLimit.RequestsPerSecond = 20
+			config.RateLimit.BurstSize = 10
+			config.RateLimit.Algorithm = tc.algorithm
+			config.RateLimit.Window = window
+
+			manager := network.NewMiddlewareManager(context.Background(), config, zap.NewNop(), nil)
+			handler := manager.RateLimit()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			burst := func(n int) int {
+				allowed := 0
+				for i := 0; i < n; i++ {
+					req := httptest.NewRequest(http.MethodGet, "/api/v1/health", nil)
+					req.RemoteAddr = "10.0.0.1:1234"
+					rec := httptest.NewRecorder()
+					handler.ServeHTTP(rec, req)
+					if rec.Code == http.StatusOK {
+						allowed++
+					}
+				}
+				return allowed
+			}
+
+			assert.Equal(t, tc.expectedAllowed, burst(20))
+
+			// Either way the long-run rate is the same: after a window,
+			// the requests that came due are allowed again
+			time.Sleep(window + 10*time.Millisecond)
+			assert.Equal(t, 2, burst(20))
+		})
+	}
+
+	t.Run("Unknown Algorithm Falls Back", func(t *testing.T) {
+		config := &network.MiddlewareConfig{}
+		config.RateLimit.RequestsPerSecond = 1
+		config.RateLimit.BurstSize = 3
+		config.RateLimit.Algorithm = "leaky"
+
+		core, logs := observer.New(zapcore.WarnLevel)
+		manager := network.NewMiddlewareManager(context.Background(), config, zap.New(core), nil)
+		assert.Equal(t, 1, logs.FilterMessage("falling back to in-memory rate limiting").Len())
+
+		handler := manager.RateLimit()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, "3", rec.Header().Get("X-RateLimit-Limit"), "the fallback is a token bucket")
+	})
+}
+
+func TestSlidingWindowRateLimitStoreResult(t *testing.T) {
+	store := network.NewSlidingWindowRateLimitStore(nil, 2, time.Second)
+	ctx := context.Background()
+
+	result, err := store.Take(ctx, "client")
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 2, result.Limit)
+	assert.Equal(t, 1, result.Remaining)
+	assert.InDelta(t, time.Second, result.Reset, float64(50*time.Millisecond))
+
+	_, err = store.Take(ctx, "client")
+	require.NoError(t, err)
+
+	result, err = store.Take(ctx, "client")
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+	assert.Greater(t, result.RetryAfter, time.Duration(0))
+	assert.LessOrEqual(t, result.RetryAfter, time.Second)
+	assert.GreaterOrEqual(t, result.Reset, result.RetryAfter, "the window empties after its oldest request leaves")
+}
+
+func TestMiddlewareManagerCloseReapsGoroutines(t *testing.T) {
+	config := &network.MiddlewareConfig{}
+	config.RateLimit.RequestsPerSecond = 10
+	config.RateLimit.BurstSize = 10
+	config.RateLimit.SweepInterval = 10 * time.Millisecond
+	config.Cache.Enabled = true
+	config.Cache.PurgeInterval = 10 * time.Millisecond
+
+	before := runtime.NumGoroutine()
+
+	manager := network.NewMiddlewareManager(context.Background(), config, zap.NewNop(), nil)
+	manager.Start()
+	manager.Start()
+	assert.Equal(t, before+2, runtime.NumGoroutine(), "one purge and one sweep goroutine")
+
+	// Cleanup doesn't stop them
+	manager.Cleanup()
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, before+2, runtime.NumGoroutine())
+
+	// Close waits for them to exit
+	manager.Close()
+	manager.Close()
+	assert.Equal(t, before, runtime.NumGoroutine())
+
+	// The lifecycle can be restarted
+	manager.Start()
+	assert.Equal(t, before+2, runtime.NumGoroutine())
+	manager.Close()
+	assert.Equal(t, before, runtime.NumGoroutine())
+}
+
+// waitForGoroutines waits up to a second for the number of goroutines to
+// drop to n. It polls from the calling goroutine, as assert.Eventually runs
+// its condition on a goroutine of its own that would be counted.
+func waitForGoroutines(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > n {
+		if time.Now().After(deadline) {
+			t.Errorf("%d goroutines still running, want %d", runtime.NumGoroutine(), n)
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestMiddlewareManagerStartDisabled(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	manager := network.NewMiddlewareManager(context.Background(), &network.MiddlewareConfig{}, zap.NewNop(), nil)
+	manager.Start()
+	assert.Equal(t, before, runtime.NumGoroutine())
+	manager.Close()
+}
+
+func TestMiddlewareManagerStopsWithContext(t *testing.T) {
+	config := &network.MiddlewareConfig{}
+	config.RateLimit.SweepInterval = 10 * time.Millisecond
+	config.Cache.Enabled = true
+	config.Cache.PurgeInterval = 10 * time.Millisecond
+
+	before := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	manager := network.NewMiddlewareManager(ctx, config, zap.NewNop(), nil)
+	manager.Start()
+	assert.Equal(t, before+2, runtime.NumGoroutine())
+
+	// Cancelling the context stops the goroutines without Close
+	cancel()
+	waitForGoroutines(t, before)
+
+	// Close still returns once they're gone
+	done := make(chan struct{})
+	go func() {
+		manager.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked after the context was cancelled")
+	}
+}
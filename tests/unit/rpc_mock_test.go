@@ -0,0 +1,190 @@
+package unit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+
+	"github.com/labs-alone/alone-main/internal/solana"
+)
+
+// rpcHandler answers a JSON-RPC call. A non-nil *rpcError is returned to the
+// client as the error member instead of a result.
+type rpcHandler func(params json.RawMessage) (interface{}, *rpcError)
+
+type rpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// mockRPC is a minimal Solana JSON-RPC server for client tests
+type mockRPC struct {
+	server   *httptest.Server
+	handlers map[string]rpcHandler
+	calls    map[string]int
+	failures []httpFailure
+	wsConns  int
+	mu       sync.Mutex
+}
+
+// httpFailure is an HTTP-level error response, such as rate limiting
+type httpFailure struct {
+	status     int
+	retryAfter string
+}
+
+func newMockRPC(t testing.TB) *mockRPC {
+	m := &mockRPC{
+		handlers: make(map[string]rpcHandler),
+		calls:    make(map[string]int),
+	}
+	m.server = httptest.NewServer(http.HandlerFunc(m.serveHTTP))
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+// on registers a handler returning a fixed result for method
+func (m *mockRPC) on(method string, result interface{}) {
+	m.handle(method, func(json.RawMessage) (interface{}, *rpcError) {
+		return result, nil
+	})
+}
+
+// handle registers a handler for method
+func (m *mockRPC) handle(method string, handler rpcHandler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handlers[method] = handler
+}
+
+// failNext answers the next n HTTP requests with status, setting the
+// Retry-After header if retryAfter is not empty
+func (m *mockRPC) failNext(n, status int, retryAfter string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := 0; i < n; i++ {
+		m.failures = append(m.failures, httpFailure{status: status, retryAfter: retryAfter})
+	}
+}
+
+// wsConnections returns how many websocket connections were opened
+func (m *mockRPC) wsConnections() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.wsConns
+}
+
+// callCount returns how many times method was called
+func (m *mockRPC) callCount(method string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.calls[method]
+}
+
+type rpcRequest struct {
+	ID     interface{}     `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+func (m *mockRPC) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if websocket.IsWebSocketUpgrade(r) {
+		m.serveWS(w, r)
+		return
+	}
+
+	var req rpcRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m.mu.Lock()
+	var failure *httpFailure
+	if len(m.failures) > 0 {
+		failure = &m.failures[0]
+		m.failures = m.failures[1:]
+		m.calls[req.Method]++
+	}
+	m.mu.Unlock()
+
+	if failure != nil {
+		if failure.retryAfter != "" {
+			w.Header().Set("Retry-After", failure.retryAfter)
+		}
+		http.Error(w, http.StatusText(failure.status), failure.status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m.dispatch(req))
+}
+
+// serveWS answers JSON-RPC calls over a websocket, so subscription methods
+// can be registered with on/handle like any other method
+func (m *mockRPC) serveWS(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	m.mu.Lock()
+	m.wsConns++
+	m.mu.Unlock()
+
+	for {
+		var req rpcRequest
+		if err := conn.ReadJSON(&req); err != nil {
+			return
+		}
+		if err := conn.WriteJSON(m.dispatch(req)); err != nil {
+			return
+		}
+	}
+}
+
+func (m *mockRPC) dispatch(req rpcRequest) map[string]interface{} {
+	m.mu.Lock()
+	m.calls[req.Method]++
+	handler, ok := m.handlers[req.Method]
+	m.mu.Unlock()
+
+	resp := map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      req.ID,
+	}
+	if !ok {
+		resp["error"] = rpcError{Code: -32601, Message: "method not found: " + req.Method}
+	} else if result, rpcErr := handler(req.Params); rpcErr != nil {
+		resp["error"] = rpcErr
+	} else {
+		resp["result"] = result
+	}
+	return resp
+}
+
+// rpcContext wraps a value in the standard {context, value} envelope
+func rpcContext(value interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"context": map[string]interface{}{"slot": 1},
+		"value":   value,
+	}
+}
+
+func setupMockSolanaClient(t testing.TB, rpc *mockRPC) *solana.Client {
+	client, err := solana.NewClient(&solana.ClientConfig{
+		Endpoint:   rpc.server.URL,
+		Commitment: "confirmed",
+		MaxRetries: 1,
+	})
+	require.NoError(t, err)
+	return client
+}
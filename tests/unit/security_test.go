@@ -0,0 +1,79 @@
+package unit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/labs-alone/alone-main/pkg/network"
+)
+
+func TestSecurityHeadersMiddleware(t *testing.T) {
+	testCases := []struct {
+		name     string
+		headers  *network.SecurityHeadersConfig
+		expected map[string]string
+	}{
+		{
+			name: "Defaults",
+			expected: map[string]string{
+				"Content-Security-Policy":   "default-src 'self'",
+				"Strict-Transport-Security": "max-age=31536000; includeSubDomains",
+				"X-Frame-Options":           "DENY",
+				"X-Content-Type-Options":    "nosniff",
+				"X-XSS-Protection":          "1; mode=block",
+				"Referrer-Policy":           "strict-origin-when-cross-origin",
+			},
+		},
+		{
+			name: "Custom",
+			headers: &network.SecurityHeadersConfig{
+				ContentSecurityPolicy: "default-src 'self'; img-src https://cdn.example.com",
+				HSTSMaxAge:            time.Hour,
+				FrameOptions:          "SAMEORIGIN",
+				ContentTypeOptions:    "nosniff",
+			},
+			expected: map[string]string{
+				"Content-Security-Policy":   "default-src 'self'; img-src https://cdn.example.com",
+				"Strict-Transport-Security": "max-age=3600",
+				"X-Frame-Options":           "SAMEORIGIN",
+				"X-Content-Type-Options":    "nosniff",
+				"X-XSS-Protection":          "",
+				"Referrer-Policy":           "",
+			},
+		},
+		{
+			name:    "Disabled",
+			headers: &network.SecurityHeadersConfig{Disabled: true, ContentSecurityPolicy: "default-src 'self'"},
+			expected: map[string]string{
+				"Content-Security-Policy":   "",
+				"Strict-Transport-Security": "",
+				"X-Frame-Options":           "",
+				"X-Content-Type-Options":    "",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := &network.MiddlewareConfig{}
+			config.Security.Headers = tc.headers
+			manager := network.NewMiddlewareManager(context.Background(), config, zap.NewNop(), nil)
+			handler := manager.SecurityHeaders()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			for key, value := range tc.expected {
+				assert.Equal(t, value, rec.Header().Get(key), key)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/labs-alone/alone-main/internal/middleware"
+	"github.com/labs-alone/alone-main/internal/utils"
+	"github.com/labs-alone/alone-main/pkg/api"
+	"github.com/labs-alone/alone-main/pkg/logger"
+)
+
+type fakeDatabase struct {
+	err error
+}
+
+func (db fakeDatabase) PingContext(ctx context.Context) error {
+	return db.err
+}
+
+func selfCheckConfig(endpoint string) *utils.Config {
+	config := &utils.Config{Environment: "test"}
+	config.Solana.Endpoint = endpoint
+	config.OpenAI.APIKey = "sk-test-0123456789abcdef"
+	return config
+}
+
+func checkStatuses(report api.SelfCheckReport) map[string]api.CheckStatus {
+	statuses := make(map[string]api.CheckStatus)
+	for _, check := range report.Checks {
+		statuses[check.Name] = check.Status
+	}
+	return statuses
+}
+
+func TestSelfCheckAllPass(t *testing.T) {
+	rpc := newMockRPC(t)
+	rpc.on("getHealth", "ok")
+	client := setupMockSolanaClient(t, rpc)
+
+	handler := api.NewHandler(nil, client, nil)
+	handler.SetDatabase(fakeDatabase{})
+	config := selfCheckConfig(rpc.server.URL)
+	config.Startup.FailOnSelfCheck = true
+
+	report, err := handler.RunSelfCheck(context.Background(), config)
+	require.NoError(t, err)
+	assert.True(t, report.Passed)
+	assert.Empty(t, report.Failed())
+	assert.Equal(t, map[string]api.CheckStatus{
+		"config":   api.CheckPass,
+		"database": api.CheckPass,
+		"solana":   api.CheckPass,
+		"openai":   api.CheckPass,
+	}, checkStatuses(report))
+
+	router := api.NewRouter(handler, config)
+	rec, resp := doRequest(router, http.MethodGet, "/api/v1/selfcheck", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, resp.Success)
+}
+
+func TestSelfCheckPartialFailure(t *testing.T) {
+	rpc := newMockRPC(t)
+	rpc.on("getHealth", "ok")
+	client := setupMockSolanaClient(t, rpc)
+
+	handler := api.NewHandler(nil, client, nil)
+	handler.SetDatabase(fakeDatabase{err: errors.New("connection refused")})
+	config := selfCheckConfig(rpc.server.URL)
+	config.OpenAI.APIKey = "not-a-key"
+
+	// Without fail-fast the failures are reported but startup continues
+	report, err := handler.RunSelfCheck(context.Background(), config)
+	require.NoError(t, err)
+	assert.False(t, report.Passed)
+	assert.Equal(t, map[string]api.CheckStatus{
+		"config":   api.CheckPass,
+		"database": api.CheckFail,
+		"solana":   api.CheckPass,
+		"openai":   api.CheckFail,
+	}, checkStatuses(report))
+
+	failed := report.Failed()
+	require.Len(t, failed, 1, "the OpenAI key check only warns")
+	assert.Equal(t, "database", failed[0].Name)
+	assert.Equal(t, "connection refused", failed[0].Message)
+
+	router, adminToken, userToken := setupAuthRouter(t, handler)
+	rec, resp := doRequest(router, http.MethodGet, "/api/v1/selfcheck", "")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.False(t, resp.Success)
+	assert.Contains(t, rec.Body.String(), `"status":"fail"`)
+	assert.NotContains(t, rec.Body.String(), "connection refused", "dependency errors aren't public")
+
+	// Only admins run the checks again, and see why they failed
+	rec = doAdminRequest(router, http.MethodGet, "/api/v1/selfcheck?refresh=true", "", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	rec = doAdminRequest(router, http.MethodGet, "/api/v1/selfcheck?refresh=true", userToken, "")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	rec = doAdminRequest(router, http.MethodGet, "/api/v1/selfcheck?refresh=true", adminToken, "")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Contains(t, rec.Body.String(), "connection refused")
+
+	config.Startup.FailOnSelfCheck = true
+	_, err = handler.RunSelfCheck(context.Background(), config)
+	assert.ErrorContains(t, err, "database")
+}
+
+func TestSelfCheckConcurrentRefresh(t *testing.T) {
+	router := api.NewRouter(api.NewHandler(nil, nil, nil), selfCheckConfig("http://localhost:8899"))
+	authMiddleware := middleware.NewAuthMiddleware(logger.Nop())
+	router.SetAuthenticator(authMiddleware.Authenticate)
+	adminToken, err := authMiddleware.GenerateToken("admin-1", "admin")
+	require.NoError(t, err)
+
+	// The report is read and replaced concurrently without a race
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			rec, _ := doRequest(router, http.MethodGet, "/api/v1/selfcheck", "")
+			assert.Equal(t, http.StatusOK, rec.Code)
+		}()
+		go func() {
+			defer wg.Done()
+			rec := doAdminRequest(router, http.MethodGet, "/api/v1/selfcheck?refresh=true", adminToken, "")
+			assert.Equal(t, http.StatusOK, rec.Code)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestSelfCheckSkipsUnconfigured(t *testing.T) {
+	handler := api.NewHandler(nil, nil, nil)
+
+	report := handler.SelfCheck(context.Background(), selfCheckConfig("http://localhost:8899"))
+	assert.True(t, report.Passed)
+	assert.Equal(t, api.CheckSkip, checkStatuses(report)["database"])
+	assert.Equal(t, api.CheckSkip, checkStatuses(report)["solana"])
+}
@@ -0,0 +1,520 @@
+package unit
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"golang.org/x/net/http2"
+
+	network "github.com/labs-alone/alone-main/src"
+)
+
+// writeSelfSignedCert writes a self-signed certificate for 127.0.0.1 to dir
+// and returns the parsed certificate
+func writeSelfSignedCert(t *testing.T, dir string, serial int64) (*x509.Certificate, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+
+	return cert, certFile, keyFile
+}
+
+// startTestServer serves s on a random local port until the test ends
+func startTestServer(t *testing.T, s *network.Server) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go s.Serve(ln)
+	t.Cleanup(func() { s.Shutdown() })
+
+	return ln.Addr().String()
+}
+
+func newTestServerConfig() *network.ServerConfig {
+	return &network.ServerConfig{
+		ReadTimeout:     5 * time.Second,
+		WriteTimeout:    5 * time.Second,
+		ShutdownTimeout: 5 * time.Second,
+		EnableHealth:    true,
+		HealthPath:      "/health",
+	}
+}
+
+func TestServerTLS(t *testing.T) {
+	dir := t.TempDir()
+	cert, certFile, keyFile := writeSelfSignedCert(t, dir, 1)
+
+	config := newTestServerConfig()
+	config.TLS = &network.TLSConfig{
+		CertFile: certFile,
+		KeyFile:  keyFile,
+	}
+	server := network.NewServer(config, zap.NewNop())
+	addr := startTestServer(t, server)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		},
+	}
+
+	require.Eventually(t, func() bool {
+		resp, err := client.Get("https://" + addr + "/health")
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	}, 2*time.Second, 20*time.Millisecond)
+
+	resp, err := client.Get("https://" + addr + "/health")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.NotNil(t, resp.TLS)
+	assert.GreaterOrEqual(t, resp.TLS.Version, uint16(tls.VersionTLS12))
+	assert.Equal(t, cert.SerialNumber, resp.TLS.PeerCertificates[0].SerialNumber)
+
+	// Plain HTTP is rejected
+	resp, err = http.Get("http://" + addr + "/health")
+	if err == nil {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+
+	// TLS 1.1 is below the default minimum
+	_, err = tls.Dial("tcp", addr, &tls.Config{
+		RootCAs:    pool,
+		MaxVersion: tls.VersionTLS11,
+	})
+	assert.Error(t, err)
+}
+
+func TestServerReloadCertificates(t *testing.T) {
+	dir := t.TempDir()
+	_, certFile, keyFile := writeSelfSignedCert(t, dir, 1)
+
+	config := newTestServerConfig()
+	config.TLS = &network.TLSConfig{
+		CertFile: certFile,
+		KeyFile:  keyFile,
+	}
+	server := network.NewServer(config, zap.NewNop())
+	addr := startTestServer(t, server)
+
+	serial := func() int64 {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			return 0
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+	}
+
+	require.Eventually(t, func() bool { return serial() == 1 }, 2*time.Second, 20*time.Millisecond)
+
+	// Rotate the files on disk and reload
+	writeSelfSignedCert(t, dir, 2)
+	require.NoError(t, server.ReloadCertificates())
+	assert.Equal(t, int64(2), serial())
+
+	// A broken certificate keeps the current one in place
+	require.NoError(t, os.WriteFile(certFile, []byte("invalid"), 0600))
+	assert.Error(t, server.ReloadCertificates())
+	assert.Equal(t, int64(2), serial())
+}
+
+func TestServerReloadWithoutTLS(t *testing.T) {
+	server := network.NewServer(newTestServerConfig(), zap.NewNop())
+	assert.Error(t, server.ReloadCertificates())
+}
+
+func TestServerHTTP2OverTLS(t *testing.T) {
+	cert, certFile, keyFile := writeSelfSignedCert(t, t.TempDir(), 1)
+
+	config := newTestServerConfig()
+	config.TLS = &network.TLSConfig{
+		CertFile: certFile,
+		KeyFile:  keyFile,
+	}
+	addr := startTestServer(t, network.NewServer(config, zap.NewNop()))
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: pool},
+			ForceAttemptHTTP2: true,
+		},
+	}
+
+	resp, err := client.Get("https://" + addr + "/health")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "HTTP/2.0", resp.Proto)
+	assert.Equal(t, "h2", resp.TLS.NegotiatedProtocol)
+}
+
+func TestServerH2C(t *testing.T) {
+	config := newTestServerConfig()
+	config.EnableH2C = true
+	addr := startTestServer(t, network.NewServer(config, zap.NewNop()))
+
+	// Prior-knowledge cleartext HTTP/2
+	h2cClient := &http.Client{
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, proto, addr string, _ *tls.Config) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, proto, addr)
+			},
+		},
+	}
+
+	resp, err := h2cClient.Get("http://" + addr + "/health")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 2, resp.ProtoMajor)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+	// HTTP/1.1 clients are still served on the same port
+	resp, err = http.Get("http://" + addr + "/health")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 1, resp.ProtoMajor)
+}
+
+func TestServerAddRouteMiddlewareOrder(t *testing.T) {
+	server := network.NewServer(newTestServerConfig(), zap.NewNop())
+
+	var mu sync.Mutex
+	var calls []string
+	record := func(name string) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, name)
+	}
+
+	middleware := func(name string) mux.MiddlewareFunc {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				record(name + ":before")
+				w.Header().Add("X-Middleware", name)
+				next.ServeHTTP(w, r)
+				record(name + ":after")
+			})
+		}
+	}
+
+	require.NoError(t, server.AddRoute(http.MethodGet, "/ordered", func(w http.ResponseWriter, r *http.Request) {
+		record("handler")
+		w.WriteHeader(http.StatusNoContent)
+	}, middleware("first"), middleware("second")))
+
+	addr := startTestServer(t, server)
+
+	resp, err := http.Get("http://" + addr + "/ordered")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, []string{"first", "second"}, resp.Header.Values("X-Middleware"))
+	assert.Equal(t, []string{
+		"first:before",
+		"second:before",
+		"handler",
+		"second:after",
+		"first:after",
+	}, calls)
+}
+
+func TestServerReadHeaderTimeout(t *testing.T) {
+	config := newTestServerConfig()
+	config.ReadHeaderTimeout = 100 * time.Millisecond
+	addr := startTestServer(t, network.NewServer(config, zap.NewNop()))
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Trickle headers without ever finishing them
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		fmt.Fprint(conn, "GET /health HTTP/1.1\r\nHost: localhost\r\n")
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if _, err := fmt.Fprintf(conn, "X-Slow-%d: 1\r\n", i); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	start := time.Now()
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, err = io.ReadAll(conn)
+
+	// The server hangs up well before the 5s read timeout
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		assert.False(t, netErr.Timeout(), "connection was not dropped")
+	}
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestServerMaxHeaderBytes(t *testing.T) {
+	config := newTestServerConfig()
+	config.MaxHeaderBytes = 1 << 10
+	addr := startTestServer(t, network.NewServer(config, zap.NewNop()))
+
+	req, err := http.NewRequest(http.MethodGet, "http://"+addr+"/health", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Large", strings.Repeat("a", 8<<10))
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusRequestHeaderFieldsTooLarge, resp.StatusCode)
+
+	resp, err = http.Get("http://" + addr + "/health")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestServerSecurityHeaders(t *testing.T) {
+	get := func(t *testing.T, config *network.ServerConfig) http.Header {
+		addr := startTestServer(t, network.NewServer(config, zap.NewNop()))
+		resp, err := http.Get("http://" + addr + "/health")
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		return resp.Header
+	}
+
+	t.Run("Defaults", func(t *testing.T) {
+		header := get(t, newTestServerConfig())
+		assert.Equal(t, "default-src 'self'", header.Get("Content-Security-Policy"))
+		assert.Equal(t, "max-age=31536000; includeSubDomains", header.Get("Strict-Transport-Security"))
+		assert.Equal(t, "DENY", header.Get("X-Frame-Options"))
+	})
+
+	t.Run("Custom", func(t *testing.T) {
+		config := newTestServerConfig()
+		config.SecurityHeaders = &network.SecurityHeadersConfig{
+			ContentSecurityPolicy: "default-src *",
+			HSTSMaxAge:            24 * time.Hour,
+			HSTSIncludeSubdomains: true,
+			FrameOptions:          "SAMEORIGIN",
+		}
+		header := get(t, config)
+		assert.Equal(t, "default-src *", header.Get("Content-Security-Policy"))
+		assert.Equal(t, "max-age=86400; includeSubDomains", header.Get("Strict-Transport-Security"))
+		assert.Equal(t, "SAMEORIGIN", header.Get("X-Frame-Options"))
+		assert.Empty(t, header.Get("Referrer-Policy"))
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		config := newTestServerConfig()
+		config.SecurityHeaders = &network.SecurityHeadersConfig{Disabled: true}
+		header := get(t, config)
+		assert.Empty(t, header.Get("Content-Security-Policy"))
+		assert.Empty(t, header.Get("Strict-Transport-Security"))
+		assert.Empty(t, header.Get("X-Frame-Options"))
+	})
+}
+
+func TestServerMetricsStatusClass(t *testing.T) {
+	config := newTestServerConfig()
+	config.EnableMetrics = true
+	config.MetricsPath = "/metrics"
+	server := network.NewServer(config, zap.NewNop())
+
+	require.NoError(t, server.AddRoute(http.MethodGet, "/failing", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	require.NoError(t, server.AddRoute(http.MethodGet, "/silent", func(w http.ResponseWriter, r *http.Request) {}))
+
+	addr := startTestServer(t, server)
+
+	for _, path := range []string{"/failing", "/failing", "/silent"} {
+		resp, err := http.Get("http://" + addr + path)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	resp, err := http.Get("http://" + addr + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	assert.Contains(t, string(body), `http_responses_by_class_total{class="5xx",method="GET",path="/failing"} 2`)
+	assert.Contains(t, string(body), `http_responses_by_class_total{class="2xx",method="GET",path="/silent"} 1`)
+}
+
+func TestServerAddRouteDuplicate(t *testing.T) {
+	server := network.NewServer(newTestServerConfig(), zap.NewNop())
+	handler := func(w http.ResponseWriter, r *http.Request) {}
+
+	require.NoError(t, server.AddRoute(http.MethodGet, "/items", handler))
+	assert.ErrorIs(t, server.AddRoute(http.MethodGet, "/items", handler), network.ErrDuplicateRoute)
+	assert.ErrorIs(t, server.AddRoute("get", "/items", handler), network.ErrDuplicateRoute)
+	assert.ErrorIs(t, server.AddRoute(http.MethodGet, "/health", handler), network.ErrDuplicateRoute)
+
+	// Another method on the same path is a separate route
+	assert.NoError(t, server.AddRoute(http.MethodPost, "/items", handler))
+}
+
+func TestRouterAddRouteDuplicate(t *testing.T) {
+	router := network.NewRouter(zap.NewNop(), nil)
+	route := network.RouteConfig{
+		Path:    "/v1/items/{id}",
+		Method:  http.MethodGet,
+		Handler: func(w http.ResponseWriter, r *http.Request) {},
+	}
+
+	require.NoError(t, router.AddRoute(route))
+	err := router.AddRoute(route)
+	assert.ErrorIs(t, err, network.ErrDuplicateRoute)
+	assert.ErrorContains(t, err, "GET /v1/items/{id}")
+
+	route.Method = http.MethodDelete
+	assert.NoError(t, router.AddRoute(route))
+}
+
+func TestRouterRateLimitHeaders(t *testing.T) {
+	router := network.NewRouter(zap.NewNop(), nil)
+	require.NoError(t, router.AddRoute(network.RouteConfig{
+		Path:      "/limited",
+		Method:    http.MethodGet,
+		Handler:   func(w http.ResponseWriter, r *http.Request) {},
+		RateLimit: &network.RateLimit{Requests: 2, Window: time.Second},
+	}))
+
+	request := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/limited", nil))
+		return rec
+	}
+
+	rec := request()
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "1", rec.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, "1", rec.Header().Get("X-RateLimit-Reset"))
+	assert.Empty(t, rec.Header().Get("Retry-After"))
+
+	require.Equal(t, http.StatusOK, request().Code)
+
+	rec = request()
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+	assert.Equal(t, "0", rec.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, "2", rec.Header().Get("X-RateLimit-Reset"))
+
+	var resp network.APIResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.False(t, resp.Success)
+	require.NotNil(t, resp.Error)
+	assert.Equal(t, "rate_limited", resp.Error.Code)
+	assert.Equal(t, map[string]interface{}{
+		"limit":       float64(2),
+		"remaining":   float64(0),
+		"retry_after": float64(1),
+		"reset":       float64(2),
+	}, resp.Error.Details)
+}
+
+func TestRouterLoggingWithoutRequestID(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	router := network.NewRouter(zap.New(core), nil)
+
+	// Wrapped directly, so the request ID middleware never runs
+	handler := router.LoggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		require.NotPanics(t, func() {
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/untracked", nil))
+		})
+		assert.Equal(t, http.StatusAccepted, rec.Code)
+	}
+
+	// Warned about once, however many requests are missing an ID
+	assert.Equal(t, 1, logs.FilterLevelExact(zapcore.WarnLevel).Len())
+
+	processed := logs.FilterMessage("Request processed").All()
+	require.Len(t, processed, 2)
+	for _, entry := range processed {
+		assert.Equal(t, "unknown", entry.ContextMap()["request_id"])
+		assert.Equal(t, int64(http.StatusAccepted), entry.ContextMap()["status"])
+	}
+}
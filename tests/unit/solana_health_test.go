@@ -0,0 +1,116 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/labs-alone/alone-main/internal/solana"
+	"github.com/labs-alone/alone-main/pkg/api"
+)
+
+func TestClientHealth(t *testing.T) {
+	testCases := []struct {
+		name    string
+		handler rpcHandler
+		status  string
+		message string
+	}{
+		{
+			name: "OK",
+			handler: func(json.RawMessage) (interface{}, *rpcError) {
+				return "ok", nil
+			},
+		},
+		{
+			name: "Behind Status",
+			handler: func(json.RawMessage) (interface{}, *rpcError) {
+				return "behind", nil
+			},
+			status: "behind",
+		},
+		{
+			name: "Behind Error",
+			handler: func(json.RawMessage) (interface{}, *rpcError) {
+				return nil, &rpcError{Code: -32005, Message: "Node is behind by 42 slots"}
+			},
+			status:  solana.HealthBehind,
+			message: "Node is behind by 42 slots",
+		},
+		{
+			name: "Unhealthy Error",
+			handler: func(json.RawMessage) (interface{}, *rpcError) {
+				return nil, &rpcError{Code: -32005, Message: "Node is unhealthy"}
+			},
+			status:  solana.HealthUnhealthy,
+			message: "Node is unhealthy",
+		},
+		{
+			name: "RPC Error",
+			handler: func(json.RawMessage) (interface{}, *rpcError) {
+				return nil, &rpcError{Code: -32603, Message: "internal error"}
+			},
+			status: solana.HealthUnknown,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rpc := newMockRPC(t)
+			rpc.handle("getHealth", tc.handler)
+			client := setupMockSolanaClient(t, rpc)
+
+			err := client.Health(context.Background())
+			if tc.status == "" {
+				assert.NoError(t, err)
+				return
+			}
+
+			assert.ErrorIs(t, err, solana.ErrNodeUnhealthy)
+			var healthErr *solana.HealthError
+			require.True(t, errors.As(err, &healthErr))
+			assert.Equal(t, tc.status, healthErr.Status)
+			assert.Equal(t, tc.message, healthErr.Message)
+		})
+	}
+}
+
+func TestHealthReportsDegradedRPC(t *testing.T) {
+	rpc := newMockRPC(t)
+	rpc.on("getHealth", "ok")
+	router := setupTestRouter(t, api.NewHandler(nil, setupMockSolanaClient(t, rpc), nil))
+
+	rec, resp := doRequest(router, http.MethodGet, "/api/v1/health", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	data := resp.Data.(map[string]interface{})
+	assert.Equal(t, "ok", data["status"])
+
+	rec, _ = doRequest(router, http.MethodGet, "/api/v1/ready", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rpc.handle("getHealth", func(json.RawMessage) (interface{}, *rpcError) {
+		return nil, &rpcError{Code: -32005, Message: "Node is behind by 42 slots"}
+	})
+
+	// Health stays up but says why it's degraded
+	rec, resp = doRequest(router, http.MethodGet, "/api/v1/health", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	data = resp.Data.(map[string]interface{})
+	assert.Equal(t, "degraded", data["status"])
+	services := data["services"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{
+		"status": "unhealthy",
+		"error":  "node health behind: Node is behind by 42 slots",
+	}, services["solana_rpc"])
+
+	// Readiness takes the service out of rotation
+	rec, resp = doRequest(router, http.MethodGet, "/api/v1/ready", "")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.False(t, resp.Success)
+	assert.Equal(t, "service not ready", resp.Error)
+}
@@ -0,0 +1,422 @@
+package unit
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+
+	sol "github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/programs/system"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/labs-alone/alone-main/internal/solana"
+	"github.com/labs-alone/alone-main/pkg/api"
+)
+
+// swapProgram is the program mock swap transactions call
+var swapProgram = sol.MustPublicKeyFromBase58(solana.DefaultSwapProgram)
+
+// mockQuoteAPI serves a Jupiter-style quote API. Quotes give twice the input
+// amount, and swaps are a route instruction with the quoted amounts paid for
+// by the requesting wallet.
+type mockQuoteAPI struct {
+	server *httptest.Server
+	// noRoute is an output mint the API can't route to
+	noRoute string
+	// tamper, if set, changes the instructions of built swaps
+	tamper func(user sol.PublicKey, instructions []sol.Instruction) []sol.Instruction
+
+	quotes []url.Values
+	swaps  []map[string]interface{}
+	mu     sync.Mutex
+}
+
+func setupMockQuoteAPI(t *testing.T) *mockQuoteAPI {
+	m := &mockQuoteAPI{noRoute: sol.NewWallet().PublicKey().String()}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/quote", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		m.mu.Lock()
+		m.quotes = append(m.quotes, query)
+		m.mu.Unlock()
+
+		if query.Get("outputMint") == m.noRoute {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error":     "Could not find any route",
+				"errorCode": "COULD_NOT_FIND_ANY_ROUTE",
+			})
+			return
+		}
+
+		amount, _ := strconv.ParseUint(query.Get("amount"), 10, 64)
+		slippage, _ := strconv.Atoi(query.Get("slippageBps"))
+		out := amount * 2
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"inputMint":            query.Get("inputMint"),
+			"outputMint":           query.Get("outputMint"),
+			"inAmount":             strconv.FormatUint(amount, 10),
+			"outAmount":            strconv.FormatUint(out, 10),
+			"otherAmountThreshold": strconv.FormatUint(out*uint64(10000-slippage)/10000, 10),
+			"slippageBps":          slippage,
+			"priceImpactPct":       "0.01",
+			"routePlan":            []interface{}{map[string]interface{}{"percent": 100}},
+		})
+	})
+	mux.HandleFunc("/swap", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		m.mu.Lock()
+		m.swaps = append(m.swaps, body)
+		m.mu.Unlock()
+
+		user, err := sol.PublicKeyFromBase58(body["userPublicKey"].(string))
+		require.NoError(t, err)
+		var quote struct {
+			InAmount    string `json:"inAmount"`
+			OutAmount   string `json:"outAmount"`
+			SlippageBps uint16 `json:"slippageBps"`
+		}
+		raw, err := json.Marshal(body["quoteResponse"])
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(raw, &quote))
+		in, _ := strconv.ParseUint(quote.InAmount, 10, 64)
+		out, _ := strconv.ParseUint(quote.OutAmount, 10, 64)
+
+		instructions := []sol.Instruction{routeInstruction(user, in, out, quote.SlippageBps)}
+		m.mu.Lock()
+		tamper := m.tamper
+		m.mu.Unlock()
+		if tamper != nil {
+			instructions = tamper(user, instructions)
+		}
+		tx, err := sol.NewTransaction(instructions, sol.Hash{1}, sol.TransactionPayer(user))
+		require.NoError(t, err)
+		data, err := tx.MarshalBinary()
+		require.NoError(t, err)
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"swapTransaction":      base64.StdEncoding.EncodeToString(data),
+			"lastValidBlockHeight": 300,
+		})
+	})
+
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+// routeInstruction is a swap program instruction ending with the amounts of
+// a Jupiter route
+func routeInstruction(user sol.PublicKey, in, out uint64, slippageBps uint16) sol.Instruction {
+	data := make([]byte, 8, 8+19)
+	data = binary.LittleEndian.AppendUint64(data, in)
+	data = binary.LittleEndian.AppendUint64(data, out)
+	data = binary.LittleEndian.AppendUint16(data, slippageBps)
+	data = append(data, 0)
+	return sol.NewInstruction(swapProgram, sol.AccountMetaSlice{sol.Meta(user).SIGNER().WRITE()}, data)
+}
+
+func (m *mockQuoteAPI) setTamper(tamper func(user sol.PublicKey, instructions []sol.Instruction) []sol.Instruction) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tamper = tamper
+}
+
+func (m *mockQuoteAPI) Quotes() []url.Values {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]url.Values(nil), m.quotes...)
+}
+
+func (m *mockQuoteAPI) Swaps() []map[string]interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]map[string]interface{}(nil), m.swaps...)
+}
+
+func setupSwapRouter(t *testing.T, quotes *mockQuoteAPI, rpc *mockRPC, wallet bool) *api.Router {
+	router, _ := setupServerSwapRouter(t, quotes, rpc, wallet, nil)
+	return router
+}
+
+// setupServerSwapRouter returns a swap router verifying bearer tokens, with
+// an admin token. The client's program allowlist is allowed.
+func setupServerSwapRouter(t *testing.T, quotes *mockQuoteAPI, rpc *mockRPC, wallet bool, allowed []string) (*api.Router, string) {
+	client, err := solana.NewClient(&solana.ClientConfig{
+		Endpoint:   rpc.server.URL,
+		Commitment: "confirmed",
+		MaxRetries: 1,
+		Programs:   solana.ProgramPolicy{Allowed: allowed},
+	})
+	require.NoError(t, err)
+
+	handler := api.NewHandler(nil, client, nil)
+	handler.SetSwapClient(solana.NewSwapClient(solana.SwapConfig{Endpoint: quotes.server.URL}))
+	if wallet {
+		w, err := solana.CreateNewWallet(client)
+		require.NoError(t, err)
+		handler.SetWallet(w)
+	}
+	router, adminToken, _ := setupAuthRouter(t, handler)
+	return router, adminToken
+}
+
+// swapPrograms is a program allowlist fit for swaps
+var swapPrograms = []string{
+	swapProgram.String(),
+	sol.SystemProgramID.String(),
+	sol.TokenProgramID.String(),
+}
+
+func TestSolanaSwapUnsigned(t *testing.T) {
+	var blockHeight uint64 = 50
+	rpc := setupTransferRPC(t, &blockHeight)
+	quotes := setupMockQuoteAPI(t)
+	router := setupSwapRouter(t, quotes, rpc, false)
+
+	input := sol.NewWallet().PublicKey().String()
+	output := sol.NewWallet().PublicKey().String()
+	user := sol.NewWallet().PublicKey()
+
+	rec, resp := doRequest(router, http.MethodPost, "/api/v1/solana/swap", fmt.Sprintf(
+		`{"input_mint":%q,"output_mint":%q,"amount":"1000","slippage_bps":100,"user_public_key":%q}`,
+		input, output, user.String()))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	data := resp.Data.(map[string]interface{})
+	quote := data["quote"].(map[string]interface{})
+	assert.Equal(t, float64(1000), quote["in_amount"])
+	assert.Equal(t, float64(2000), quote["out_amount"])
+	assert.Equal(t, float64(1980), quote["min_out_amount"])
+	assert.Equal(t, float64(100), quote["slippage_bps"])
+	assert.Equal(t, float64(300), data["last_valid_block_height"])
+
+	require.Len(t, quotes.Quotes(), 1)
+	assert.Equal(t, "100", quotes.Quotes()[0].Get("slippageBps"))
+	assert.Equal(t, "1000", quotes.Quotes()[0].Get("amount"))
+
+	// The quote is passed back as the API returned it
+	require.Len(t, quotes.Swaps(), 1)
+	swap := quotes.Swaps()[0]
+	assert.Equal(t, user.String(), swap["userPublicKey"])
+	assert.Contains(t, swap["quoteResponse"], "routePlan")
+
+	raw, err := base64.StdEncoding.DecodeString(data["transaction"].(string))
+	require.NoError(t, err)
+	tx, err := sol.TransactionFromDecoder(sol.NewBinDecoder(raw))
+	require.NoError(t, err)
+	assert.Empty(t, tx.Signatures, "the server must not sign")
+	assert.Equal(t, user, tx.Message.AccountKeys[0])
+	assert.Equal(t, 0, rpc.callCount("sendTransaction"))
+}
+
+func TestSolanaSwapDefaultSlippage(t *testing.T) {
+	var blockHeight uint64 = 50
+	quotes := setupMockQuoteAPI(t)
+	router := setupSwapRouter(t, quotes, setupTransferRPC(t, &blockHeight), false)
+
+	rec, _ := doRequest(router, http.MethodPost, "/api/v1/solana/swap", fmt.Sprintf(
+		`{"input_mint":%q,"output_mint":%q,"amount":1000,"user_public_key":%q}`,
+		sol.NewWallet().PublicKey(), sol.NewWallet().PublicKey(), sol.NewWallet().PublicKey()))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	require.Len(t, quotes.Quotes(), 1)
+	assert.Equal(t, strconv.Itoa(solana.DefaultSlippageBps), quotes.Quotes()[0].Get("slippageBps"))
+}
+
+func TestSolanaSwapServerWallet(t *testing.T) {
+	var blockHeight uint64 = 50
+	rpc := setupTransferRPC(t, &blockHeight)
+	quotes := setupMockQuoteAPI(t)
+	router, adminToken := setupServerSwapRouter(t, quotes, rpc, true, swapPrograms)
+
+	body := fmt.Sprintf(`{"input_mint":%q,"output_mint":%q,"amount":1000}`,
+		sol.NewWallet().PublicKey(), sol.NewWallet().PublicKey())
+
+	// The server wallet only swaps for admins
+	rec := doAdminRequest(router, http.MethodPost, "/api/v1/solana/swap/execute", "", body)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Empty(t, quotes.Quotes())
+
+	rec = doAdminRequest(router, http.MethodPost, "/api/v1/solana/swap/execute", adminToken, body)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var resp api.Response
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	data := resp.Data.(map[string]interface{})
+	assert.Equal(t, sol.Signature{7}.String(), data["signature"])
+	assert.NotNil(t, data["quote"])
+	assert.Equal(t, 1, rpc.callCount("sendTransaction"))
+
+	// The plain endpoint never signs
+	rec, _ = doRequest(router, http.MethodPost, "/api/v1/solana/swap", body)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, 1, rpc.callCount("sendTransaction"))
+}
+
+func TestSolanaSwapServerWalletRequiresAllowlist(t *testing.T) {
+	var blockHeight uint64 = 50
+	rpc := setupTransferRPC(t, &blockHeight)
+	quotes := setupMockQuoteAPI(t)
+	router, adminToken := setupServerSwapRouter(t, quotes, rpc, true, nil)
+
+	rec := doAdminRequest(router, http.MethodPost, "/api/v1/solana/swap/execute", adminToken, fmt.Sprintf(
+		`{"input_mint":%q,"output_mint":%q,"amount":1000}`,
+		sol.NewWallet().PublicKey(), sol.NewWallet().PublicKey()))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Body.String(), solana.ErrSwapPolicyRequired.Error())
+	assert.Equal(t, 0, rpc.callCount("sendTransaction"))
+}
+
+func TestSolanaSwapServerWalletVerifiesTransaction(t *testing.T) {
+	attacker := sol.NewWallet().PublicKey()
+
+	testCases := []struct {
+		name   string
+		tamper func(user sol.PublicKey, instructions []sol.Instruction) []sol.Instruction
+	}{
+		{
+			name: "Drains Wallet",
+			tamper: func(user sol.PublicKey, instructions []sol.Instruction) []sol.Instruction {
+				return append(instructions, system.NewTransferInstruction(1_000_000_000, user, attacker).Build())
+			},
+		},
+		{
+			name: "Different Amount",
+			tamper: func(user sol.PublicKey, instructions []sol.Instruction) []sol.Instruction {
+				return []sol.Instruction{routeInstruction(user, 1000, 1, 100)}
+			},
+		},
+		{
+			name: "No Swap",
+			tamper: func(user sol.PublicKey, instructions []sol.Instruction) []sol.Instruction {
+				return []sol.Instruction{sol.NewInstruction(sol.TokenProgramID, sol.AccountMetaSlice{sol.Meta(user).SIGNER()}, []byte{17})}
+			},
+		},
+		{
+			name: "Two Swaps",
+			tamper: func(user sol.PublicKey, instructions []sol.Instruction) []sol.Instruction {
+				return append(instructions, instructions[0])
+			},
+		},
+		{
+			name: "Token Transfer",
+			tamper: func(user sol.PublicKey, instructions []sol.Instruction) []sol.Instruction {
+				return append(instructions, sol.NewInstruction(sol.TokenProgramID, sol.AccountMetaSlice{
+					sol.Meta(user).WRITE(), sol.Meta(attacker).WRITE(), sol.Meta(user).SIGNER(),
+				}, []byte{3, 1, 0, 0, 0, 0, 0, 0, 0}))
+			},
+		},
+		{
+			name: "Other Fee Payer",
+			tamper: func(user sol.PublicKey, instructions []sol.Instruction) []sol.Instruction {
+				return append([]sol.Instruction{
+					sol.NewInstruction(sol.TokenProgramID, sol.AccountMetaSlice{sol.Meta(attacker).SIGNER().WRITE()}, []byte{17}),
+				}, instructions...)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var blockHeight uint64 = 50
+			rpc := setupTransferRPC(t, &blockHeight)
+			quotes := setupMockQuoteAPI(t)
+			quotes.setTamper(tc.tamper)
+			router, adminToken := setupServerSwapRouter(t, quotes, rpc, true, swapPrograms)
+
+			rec := doAdminRequest(router, http.MethodPost, "/api/v1/solana/swap/execute", adminToken, fmt.Sprintf(
+				`{"input_mint":%q,"output_mint":%q,"amount":1000,"slippage_bps":100}`,
+				sol.NewWallet().PublicKey(), sol.NewWallet().PublicKey()))
+			assert.Equal(t, http.StatusBadGateway, rec.Code)
+			assert.Contains(t, rec.Body.String(), solana.ErrSwapMismatch.Error())
+			assert.Equal(t, 0, rpc.callCount("sendTransaction"))
+		})
+	}
+}
+
+func TestSolanaSwapErrors(t *testing.T) {
+	var blockHeight uint64 = 50
+	rpc := setupTransferRPC(t, &blockHeight)
+	quotes := setupMockQuoteAPI(t)
+	router := setupSwapRouter(t, quotes, rpc, false)
+
+	mint := sol.NewWallet().PublicKey().String()
+	other := sol.NewWallet().PublicKey().String()
+	user := sol.NewWallet().PublicKey().String()
+
+	testCases := []struct {
+		name           string
+		body           string
+		expectedStatus int
+	}{
+		{
+			name:           "Invalid Input Mint",
+			body:           fmt.Sprintf(`{"input_mint":"nope","output_mint":%q,"amount":1,"user_public_key":%q}`, other, user),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Same Mints",
+			body:           fmt.Sprintf(`{"input_mint":%q,"output_mint":%q,"amount":1,"user_public_key":%q}`, mint, mint, user),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Zero Amount",
+			body:           fmt.Sprintf(`{"input_mint":%q,"output_mint":%q,"amount":0,"user_public_key":%q}`, mint, other, user),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Slippage Too High",
+			body:           fmt.Sprintf(`{"input_mint":%q,"output_mint":%q,"amount":1,"slippage_bps":5001,"user_public_key":%q}`, mint, other, user),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid User Key",
+			body:           fmt.Sprintf(`{"input_mint":%q,"output_mint":%q,"amount":1,"user_public_key":"nope"}`, mint, other),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Missing User Key",
+			body:           fmt.Sprintf(`{"input_mint":%q,"output_mint":%q,"amount":1}`, mint, other),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "No Route",
+			body:           fmt.Sprintf(`{"input_mint":%q,"output_mint":%q,"amount":1,"user_public_key":%q}`, mint, quotes.noRoute, user),
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec, resp := doRequest(router, http.MethodPost, "/api/v1/solana/swap", tc.body)
+			assert.Equal(t, tc.expectedStatus, rec.Code)
+			assert.False(t, resp.Success)
+		})
+	}
+
+	// Only the unroutable swap reaches the quote API; the rest fail
+	// validation first
+	assert.Len(t, quotes.Quotes(), 1)
+	assert.Empty(t, quotes.Swaps())
+}
+
+func TestSolanaSwapNotConfigured(t *testing.T) {
+	router := setupTestRouter(t, nil)
+
+	rec, resp := doRequest(router, http.MethodPost, "/api/v1/solana/swap", fmt.Sprintf(
+		`{"input_mint":%q,"output_mint":%q,"amount":1}`,
+		sol.NewWallet().PublicKey(), sol.NewWallet().PublicKey()))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.False(t, resp.Success)
+}
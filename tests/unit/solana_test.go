@@ -3,9 +3,14 @@ package unit
 import (
 	"context"
 	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	sol "github.com/gagliardetto/solana-go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -32,7 +37,7 @@ func TestClientInitialization(t *testing.T) {
 	client, _ := setupTestClient(t)
 
 	assert.NotNil(t, client)
-	assert.Equal(t, "connected", client.Status())
+	assert.Equal(t, "connected", client.Status().State)
 
 	metrics := client.GetMetrics()
 	assert.NotNil(t, metrics)
@@ -46,7 +51,7 @@ func TestGetBalance(t *testing.T) {
 		name        string
 		address     string
 		expectError bool
-		expected    uint64
+		expected    solana.Lamports
 	}{
 		{
 			name:        "Valid Address",
@@ -298,4 +303,470 @@ func BenchmarkSendTransaction(b *testing.B) {
 			b.Fatal(err)
 		}
 	}
-}
\ No newline at end of file
+}
+func TestSubscriptionLimit(t *testing.T) {
+	rpc := newMockRPC(t)
+	rpc.on("programSubscribe", 1)
+	rpc.on("accountSubscribe", 2)
+
+	client, err := solana.NewClient(&solana.ClientConfig{
+		Endpoint:         rpc.server.URL,
+		Commitment:       "confirmed",
+		MaxSubscriptions: 2,
+	})
+	require.NoError(t, err)
+	defer client.Close()
+
+	callback := func(interface{}) error { return nil }
+	address := "11111111111111111111111111111111"
+
+	programSub, err := client.SubscribeToProgram(address, callback)
+	require.NoError(t, err)
+	_, err = client.SubscribeToAccount(address, callback)
+	require.NoError(t, err)
+
+	status := client.Status()
+	assert.Equal(t, 2, status.Subscriptions)
+	assert.Equal(t, 2, status.MaxSubscriptions)
+
+	_, err = client.SubscribeToProgram(address, callback)
+	assert.ErrorIs(t, err, solana.ErrTooManySubscriptions)
+	_, err = client.SubscribeToAccount(address, callback)
+	assert.ErrorIs(t, err, solana.ErrTooManySubscriptions)
+	assert.Equal(t, 1, rpc.callCount("programSubscribe"), "rejected subscriptions never reach the node")
+
+	// Unsubscribing frees a slot
+	require.NoError(t, client.UnsubscribeFromProgram(programSub))
+	_, err = client.SubscribeToProgram(address, callback)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, client.Status().Subscriptions)
+}
+
+func TestConcurrentFirstSubscriptions(t *testing.T) {
+	rpc := newMockRPC(t)
+	rpc.on("accountSubscribe", 1)
+
+	client := setupMockSolanaClient(t, rpc)
+	defer client.Close()
+
+	// Subscribers racing to open the websocket all end up on one
+	// connection, and the client stays usable while it is dialed
+	const subscribers = 8
+	var wg sync.WaitGroup
+	for i := 0; i < subscribers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.SubscribeToAccount(sol.NewWallet().PublicKey().String(), func(interface{}) error { return nil })
+			assert.NoError(t, err)
+			client.Status()
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, client.ListSubscriptions(), subscribers)
+	assert.Equal(t, subscribers, rpc.callCount("accountSubscribe"))
+}
+
+func TestSubscriptionsRoutedByType(t *testing.T) {
+	rpc := newMockRPC(t)
+
+	// The node hands out a new ID for every subscription, and unsubscribing
+	// takes that ID
+	var serverIDs uint64
+	nextID := func(json.RawMessage) (interface{}, *rpcError) {
+		return atomic.AddUint64(&serverIDs, 1), nil
+	}
+	rpc.handle("programSubscribe", nextID)
+	rpc.handle("accountSubscribe", nextID)
+	var unsubscribed []string
+	var unsubscribedMu sync.Mutex
+	unsubscribe := func(params json.RawMessage) (interface{}, *rpcError) {
+		unsubscribedMu.Lock()
+		defer unsubscribedMu.Unlock()
+		unsubscribed = append(unsubscribed, string(params))
+		return true, nil
+	}
+	rpc.handle("programUnsubscribe", unsubscribe)
+	rpc.handle("accountUnsubscribe", unsubscribe)
+	lastUnsubscribed := func() string {
+		unsubscribedMu.Lock()
+		defer unsubscribedMu.Unlock()
+		require.NotEmpty(t, unsubscribed)
+		return unsubscribed[len(unsubscribed)-1]
+	}
+
+	client := setupMockSolanaClient(t, rpc)
+	defer client.Close()
+
+	callback := func(interface{}) error { return nil }
+	program := "11111111111111111111111111111111"
+	account := sol.NewWallet().PublicKey().String()
+
+	programSub, err := client.SubscribeToProgram(program, callback)
+	require.NoError(t, err)
+	accountSub, err := client.SubscribeToAccount(account, callback)
+	require.NoError(t, err)
+
+	byID := func() map[string]solana.SubscriptionInfo {
+		infos := make(map[string]solana.SubscriptionInfo)
+		for _, info := range client.ListSubscriptions() {
+			infos[info.ID] = info
+		}
+		return infos
+	}
+	subs := byID()
+	require.Len(t, subs, 2)
+	assert.Equal(t, solana.SubscriptionProgram, subs[programSub].Type)
+	assert.Equal(t, program, subs[programSub].Target)
+	assert.Equal(t, solana.SubscriptionAccount, subs[accountSub].Type)
+	assert.Equal(t, account, subs[accountSub].Target)
+	assert.True(t, subs[accountSub].Active)
+	assert.False(t, subs[accountSub].CreatedAt.IsZero())
+
+	// Reconnecting resubscribes each subscription with its own method
+	require.NoError(t, client.Reconnect())
+	assert.Equal(t, 2, rpc.wsConnections())
+	assert.Equal(t, 2, rpc.callCount("programSubscribe"))
+	assert.Equal(t, 2, rpc.callCount("accountSubscribe"))
+	assert.Len(t, byID(), 2, "subscriptions keep their IDs across reconnects")
+
+	// The account subscription was renumbered 4 on the new connection
+	require.NoError(t, client.Unsubscribe(accountSub))
+	assert.Equal(t, 1, rpc.callCount("accountUnsubscribe"))
+	assert.Equal(t, 0, rpc.callCount("programUnsubscribe"))
+	assert.JSONEq(t, `[4]`, lastUnsubscribed())
+	assert.Error(t, client.Unsubscribe(accountSub), "unsubscribing twice fails")
+
+	// Subscriptions that can't be restored are dropped and reported
+	accountSub, err = client.SubscribeToAccount(account, callback)
+	require.NoError(t, err)
+	rpc.handle("accountSubscribe", func(json.RawMessage) (interface{}, *rpcError) {
+		return nil, &rpcError{Code: -32602, Message: "invalid account"}
+	})
+	err = client.Reconnect()
+	assert.ErrorContains(t, err, accountSub)
+	subs = byID()
+	assert.Len(t, subs, 1)
+	assert.Contains(t, subs, programSub)
+	assert.Equal(t, 3, rpc.callCount("programSubscribe"))
+
+	require.NoError(t, client.UnsubscribeFromProgram(programSub))
+	assert.Equal(t, 1, rpc.callCount("programUnsubscribe"))
+	assert.JSONEq(t, `[6]`, lastUnsubscribed())
+	assert.Empty(t, client.ListSubscriptions())
+
+	// Without subscriptions there is nothing to reconnect
+	connections := rpc.wsConnections()
+	require.NoError(t, client.Reconnect())
+	assert.Equal(t, connections, rpc.wsConnections())
+}
+
+func TestClientCommitmentValidation(t *testing.T) {
+	rpc := newMockRPC(t)
+
+	testCases := []struct {
+		name        string
+		commitment  string
+		expectError bool
+	}{
+		{name: "Processed", commitment: "processed"},
+		{name: "Confirmed", commitment: "confirmed"},
+		{name: "Finalized", commitment: "finalized"},
+		{name: "Empty Defaults", commitment: ""},
+		{name: "Typo", commitment: "finalised", expectError: true},
+		{name: "Wrong Case", commitment: "Confirmed", expectError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := &solana.ClientConfig{
+				Endpoint:   rpc.server.URL,
+				Commitment: tc.commitment,
+			}
+
+			client, err := solana.NewClient(config)
+			if tc.expectError {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.commitment)
+				assert.Nil(t, client)
+				return
+			}
+
+			require.NoError(t, err)
+			defer client.Close()
+			assert.Equal(t, tc.commitment, config.Commitment, "caller config is not modified")
+		})
+	}
+}
+
+func TestClientDefaultCommitment(t *testing.T) {
+	rpc := newMockRPC(t)
+
+	commitments := make(chan string, 1)
+	rpc.handle("getBalance", func(params json.RawMessage) (interface{}, *rpcError) {
+		var args []json.RawMessage
+		json.Unmarshal(params, &args)
+
+		var config struct {
+			Commitment string `json:"commitment"`
+		}
+		json.Unmarshal(args[1], &config)
+		commitments <- config.Commitment
+		return rpcContext(1), nil
+	})
+
+	client, err := solana.NewClient(&solana.ClientConfig{Endpoint: rpc.server.URL})
+	require.NoError(t, err)
+	defer client.Close()
+
+	_, err = client.GetBalance(context.Background(), "11111111111111111111111111111111")
+	require.NoError(t, err)
+	assert.Equal(t, "finalized", <-commitments)
+}
+
+// fakeClock records requested delays and fires immediately
+type fakeClock struct {
+	now    time.Time
+	delays []time.Duration
+	mu     sync.Mutex
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.delays = append(c.delays, d)
+	c.now = c.now.Add(d)
+
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+func (c *fakeClock) Delays() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]time.Duration(nil), c.delays...)
+}
+
+func TestClientRetryDelay(t *testing.T) {
+	testCases := []struct {
+		name           string
+		failures       int
+		status         int
+		retryAfter     string
+		expectedDelays []time.Duration
+		expectError    bool
+	}{
+		{
+			name:           "Retry-After Honored",
+			failures:       2,
+			status:         http.StatusTooManyRequests,
+			retryAfter:     "3",
+			expectedDelays: []time.Duration{3 * time.Second, 3 * time.Second},
+		},
+		{
+			name:           "Exponential Backoff",
+			failures:       3,
+			status:         http.StatusServiceUnavailable,
+			expectedDelays: []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond},
+		},
+		{
+			name:           "Retry-After Capped",
+			failures:       1,
+			status:         http.StatusTooManyRequests,
+			retryAfter:     "120",
+			expectedDelays: []time.Duration{5 * time.Second},
+		},
+		{
+			name:           "Retries Exhausted",
+			failures:       4,
+			status:         http.StatusTooManyRequests,
+			expectedDelays: []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond},
+			expectError:    true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rpc := newMockRPC(t)
+			rpc.on("getBalance", rpcContext(42))
+			rpc.failNext(tc.failures, tc.status, tc.retryAfter)
+
+			clock := &fakeClock{now: time.Now()}
+			client, err := solana.NewClient(&solana.ClientConfig{
+				Endpoint:      rpc.server.URL,
+				Commitment:    "confirmed",
+				MaxRetries:    3,
+				RetryDelay:    100 * time.Millisecond,
+				MaxRetryDelay: 5 * time.Second,
+				Clock:         clock,
+			})
+			require.NoError(t, err)
+			defer client.Close()
+
+			balance, err := client.GetBalance(context.Background(), "11111111111111111111111111111111")
+			assert.Equal(t, tc.expectedDelays, clock.Delays())
+			if tc.expectError {
+				assert.Error(t, err)
+				assert.Equal(t, 4, rpc.callCount("getBalance"))
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, solana.Lamports(42), balance)
+			assert.Equal(t, tc.failures+1, rpc.callCount("getBalance"))
+		})
+	}
+}
+
+func TestGetMultipleAccounts(t *testing.T) {
+	rpc := newMockRPC(t)
+
+	funded := sol.NewWallet().PublicKey().String()
+	missing := sol.NewWallet().PublicKey().String()
+
+	var batchSizes []int
+	var mu sync.Mutex
+	rpc.handle("getMultipleAccounts", func(params json.RawMessage) (interface{}, *rpcError) {
+		var args []json.RawMessage
+		json.Unmarshal(params, &args)
+
+		var keys []string
+		json.Unmarshal(args[0], &keys)
+
+		mu.Lock()
+		batchSizes = append(batchSizes, len(keys))
+		mu.Unlock()
+
+		values := make([]interface{}, len(keys))
+		for i, key := range keys {
+			if key == missing {
+				continue
+			}
+			values[i] = map[string]interface{}{
+				"data":       []string{base64.StdEncoding.EncodeToString([]byte(key[:4])), "base64"},
+				"executable": false,
+				"lamports":   5000,
+				"owner":      "11111111111111111111111111111111",
+				"rentEpoch":  361,
+			}
+		}
+		return rpcContext(values), nil
+	})
+
+	client := setupMockSolanaClient(t, rpc)
+
+	// Pad past one chunk so the addresses span two calls
+	addresses := []string{funded, missing, "not-an-address", funded}
+	for len(addresses) < 150 {
+		addresses = append(addresses, sol.NewWallet().PublicKey().String())
+	}
+
+	accounts, errs := client.GetMultipleAccounts(context.Background(), addresses)
+
+	require.Contains(t, accounts, funded)
+	assert.Equal(t, uint64(5000), accounts[funded].Lamports)
+	assert.Equal(t, []byte(funded[:4]), accounts[funded].Data)
+	assert.Equal(t, uint64(361), accounts[funded].RentEpoch)
+
+	assert.NotContains(t, accounts, missing)
+	assert.ErrorIs(t, errs[missing], solana.ErrAccountNotFound)
+
+	assert.NotContains(t, accounts, "not-an-address")
+	assert.ErrorContains(t, errs["not-an-address"], "invalid address")
+
+	// The duplicate and invalid addresses aren't sent
+	assert.Len(t, accounts, 147)
+	assert.Len(t, errs, 2)
+	// Chunks are fetched concurrently, so they may finish in any order
+	assert.ElementsMatch(t, []int{100, 48}, batchSizes)
+}
+
+func TestClientCloseWithoutWebsocket(t *testing.T) {
+	rpc := newMockRPC(t)
+	rpc.on("getBalance", rpcContext(42))
+
+	client := setupMockSolanaClient(t, rpc)
+
+	balance, err := client.GetBalance(context.Background(), "11111111111111111111111111111111")
+	require.NoError(t, err)
+	assert.Equal(t, solana.Lamports(42), balance)
+
+	assert.NoError(t, client.Close())
+	assert.NoError(t, client.Close(), "closing twice is safe")
+	assert.Equal(t, 0, rpc.wsConnections(), "balance-only clients never connect the websocket")
+	assert.Equal(t, "closed", client.Status().State)
+
+	_, err = client.SubscribeToProgram("11111111111111111111111111111111", func(interface{}) error { return nil })
+	assert.ErrorIs(t, err, solana.ErrClientClosed)
+}
+
+func TestClientUnreachableWebsocket(t *testing.T) {
+	rpc := newMockRPC(t)
+	rpc.on("getBalance", rpcContext(42))
+
+	// Nothing listens on the websocket endpoint; only subscriptions need it
+	client, err := solana.NewClient(&solana.ClientConfig{
+		Endpoint:   rpc.server.URL,
+		WsEndpoint: "ws://127.0.0.1:1",
+		Commitment: "confirmed",
+	})
+	require.NoError(t, err)
+
+	_, err = client.GetBalance(context.Background(), "11111111111111111111111111111111")
+	require.NoError(t, err)
+
+	_, err = client.SubscribeToAccount("11111111111111111111111111111111", func(interface{}) error { return nil })
+	assert.Error(t, err)
+	assert.Equal(t, 0, client.Status().Subscriptions)
+
+	assert.NoError(t, client.Close())
+}
+
+func TestClientCloseCancelsInFlightRequests(t *testing.T) {
+	rpc := newMockRPC(t)
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	rpc.handle("getBalance", func(json.RawMessage) (interface{}, *rpcError) {
+		started <- struct{}{}
+		<-release
+		return rpcContext(42), nil
+	})
+
+	client := setupMockSolanaClient(t, rpc)
+	t.Cleanup(func() { close(release) })
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := client.GetBalance(context.Background(), "11111111111111111111111111111111")
+		errs <- err
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request never reached the node")
+	}
+
+	closedAt := time.Now()
+	require.NoError(t, client.Close())
+
+	select {
+	case err := <-errs:
+		assert.Error(t, err)
+		assert.Less(t, time.Since(closedAt), time.Second, "Close aborts the request promptly")
+	case <-time.After(5 * time.Second):
+		t.Fatal("in-flight request was not cancelled by Close")
+	}
+
+	_, err := client.GetBalance(context.Background(), "11111111111111111111111111111111")
+	assert.Error(t, err, "requests after Close fail without reaching the node")
+	assert.Equal(t, 1, rpc.callCount("getBalance"))
+}
@@ -0,0 +1,1053 @@
+package unit
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	sol "github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/programs/system"
+	solrpc "github.com/gagliardetto/solana-go/rpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/labs-alone/alone-main/internal/solana"
+	"github.com/labs-alone/alone-main/internal/utils"
+	"github.com/labs-alone/alone-main/pkg/api"
+)
+
+// setupTransferRPC mocks the calls used to build, send and check transfers.
+// Each getLatestBlockhash call returns a new blockhash valid until 100 blocks
+// past the previous one.
+func setupTransferRPC(t *testing.T, blockHeight *uint64) *mockRPC {
+	rpc := newMockRPC(t)
+
+	var blockhashes uint64
+	rpc.handle("getLatestBlockhash", func(json.RawMessage) (interface{}, *rpcError) {
+		n := atomic.AddUint64(&blockhashes, 1)
+		return rpcContext(map[string]interface{}{
+			"blockhash":            sol.Hash{byte(n)}.String(),
+			"lastValidBlockHeight": n * 100,
+		}), nil
+	})
+	rpc.handle("getBlockHeight", func(json.RawMessage) (interface{}, *rpcError) {
+		return atomic.LoadUint64(blockHeight), nil
+	})
+	rpc.on("getSignatureStatuses", rpcContext([]interface{}{nil}))
+	rpc.on("sendTransaction", sol.Signature{7}.String())
+
+	return rpc
+}
+
+func TestResubmitExpiredServerTransfer(t *testing.T) {
+	var blockHeight uint64 = 50
+	rpc := setupTransferRPC(t, &blockHeight)
+	client := setupMockSolanaClient(t, rpc)
+
+	wallet, err := solana.CreateNewWallet(client)
+	require.NoError(t, err)
+
+	recipient := sol.NewWallet().PublicKey().String()
+	ctx := context.Background()
+
+	sent, err := wallet.SendTransfer(ctx, "transfer-1", recipient, 1000)
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent.Attempt)
+	assert.Equal(t, uint64(100), sent.LastValidBlockHeight)
+
+	// Still within the blockhash validity window
+	_, err = client.ResubmitTransfer(ctx, "transfer-1", wallet)
+	assert.ErrorIs(t, err, solana.ErrTransferNotExpired)
+
+	// The chain moves past the last valid block height without the
+	// transaction landing
+	atomic.StoreUint64(&blockHeight, 150)
+
+	result, err := client.ResubmitTransfer(ctx, "transfer-1", wallet)
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Attempt)
+	assert.NotEmpty(t, result.Signature)
+	assert.Empty(t, result.UnsignedTransaction)
+	assert.Equal(t, uint64(200), result.LastValidBlockHeight)
+	assert.Equal(t, 2, rpc.callCount("sendTransaction"))
+
+	transfer, ok := client.GetPendingTransfer("transfer-1")
+	require.True(t, ok)
+	assert.Equal(t, 2, transfer.Attempts)
+	assert.Equal(t, uint64(200), transfer.LastValidBlockHeight)
+}
+
+func TestSendTransferRejected(t *testing.T) {
+	var blockHeight uint64 = 50
+	rpc := setupTransferRPC(t, &blockHeight)
+	rpc.handle("sendTransaction", func(json.RawMessage) (interface{}, *rpcError) {
+		return nil, &rpcError{
+			Code:    -32002,
+			Message: "Transaction simulation failed",
+			Data: map[string]interface{}{
+				"err":  map[string]interface{}{"InstructionError": []interface{}{0, map[string]interface{}{"Custom": 1}}},
+				"logs": []string{},
+			},
+		}
+	})
+	client := setupMockSolanaClient(t, rpc)
+	wallet, err := solana.CreateNewWallet(client)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	recipient := sol.NewWallet().PublicKey().String()
+	_, err = wallet.SendTransfer(ctx, "transfer-1", recipient, 1000)
+	var txErr *solana.TransactionError
+	require.ErrorAs(t, err, &txErr)
+
+	// A rejected transfer can't land, so its key is free to use again
+	_, ok := client.GetPendingTransfer("transfer-1")
+	assert.False(t, ok)
+
+	rpc.on("sendTransaction", sol.Signature{7}.String())
+	_, err = wallet.SendTransfer(ctx, "transfer-1", recipient, 1000)
+	assert.NoError(t, err)
+}
+
+func TestResubmitConcurrent(t *testing.T) {
+	var blockHeight uint64 = 50
+	rpc := setupTransferRPC(t, &blockHeight)
+	client := setupMockSolanaClient(t, rpc)
+
+	wallet, err := solana.CreateNewWallet(client)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	_, err = wallet.SendTransfer(ctx, "transfer-1", sol.NewWallet().PublicKey().String(), 1000)
+	require.NoError(t, err)
+	atomic.StoreUint64(&blockHeight, 150)
+
+	// Hold the resubmission's send until every other request has returned
+	sending := make(chan struct{})
+	unblock := make(chan struct{})
+	rpc.handle("sendTransaction", func(json.RawMessage) (interface{}, *rpcError) {
+		close(sending)
+		<-unblock
+		return sol.Signature{8}.String(), nil
+	})
+
+	const requests = 8
+	errs := make(chan error, requests)
+	for i := 0; i < requests; i++ {
+		go func() {
+			_, err := client.ResubmitTransfer(ctx, "transfer-1", wallet)
+			errs <- err
+		}()
+	}
+
+	<-sending
+	for i := 0; i < requests-1; i++ {
+		assert.ErrorIs(t, <-errs, solana.ErrTransferInFlight)
+	}
+	close(unblock)
+	assert.NoError(t, <-errs)
+
+	assert.Equal(t, 2, rpc.callCount("sendTransaction"))
+	transfer, ok := client.GetPendingTransfer("transfer-1")
+	require.True(t, ok)
+	assert.Equal(t, 2, transfer.Attempts)
+	assert.Equal(t, sol.Signature{8}.String(), transfer.Signature)
+}
+
+func TestResubmitReleasesClaimOnFailure(t *testing.T) {
+	var blockHeight uint64 = 50
+	rpc := setupTransferRPC(t, &blockHeight)
+	client := setupMockSolanaClient(t, rpc)
+
+	wallet, err := solana.CreateNewWallet(client)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	_, err = wallet.SendTransfer(ctx, "transfer-1", sol.NewWallet().PublicKey().String(), 1000)
+	require.NoError(t, err)
+	atomic.StoreUint64(&blockHeight, 150)
+
+	_, err = client.ResubmitTransfer(ctx, "transfer-1", nil)
+	assert.ErrorIs(t, err, solana.ErrSignerRequired)
+
+	transfer, ok := client.GetPendingTransfer("transfer-1")
+	require.True(t, ok)
+	assert.Equal(t, 1, transfer.Attempts)
+
+	result, err := client.ResubmitTransfer(ctx, "transfer-1", wallet)
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Attempt)
+}
+
+func TestResubmitRequiresServerWallet(t *testing.T) {
+	var blockHeight uint64 = 500
+	rpc := setupTransferRPC(t, &blockHeight)
+	client := setupMockSolanaClient(t, rpc)
+
+	wallet, err := solana.CreateNewWallet(client)
+	require.NoError(t, err)
+
+	other, err := solana.CreateNewWallet(client)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	_, err = wallet.SendTransfer(ctx, "transfer-1", sol.NewWallet().PublicKey().String(), 1000)
+	require.NoError(t, err)
+
+	_, err = client.ResubmitTransfer(ctx, "transfer-1", nil)
+	assert.ErrorIs(t, err, solana.ErrSignerRequired)
+
+	_, err = client.ResubmitTransfer(ctx, "transfer-1", other)
+	assert.ErrorIs(t, err, solana.ErrSignerRequired)
+}
+
+func TestResubmitClientSignedTransfer(t *testing.T) {
+	var blockHeight uint64 = 50
+	rpc := setupTransferRPC(t, &blockHeight)
+	client := setupMockSolanaClient(t, rpc)
+
+	from := sol.NewWallet().PublicKey().String()
+	to := sol.NewWallet().PublicKey().String()
+	ctx := context.Background()
+
+	prepared, err := client.PrepareTransfer(ctx, "client-1", from, to, 5000)
+	require.NoError(t, err)
+	assert.NotEmpty(t, prepared.Transaction)
+
+	_, err = client.PrepareTransfer(ctx, "client-1", from, to, 5000)
+	assert.ErrorIs(t, err, solana.ErrTransferExists)
+
+	atomic.StoreUint64(&blockHeight, 150)
+
+	result, err := client.ResubmitTransfer(ctx, "client-1", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Attempt)
+	assert.Empty(t, result.Signature)
+	assert.NotEqual(t, prepared.Transaction, result.UnsignedTransaction)
+	assert.Zero(t, rpc.callCount("sendTransaction"))
+
+	data, err := base64.StdEncoding.DecodeString(result.UnsignedTransaction)
+	require.NoError(t, err)
+
+	tx, err := sol.TransactionFromDecoder(sol.NewBinDecoder(data))
+	require.NoError(t, err)
+	assert.Equal(t, sol.Hash{2}, tx.Message.RecentBlockhash)
+	assert.Equal(t, from, tx.Message.AccountKeys[0].String())
+}
+
+func TestResubmitAttemptLimit(t *testing.T) {
+	var blockHeight uint64 = 10000
+	rpc := setupTransferRPC(t, &blockHeight)
+	client := setupMockSolanaClient(t, rpc)
+
+	from := sol.NewWallet().PublicKey().String()
+	to := sol.NewWallet().PublicKey().String()
+	ctx := context.Background()
+
+	_, err := client.PrepareTransfer(ctx, "client-1", from, to, 5000)
+	require.NoError(t, err)
+
+	for i := 0; i < solana.DefaultMaxResubmitAttempts; i++ {
+		_, err := client.ResubmitTransfer(ctx, "client-1", nil)
+		require.NoError(t, err)
+	}
+
+	_, err = client.ResubmitTransfer(ctx, "client-1", nil)
+	assert.ErrorIs(t, err, solana.ErrResubmitLimit)
+
+	_, err = client.ResubmitTransfer(ctx, "unknown", nil)
+	assert.ErrorIs(t, err, solana.ErrTransferNotFound)
+}
+
+func TestBuildAndSubmitTransaction(t *testing.T) {
+	var blockHeight uint64 = 50
+	rpc := setupTransferRPC(t, &blockHeight)
+	router := setupTestRouter(t, api.NewHandler(nil, setupMockSolanaClient(t, rpc), nil))
+
+	sender := sol.NewWallet()
+	recipient := sol.NewWallet().PublicKey().String()
+
+	body := fmt.Sprintf(`{"from":%q,"to":%q,"amount":1000}`, sender.PublicKey(), recipient)
+	rec, resp := doRequest(router, http.MethodPost, "/api/v1/solana/transaction/build", body)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	built, ok := resp.Data.(map[string]interface{})
+	require.True(t, ok)
+	assert.Equal(t, sol.Hash{1}.String(), built["blockhash"])
+	assert.Equal(t, float64(100), built["last_valid_block_height"])
+
+	unsigned, ok := built["transaction"].(string)
+	require.True(t, ok)
+	data, err := base64.StdEncoding.DecodeString(unsigned)
+	require.NoError(t, err)
+
+	tx, err := sol.TransactionFromDecoder(sol.NewBinDecoder(data))
+	require.NoError(t, err)
+	assert.Empty(t, tx.Signatures, "the server must not sign")
+	assert.Equal(t, sender.PublicKey(), tx.Message.AccountKeys[0])
+
+	// Submitting without the client's signature is rejected
+	rec, _ = doRequest(router, http.MethodPost, "/api/v1/solana/transaction/submit",
+		fmt.Sprintf(`{"transaction":%q}`, unsigned))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, 0, rpc.callCount("sendTransaction"))
+
+	_, err = tx.Sign(func(key sol.PublicKey) *sol.PrivateKey {
+		if key.Equals(sender.PublicKey()) {
+			return &sender.PrivateKey
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	signed, err := tx.MarshalBinary()
+	require.NoError(t, err)
+
+	rec, resp = doRequest(router, http.MethodPost, "/api/v1/solana/transaction/submit",
+		fmt.Sprintf(`{"transaction":%q}`, base64.StdEncoding.EncodeToString(signed)))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, map[string]interface{}{"signature": sol.Signature{7}.String()}, resp.Data)
+	assert.Equal(t, 1, rpc.callCount("sendTransaction"))
+}
+
+func TestBuildAndSubmitTrackedTransfer(t *testing.T) {
+	var blockHeight uint64 = 50
+	rpc := setupTransferRPC(t, &blockHeight)
+	client := setupMockSolanaClient(t, rpc)
+	handler := api.NewHandler(nil, client, nil)
+	router := setupTestRouter(t, handler)
+
+	sender := sol.NewWallet()
+	recipient := sol.NewWallet().PublicKey().String()
+	sign := func(t *testing.T, unsigned string) string {
+		data, err := base64.StdEncoding.DecodeString(unsigned)
+		require.NoError(t, err)
+		tx, err := sol.TransactionFromDecoder(sol.NewBinDecoder(data))
+		require.NoError(t, err)
+		_, err = tx.Sign(func(key sol.PublicKey) *sol.PrivateKey {
+			if key.Equals(sender.PublicKey()) {
+				return &sender.PrivateKey
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		signed, err := tx.MarshalBinary()
+		require.NoError(t, err)
+		return base64.StdEncoding.EncodeToString(signed)
+	}
+
+	body := fmt.Sprintf(`{"from":%q,"to":%q,"amount":1000,"idempotency_key":"client-1"}`, sender.PublicKey(), recipient)
+	rec, resp := doRequest(router, http.MethodPost, "/api/v1/solana/transaction/build", body)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	built, ok := resp.Data.(map[string]interface{})
+	require.True(t, ok)
+	assert.Equal(t, "client-1", built["idempotency_key"])
+
+	rec, _ = doRequest(router, http.MethodPost, "/api/v1/solana/transaction/build", body)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	// Only the transaction built for the key is accepted under it
+	other, err := client.BuildUnsignedTransfer(context.Background(), sender.PublicKey().String(), recipient, 2000)
+	require.NoError(t, err)
+	rec, _ = doRequest(router, http.MethodPost, "/api/v1/solana/transaction/submit",
+		fmt.Sprintf(`{"transaction":%q,"idempotency_key":"client-1"}`, sign(t, other.Transaction)))
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	rec, _ = doRequest(router, http.MethodPost, "/api/v1/solana/transaction/submit",
+		fmt.Sprintf(`{"transaction":%q,"idempotency_key":"unknown"}`, sign(t, built["transaction"].(string))))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, 0, rpc.callCount("sendTransaction"))
+
+	signed := sign(t, built["transaction"].(string))
+	rec, _ = doRequest(router, http.MethodPost, "/api/v1/solana/transaction/submit",
+		fmt.Sprintf(`{"transaction":%q,"idempotency_key":"client-1"}`, signed))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	transfer, ok := client.GetPendingTransfer("client-1")
+	require.True(t, ok)
+	data, err := base64.StdEncoding.DecodeString(signed)
+	require.NoError(t, err)
+	tx, err := sol.TransactionFromDecoder(sol.NewBinDecoder(data))
+	require.NoError(t, err)
+	assert.Equal(t, tx.Signatures[0].String(), transfer.Signature)
+
+	// The transfer landed, so once its blockhash expires it isn't built
+	// again for the client to pay twice
+	rpc.on("getSignatureStatuses", rpcContext([]interface{}{
+		map[string]interface{}{"slot": 1, "confirmations": nil, "err": nil, "confirmationStatus": "confirmed"},
+	}))
+	atomic.StoreUint64(&blockHeight, 150)
+	_, err = client.ResubmitTransfer(context.Background(), "client-1", nil)
+	assert.ErrorIs(t, err, solana.ErrTransferNotExpired)
+}
+
+func TestSolanaTransactionTracked(t *testing.T) {
+	var blockHeight uint64 = 50
+	rpc := setupTransferRPC(t, &blockHeight)
+	client := setupMockSolanaClient(t, rpc)
+	wallet, err := solana.CreateNewWallet(client)
+	require.NoError(t, err)
+
+	handler := api.NewHandler(nil, client, nil)
+	handler.SetWallet(wallet)
+	router, adminToken, _ := setupAuthRouter(t, handler)
+
+	body := fmt.Sprintf(`{"from":%q,"to":%q,"amount":1000,"idempotency_key":"transfer-1"}`,
+		wallet.GetAddress(), sol.NewWallet().PublicKey())
+	rec := doAdminRequest(router, http.MethodPost, "/api/v1/solana/transaction", adminToken, body)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var resp api.Response
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	data, ok := resp.Data.(map[string]interface{})
+	require.True(t, ok)
+	assert.Equal(t, "transfer-1", data["idempotency_key"])
+
+	transfer, ok := client.GetPendingTransfer("transfer-1")
+	require.True(t, ok)
+	assert.Equal(t, data["signature"], transfer.Signature)
+
+	rec = doAdminRequest(router, http.MethodPost, "/api/v1/solana/transaction", adminToken, body)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Equal(t, 1, rpc.callCount("sendTransaction"))
+
+	// Once expired it can be resubmitted through the API
+	atomic.StoreUint64(&blockHeight, 150)
+	rec = doAdminRequest(router, http.MethodPost, "/api/v1/solana/transaction/resubmit", adminToken,
+		`{"idempotency_key":"transfer-1"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, 2, rpc.callCount("sendTransaction"))
+}
+
+func TestSolanaTransactionServerWallet(t *testing.T) {
+	var blockHeight uint64 = 50
+	rpc := setupTransferRPC(t, &blockHeight)
+	client := setupMockSolanaClient(t, rpc)
+	wallet, err := solana.CreateNewWallet(client)
+	require.NoError(t, err)
+
+	handler := api.NewHandler(nil, client, nil)
+	handler.SetWallet(wallet)
+	router, adminToken, userToken := setupAuthRouter(t, handler)
+
+	recipient := sol.NewWallet().PublicKey().String()
+	body := fmt.Sprintf(`{"from":%q,"to":%q,"amount":1000}`, wallet.GetAddress(), recipient)
+
+	// The server wallet only sends for admins
+	rec := doAdminRequest(router, http.MethodPost, "/api/v1/solana/transaction", "", body)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	rec = doAdminRequest(router, http.MethodPost, "/api/v1/solana/transaction", userToken, body)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	// Nor can it be asked to sign for another sender
+	other := fmt.Sprintf(`{"from":%q,"to":%q,"amount":1000}`, sol.NewWallet().PublicKey(), recipient)
+	rec = doAdminRequest(router, http.MethodPost, "/api/v1/solana/transaction", adminToken, other)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, 0, rpc.callCount("sendTransaction"))
+
+	rec = doAdminRequest(router, http.MethodPost, "/api/v1/solana/transaction", adminToken, body)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var resp api.Response
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	data, ok := resp.Data.(map[string]interface{})
+	require.True(t, ok)
+	assert.NotEmpty(t, data["signature"])
+	assert.Equal(t, 1, rpc.callCount("sendTransaction"))
+
+	// Without an authenticator the route is closed rather than open
+	rec, _ = doRequest(setupTestRouter(t, handler), http.MethodPost, "/api/v1/solana/transaction", body)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, 1, rpc.callCount("sendTransaction"))
+}
+
+func TestSolanaResubmitRequiresAdmin(t *testing.T) {
+	var blockHeight uint64 = 50
+	rpc := setupTransferRPC(t, &blockHeight)
+	client := setupMockSolanaClient(t, rpc)
+	wallet, err := solana.CreateNewWallet(client)
+	require.NoError(t, err)
+
+	_, err = wallet.SendTransfer(context.Background(), "transfer-1", sol.NewWallet().PublicKey().String(), 1000)
+	require.NoError(t, err)
+	atomic.StoreUint64(&blockHeight, 150)
+
+	handler := api.NewHandler(nil, client, nil)
+	router, adminToken, userToken := setupAuthRouter(t, handler)
+	body := `{"idempotency_key":"transfer-1"}`
+
+	// Resubmitting signs and sends from the server wallet, so only admins
+	// may ask for it
+	rec := doAdminRequest(router, http.MethodPost, "/api/v1/solana/transaction/resubmit", "", body)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	rec = doAdminRequest(router, http.MethodPost, "/api/v1/solana/transaction/resubmit", userToken, body)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	// A server that doesn't hold the sending wallet can't re-sign it
+	rec = doAdminRequest(router, http.MethodPost, "/api/v1/solana/transaction/resubmit", adminToken, body)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Contains(t, rec.Body.String(), solana.ErrSignerRequired.Error())
+	assert.Equal(t, 1, rpc.callCount("sendTransaction"))
+
+	handler.SetWallet(wallet)
+	rec = doAdminRequest(router, http.MethodPost, "/api/v1/solana/transaction/resubmit", adminToken, body)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, 2, rpc.callCount("sendTransaction"))
+
+	rec, _ = doRequest(setupTestRouter(t, handler), http.MethodPost, "/api/v1/solana/transaction/resubmit", body)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
+func TestBuildAndSubmitTransactionInvalid(t *testing.T) {
+	var blockHeight uint64 = 50
+	rpc := setupTransferRPC(t, &blockHeight)
+	router := setupTestRouter(t, api.NewHandler(nil, setupMockSolanaClient(t, rpc), nil))
+
+	recipient := sol.NewWallet().PublicKey().String()
+
+	testCases := []struct {
+		name string
+		path string
+		body string
+	}{
+		{
+			name: "Zero Amount",
+			path: "/api/v1/solana/transaction/build",
+			body: fmt.Sprintf(`{"from":%q,"to":%q,"amount":0}`, recipient, recipient),
+		},
+		{
+			name: "Invalid Sender",
+			path: "/api/v1/solana/transaction/build",
+			body: fmt.Sprintf(`{"from":"nope","to":%q,"amount":1}`, recipient),
+		},
+		{
+			name: "Missing Transaction",
+			path: "/api/v1/solana/transaction/submit",
+			body: `{"transaction":""}`,
+		},
+		{
+			name: "Invalid Base64",
+			path: "/api/v1/solana/transaction/submit",
+			body: `{"transaction":"not base64!"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec, resp := doRequest(router, http.MethodPost, tc.path, tc.body)
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.False(t, resp.Success)
+		})
+	}
+
+	assert.Equal(t, 0, rpc.callCount("getLatestBlockhash"))
+	assert.Equal(t, 0, rpc.callCount("sendTransaction"))
+}
+
+// signedTransfer returns a base64 transfer signed by a new wallet
+func signedTransfer(t *testing.T) string {
+	sender := sol.NewWallet()
+	tx, err := sol.NewTransaction(
+		[]sol.Instruction{
+			system.NewTransferInstruction(1000, sender.PublicKey(), sol.NewWallet().PublicKey()).Build(),
+		},
+		sol.Hash{1},
+		sol.TransactionPayer(sender.PublicKey()),
+	)
+	require.NoError(t, err)
+
+	_, err = tx.Sign(func(key sol.PublicKey) *sol.PrivateKey {
+		if key.Equals(sender.PublicKey()) {
+			return &sender.PrivateKey
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	data, err := tx.MarshalBinary()
+	require.NoError(t, err)
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+func TestSendTransactionErrors(t *testing.T) {
+	testCases := []struct {
+		name            string
+		err             interface{}
+		logs            []string
+		expectedKind    string
+		expectedIndex   int
+		expectedCode    *uint32
+		expectedMessage string
+	}{
+		{
+			name:            "Custom Program Error",
+			err:             map[string]interface{}{"InstructionError": []interface{}{0, map[string]interface{}{"Custom": 1}}},
+			logs:            []string{"Program Tokenkeg invoke [1]", "Program log: Error: insufficient funds"},
+			expectedKind:    "Custom",
+			expectedIndex:   0,
+			expectedCode:    func() *uint32 { c := uint32(1); return &c }(),
+			expectedMessage: "instruction 0 failed: custom program error 0x1",
+		},
+		{
+			name:            "Instruction Error",
+			err:             map[string]interface{}{"InstructionError": []interface{}{2, "InvalidAccountData"}},
+			expectedKind:    "InvalidAccountData",
+			expectedIndex:   2,
+			expectedMessage: "instruction 2 failed: InvalidAccountData",
+		},
+		{
+			name:            "Transaction Error",
+			err:             "BlockhashNotFound",
+			expectedKind:    "BlockhashNotFound",
+			expectedIndex:   -1,
+			expectedMessage: "transaction failed: BlockhashNotFound",
+		},
+		{
+			name:            "Transaction Error With Payload",
+			err:             map[string]interface{}{"InsufficientFundsForRent": map[string]interface{}{"account_index": 2}},
+			expectedKind:    "InsufficientFundsForRent",
+			expectedIndex:   -1,
+			expectedMessage: "transaction failed: InsufficientFundsForRent",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rpc := newMockRPC(t)
+			rpc.handle("sendTransaction", func(json.RawMessage) (interface{}, *rpcError) {
+				return nil, &rpcError{
+					Code:    -32002,
+					Message: "Transaction simulation failed: " + tc.expectedMessage,
+					Data: map[string]interface{}{
+						"err":           tc.err,
+						"logs":          tc.logs,
+						"unitsConsumed": 0,
+					},
+				}
+			})
+			client := setupMockSolanaClient(t, rpc)
+
+			_, err := client.SubmitSignedTransaction(context.Background(), signedTransfer(t))
+			require.Error(t, err)
+
+			var txErr *solana.TransactionError
+			require.ErrorAs(t, err, &txErr)
+			assert.Equal(t, tc.expectedKind, txErr.Kind)
+			assert.Equal(t, tc.expectedIndex, txErr.InstructionIndex)
+			assert.Equal(t, tc.expectedCode, txErr.Code)
+			assert.Equal(t, tc.logs, txErr.Logs)
+			assert.Equal(t, tc.expectedMessage, txErr.Error())
+		})
+	}
+
+	t.Run("Plain RPC Error", func(t *testing.T) {
+		rpc := newMockRPC(t)
+		rpc.handle("sendTransaction", func(json.RawMessage) (interface{}, *rpcError) {
+			return nil, &rpcError{Code: -32005, Message: "node is behind"}
+		})
+		client := setupMockSolanaClient(t, rpc)
+
+		_, err := client.SubmitSignedTransaction(context.Background(), signedTransfer(t))
+		require.Error(t, err)
+
+		var txErr *solana.TransactionError
+		assert.False(t, errors.As(err, &txErr))
+	})
+}
+
+func TestSubmitTransactionOnChainFailure(t *testing.T) {
+	rpc := newMockRPC(t)
+	rpc.handle("sendTransaction", func(json.RawMessage) (interface{}, *rpcError) {
+		return nil, &rpcError{
+			Code:    -32002,
+			Message: "Transaction simulation failed",
+			Data: map[string]interface{}{
+				"err":  map[string]interface{}{"InstructionError": []interface{}{0, map[string]interface{}{"Custom": 1}}},
+				"logs": []string{"Program log: Error: insufficient funds"},
+			},
+		}
+	})
+	router := setupTestRouter(t, api.NewHandler(nil, setupMockSolanaClient(t, rpc), nil))
+
+	rec, resp := doRequest(router, http.MethodPost, "/api/v1/solana/transaction/submit",
+		fmt.Sprintf(`{"transaction":%q}`, signedTransfer(t)))
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Equal(t, "instruction 0 failed: custom program error 0x1", resp.Error)
+
+	details, ok := resp.Details.(map[string]interface{})
+	require.True(t, ok)
+	assert.Equal(t, float64(1), details["code"])
+	assert.Equal(t, float64(0), details["instruction_index"])
+}
+
+func TestTransactionAmountFormats(t *testing.T) {
+	var blockHeight uint64 = 50
+	rpc := setupTransferRPC(t, &blockHeight)
+	router := setupTestRouter(t, api.NewHandler(nil, setupMockSolanaClient(t, rpc), nil))
+
+	sender := sol.NewWallet().PublicKey()
+	recipient := sol.NewWallet().PublicKey()
+
+	testCases := []struct {
+		name           string
+		amount         string
+		expectedAmount uint64
+		expectedError  string
+	}{
+		{name: "Numeric", amount: `1000000`, expectedAmount: 1000000},
+		{name: "String", amount: `"1000000"`, expectedAmount: 1000000},
+		{name: "Exponent", amount: `1e9`, expectedAmount: 1000000000},
+		{name: "String Exponent", amount: `"2.5e9"`, expectedAmount: 2500000000},
+		{name: "Max Uint64 String", amount: `"18446744073709551615"`, expectedAmount: 18446744073709551615},
+		{name: "Fraction", amount: `1.5`, expectedError: "invalid amount 1.5: must be a whole number of base units"},
+		{name: "Negative", amount: `-5`, expectedError: "invalid amount -5: must not be negative"},
+		{name: "Negative String", amount: `"-5"`, expectedError: `invalid amount "-5": must not be negative`},
+		{name: "Overflow", amount: `18446744073709551616`, expectedError: "invalid amount 18446744073709551616: exceeds the maximum amount"},
+		{name: "Hex String", amount: `"0x10"`, expectedError: `invalid amount "0x10": must be a number or numeric string`},
+		{name: "Boolean", amount: `true`, expectedError: "invalid amount true: must be a number or numeric string"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			body := fmt.Sprintf(`{"from":%q,"to":%q,"amount":%s}`, sender, recipient, tc.amount)
+			rec, resp := doRequest(router, http.MethodPost, "/api/v1/solana/transaction/build", body)
+
+			if tc.expectedError != "" {
+				assert.Equal(t, http.StatusBadRequest, rec.Code)
+				assert.Equal(t, tc.expectedError, resp.Error)
+				details, ok := resp.Details.(map[string]interface{})
+				require.True(t, ok)
+				assert.Equal(t, "invalid_amount", details["reason"])
+				assert.Equal(t, tc.amount, details["got"])
+				return
+			}
+
+			require.Equal(t, http.StatusOK, rec.Code, resp.Error)
+			built, ok := resp.Data.(map[string]interface{})
+			require.True(t, ok)
+			data, err := base64.StdEncoding.DecodeString(built["transaction"].(string))
+			require.NoError(t, err)
+			tx, err := sol.TransactionFromDecoder(sol.NewBinDecoder(data))
+			require.NoError(t, err)
+
+			// System transfer data is a u32 instruction index then the u64 lamports
+			require.Len(t, tx.Message.Instructions, 1)
+			instruction := tx.Message.Instructions[0].Data
+			require.Len(t, instruction, 12)
+			assert.Equal(t, tc.expectedAmount, binary.LittleEndian.Uint64(instruction[4:]))
+		})
+	}
+}
+
+func TestSendSOLLogsLifecycle(t *testing.T) {
+	recipient := sol.NewWallet().PublicKey().String()
+
+	testCases := []struct {
+		name           string
+		confirm        bool
+		simulation     interface{}
+		status         string
+		expectedStages []string
+		expectedError  bool
+		expectedErr    error
+	}{
+		{
+			name:           "Submitted",
+			simulation:     map[string]interface{}{"err": nil, "logs": []string{}},
+			expectedStages: []string{"build", "sign", "submit"},
+		},
+		{
+			name:           "Confirmed",
+			confirm:        true,
+			simulation:     map[string]interface{}{"err": nil, "logs": []string{}},
+			expectedStages: []string{"build", "sign", "simulate", "submit", "confirm"},
+		},
+		{
+			name:           "Confirmation Timeout",
+			confirm:        true,
+			simulation:     map[string]interface{}{"err": nil, "logs": []string{}},
+			status:         "processed",
+			expectedStages: []string{"build", "sign", "simulate", "submit", "confirm"},
+			expectedErr:    context.DeadlineExceeded,
+		},
+		{
+			name:    "Simulation Failure",
+			confirm: true,
+			simulation: map[string]interface{}{
+				"err":  map[string]interface{}{"InstructionError": []interface{}{0, map[string]interface{}{"Custom": 1}}},
+				"logs": []string{"Program 11111111111111111111111111111111 failed"},
+			},
+			expectedStages: []string{"build", "sign", "simulate"},
+			expectedError:  true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var blockHeight uint64 = 50
+			rpc := setupTransferRPC(t, &blockHeight)
+			status := tc.status
+			if status == "" {
+				status = "confirmed"
+			}
+			rpc.on("simulateTransaction", rpcContext(tc.simulation))
+			rpc.on("getSignatureStatuses", rpcContext([]interface{}{
+				map[string]interface{}{"slot": 1, "confirmations": nil, "err": nil, "confirmationStatus": status},
+			}))
+
+			var out bytes.Buffer
+			client, err := solana.NewClient(&solana.ClientConfig{
+				Endpoint:       rpc.server.URL,
+				Commitment:     "confirmed",
+				MaxRetries:     1,
+				ConfirmTimeout: 50 * time.Millisecond,
+				Logger:         utils.NewLogger(utils.WithOutput(&out, utils.DEBUG)),
+			})
+			require.NoError(t, err)
+			wallet, err := solana.CreateNewWallet(client)
+			require.NoError(t, err)
+
+			send := wallet.SendSOL
+			if tc.confirm {
+				send = wallet.SendSOLConfirmed
+			}
+			signature, err := send(context.Background(), recipient, 5000)
+
+			var lines []string
+			var stages []string
+			stagePattern := regexp.MustCompile(`stage=(\w+)`)
+			for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+				if match := stagePattern.FindStringSubmatch(line); match != nil {
+					lines = append(lines, line)
+					stages = append(stages, match[1])
+				}
+			}
+			assert.Equal(t, tc.expectedStages, stages)
+
+			for _, line := range lines {
+				assert.Contains(t, line, "amount=5000")
+				assert.Contains(t, line, "recipient="+recipient[:4]+"..."+recipient[len(recipient)-4:])
+				assert.NotContains(t, line, recipient)
+				assert.Contains(t, line, "elapsed=")
+			}
+			last := lines[len(lines)-1]
+
+			if tc.expectedError {
+				var txErr *solana.TransactionError
+				require.True(t, errors.As(err, &txErr))
+				assert.Equal(t, "Custom", txErr.Kind)
+				assert.Contains(t, last, "ERROR")
+				assert.Contains(t, last, "outcome=failed")
+				assert.Equal(t, 0, rpc.callCount("sendTransaction"))
+				return
+			}
+
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+				assert.NotEmpty(t, signature)
+				assert.Contains(t, last, "ERROR")
+				assert.Contains(t, last, "outcome=failed")
+				assert.Contains(t, last, "signature="+signature)
+				return
+			}
+
+			require.NoError(t, err)
+			if !tc.confirm {
+				assert.Equal(t, 0, rpc.callCount("simulateTransaction"))
+				assert.Equal(t, 0, rpc.callCount("getSignatureStatuses"))
+			}
+			assert.Contains(t, lines[0], "DEBUG")
+			assert.Contains(t, last, "INFO")
+			assert.Contains(t, last, "outcome=ok")
+			assert.Contains(t, last, "signature="+signature)
+			assert.NotContains(t, lines[0], "signature=")
+		})
+	}
+}
+
+func TestSendAndConfirm(t *testing.T) {
+	transaction, err := base64.StdEncoding.DecodeString(signedTransfer(t))
+	require.NoError(t, err)
+	signature := sol.Signature{7}.String()
+
+	testCases := []struct {
+		name           string
+		status         interface{}
+		commitment     solrpc.CommitmentType
+		timeout        time.Duration
+		expectedStatus string
+		expectedSlot   uint64
+		expectedErr    error
+		expectedKind   string
+	}{
+		{
+			name:           "Confirmed",
+			status:         map[string]interface{}{"slot": 42, "confirmations": 3, "err": nil, "confirmationStatus": "confirmed"},
+			expectedStatus: "confirmed",
+			expectedSlot:   42,
+		},
+		{
+			name: "Failed On Chain",
+			status: map[string]interface{}{
+				"slot":               43,
+				"confirmations":      1,
+				"err":                map[string]interface{}{"InstructionError": []interface{}{0, map[string]interface{}{"Custom": 1}}},
+				"confirmationStatus": "processed",
+			},
+			expectedStatus: "processed",
+			expectedSlot:   43,
+			expectedErr:    solana.ErrTransferFailed,
+			expectedKind:   "Custom",
+		},
+		{
+			name:        "Below Commitment Times Out",
+			status:      map[string]interface{}{"slot": 44, "confirmations": 0, "err": nil, "confirmationStatus": "processed"},
+			commitment:  solrpc.CommitmentFinalized,
+			timeout:     50 * time.Millisecond,
+			expectedErr: context.DeadlineExceeded,
+		},
+		{
+			name:        "Not Found Times Out",
+			timeout:     50 * time.Millisecond,
+			expectedErr: context.DeadlineExceeded,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mock := newMockRPC(t)
+			mock.on("sendTransaction", signature)
+			mock.on("getSignatureStatuses", rpcContext([]interface{}{tc.status}))
+			client, err := solana.NewClient(&solana.ClientConfig{
+				Endpoint:       mock.server.URL,
+				Commitment:     "confirmed",
+				MaxRetries:     1,
+				ConfirmTimeout: tc.timeout,
+			})
+			require.NoError(t, err)
+
+			result, err := client.SendAndConfirm(context.Background(), transaction, tc.commitment)
+			require.NotNil(t, result)
+			assert.Equal(t, signature, result.Signature)
+			assert.Equal(t, 1, mock.callCount("sendTransaction"))
+
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+			} else {
+				require.NoError(t, err)
+				assert.Nil(t, result.Err)
+			}
+			assert.Equal(t, tc.expectedStatus, result.ConfirmationStatus)
+			assert.Equal(t, tc.expectedSlot, result.Slot)
+
+			if tc.expectedKind != "" {
+				require.NotNil(t, result.Err)
+				assert.Equal(t, tc.expectedKind, result.Err.Kind)
+				assert.Equal(t, "instruction 0 failed: custom program error 0x1", result.Err.Error())
+
+				var txErr *solana.TransactionError
+				require.ErrorAs(t, err, &txErr)
+				assert.Same(t, result.Err, txErr)
+			}
+		})
+	}
+
+	t.Run("Invalid Commitment", func(t *testing.T) {
+		mock := newMockRPC(t)
+		client := setupMockSolanaClient(t, mock)
+
+		_, err := client.SendAndConfirm(context.Background(), transaction, "eventually")
+		assert.Error(t, err)
+		assert.Equal(t, 0, mock.callCount("sendTransaction"))
+	})
+}
+
+func TestWalletProgramPolicy(t *testing.T) {
+	systemID := sol.SystemProgramID.String()
+	tokenID := sol.TokenProgramID.String()
+
+	testCases := []struct {
+		name           string
+		policy         solana.ProgramPolicy
+		expectedReason string
+	}{
+		{
+			name: "No Policy",
+		},
+		{
+			name:   "Allowed",
+			policy: solana.ProgramPolicy{Allowed: []string{systemID, tokenID}},
+		},
+		{
+			name:           "Not In Allowlist",
+			policy:         solana.ProgramPolicy{Allowed: []string{systemID}},
+			expectedReason: "not_allowed",
+		},
+		{
+			name:           "Denied",
+			policy:         solana.ProgramPolicy{Denied: []string{tokenID}},
+			expectedReason: "denied",
+		},
+		{
+			name:           "Denied Overrides Allowed",
+			policy:         solana.ProgramPolicy{Allowed: []string{systemID, tokenID}, Denied: []string{tokenID}},
+			expectedReason: "denied",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var audit bytes.Buffer
+			client, err := solana.NewClient(&solana.ClientConfig{
+				Programs:    tc.policy,
+				AuditLogger: utils.NewLogger(utils.WithOutput(&audit, utils.DEBUG)),
+			})
+			require.NoError(t, err)
+			wallet, err := solana.CreateNewWallet(client)
+			require.NoError(t, err)
+
+			owner := sol.MustPublicKeyFromBase58(wallet.GetAddress())
+			tx, err := sol.NewTransaction(
+				[]sol.Instruction{
+					system.NewTransferInstruction(1000, owner, sol.NewWallet().PublicKey()).Build(),
+					sol.NewInstruction(sol.TokenProgramID, sol.AccountMetaSlice{sol.Meta(owner).SIGNER()}, []byte{1}),
+				},
+				sol.Hash{1},
+				sol.TransactionPayer(owner),
+			)
+			require.NoError(t, err)
+
+			err = wallet.SignTransaction(tx)
+			if tc.expectedReason == "" {
+				require.NoError(t, err)
+				assert.Len(t, tx.Signatures, 1)
+				assert.Empty(t, audit.String())
+				return
+			}
+
+			require.ErrorIs(t, err, solana.ErrProgramNotAllowed)
+			var violation *solana.ProgramViolation
+			require.ErrorAs(t, err, &violation)
+			assert.Equal(t, 1, violation.Instruction)
+			assert.Equal(t, sol.TokenProgramID, violation.Program)
+			assert.Equal(t, tc.expectedReason, violation.Reason)
+			assert.Empty(t, tx.Signatures)
+
+			logged := audit.String()
+			assert.Contains(t, logged, "Refused to sign transaction")
+			assert.Contains(t, logged, "program="+tokenID)
+			assert.Contains(t, logged, "reason="+tc.expectedReason)
+			assert.Contains(t, logged, "wallet="+wallet.GetAddress())
+		})
+	}
+
+	_, err := solana.NewClient(&solana.ClientConfig{Programs: solana.ProgramPolicy{Denied: []string{"not-a-program"}}})
+	assert.ErrorContains(t, err, `invalid denied program "not-a-program"`)
+}
@@ -0,0 +1,107 @@
+package unit
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/labs-alone/alone-main/internal/core"
+)
+
+func TestStateNilMetadata(t *testing.T) {
+	state, err := core.NewState()
+	require.NoError(t, err)
+
+	state.TrackTransaction(&core.Transaction{ID: "tx-1", Status: "pending"})
+	state.TrackTransaction(nil)
+
+	tx, ok := state.GetTransaction("tx-1")
+	require.True(t, ok)
+	assert.NotNil(t, tx.Data)
+
+	// Clearing metadata through the returned pointer must not break export
+	tx.Data = nil
+	state.UpdateTransaction("tx-1", "confirmed")
+	assert.NotNil(t, tx.Data)
+
+	tx.Data = nil
+	state.AddConnection(&core.Connection{ID: "conn-1", Type: "ws"})
+
+	data, err := state.Export()
+	require.NoError(t, err)
+
+	var exported struct {
+		Connections  map[string]map[string]interface{} `json:"connections"`
+		Transactions map[string]map[string]interface{} `json:"transactions"`
+	}
+	require.NoError(t, json.Unmarshal(data, &exported))
+
+	require.Contains(t, exported.Transactions, "tx-1")
+	assert.Equal(t, map[string]interface{}{}, exported.Transactions["tx-1"]["data"])
+	assert.Equal(t, "confirmed", exported.Transactions["tx-1"]["status"])
+	assert.Equal(t, map[string]interface{}{}, exported.Connections["conn-1"]["metadata"])
+}
+
+func TestStateListConnections(t *testing.T) {
+	state, err := core.NewState()
+	require.NoError(t, err)
+
+	now := time.Now()
+	state.AddConnection(&core.Connection{
+		ID:        "ws-fresh",
+		Type:      "ws",
+		StartTime: now.Add(-time.Minute),
+		LastPing:  now,
+		Metadata:  core.Metadata{"client": "web"},
+	})
+	state.AddConnection(&core.Connection{
+		ID:        "ws-stale",
+		Type:      "ws",
+		StartTime: now.Add(-time.Hour),
+		LastPing:  now.Add(-10 * time.Minute),
+	})
+	state.AddConnection(&core.Connection{
+		ID:        "rpc-stale",
+		Type:      "rpc",
+		StartTime: now.Add(-2 * time.Hour),
+		LastPing:  now.Add(-30 * time.Minute),
+	})
+
+	t.Run("All", func(t *testing.T) {
+		conns := state.ListConnections(core.ConnectionFilter{})
+		require.Len(t, conns, 3)
+		assert.Equal(t, "rpc-stale", conns[0].ID)
+		assert.Equal(t, "ws-fresh", conns[2].ID)
+	})
+
+	t.Run("By Type", func(t *testing.T) {
+		conns := state.ListConnections(core.ConnectionFilter{Type: "ws"})
+		require.Len(t, conns, 2)
+		assert.Equal(t, "ws-stale", conns[0].ID)
+		assert.Equal(t, "ws-fresh", conns[1].ID)
+	})
+
+	t.Run("By Staleness", func(t *testing.T) {
+		conns := state.ListConnections(core.ConnectionFilter{MinIdle: 5 * time.Minute})
+		require.Len(t, conns, 2)
+		assert.Equal(t, "rpc-stale", conns[0].ID)
+		assert.Equal(t, "ws-stale", conns[1].ID)
+
+		conns = state.ListConnections(core.ConnectionFilter{Type: "ws", MinIdle: 5 * time.Minute})
+		require.Len(t, conns, 1)
+		assert.Equal(t, "ws-stale", conns[0].ID)
+	})
+
+	t.Run("Returns Copies", func(t *testing.T) {
+		conns := state.ListConnections(core.ConnectionFilter{Type: "ws"})
+		conns[1].Metadata["client"] = "changed"
+		conns[1].Type = "changed"
+
+		conns = state.ListConnections(core.ConnectionFilter{Type: "ws"})
+		require.Len(t, conns, 2)
+		assert.Equal(t, "web", conns[1].Metadata["client"])
+	})
+}
@@ -0,0 +1,229 @@
+package unit
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	sol "github.com/gagliardetto/solana-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/labs-alone/alone-main/internal/validate"
+)
+
+func TestValidateRules(t *testing.T) {
+	address := sol.NewWallet().PublicKey().String()
+
+	testCases := []struct {
+		name         string
+		check        func(v *validate.Validator) bool
+		expectedCode string
+		expectedMsg  string
+	}{
+		{
+			name: "Required Present",
+			check: func(v *validate.Validator) bool {
+				return validate.Field(v, "f", "x", validate.Required[string]())
+			},
+		},
+		{
+			name: "Required Missing",
+			check: func(v *validate.Validator) bool {
+				return validate.Field(v, "f", "", validate.Required[string]())
+			},
+			expectedCode: validate.CodeRequired,
+			expectedMsg:  "f is required",
+		},
+		{
+			name: "Address Valid",
+			check: func(v *validate.Validator) bool {
+				return validate.Field(v, "f", address, validate.Address())
+			},
+		},
+		{
+			name: "Address Invalid",
+			check: func(v *validate.Validator) bool {
+				return validate.Field(v, "f", "not-an-address", validate.Address())
+			},
+			expectedCode: validate.CodeInvalidAddress,
+			expectedMsg:  "f must be a base58 Solana address",
+		},
+		{
+			name: "Address Too Short",
+			check: func(v *validate.Validator) bool {
+				return validate.Field(v, "f", "1111", validate.Address())
+			},
+			expectedCode: validate.CodeInvalidAddress,
+		},
+		{
+			name: "Commitment Valid",
+			check: func(v *validate.Validator) bool {
+				return validate.Field(v, "f", "finalized", validate.Commitment())
+			},
+		},
+		{
+			name: "Commitment Invalid",
+			check: func(v *validate.Validator) bool {
+				return validate.Field(v, "f", "Finalized", validate.Commitment())
+			},
+			expectedCode: validate.CodeInvalidCommitment,
+			expectedMsg:  "f must be processed, confirmed or finalized",
+		},
+		{
+			name: "OneOf Invalid",
+			check: func(v *validate.Validator) bool {
+				return validate.Field(v, "f", "btc", validate.OneOf("lamports", "sol"))
+			},
+			expectedCode: validate.CodeNotAllowed,
+			expectedMsg:  "f must be lamports or sol",
+		},
+		{
+			name: "Positive Zero",
+			check: func(v *validate.Validator) bool {
+				return validate.Field(v, "f", uint64(0), validate.Positive[uint64]())
+			},
+			expectedCode: validate.CodeOutOfRange,
+			expectedMsg:  "f must be greater than zero",
+		},
+		{
+			name: "Min Below",
+			check: func(v *validate.Validator) bool {
+				return validate.Field(v, "f", 0, validate.Min(1))
+			},
+			expectedCode: validate.CodeOutOfRange,
+			expectedMsg:  "f must be at least 1",
+		},
+		{
+			name: "Between Bounds Inclusive",
+			check: func(v *validate.Validator) bool {
+				return validate.Field(v, "f", 100, validate.Between(1, 100))
+			},
+		},
+		{
+			name: "Between Above",
+			check: func(v *validate.Validator) bool {
+				return validate.Field(v, "f", float32(2.5), validate.Between[float32](0, 2))
+			},
+			expectedCode: validate.CodeOutOfRange,
+			expectedMsg:  "f must be between 0 and 2",
+		},
+		{
+			name: "MaxLength Counts Characters",
+			check: func(v *validate.Validator) bool {
+				return validate.Field(v, "f", "héllo", validate.MaxLength(5))
+			},
+		},
+		{
+			name: "MaxLength Exceeded",
+			check: func(v *validate.Validator) bool {
+				return validate.Field(v, "f", "hello!", validate.MaxLength(5))
+			},
+			expectedCode: validate.CodeTooLong,
+			expectedMsg:  "f must be at most 5 characters",
+		},
+		{
+			name: "Optional Empty",
+			check: func(v *validate.Validator) bool {
+				return validate.Field(v, "f", "", validate.Optional(validate.Address()))
+			},
+		},
+		{
+			name: "Optional Present",
+			check: func(v *validate.Validator) bool {
+				return validate.Field(v, "f", "nope", validate.Optional(validate.Address()))
+			},
+			expectedCode: validate.CodeInvalidAddress,
+		},
+		{
+			name: "All Stops At First Failure",
+			check: func(v *validate.Validator) bool {
+				return validate.Field(v, "f", 0, validate.All(validate.Min(1), validate.Between(5, 10)))
+			},
+			expectedCode: validate.CodeOutOfRange,
+			expectedMsg:  "f must be at least 1",
+		},
+		{
+			name: "Int Valid",
+			check: func(v *validate.Validator) bool {
+				n, ok := validate.Int(v, "f", "42", validate.Min(1))
+				return ok && n == 42
+			},
+		},
+		{
+			name: "Int Not A Number",
+			check: func(v *validate.Validator) bool {
+				_, ok := validate.Int(v, "f", "many", validate.Min(1))
+				return ok
+			},
+			expectedCode: validate.CodeInvalidType,
+			expectedMsg:  "f must be an integer",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var v validate.Validator
+			ok := tc.check(&v)
+			err := v.Err()
+
+			if tc.expectedCode == "" {
+				assert.True(t, ok)
+				assert.NoError(t, err)
+				return
+			}
+
+			assert.False(t, ok)
+			var errs validate.Errors
+			require.True(t, errors.As(err, &errs))
+			require.Len(t, errs, 1)
+			assert.Equal(t, "f", errs[0].Field)
+			assert.Equal(t, tc.expectedCode, errs[0].Code)
+			if tc.expectedMsg != "" {
+				assert.Equal(t, tc.expectedMsg, err.Error())
+			}
+		})
+	}
+}
+
+func TestValidateMultipleFields(t *testing.T) {
+	var v validate.Validator
+	validate.Field(&v, "from", "", validate.Required[string](), validate.Address())
+	validate.Field(&v, "to", sol.NewWallet().PublicKey().String(), validate.Required[string](), validate.Address())
+	validate.Field(&v, "amount", 0, validate.Positive[int]())
+	v.Merge("[1].", validate.Errors{{Field: "prompt", Code: validate.CodeRequired, Message: "is required"}})
+	v.Merge("[2].", nil)
+
+	var errs validate.Errors
+	require.True(t, errors.As(v.Err(), &errs))
+	assert.Equal(t, validate.Errors{
+		{Field: "from", Code: validate.CodeRequired, Message: "is required"},
+		{Field: "amount", Code: validate.CodeOutOfRange, Message: "must be greater than zero"},
+		{Field: "[1].prompt", Code: validate.CodeRequired, Message: "is required"},
+	}, errs)
+	assert.Equal(t, "from is required; amount must be greater than zero; [1].prompt is required", errs.Error())
+}
+
+func TestValidationErrorResponse(t *testing.T) {
+	router := setupTestRouter(t, nil)
+
+	rec, resp := doRequest(router, http.MethodPost, "/api/v1/solana/transaction",
+		`{"from":"nope","to":"","amount":0}`)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.False(t, resp.Success)
+	assert.Equal(t, "from must be a base58 Solana address; to is required; amount must be greater than zero", resp.Error)
+
+	details, ok := resp.Details.(map[string]interface{})
+	require.True(t, ok)
+	assert.Equal(t, "invalid_fields", details["reason"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"field": "from", "code": "invalid_address", "message": "must be a base58 Solana address"},
+		map[string]interface{}{"field": "to", "code": "required", "message": "is required"},
+		map[string]interface{}{"field": "amount", "code": "out_of_range", "message": "must be greater than zero"},
+	}, details["fields"])
+
+	// Query parameters are reported the same way
+	rec, resp = doRequest(router, http.MethodGet, "/api/v1/solana/transactions?page=0&per_page=many", "")
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "address is required; page must be at least 1; per_page must be an integer", resp.Error)
+}
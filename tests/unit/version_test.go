@@ -0,0 +1,95 @@
+package unit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/labs-alone/alone-main/pkg/api"
+)
+
+func TestAPIVersionNegotiation(t *testing.T) {
+	router := setupTestRouter(t, nil)
+
+	testCases := []struct {
+		name            string
+		headers         map[string]string
+		expectedStatus  int
+		expectedVersion string
+	}{
+		{
+			name:            "URL Prefix Default",
+			expectedStatus:  http.StatusOK,
+			expectedVersion: "v1",
+		},
+		{
+			name:            "Version Header",
+			headers:         map[string]string{"X-API-Version": "v1"},
+			expectedStatus:  http.StatusOK,
+			expectedVersion: "v1",
+		},
+		{
+			name:            "Bare Version Header",
+			headers:         map[string]string{"X-API-Version": "1"},
+			expectedStatus:  http.StatusOK,
+			expectedVersion: "v1",
+		},
+		{
+			name:            "Accept Media Type",
+			headers:         map[string]string{"Accept": "application/vnd.alone.v1+json"},
+			expectedStatus:  http.StatusOK,
+			expectedVersion: "v1",
+		},
+		{
+			name:            "Accept Falls Back To Supported Version",
+			headers:         map[string]string{"Accept": "application/vnd.alone.v2+json, application/vnd.alone.v1+json; q=0.5"},
+			expectedStatus:  http.StatusOK,
+			expectedVersion: "v1",
+		},
+		{
+			name:            "Plain Accept",
+			headers:         map[string]string{"Accept": "application/json"},
+			expectedStatus:  http.StatusOK,
+			expectedVersion: "v1",
+		},
+		{
+			name:           "Unsupported Header",
+			headers:        map[string]string{"X-API-Version": "v2"},
+			expectedStatus: http.StatusNotAcceptable,
+		},
+		{
+			name:           "Unsupported Accept",
+			headers:        map[string]string{"Accept": "application/vnd.alone.v2+json"},
+			expectedStatus: http.StatusNotAcceptable,
+		},
+		{
+			name: "Header Overrides Accept",
+			headers: map[string]string{
+				"X-API-Version": "v3",
+				"Accept":        "application/vnd.alone.v1+json",
+			},
+			expectedStatus: http.StatusNotAcceptable,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/health", nil)
+			for key, value := range tc.headers {
+				req.Header.Set(key, value)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			assert.Equal(t, tc.expectedStatus, rec.Code)
+			assert.Equal(t, tc.expectedVersion, rec.Header().Get(api.APIVersionHeader))
+
+			if tc.expectedStatus == http.StatusNotAcceptable {
+				assert.Contains(t, rec.Body.String(), "unsupported API version")
+				assert.Contains(t, rec.Body.String(), `"supported":["v1"]`)
+			}
+		})
+	}
+}
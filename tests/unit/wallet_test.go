@@ -0,0 +1,294 @@
+package unit
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	bin "github.com/gagliardetto/binary"
+	sol "github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/programs/token"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/labs-alone/alone-main/internal/solana"
+)
+
+func TestWalletGetInfoPartialFailure(t *testing.T) {
+	testCases := []struct {
+		name    string
+		handler rpcHandler
+		warning string
+	}{
+		{
+			name: "Token Accounts Unavailable",
+			handler: func(json.RawMessage) (interface{}, *rpcError) {
+				return nil, &rpcError{Code: -32005, Message: "node is behind"}
+			},
+			warning: "token balances unavailable",
+		},
+		{
+			name: "Undecodable Token Account",
+			handler: func(json.RawMessage) (interface{}, *rpcError) {
+				return rpcContext([]interface{}{
+					map[string]interface{}{
+						"pubkey": "4Nd1mBQtrMJVYVfKf2PJy9NZUZdTAsp7D4xWLs4gDB4T",
+						"account": map[string]interface{}{
+							"data":       []string{base64.StdEncoding.EncodeToString([]byte{1, 2, 3}), "base64"},
+							"executable": false,
+							"lamports":   2039280,
+							"owner":      "TokenkegQfeZyiNwAJbNbGKPFXCWuBvf9Ss623VQ5DA",
+							"rentEpoch":  0,
+						},
+					},
+				}), nil
+			},
+			warning: "failed to decode token account 4Nd1mBQtrMJVYVfKf2PJy9NZUZdTAsp7D4xWLs4gDB4T",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rpc := newMockRPC(t)
+			rpc.on("getBalance", rpcContext(12345))
+			rpc.handle("getTokenAccountsByOwner", tc.handler)
+
+			wallet, err := solana.CreateNewWallet(setupMockSolanaClient(t, rpc))
+			require.NoError(t, err)
+
+			info, err := wallet.GetInfo(context.Background())
+			require.NoError(t, err)
+
+			assert.Equal(t, solana.Lamports(12345), info.Balance)
+			assert.Empty(t, info.Tokens)
+			require.Len(t, info.Warnings, 1)
+			assert.Contains(t, info.Warnings[0], tc.warning)
+
+			timings, ok := info.Metadata["timings"].(map[string]string)
+			require.True(t, ok)
+			assert.Contains(t, timings, "balance")
+			assert.Contains(t, timings, "tokens")
+			assert.Contains(t, timings, "nfts")
+		})
+	}
+}
+
+func TestWalletGetInfoBalanceFailure(t *testing.T) {
+	rpc := newMockRPC(t)
+	rpc.handle("getBalance", func(json.RawMessage) (interface{}, *rpcError) {
+		return nil, &rpcError{Code: -32005, Message: "node is behind"}
+	})
+
+	wallet, err := solana.CreateNewWallet(setupMockSolanaClient(t, rpc))
+	require.NoError(t, err)
+
+	_, err = wallet.GetInfo(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, 0, rpc.callCount("getTokenAccountsByOwner"))
+}
+
+func TestWalletGetInfoConcurrent(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	rpc := newMockRPC(t)
+	rpc.on("getBalance", rpcContext(12345))
+	rpc.handle("getTokenAccountsByOwner", func(json.RawMessage) (interface{}, *rpcError) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+		return rpcContext([]interface{}{}), nil
+	})
+
+	wallet, err := solana.CreateNewWallet(setupMockSolanaClient(t, rpc))
+	require.NoError(t, err)
+	created := wallet.LastUpdated()
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			info, err := wallet.GetInfo(ctx)
+			assert.NoError(t, err)
+			if err == nil {
+				assert.Equal(t, solana.Lamports(12345), info.Balance)
+			}
+		}()
+	}
+
+	// While GetInfo waits on the node, the rest of the wallet stays usable
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("GetInfo never fetched token accounts")
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		balance, err := wallet.GetBalance(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, solana.Lamports(12345), balance)
+		assert.Equal(t, created, wallet.LastUpdated())
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("GetBalance blocked behind GetInfo")
+	}
+
+	close(release)
+	wg.Wait()
+	assert.True(t, wallet.LastUpdated().After(created))
+}
+
+// tokenAccountEntry encodes a token account as returned by
+// getTokenAccountsByOwner with base64 encoding
+func tokenAccountEntry(t *testing.T, mint, owner sol.PublicKey, amount uint64) map[string]interface{} {
+	var buf bytes.Buffer
+	require.NoError(t, bin.NewBinEncoder(&buf).Encode(token.Account{
+		Mint:   mint,
+		Owner:  owner,
+		Amount: amount,
+		State:  token.Initialized,
+	}))
+
+	return map[string]interface{}{
+		"pubkey": sol.NewWallet().PublicKey().String(),
+		"account": map[string]interface{}{
+			"data":       []string{base64.StdEncoding.EncodeToString(buf.Bytes()), "base64"},
+			"executable": false,
+			"lamports":   2039280,
+			"owner":      sol.TokenProgramID.String(),
+			"rentEpoch":  0,
+		},
+	}
+}
+
+func TestWalletGetInfoTokenDecimals(t *testing.T) {
+	rpc := newMockRPC(t)
+	wallet, err := solana.CreateNewWallet(setupMockSolanaClient(t, rpc))
+	require.NoError(t, err)
+
+	owner := sol.MustPublicKeyFromBase58(wallet.GetAddress())
+	usdc := sol.NewWallet().PublicKey()
+	unknown := sol.NewWallet().PublicKey()
+
+	rpc.on("getBalance", rpcContext(12345))
+	rpc.on("getTokenAccountsByOwner", rpcContext([]interface{}{
+		tokenAccountEntry(t, usdc, owner, 2500000),
+		tokenAccountEntry(t, unknown, owner, 7),
+	}))
+
+	var mint bytes.Buffer
+	require.NoError(t, bin.NewBinEncoder(&mint).Encode(token.Mint{
+		Supply:        1000000000,
+		Decimals:      6,
+		IsInitialized: true,
+	}))
+	rpc.handle("getMultipleAccounts", func(params json.RawMessage) (interface{}, *rpcError) {
+		var args []json.RawMessage
+		json.Unmarshal(params, &args)
+
+		var keys []string
+		json.Unmarshal(args[0], &keys)
+
+		values := make([]interface{}, len(keys))
+		for i, key := range keys {
+			if key != usdc.String() {
+				continue
+			}
+			values[i] = map[string]interface{}{
+				"data":       []string{base64.StdEncoding.EncodeToString(mint.Bytes()), "base64"},
+				"executable": false,
+				"lamports":   1461600,
+				"owner":      sol.TokenProgramID.String(),
+				"rentEpoch":  0,
+			}
+		}
+		return rpcContext(values), nil
+	})
+
+	info, err := wallet.GetInfo(context.Background())
+	require.NoError(t, err)
+
+	decimals := make(map[string]uint8)
+	for _, balance := range info.Tokens {
+		decimals[balance.Mint] = balance.Decimals
+	}
+	assert.Equal(t, map[string]uint8{usdc.String(): 6, unknown.String(): 0}, decimals)
+	assert.Equal(t, 1, rpc.callCount("getMultipleAccounts"))
+
+	// A mint that can't be read leaves its decimals unknown
+	require.Len(t, info.Warnings, 1)
+	assert.Contains(t, info.Warnings[0], "decimals unavailable for mint "+unknown.String())
+}
+
+func TestWalletGetTokenBalance(t *testing.T) {
+	rpc := newMockRPC(t)
+	wallet, err := solana.CreateNewWallet(setupMockSolanaClient(t, rpc))
+	require.NoError(t, err)
+
+	owner := sol.MustPublicKeyFromBase58(wallet.GetAddress())
+	held := sol.NewWallet().PublicKey()
+	empty := sol.NewWallet().PublicKey()
+	unheld := sol.NewWallet().PublicKey()
+
+	accounts := map[string][]interface{}{
+		held.String(): {
+			tokenAccountEntry(t, held, owner, 700),
+			tokenAccountEntry(t, held, owner, 300),
+		},
+		empty.String(): {tokenAccountEntry(t, empty, owner, 0)},
+	}
+	rpc.handle("getTokenAccountsByOwner", func(params json.RawMessage) (interface{}, *rpcError) {
+		var args []json.RawMessage
+		json.Unmarshal(params, &args)
+
+		var filter struct {
+			Mint string `json:"mint"`
+		}
+		json.Unmarshal(args[1], &filter)
+
+		found := accounts[filter.Mint]
+		if found == nil {
+			found = []interface{}{}
+		}
+		return rpcContext(found), nil
+	})
+
+	testCases := []struct {
+		name            string
+		mint            string
+		expectedBalance uint64
+		expectedFound   bool
+	}{
+		{name: "Held Token", mint: held.String(), expectedBalance: 1000, expectedFound: true},
+		{name: "Empty Account", mint: empty.String(), expectedBalance: 0, expectedFound: true},
+		{name: "Unheld Token", mint: unheld.String(), expectedBalance: 0, expectedFound: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			balance, found, err := wallet.GetTokenBalance(context.Background(), tc.mint)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedBalance, balance)
+			assert.Equal(t, tc.expectedFound, found)
+		})
+	}
+
+	t.Run("Invalid Mint", func(t *testing.T) {
+		calls := rpc.callCount("getTokenAccountsByOwner")
+
+		_, found, err := wallet.GetTokenBalance(context.Background(), "not-a-mint")
+		assert.Error(t, err)
+		assert.False(t, found)
+		assert.Equal(t, calls, rpc.callCount("getTokenAccountsByOwner"))
+	})
+}